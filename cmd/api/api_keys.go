@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/VJ-2303/CityStars/internal/data"
+	"github.com/VJ-2303/CityStars/internal/validator"
+)
+
+// CreateAPIKeyHandler issues an API key for a machine integration, the key
+// itself is only returned in this response
+func (app *application) CreateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	userID, _ := r.Context().Value(userIDKey).(int64)
+
+	key, plainText, err := data.NewAPIKey(input.Name, input.Scopes, userID, input.ExpiresAt)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateAPIKey(v, key); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.APIKeys.Insert(r.Context(), key)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.audit(r.Context(), data.AuditAPIKeyCreated, "api_key", key.ID, nil, key)
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"api_key": key, "key": plainText})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// ListAPIKeysHandler returns every API key, revoked ones included
+func (app *application) ListAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := app.models.APIKeys.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"api_keys": keys})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// RevokeAPIKeyHandler revokes an API key, the key is kept for the record
+func (app *application) RevokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	err = app.models.APIKeys.Revoke(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, data.ErrAPIKeyNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r.Context(), data.AuditAPIKeyRevoked, "api_key", id, envelope{"revoked": false}, envelope{"revoked": true})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "api key successfully revoked"})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
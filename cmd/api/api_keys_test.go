@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIKeys(t *testing.T) {
+	app := newTestApplication(t)
+	user := app.insertUser(t, "Priya Raman", "9000000001", "pa55word-1234", "user")
+	admin := app.insertUser(t, "Admin Person", "9000000002", "pa55word-1234", "admin")
+	token := app.tokenFor(t, admin)
+
+	valid := map[string]any{"name": "Contractor", "scopes": []string{"reports:read", "reports:update_status"}}
+
+	tests := []struct {
+		name  string
+		token string
+		body  any
+		want  int
+	}{
+		{"as user", app.tokenFor(t, user), valid, http.StatusUnauthorized},
+		{"missing name", token, map[string]any{"scopes": []string{"reports:read"}}, http.StatusUnprocessableEntity},
+		{"unknown scope", token, map[string]any{"name": "Contractor", "scopes": []string{"reports:delete"}}, http.StatusUnprocessableEntity},
+		{"no scopes", token, map[string]any{"name": "Contractor"}, http.StatusUnprocessableEntity},
+		{"expired", token, map[string]any{"name": "Contractor", "scopes": []string{"reports:read"}, "expires_at": time.Now().Add(-time.Hour)}, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := app.do(t, http.MethodPost, "/v1/admin/api-keys", tt.token, tt.body)
+			checkStatus(t, res, tt.want)
+		})
+	}
+
+	res := app.do(t, http.MethodPost, "/v1/admin/api-keys", token, valid)
+	checkStatus(t, res, http.StatusCreated)
+
+	plainText, _ := res.body["key"].(string)
+	var key struct {
+		ID        int64  `json:"id"`
+		Prefix    string `json:"prefix"`
+		CreatedBy int64  `json:"created_by"`
+	}
+	decodeField(t, res, "api_key", &key)
+	if !strings.HasPrefix(plainText, "cs_"+key.Prefix+"_") || key.CreatedBy != admin.ID {
+		t.Fatalf("got key %q with prefix %q created by %d", plainText, key.Prefix, key.CreatedBy)
+	}
+
+	t.Run("list", func(t *testing.T) {
+		res := app.do(t, http.MethodGet, "/v1/admin/api-keys", token, nil)
+		checkStatus(t, res, http.StatusOK)
+
+		var keys []map[string]any
+		decodeField(t, res, "api_keys", &keys)
+		if len(keys) != 1 {
+			t.Fatalf("got %d keys, want 1", len(keys))
+		}
+		if _, ok := keys[0]["hash"]; ok {
+			t.Error("key hash is listed")
+		}
+		if _, ok := keys[0]["last_used_at"]; ok {
+			t.Error("unused key has a last use")
+		}
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		path := fmt.Sprintf("/v1/admin/api-keys/%d", key.ID)
+		res := app.do(t, http.MethodDelete, path, token, nil)
+		checkStatus(t, res, http.StatusOK)
+
+		res = app.do(t, http.MethodGet, "/v1/reports", plainText, nil)
+		checkStatus(t, res, http.StatusUnauthorized)
+
+		res = app.do(t, http.MethodDelete, "/v1/admin/api-keys/9999", token, nil)
+		checkStatus(t, res, http.StatusNotFound)
+	})
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	app := newTestApplication(t)
+	user := app.insertUser(t, "Priya Raman", "9000000001", "pa55word-1234", "user")
+	admin := app.insertUser(t, "Admin Person", "9000000002", "pa55word-1234", "admin")
+	report := app.insertReport(t, user.ID, "Deep pothole", "pothole")
+
+	createKey := func(scopes ...string) string {
+		t.Helper()
+
+		res := app.do(t, http.MethodPost, "/v1/admin/api-keys", app.tokenFor(t, admin), map[string]any{"name": "Contractor", "scopes": scopes})
+		checkStatus(t, res, http.StatusCreated)
+		return res.body["key"].(string)
+	}
+	readKey := createKey("reports:read")
+	updateKey := createKey("reports:read", "reports:update_status")
+
+	path := fmt.Sprintf("/v1/reports/%d", report.ID)
+	update := func(version int) map[string]any {
+		return map[string]any{"status": "in-progress", "version": version}
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		body   any
+		want   int
+	}{
+		{"list anonymously", http.MethodGet, "/v1/reports", "", nil, http.StatusOK},
+		{"list with key", http.MethodGet, "/v1/reports", readKey, nil, http.StatusOK},
+		{"get with key", http.MethodGet, path, readKey, nil, http.StatusOK},
+		{"list with unknown key", http.MethodGet, "/v1/reports", "cs_aaaaaaaa_bbbbbbbb", nil, http.StatusUnauthorized},
+		{"list with tampered key", http.MethodGet, "/v1/reports", readKey + "x", nil, http.StatusUnauthorized},
+		{"list with malformed key", http.MethodGet, "/v1/reports", "cs_", nil, http.StatusUnauthorized},
+		{"update without scope", http.MethodPatch, path, readKey, update(1), http.StatusForbidden},
+		{"update with scope", http.MethodPatch, path, updateKey, update(1), http.StatusOK},
+		{"update as user", http.MethodPatch, path, app.tokenFor(t, user), update(2), http.StatusForbidden},
+		{"update as admin", http.MethodPatch, path, app.tokenFor(t, admin), update(2), http.StatusOK},
+		{"admin routes with key", http.MethodGet, "/v1/admin/api-keys", updateKey, nil, http.StatusUnauthorized},
+		{"user routes with key", http.MethodGet, "/v1/user/me", updateKey, nil, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := app.do(t, tt.method, tt.path, tt.token, tt.body)
+			checkStatus(t, res, tt.want)
+		})
+	}
+
+	t.Run("last used", func(t *testing.T) {
+		res := app.do(t, http.MethodGet, "/v1/admin/api-keys", app.tokenFor(t, admin), nil)
+		checkStatus(t, res, http.StatusOK)
+
+		var keys []struct {
+			LastUsedAt *string `json:"last_used_at"`
+		}
+		decodeField(t, res, "api_keys", &keys)
+		for i, key := range keys {
+			if key.LastUsedAt == nil {
+				t.Errorf("key %d has no last use", i)
+			}
+		}
+	})
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/VJ-2303/CityStars/internal/data"
+	"github.com/VJ-2303/CityStars/internal/validator"
+)
+
+// audit appends the action of the actor of ctx to the audit log. The action
+// already happened by then, so a failure to record it is logged rather than
+// failing the request
+func (app *application) audit(ctx context.Context, action, targetType string, targetID any, before, after any) {
+	actor := data.ActorFromContext(ctx)
+
+	e, err := data.NewAuditEvent(actor, action, targetType, fmt.Sprint(targetID), before, after)
+	if err == nil {
+		err = app.models.Audit.Record(ctx, e)
+	}
+	if err != nil {
+		app.logger.Error("failed to record audit event", "action", action, "request_id", actor.RequestID, "error", err)
+	}
+}
+
+// asUser returns a copy of ctx whose actor is the user, for the events of
+// requests which authenticate the user rather than carrying a token
+func asUser(ctx context.Context, user *data.User) context.Context {
+	actor := *data.ActorFromContext(ctx)
+	actor.Type = data.ActorUser
+	actor.ID = user.ID
+	actor.Role = user.Role
+	actor.Name = user.Name
+	return data.ContextWithActor(ctx, &actor)
+}
+
+// ListAuditEventsHandler returns the audit log, newest first, filtered by
+// actor, action, target and time range
+func (app *application) ListAuditEventsHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+
+	filter := data.AuditFilter{
+		ActorType:  qs.Get("actor_type"),
+		Action:     qs.Get("action"),
+		TargetType: qs.Get("target_type"),
+		TargetID:   qs.Get("target_id"),
+	}
+
+	if actorID := qs.Get("actor_id"); actorID != "" {
+		id, err := strconv.ParseInt(actorID, 10, 64)
+		v.Check(err == nil && id > 0, "actor_id", "must be a positive integer")
+		filter.ActorID = id
+	}
+	filter.Since = readTimeParam(v, qs.Get("since"), "since")
+	filter.Until = readTimeParam(v, qs.Get("until"), "until")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	limit := 50 // default limit
+	if limitStr := qs.Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 100 {
+			limit = l
+		}
+	}
+
+	offset := 0
+	if offsetStr := qs.Get("offset"); offsetStr != "" {
+		if o, err := strconv.Atoi(offsetStr); err == nil && o >= 0 {
+			offset = o
+		}
+	}
+
+	events, err := app.models.Audit.GetAll(r.Context(), filter, limit, offset)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"audit_events": events})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// auditVerifyLimit is the default number of events verified per request,
+// enough for most logs while keeping the request well within its timeout
+const auditVerifyLimit = 10_000
+
+// VerifyAuditLogHandler recomputes the hash chain of the audit log and
+// reports the first event which doesn't match, if any. Long logs are
+// verified in steps, each starting after the last_id of the previous one
+func (app *application) VerifyAuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+
+	var afterID int64
+	if s := qs.Get("after_id"); s != "" {
+		id, err := strconv.ParseInt(s, 10, 64)
+		v.Check(err == nil && id >= 0, "after_id", "must be a non-negative integer")
+		afterID = id
+	}
+
+	limit := auditVerifyLimit
+	if s := qs.Get("limit"); s != "" {
+		l, err := strconv.Atoi(s)
+		v.Check(err == nil && l > 0 && l <= auditVerifyLimit, "limit", fmt.Sprintf("must be between 1 and %d", auditVerifyLimit))
+		limit = l
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	result, err := app.models.Audit.Verify(r.Context(), afterID, limit)
+	if err != nil {
+		if errors.Is(err, data.ErrAuditEventNotFound) {
+			v.AddError("after_id", "must be the id of an audit event")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !result.Valid {
+		app.logger.Error("audit log hash chain is broken", "event_id", result.BrokenAt)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"verification": result})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readTimeParam parses an RFC 3339 query parameter, nil when it's empty
+func readTimeParam(v *validator.Validator, value, key string) *time.Time {
+	if value == "" {
+		return nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	v.Check(err == nil, key, "must be an RFC 3339 timestamp")
+	if err != nil {
+		return nil
+	}
+	return &t
+}
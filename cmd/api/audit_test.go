@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// auditEvent is an audit event as listed by the API
+type auditEvent struct {
+	ActorType  string          `json:"actor_type"`
+	ActorID    int64           `json:"actor_id"`
+	ActorRole  string          `json:"actor_role"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetID   string          `json:"target_id"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+	IP         string          `json:"ip"`
+	RequestID  string          `json:"request_id"`
+}
+
+func TestAuditLog(t *testing.T) {
+	app := newTestApplication(t)
+	admin := app.insertUser(t, "Admin Person", "9000000001", "pa55word-1234", "admin")
+	user := app.insertUser(t, "Priya Raman", "9000000002", "pa55word-1234", "user")
+	report := app.insertReport(t, user.ID, "Deep pothole", "pothole")
+	token := app.tokenFor(t, admin)
+
+	// The status update of the report records who made it and from where
+	body := `{"status": "completed", "after_image": "https://img.example.com/after.jpg"}`
+	r := httptest.NewRequest(http.MethodPatch, fmt.Sprintf("/v1/reports/%d", report.ID), strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+token)
+	r.Header.Set("If-Match", `"1"`)
+	r.Header.Set(requestIDHeader, "req-audit-1")
+	checkStatus(t, app.send(t, r), http.StatusOK)
+
+	res := app.do(t, http.MethodPost, "/v1/user/login", "", map[string]string{"phone_number": "9000000002", "password": "wrong-password"})
+	checkStatus(t, res, http.StatusUnauthorized)
+	res = app.do(t, http.MethodPost, "/v1/user/login", "", map[string]string{"phone_number": "9000000002", "password": "pa55word-1234"})
+	checkStatus(t, res, http.StatusCreated)
+
+	res = app.do(t, http.MethodPost, "/v1/admin/api-keys", token, map[string]any{"name": "Contractor", "scopes": []string{"reports:read"}})
+	checkStatus(t, res, http.StatusCreated)
+
+	res = app.do(t, http.MethodGet, "/v1/admin/audit-events?target_type=report", token, nil)
+	checkStatus(t, res, http.StatusOK)
+	var events []auditEvent
+	decodeField(t, res, "audit_events", &events)
+	if len(events) != 1 {
+		t.Fatalf("got %d report events, want 1", len(events))
+	}
+	// The after image is recorded by its SHA-256
+	want := auditEvent{
+		ActorType:  "user",
+		ActorID:    admin.ID,
+		ActorRole:  "admin",
+		Action:     "report.status_updated",
+		TargetType: "report",
+		TargetID:   fmt.Sprint(report.ID),
+		Before:     json.RawMessage(`{"after_image_sha256":"","status":"pending"}`),
+		After:      json.RawMessage(`{"after_image_sha256":"c2d4c70a6319b5df30dd702ac9ef22a1f29e8fea69ce8cf75bc298b90d65978f","status":"completed"}`),
+		IP:         "192.0.2.1",
+		RequestID:  "req-audit-1",
+	}
+	if fmt.Sprint(events[0]) != fmt.Sprint(want) {
+		t.Errorf("got event %+v, want %+v", events[0], want)
+	}
+
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"all", "", []string{"api_key.created", "auth.login_succeeded", "auth.login_failed", "report.status_updated"}},
+		{"action", "?action=auth.login_failed", []string{"auth.login_failed"}},
+		{"actor", fmt.Sprintf("?actor_type=user&actor_id=%d", user.ID), []string{"auth.login_succeeded"}},
+		{"anonymous", "?actor_type=anonymous", []string{"auth.login_failed"}},
+		{"target", "?target_type=phone_number&target_id=9000000002", []string{"auth.login_failed"}},
+		{"since", "?since=" + future, nil},
+		{"until", "?until=" + future, []string{"api_key.created", "auth.login_succeeded", "auth.login_failed", "report.status_updated"}},
+		{"paginated", "?limit=1&offset=1", []string{"auth.login_succeeded"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := app.do(t, http.MethodGet, "/v1/admin/audit-events"+tt.query, token, nil)
+			checkStatus(t, res, http.StatusOK)
+
+			var events []auditEvent
+			decodeField(t, res, "audit_events", &events)
+			var actions []string
+			for _, e := range events {
+				actions = append(actions, e.Action)
+			}
+			if fmt.Sprint(actions) != fmt.Sprint(tt.want) {
+				t.Errorf("got actions %v, want %v", actions, tt.want)
+			}
+		})
+	}
+
+	t.Run("invalid filters", func(t *testing.T) {
+		res := app.do(t, http.MethodGet, "/v1/admin/audit-events?actor_id=abc&since=yesterday", token, nil)
+		checkStatus(t, res, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("not an admin", func(t *testing.T) {
+		res := app.do(t, http.MethodGet, "/v1/admin/audit-events", app.tokenFor(t, user), nil)
+		checkStatus(t, res, http.StatusUnauthorized)
+	})
+
+	t.Run("verify", func(t *testing.T) {
+		res := app.do(t, http.MethodGet, "/v1/admin/audit-events/verify", token, nil)
+		checkStatus(t, res, http.StatusOK)
+
+		var result struct {
+			Valid   bool `json:"valid"`
+			Checked int  `json:"checked"`
+		}
+		decodeField(t, res, "verification", &result)
+		if !result.Valid || result.Checked != 4 {
+			t.Errorf("got %+v, want a valid chain of 4 events", result)
+		}
+	})
+
+	t.Run("verify in steps", func(t *testing.T) {
+		type verification struct {
+			Valid   bool  `json:"valid"`
+			Checked int   `json:"checked"`
+			LastID  int64 `json:"last_id"`
+			More    bool  `json:"more"`
+		}
+
+		res := app.do(t, http.MethodGet, "/v1/admin/audit-events/verify?limit=3", token, nil)
+		checkStatus(t, res, http.StatusOK)
+		var first verification
+		decodeField(t, res, "verification", &first)
+		if !first.Valid || first.Checked != 3 || !first.More {
+			t.Fatalf("got %+v, want 3 valid events with more left", first)
+		}
+
+		res = app.do(t, http.MethodGet, fmt.Sprintf("/v1/admin/audit-events/verify?after_id=%d&limit=3", first.LastID), token, nil)
+		checkStatus(t, res, http.StatusOK)
+		var second verification
+		decodeField(t, res, "verification", &second)
+		if !second.Valid || second.Checked != 1 || second.More {
+			t.Errorf("got %+v, want the last valid event", second)
+		}
+	})
+
+	t.Run("verify invalid params", func(t *testing.T) {
+		for _, query := range []string{"?after_id=-1", "?limit=0", "?limit=abc", "?after_id=9999"} {
+			res := app.do(t, http.MethodGet, "/v1/admin/audit-events/verify"+query, token, nil)
+			checkStatus(t, res, http.StatusUnprocessableEntity)
+		}
+	})
+}
@@ -0,0 +1,538 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/VJ-2303/CityStars/internal/data"
+	"github.com/VJ-2303/CityStars/internal/validator"
+	"gopkg.in/yaml.v3"
+)
+
+// Settings are loaded in layers, each overriding the ones before it:
+//
+//  1. the defaults registered in flagSet
+//  2. the YAML or TOML file named by -config or CITYSTARS_CONFIG, whose keys
+//     are the flag names, nested tables being joined with dashes
+//  3. environment variables, CITYSTARS_ followed by the flag name in upper
+//     case with underscores (CITYSTARS_DB_DSN), or the names in envAliases
+//  4. command line flags
+
+// weakSecrets are placeholder secrets refused outside development
+var weakSecrets = []string{"mysecretkey", "secret", "changeme", "password"}
+
+// envAliases are the environment variables read for a setting besides its
+// CITYSTARS_ name, mostly the ones set by the hosting platform
+var envAliases = map[string][]string{
+	"port":             {"PORT"},
+	"env":              {"ENVIRONMENT"},
+	"db-dsn":           {"DATABASE_URL", "DB_DSN"},
+	"db-auto-migrate":  {"DB_AUTO_MIGRATE"},
+	"otel-endpoint":    {"OTEL_EXPORTER_OTLP_ENDPOINT"},
+	"metrics-username": {"METRICS_USERNAME"},
+	"metrics-password": {"METRICS_PASSWORD"},
+	"smtp-host":        {"SMTP_HOST"},
+	"smtp-username":    {"SMTP_USERNAME"},
+	"smtp-password":    {"SMTP_PASSWORD"},
+	"sms-url":          {"SMS_URL"},
+	"sms-api-key":      {"SMS_API_KEY"},
+}
+
+// secretSettings are redacted when the configuration is printed
+var secretSettings = map[string]bool{
+	"metrics-password":   true,
+	"oidc-client-secret": true,
+	"smtp-password":      true,
+	"sms-api-key":        true,
+}
+
+// config holds configuration settings for the application,
+// including server port, environment, database connection string, and JWT signing keys.
+type config struct {
+	port       int    // Port number for HTTP server
+	env        string // Application environment ("development", "staging", "production")
+	logFormat  string // Log output format ("text" or "json")
+	dsn        string // PostgreSQL database connection string
+	replicaDSN string // Read replica the report lists and stats are queried on, optional
+
+	autoMigrate  bool          // Apply pending migrations on startup
+	authTokenTTL time.Duration // Lifetime of the authentication tokens issued on login
+
+	notificationRetention time.Duration // How long notifications are kept before being pruned
+	shutdownTimeout       time.Duration // How long in-flight requests and background tasks get to finish
+	requestTimeout        time.Duration // Deadline of a request, event streams excluded
+	queryTimeout          time.Duration // Deadline of a single database query
+
+	// Keys signing and verifying the JWTs, as PEM or the path of a PEM file
+	jwt struct {
+		signingKey   string   // Private key signing new tokens
+		previousKeys []string // Keys replaced by signingKey, verifying the tokens they signed
+		issuer       string
+		audience     string
+	}
+
+	// TOTP two-factor authentication
+	mfa struct {
+		issuer        string        // Name authenticator apps show the accounts under
+		pendingTTL    time.Duration // Lifetime of the token between the password and the code
+		requireAdmins bool          // Refuse admin actions to sessions which didn't complete 2FA
+	}
+
+	// OpenID Connect login of city staff, disabled when issuer is empty
+	oidc struct {
+		issuer       string // Issuer URL the provider is discovered at
+		clientID     string
+		clientSecret string
+		redirectURL  string   // URL of /v1/auth/oidc/callback as registered with the provider
+		scopes       []string // Requested scopes, openid included
+		groupsClaim  string   // ID token claim listing the user's groups
+		groupRoles   []string // group=role mappings granting CityStars roles
+		postLoginURL string   // Frontend page the token is handed to, the callback answers with JSON when empty
+	}
+
+	// Database connection pool
+	db struct {
+		maxOpenConns    int
+		maxIdleConns    int
+		connMaxIdleTime time.Duration // Idle connections are closed after this, zero keeps them
+		connMaxLifetime time.Duration // Connections are replaced after this, zero keeps them
+		connectTimeout  time.Duration // How long startup keeps retrying to reach the database
+	}
+
+	// Origins allowed to call the API from a browser
+	cors struct {
+		trustedOrigins []string
+	}
+
+	// Request rate limiting, per IP and per authenticated user
+	limiter struct {
+		enabled    bool
+		store      string  // Bucket store ("memory" or "postgres")
+		rps        float64 // Global requests per second per IP
+		burst      int     // Global burst size per IP
+		trustProxy bool    // Take the client IP from X-Forwarded-For
+	}
+
+	// OpenTelemetry trace export, disabled when endpoint is empty
+	otel struct {
+		endpoint    string  // OTLP/HTTP collector URL, e.g. http://localhost:4318
+		sampleRatio float64 // Share of new traces which are sampled
+	}
+
+	// Basic auth credentials for /metrics, left open when username is empty
+	metrics struct {
+		username string
+		password string
+	}
+
+	// Progressive lockout of phone numbers after failed logins
+	login struct {
+		maxFailures int
+		lockout     time.Duration
+		maxLockout  time.Duration
+	}
+
+	// SMTP server used for email notifications, logged only when host is empty
+	smtp struct {
+		host     string
+		port     int
+		username string
+		password string
+		sender   string
+	}
+
+	// HTTP provider used for SMS notifications, logged only when url is empty
+	sms struct {
+		url    string
+		apiKey string
+		sender string
+	}
+
+	// sources records where every setting not left at its default came
+	// from ("file", "env CITYSTARS_PORT" or "flag"), by flag name
+	sources map[string]string
+}
+
+// flagSet registers every setting as a flag of the returned set, along with its default
+func (cfg *config) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+
+	cfg.cors.trustedOrigins = []string{"*"}
+	cfg.oidc.scopes = []string{"openid", "profile", "phone"}
+
+	fs.String("config", "", "YAML or TOML file to load settings from")
+	fs.IntVar(&cfg.port, "port", 4000, "API server port")
+	fs.StringVar(&cfg.env, "env", "production", "Environment (development|staging|production)")
+	fs.StringVar(&cfg.logFormat, "log-format", "text", "Log output format (text|json)")
+	fs.StringVar(&cfg.jwt.signingKey, "jwt-signing-key", "", "Ed25519 or RSA private key signing the tokens, as PEM or a PEM file path")
+	fs.Var((*stringList)(&cfg.jwt.previousKeys), "jwt-previous-keys", "Comma separated keys still verifying tokens after a rotation, as PEM or PEM file paths")
+	fs.StringVar(&cfg.jwt.issuer, "jwt-issuer", "citystars", "Issuer claim of the tokens")
+	fs.StringVar(&cfg.jwt.audience, "jwt-audience", "citystars", "Audience claim of the tokens")
+	fs.DurationVar(&cfg.authTokenTTL, "auth-token-ttl", 24*time.Hour, "Lifetime of the authentication tokens issued on login")
+	fs.StringVar(&cfg.mfa.issuer, "mfa-issuer", "CityStars", "Issuer authenticator apps list the 2FA accounts under")
+	fs.DurationVar(&cfg.mfa.pendingTTL, "mfa-pending-ttl", 5*time.Minute, "How long a login may take between the password and the 2FA code")
+	fs.BoolVar(&cfg.mfa.requireAdmins, "mfa-require-admins", false, "Require two-factor authentication for admin actions")
+	fs.StringVar(&cfg.oidc.issuer, "oidc-issuer", "", "OpenID Connect issuer URL of the staff identity provider, empty disables OIDC login")
+	fs.StringVar(&cfg.oidc.clientID, "oidc-client-id", "", "OpenID Connect client ID")
+	fs.StringVar(&cfg.oidc.clientSecret, "oidc-client-secret", "", "OpenID Connect client secret")
+	fs.StringVar(&cfg.oidc.redirectURL, "oidc-redirect-url", "", "Callback URL registered with the provider, ending in /v1/auth/oidc/callback")
+	fs.Var((*stringList)(&cfg.oidc.scopes), "oidc-scopes", "Comma separated scopes requested from the provider")
+	fs.StringVar(&cfg.oidc.groupsClaim, "oidc-groups-claim", "groups", "ID token claim listing the groups of the user")
+	fs.Var((*stringList)(&cfg.oidc.groupRoles), "oidc-group-roles", "Comma separated group=role mappings, users in none of the groups can't log in")
+	fs.StringVar(&cfg.oidc.postLoginURL, "oidc-post-login-url", "", "Frontend page receiving the token in the URL fragment after an OIDC login")
+	fs.StringVar(&cfg.dsn, "db-dsn", "", "Postgres DB connection string")
+	fs.BoolVar(&cfg.autoMigrate, "db-auto-migrate", false, "Apply pending migrations on startup")
+	fs.StringVar(&cfg.replicaDSN, "db-replica-dsn", "", "Postgres read replica connection string for the report lists and stats")
+	fs.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "Maximum open connections to the database")
+	fs.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "Maximum idle connections kept in the pool")
+	fs.DurationVar(&cfg.db.connMaxIdleTime, "db-conn-max-idle-time", 15*time.Minute, "Close connections idle for this long, 0 keeps them")
+	fs.DurationVar(&cfg.db.connMaxLifetime, "db-conn-max-lifetime", time.Hour, "Replace connections after this long, 0 keeps them")
+	fs.DurationVar(&cfg.db.connectTimeout, "db-connect-timeout", 30*time.Second, "How long startup keeps retrying to reach the database, 0 tries once")
+	fs.DurationVar(&cfg.queryTimeout, "db-query-timeout", data.DefaultQueryTimeout, "Deadline of a single database query")
+	fs.Var((*stringList)(&cfg.cors.trustedOrigins), "cors-trusted-origins", "Comma separated origins allowed to call the API from a browser, * allows any")
+	fs.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiting")
+	fs.StringVar(&cfg.limiter.store, "limiter-store", "memory", "Rate limiter store (memory|postgres)")
+	fs.Float64Var(&cfg.limiter.rps, "limiter-rps", 10, "Rate limiter maximum requests per second per IP")
+	fs.IntVar(&cfg.limiter.burst, "limiter-burst", 40, "Rate limiter maximum burst per IP")
+	fs.BoolVar(&cfg.limiter.trustProxy, "limiter-trust-proxy", false, "Take the client IP from the X-Forwarded-For header")
+	fs.StringVar(&cfg.otel.endpoint, "otel-endpoint", "", "OTLP/HTTP collector URL for traces, empty disables tracing")
+	fs.Float64Var(&cfg.otel.sampleRatio, "otel-sample-ratio", 1, "Share of new traces which are sampled")
+	fs.StringVar(&cfg.metrics.username, "metrics-username", "", "Basic auth username for /metrics")
+	fs.StringVar(&cfg.metrics.password, "metrics-password", "", "Basic auth password for /metrics")
+	fs.IntVar(&cfg.login.maxFailures, "login-max-failures", 5, "Failed logins allowed before a phone number is locked out")
+	fs.DurationVar(&cfg.login.lockout, "login-lockout", time.Minute, "First lockout period, doubled for every further failure")
+	fs.DurationVar(&cfg.login.maxLockout, "login-max-lockout", time.Hour, "Longest lockout period")
+	fs.StringVar(&cfg.smtp.host, "smtp-host", "", "SMTP host for email notifications")
+	fs.IntVar(&cfg.smtp.port, "smtp-port", 1025, "SMTP port for email notifications")
+	fs.StringVar(&cfg.smtp.username, "smtp-username", "", "SMTP username")
+	fs.StringVar(&cfg.smtp.password, "smtp-password", "", "SMTP password")
+	fs.StringVar(&cfg.smtp.sender, "smtp-sender", "CityStars <no-reply@citystars.local>", "SMTP sender address")
+	fs.StringVar(&cfg.sms.url, "sms-url", "", "SMS provider endpoint for SMS notifications")
+	fs.StringVar(&cfg.sms.apiKey, "sms-api-key", "", "SMS provider API key")
+	fs.StringVar(&cfg.sms.sender, "sms-sender", "CityStars", "SMS sender ID")
+	fs.DurationVar(&cfg.requestTimeout, "request-timeout", 10*time.Second, "Deadline of a request, event streams excluded")
+	fs.DurationVar(&cfg.shutdownTimeout, "shutdown-timeout", 20*time.Second, "How long in-flight requests and background tasks get to finish on shutdown")
+	fs.DurationVar(&cfg.notificationRetention, "notification-retention", 90*24*time.Hour, "How long notifications are kept before being pruned")
+
+	return fs
+}
+
+// loadConfig layers the settings from the config file, the environment and
+// args on top of the defaults. The returned flag set is bound to the config
+func loadConfig(args []string, getenv func(string) string) (*config, *flag.FlagSet, error) {
+	cfg := &config{sources: map[string]string{}}
+	fs := cfg.flagSet("api")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, nil, fmt.Errorf("unexpected arguments %v", fs.Args())
+	}
+	fs.Visit(func(f *flag.Flag) {
+		cfg.sources[f.Name] = "flag"
+	})
+
+	path := fs.Lookup("config").Value.String()
+	if path == "" {
+		path = getenv("CITYSTARS_CONFIG")
+	}
+	if path != "" {
+		settings, err := readConfigFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		for name, value := range settings {
+			if fs.Lookup(name) == nil || name == "config" {
+				return nil, nil, fmt.Errorf("%s: unknown setting %q", path, name)
+			}
+			if cfg.sources[name] != "" {
+				continue
+			}
+			err = fs.Set(name, value)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %s: %w", path, name, err)
+			}
+			cfg.sources[name] = "file"
+		}
+	}
+
+	var envErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" || cfg.sources[f.Name] == "flag" {
+			return
+		}
+		for _, name := range envNames(f.Name) {
+			value := getenv(name)
+			if value == "" {
+				continue
+			}
+			err := fs.Set(f.Name, value)
+			if err != nil {
+				envErr = errors.Join(envErr, fmt.Errorf("%s: %w", name, err))
+			}
+			cfg.sources[f.Name] = "env " + name
+			return
+		}
+	})
+	if envErr != nil {
+		return nil, nil, envErr
+	}
+
+	return cfg, fs, nil
+}
+
+// envNames returns the environment variables read for a setting, in order of precedence
+func envNames(setting string) []string {
+	name := "CITYSTARS_" + strings.ToUpper(strings.ReplaceAll(setting, "-", "_"))
+	return append([]string{name}, envAliases[setting]...)
+}
+
+// readConfigFile returns the settings of a YAML or TOML file by flag name
+func readConfigFile(path string) (map[string]string, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]any
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(contents, &raw)
+	case ".toml":
+		err = toml.Unmarshal(contents, &raw)
+	default:
+		return nil, fmt.Errorf("%s: config files must be .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	settings := map[string]string{}
+	flattenSettings("", raw, settings)
+	return settings, nil
+}
+
+// flattenSettings adds the values of raw to settings, keys of nested tables
+// are joined with dashes and lists are joined with commas
+func flattenSettings(prefix string, raw map[string]any, settings map[string]string) {
+	for key, value := range raw {
+		name := strings.ReplaceAll(strings.ToLower(key), "_", "-")
+		if prefix != "" {
+			name = prefix + "-" + name
+		}
+
+		switch v := value.(type) {
+		case map[string]any:
+			flattenSettings(name, v, settings)
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			settings[name] = strings.Join(items, ",")
+		default:
+			settings[name] = fmt.Sprint(v)
+		}
+	}
+}
+
+// validateConfig checks the settings, refusing weak secrets and an ephemeral
+// signing key outside development
+func validateConfig(v *validator.Validator, cfg *config) {
+	v.Check(validator.PermittedValue(cfg.env, "development", "staging", "production"), "env", "must be development, staging or production")
+	v.Check(cfg.port > 0 && cfg.port <= 65535, "port", "must be between 1 and 65535")
+	v.Check(validator.PermittedValue(cfg.logFormat, "text", "json"), "log-format", "must be text or json")
+	v.Check(cfg.dsn != "", "db-dsn", "must be provided")
+
+	if cfg.env != "development" {
+		v.Check(cfg.jwt.signingKey != "", "jwt-signing-key", "must be provided outside development")
+
+		// Secrets left unset are fine, the features using them are off then
+		secrets := map[string]string{
+			"smtp-password":      cfg.smtp.password,
+			"sms-api-key":        cfg.sms.apiKey,
+			"oidc-client-secret": cfg.oidc.clientSecret,
+		}
+		if cfg.metrics.username != "" {
+			secrets["metrics-password"] = cfg.metrics.password
+		}
+		for name, secret := range secrets {
+			v.Check(!slices.Contains(weakSecrets, secret), name, "must not be a placeholder value outside development")
+		}
+	}
+	v.Check(cfg.metrics.username == "" || cfg.metrics.password != "", "metrics-password", "must be provided along with metrics-username")
+	v.Check(cfg.jwt.issuer != "", "jwt-issuer", "must be provided")
+	v.Check(cfg.jwt.audience != "", "jwt-audience", "must be provided")
+
+	v.Check(cfg.authTokenTTL > 0, "auth-token-ttl", "must be positive")
+	v.Check(cfg.mfa.issuer != "" && !strings.Contains(cfg.mfa.issuer, ":"), "mfa-issuer", "must be provided and not contain a colon")
+	v.Check(cfg.mfa.pendingTTL > 0 && cfg.mfa.pendingTTL <= time.Hour, "mfa-pending-ttl", "must be positive and at most an hour")
+	v.Check(cfg.requestTimeout > 0, "request-timeout", "must be positive")
+	v.Check(cfg.queryTimeout > 0, "db-query-timeout", "must be positive")
+	v.Check(cfg.shutdownTimeout > 0, "shutdown-timeout", "must be positive")
+	v.Check(cfg.notificationRetention > 0, "notification-retention", "must be positive")
+
+	v.Check(cfg.db.maxOpenConns > 0, "db-max-open-conns", "must be at least 1")
+	v.Check(cfg.db.maxIdleConns >= 0 && cfg.db.maxIdleConns <= cfg.db.maxOpenConns, "db-max-idle-conns", "must be between 0 and db-max-open-conns")
+	v.Check(cfg.db.connMaxIdleTime >= 0, "db-conn-max-idle-time", "must not be negative")
+	v.Check(cfg.db.connMaxLifetime >= 0, "db-conn-max-lifetime", "must not be negative")
+	v.Check(cfg.db.connectTimeout >= 0, "db-connect-timeout", "must not be negative")
+
+	v.Check(len(cfg.cors.trustedOrigins) > 0, "cors-trusted-origins", "must list at least one origin")
+	for _, origin := range cfg.cors.trustedOrigins {
+		u, err := url.Parse(origin)
+		valid := origin == "*" || (err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && u.Path == "")
+		v.Check(valid, "cors-trusted-origins", "invalid origin "+origin)
+	}
+
+	if cfg.oidc.issuer != "" {
+		v.Check(validURL(cfg.oidc.issuer), "oidc-issuer", "must be an http(s) URL")
+		v.Check(cfg.oidc.clientID != "", "oidc-client-id", "must be provided along with oidc-issuer")
+		v.Check(validURL(cfg.oidc.redirectURL), "oidc-redirect-url", "must be an http(s) URL")
+		v.Check(slices.Contains(cfg.oidc.scopes, "openid"), "oidc-scopes", "must include openid")
+		v.Check(cfg.oidc.groupsClaim != "", "oidc-groups-claim", "must be provided")
+		v.Check(cfg.oidc.postLoginURL == "" || validURL(cfg.oidc.postLoginURL), "oidc-post-login-url", "must be an http(s) URL")
+
+		_, err := parseGroupRoles(cfg.oidc.groupRoles)
+		v.Check(err == nil, "oidc-group-roles", fmt.Sprint(err))
+		v.Check(len(cfg.oidc.groupRoles) > 0, "oidc-group-roles", "must map at least one group")
+	}
+
+	v.Check(validator.PermittedValue(cfg.limiter.store, "memory", "postgres"), "limiter-store", "must be memory or postgres")
+	v.Check(cfg.limiter.rps > 0, "limiter-rps", "must be positive")
+	v.Check(cfg.limiter.burst > 0, "limiter-burst", "must be positive")
+	v.Check(cfg.otel.sampleRatio >= 0 && cfg.otel.sampleRatio <= 1, "otel-sample-ratio", "must be between 0 and 1")
+	v.Check(cfg.login.maxFailures > 0, "login-max-failures", "must be at least 1")
+	v.Check(cfg.login.lockout > 0 && cfg.login.lockout <= cfg.login.maxLockout, "login-lockout", "must be positive and at most login-max-lockout")
+}
+
+// configError turns the validation errors into a single error listing them by setting
+func configError(errs map[string]string) error {
+	names := make([]string, 0, len(errs))
+	for name := range errs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := make([]string, len(names))
+	for i, name := range names {
+		lines[i] = fmt.Sprintf("  %s: %s", name, errs[name])
+	}
+	return fmt.Errorf("invalid configuration:\n%s", strings.Join(lines, "\n"))
+}
+
+// printConfig writes the effective value and the source of every setting,
+// with secrets and the password of the DSN redacted
+func printConfig(w io.Writer, cfg *config, fs *flag.FlagSet) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SETTING\tVALUE\tSOURCE")
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" {
+			return
+		}
+
+		value := f.Value.String()
+		switch {
+		case secretSettings[f.Name] && value != "", strings.Contains(value, "-----BEGIN"):
+			value = "<redacted>"
+		case f.Name == "db-dsn" || f.Name == "db-replica-dsn":
+			value = redactDSN(value)
+		}
+
+		source := cfg.sources[f.Name]
+		if source == "" {
+			source = "default"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", f.Name, value, source)
+	})
+
+	return tw.Flush()
+}
+
+// dsnPasswordRegex matches the password of a key=value DSN
+var dsnPasswordRegex = regexp.MustCompile(`password=('[^']*'|\S+)`)
+
+// redactDSN hides the password of a URL or key=value DSN
+func redactDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		return u.Redacted()
+	}
+	return dsnPasswordRegex.ReplaceAllString(dsn, "password=xxxxx")
+}
+
+// configCommand runs the config subcommand, only "print" is supported
+func configCommand(args []string) error {
+	if len(args) == 0 || args[0] != "print" {
+		return errors.New("usage: api config print [flags]")
+	}
+
+	cfg, fs, err := loadConfig(args[1:], os.Getenv)
+	if err != nil {
+		return err
+	}
+
+	err = printConfig(os.Stdout, cfg, fs)
+	if err != nil {
+		return err
+	}
+
+	v := validator.New()
+	if validateConfig(v, cfg); !v.Valid() {
+		return configError(v.Errors)
+	}
+	return nil
+}
+
+// validURL reports whether value is an absolute http or https URL
+func validURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// parseGroupRoles parses the group=role mappings of oidc-group-roles
+func parseGroupRoles(mappings []string) (map[string]string, error) {
+	roles := make(map[string]string, len(mappings))
+	for _, mapping := range mappings {
+		group, role, ok := strings.Cut(mapping, "=")
+		if !ok || group == "" {
+			return nil, fmt.Errorf("invalid mapping %q, want group=role", mapping)
+		}
+		if !validator.PermittedValue(role, "user", "admin") {
+			return nil, fmt.Errorf("invalid role %q, must be user or admin", role)
+		}
+		roles[group] = role
+	}
+	return roles, nil
+}
+
+// stringList is a flag holding a comma separated list
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = nil
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
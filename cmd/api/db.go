@@ -3,30 +3,45 @@ package main
 import (
 	"context"
 	"database/sql"
+	"log/slog"
 	"time"
 
 	_ "github.com/lib/pq"
 )
 
-// openDB creates an sql connection using the provided connection string
-func openDB(dsn string) (*sql.DB, error) {
+// openDB creates an sql connection pool for dsn sized by the configuration.
+// The database may still be starting alongside the API, so the first ping is
+// retried with exponential backoff for up to db-connect-timeout
+func openDB(cfg *config, dsn string, logger *slog.Logger) (*sql.DB, error) {
 	// opens an connection and using postgres as the driver name
 	db, err := sql.Open("postgres", dsn)
 	if err != nil {
 		return nil, err
 	}
-	db.SetMaxOpenConns(4)
-	db.SetMaxIdleConns(2)
-	db.SetConnMaxLifetime(5 * time.Second)
+	db.SetMaxOpenConns(cfg.db.maxOpenConns)
+	db.SetMaxIdleConns(cfg.db.maxIdleConns)
+	db.SetConnMaxIdleTime(cfg.db.connMaxIdleTime)
+	db.SetConnMaxLifetime(cfg.db.connMaxLifetime)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	deadline := time.Now().Add(cfg.db.connectTimeout)
+	delay := 500 * time.Millisecond
 
-	// Ping the server to check if it available to use
-	err = db.PingContext(ctx)
-	if err != nil {
-		db.Close()
-		return nil, err
+	for attempt := 1; ; attempt++ {
+		// Ping the server to check if it available to use
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err = db.PingContext(ctx)
+		cancel()
+		if err == nil {
+			return db, nil
+		}
+
+		if time.Now().Add(delay).After(deadline) {
+			db.Close()
+			return nil, err
+		}
+
+		logger.Warn("database unreachable, retrying", "attempt", attempt, "retry_in", delay, "error", err)
+		time.Sleep(delay)
+		delay = min(delay*2, 5*time.Second)
 	}
-	return db, nil
 }
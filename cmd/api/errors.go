@@ -1,7 +1,14 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/VJ-2303/CityStars/internal/data"
 )
 
 // HanlerFunction for sending notFound error message
@@ -17,13 +24,14 @@ func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.
 }
 
 // logError used to log any error happened in a specific request
-// along with its method and request uri
+// along with its request id, method and request uri
 func (app *application) logError(r *http.Request, err error) {
 	var (
-		method = r.Method
-		uri    = r.URL.RequestURI()
+		requestID = requestIDFrom(r)
+		method    = r.Method
+		uri       = r.URL.RequestURI()
 	)
-	app.logger.Error(err.Error(), "method", method, "uri", uri)
+	app.logger.Error(err.Error(), "request_id", requestID, "method", method, "uri", uri)
 }
 
 // errorResponse sends an generic error message to the client enclosed by the envelope
@@ -35,13 +43,34 @@ func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, st
 	}
 }
 
-// this response is sented when the server had any unknown errors
+// this response is sented when the server had any unknown errors,
+// errors caused by the request deadline or the client going away
+// get their own responses
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	// The driver doesn't always return the context's error when a query is
+	// interrupted, so the request context is checked as well
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(r.Context().Err(), context.DeadlineExceeded):
+		app.timeoutResponse(w, r, err)
+		return
+	case errors.Is(r.Context().Err(), context.Canceled):
+		// The client is gone, nobody is left to read a response
+		app.logger.Info("request cancelled", "request_id", requestIDFrom(r), "method", r.Method, "uri", r.URL.RequestURI())
+		return
+	}
+
 	app.logError(r, err)
 	message := "The server encountered and error and could not process your request"
 	app.errorResponse(w, r, http.StatusInternalServerError, message)
 }
 
+// timeoutResponse is sent when the request ran out of time
+func (app *application) timeoutResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logError(r, err)
+	message := "the request took too long to process, please try again"
+	app.errorResponse(w, r, http.StatusGatewayTimeout, message)
+}
+
 // this response it sended when the request body is badly formed
 func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
 	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
@@ -64,3 +93,109 @@ func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter
 func (app *application) expiredTokenResponse(w http.ResponseWriter, r *http.Request) {
 	app.errorResponse(w, r, http.StatusUnauthorized, "Auth token is expired")
 }
+
+func (app *application) invalidAPIKeyResponse(w http.ResponseWriter, r *http.Request) {
+	app.errorResponse(w, r, http.StatusUnauthorized, "invalid, expired or revoked API key")
+}
+
+func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	app.errorResponse(w, r, http.StatusForbidden, "your credentials don't permit this action")
+}
+
+func (app *application) mfaRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	app.errorResponse(w, r, http.StatusForbidden, "this action requires two-factor authentication, log in with your authenticator code")
+}
+
+func (app *application) mfaAlreadyEnabledResponse(w http.ResponseWriter, r *http.Request) {
+	app.errorResponse(w, r, http.StatusConflict, "two-factor authentication is already enabled, disable it first")
+}
+
+func (app *application) invalidMFACodeResponse(w http.ResponseWriter, r *http.Request) {
+	app.failedValidationResponse(w, r, map[string]string{"code": "invalid or already used code"})
+}
+
+// oidcLoginFailedResponse logs why the login with the identity provider
+// failed, the client only learns that it did
+func (app *application) oidcLoginFailedResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Warn("oidc login failed", "request_id", requestIDFrom(r), "error", err)
+	app.errorResponse(w, r, http.StatusUnauthorized, "the login with the identity provider failed")
+}
+
+func (app *application) oidcForbiddenResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.errorResponse(w, r, http.StatusForbidden, err.Error())
+}
+
+// preconditionRequiredResponse is sent for updates which don't say which
+// version of the resource they apply to
+func (app *application) preconditionRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	app.errorResponse(w, r, http.StatusPreconditionRequired, "send the ETag of the report in an If-Match header, or its version in the request body")
+}
+
+// editConflictResponse is sent when the report changed since the client
+// read it, along with the current report to apply the change to again
+func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request, current *data.Report) {
+	w.Header().Set("ETag", versionETag(current.Version))
+	err := app.writeJSON(w, http.StatusConflict, envelope{
+		"error":  "the report was changed by someone else in the meantime, review the current report and try again",
+		"report": current,
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// idempotencyKeyMismatchResponse is sent when the key was first used
+// for a different request
+func (app *application) idempotencyKeyMismatchResponse(w http.ResponseWriter, r *http.Request) {
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, "the Idempotency-Key was already used for a different request, use a new key for a new request")
+}
+
+// idempotencyKeyInUseResponse is sent for a retry arriving while the
+// first request with the key is still running
+func (app *application) idempotencyKeyInUseResponse(w http.ResponseWriter, r *http.Request) {
+	setRetryAfter(w, time.Second)
+	app.errorResponse(w, r, http.StatusConflict, "a request with this Idempotency-Key is still in progress, try again later")
+}
+
+// notReportOwnerResponse is sent when a citizen changes a report they
+// didn't submit, their token is valid but doesn't permit it
+func (app *application) notReportOwnerResponse(w http.ResponseWriter, r *http.Request) {
+	app.errorResponse(w, r, http.StatusForbidden, "only the reporter may change this report")
+}
+
+// reportNotEditableResponse is sent when the status of the report no longer
+// allows its reporter to edit or withdraw it
+func (app *application) reportNotEditableResponse(w http.ResponseWriter, r *http.Request) {
+	app.errorResponse(w, r, http.StatusConflict, "the report can only be edited while pending, and withdrawn until it is resolved")
+}
+
+// reportWithdrawnResponse is sent when the status of a report its reporter
+// withdrew is updated
+func (app *application) reportWithdrawnResponse(w http.ResponseWriter, r *http.Request) {
+	app.errorResponse(w, r, http.StatusConflict, "the report was withdrawn by its reporter and can no longer be updated")
+}
+
+func (app *application) accountSuspendedResponse(w http.ResponseWriter, r *http.Request) {
+	app.errorResponse(w, r, http.StatusForbidden, "your account has been suspended")
+}
+
+// rateLimitExceededResponse tells the client to slow down, along with
+// how many seconds to wait before trying again
+func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	setRetryAfter(w, retryAfter)
+	app.errorResponse(w, r, http.StatusTooManyRequests, "rate limit exceeded, try again later")
+}
+
+func (app *application) loginLockedResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	setRetryAfter(w, retryAfter)
+	app.errorResponse(w, r, http.StatusTooManyRequests, "too many failed login attempts, try again later")
+}
+
+// setRetryAfter sets the Retry-After header in whole seconds, at least one
+func setRetryAfter(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"net/http"
+	"time"
 )
 
 func (app *application) healtchCheckHandler(w http.ResponseWriter, r *http.Request) {
@@ -10,7 +12,57 @@ func (app *application) healtchCheckHandler(w http.ResponseWriter, r *http.Reque
 		"environment": app.config.env,
 		"version":     version,
 	}
-	err := app.writeJSON(w, http.StatusOK, envelope{"server": data})
+
+	// Report the connection pools, a growing wait count tells the pool is too small
+	pools := map[string]map[string]any{}
+	for name, pool := range app.pools {
+		stats := pool.Stats()
+		pools[name] = map[string]any{
+			"max_open_connections": stats.MaxOpenConnections,
+			"open_connections":     stats.OpenConnections,
+			"in_use":               stats.InUse,
+			"idle":                 stats.Idle,
+			"wait_count":           stats.WaitCount,
+			"wait_duration":        stats.WaitDuration.String(),
+		}
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"server": data, "database": pools})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// pinger is implemented by *sql.DB, the readiness check only needs to ping it
+type pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+// readyzHandler reports whether the instance should receive traffic, it
+// fails while the server is draining or when the database can't be reached
+func (app *application) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{
+		"server":   "ok",
+		"database": "ok",
+	}
+	status := http.StatusOK
+
+	if app.draining.Load() {
+		checks["server"] = "draining"
+		status = http.StatusServiceUnavailable
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	err := app.db.PingContext(ctx)
+	if err != nil {
+		app.logger.Warn("readiness check failed", "error", err)
+		checks["database"] = "unavailable"
+		status = http.StatusServiceUnavailable
+	}
+
+	err = app.writeJSON(w, status, envelope{"checks": checks})
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"testing"
+)
+
+func TestHealthcheck(t *testing.T) {
+	app := newTestApplication(t)
+
+	res := app.do(t, http.MethodGet, "/v1/healthcheck", "", nil)
+	checkStatus(t, res, http.StatusOK)
+
+	var server map[string]string
+	decodeField(t, res, "server", &server)
+	if server["status"] != "available" || server["version"] != version {
+		t.Errorf("unexpected server info %v", server)
+	}
+}
+
+func TestHealthcheckPoolStats(t *testing.T) {
+	app := newTestApplication(t)
+
+	// Opening a pool doesn't connect, its statistics are available right away
+	db, err := sql.Open("postgres", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(7)
+	app.pools = map[string]*sql.DB{"primary": db}
+
+	res := app.do(t, http.MethodGet, "/v1/healthcheck", "", nil)
+	checkStatus(t, res, http.StatusOK)
+
+	var pools map[string]struct {
+		MaxOpenConnections int `json:"max_open_connections"`
+		OpenConnections    int `json:"open_connections"`
+	}
+	decodeField(t, res, "database", &pools)
+	if len(pools) != 1 || pools["primary"].MaxOpenConnections != 7 || pools["primary"].OpenConnections != 0 {
+		t.Errorf("unexpected pool stats %+v", pools)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name     string
+		draining bool
+		pingErr  error
+		want     int
+		checks   map[string]string
+	}{
+		{"ready", false, nil, http.StatusOK, map[string]string{"server": "ok", "database": "ok"}},
+		{"draining", true, nil, http.StatusServiceUnavailable, map[string]string{"server": "draining", "database": "ok"}},
+		{"database down", false, errTest, http.StatusServiceUnavailable, map[string]string{"server": "ok", "database": "unavailable"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.draining.Store(tt.draining)
+			app.db = &fakePinger{err: tt.pingErr}
+
+			res := app.do(t, http.MethodGet, "/v1/readyz", "", nil)
+			checkStatus(t, res, tt.want)
+
+			var checks map[string]string
+			decodeField(t, res, "checks", &checks)
+			for k, v := range tt.checks {
+				if checks[k] != v {
+					t.Errorf("check %s = %q, want %q", k, checks[k], v)
+				}
+			}
+		})
+	}
+}
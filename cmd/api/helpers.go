@@ -123,3 +123,40 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 	}
 	return nil
 }
+
+// versionETag returns the strong ETag of the given version of a resource
+func versionETag(version int32) string {
+	return `"` + strconv.FormatInt(int64(version), 10) + `"`
+}
+
+// readIfMatch returns the version the request's update applies to, from the
+// If-Match header or else the version field of the body. If-Match: * puts no
+// constraint on the version, so the body's version applies if there is one
+// and otherwise a zero version, meaning any. ok is false when neither was sent
+func (app *application) readIfMatch(r *http.Request, bodyVersion *int32) (version int32, ok bool, err error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		if bodyVersion == nil {
+			return 0, header == "*", nil
+		}
+		if *bodyVersion < 1 {
+			return 0, false, errors.New("version must be a positive integer")
+		}
+		return *bodyVersion, true, nil
+	}
+
+	unquoted, err := strconv.Unquote(header)
+	if err != nil {
+		return 0, false, errors.New("If-Match must be an ETag returned by the API")
+	}
+	v, err := strconv.ParseInt(unquoted, 10, 32)
+	if err != nil || v < 1 {
+		return 0, false, errors.New("If-Match must be an ETag returned by the API")
+	}
+	version = int32(v)
+
+	if bodyVersion != nil && *bodyVersion != version {
+		return 0, false, errors.New("the If-Match header and the version field don't match")
+	}
+	return version, true, nil
+}
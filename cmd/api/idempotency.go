@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/VJ-2303/CityStars/internal/data"
+)
+
+const (
+	// idempotencyKeyHeader carries the client's key of a mutating request
+	idempotencyKeyHeader = "Idempotency-Key"
+
+	// idempotencyKeyTTL is how long the response to a key is replayed
+	idempotencyKeyTTL = 24 * time.Hour
+)
+
+// replayedHeaders are the response headers stored along with the body,
+// the others describe the response being sent rather than its content
+var replayedHeaders = []string{"Content-Type", "ETag", "Location"}
+
+// idempotent makes retries of the request safe when the client sends an
+// Idempotency-Key header. The first request with a key runs and its response
+// is stored for a day, a retry with the same key and request gets the stored
+// response back and one with a different request is rejected. Keys are
+// scoped to the authenticated user or API key, so it has to run after the
+// authentication middleware
+func (app *application) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !validIdempotencyKey(key) {
+			app.badRequestResponse(w, r, errors.New("the Idempotency-Key header must be 1 to 255 printable ASCII characters"))
+			return
+		}
+
+		client, ok := idempotencyClient(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// The body is read up front to hash it, and handed on to the handler
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1_048_576))
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				app.badRequestResponse(w, r, fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit))
+				return
+			}
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		k := &data.IdempotencyKey{
+			Client:      client,
+			Key:         key,
+			RequestHash: requestHash(r.Method, r.URL.RequestURI(), body),
+			ExpiresAt:   time.Now().Add(idempotencyKeyTTL),
+		}
+
+		// A request left in progress longer than it may run was abandoned
+		existing, err := app.models.Idempotency.Reserve(r.Context(), k, 2*app.config.requestTimeout)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if existing != nil {
+			switch {
+			case !bytes.Equal(existing.RequestHash, k.RequestHash):
+				app.idempotencyKeyMismatchResponse(w, r)
+			case !existing.Completed():
+				app.idempotencyKeyInUseResponse(w, r)
+			default:
+				for name, value := range existing.Header {
+					w.Header().Set(name, value)
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(existing.Status)
+				w.Write(existing.Body)
+			}
+			return
+		}
+
+		rec := newIdempotencyRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		// The outcome is stored even when the client went away in the
+		// meantime, as that is when it is going to retry
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 5*time.Second)
+		defer cancel()
+
+		// Only responses the request itself settled are replayed, a retry
+		// of a failed or throttled request runs again
+		if rec.status == 0 || rec.status == http.StatusTooManyRequests || rec.status >= 500 {
+			err = app.models.Idempotency.Release(ctx, client, key)
+		} else {
+			err = app.models.Idempotency.Complete(ctx, client, key, rec.status, rec.header, rec.body.Bytes())
+		}
+		if err != nil {
+			app.logError(r, err)
+		}
+	})
+}
+
+// validIdempotencyKey accepts keys of up to 255 printable ASCII characters,
+// which covers the UUIDs clients usually send
+func validIdempotencyKey(key string) bool {
+	if len(key) > 255 {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < '!' || key[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestHash identifies the request a key was used for by its method,
+// URI and body
+func requestHash(method, uri string, body []byte) []byte {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n", method, uri)
+	hash.Write(body)
+	return hash.Sum(nil)
+}
+
+// idempotencyClient returns who the keys of the request belong to, the
+// user or the API key it authenticated with
+func idempotencyClient(r *http.Request) (string, bool) {
+	if userID, ok := r.Context().Value(userIDKey).(int64); ok {
+		return "user:" + strconv.FormatInt(userID, 10), true
+	}
+	if apiKey, ok := r.Context().Value(apiKeyKey).(*data.APIKey); ok {
+		return "api_key:" + strconv.FormatInt(apiKey.ID, 10), true
+	}
+	return "", false
+}
+
+// pruneIdempotencyKeys periodically deletes the keys past their expiry,
+// it runs until ctx is cancelled
+func (app *application) pruneIdempotencyKeys(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		deleted, err := app.models.Idempotency.DeleteExpired(ctx)
+		if err != nil {
+			app.logger.Error("failed to prune idempotency keys", "error", err)
+		} else if deleted > 0 {
+			app.logger.Info("pruned expired idempotency keys", "deleted", deleted)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// idempotencyRecorder passes the response through while keeping a copy of
+// its status, replayed headers and body to store for the key
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	header map[string]string
+	body   bytes.Buffer
+}
+
+func newIdempotencyRecorder(w http.ResponseWriter) *idempotencyRecorder {
+	return &idempotencyRecorder{ResponseWriter: w}
+}
+
+func (rw *idempotencyRecorder) WriteHeader(status int) {
+	if rw.status == 0 {
+		// The headers set after this point aren't sent, so neither are they replayed
+		rw.status = status
+		rw.header = map[string]string{}
+		for _, name := range replayedHeaders {
+			if value := rw.Header().Get(name); value != "" {
+				rw.header[name] = value
+			}
+		}
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *idempotencyRecorder) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
+
+func (rw *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VJ-2303/CityStars/internal/data"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	app := newTestApplication(t)
+	user := app.insertUser(t, "Priya Raman", "9000000001", "pa55word-1234", "user")
+	other := app.insertUser(t, "Arun Kumar", "9000000002", "pa55word-1234", "user")
+
+	report := `{"title": "Pothole on Anna Salai", "description": "A deep pothole near the bus stop", "category": "pothole", "location": "Anna Salai, Chennai", "before_image": "https://img.example.com/before.jpg"}`
+	edited := `{"title": "Pothole on Mount Road", "description": "A deep pothole near the bus stop", "category": "pothole", "location": "Anna Salai, Chennai", "before_image": "https://img.example.com/before.jpg"}`
+
+	create := func(user *data.User, key, body string) testResponse {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodPost, "/v1/reports", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+app.tokenFor(t, user))
+		if key != "" {
+			r.Header.Set(idempotencyKeyHeader, key)
+		}
+		return app.send(t, r)
+	}
+	reportID := func(res testResponse) int64 {
+		t.Helper()
+		var report testReport
+		decodeField(t, res, "report", &report)
+		return report.ID
+	}
+
+	first := create(user, "retry-1", report)
+	checkStatus(t, first, http.StatusCreated)
+	if first.header.Get("Idempotent-Replayed") != "" {
+		t.Error("the first response is marked as replayed")
+	}
+
+	t.Run("retry", func(t *testing.T) {
+		res := create(user, "retry-1", report)
+		checkStatus(t, res, http.StatusCreated)
+		if res.header.Get("Idempotent-Replayed") != "true" {
+			t.Error("the replayed response isn't marked as replayed")
+		}
+		if reportID(res) != reportID(first) {
+			t.Errorf("got report %d, want the first report %d", reportID(res), reportID(first))
+		}
+	})
+
+	t.Run("different request", func(t *testing.T) {
+		res := create(user, "retry-1", edited)
+		checkStatus(t, res, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("other user", func(t *testing.T) {
+		res := create(other, "retry-1", report)
+		checkStatus(t, res, http.StatusCreated)
+		if res.header.Get("Idempotent-Replayed") != "" || reportID(res) == reportID(first) {
+			t.Error("the key of another user was replayed")
+		}
+	})
+
+	t.Run("without key", func(t *testing.T) {
+		a, b := create(user, "", report), create(user, "", report)
+		checkStatus(t, a, http.StatusCreated)
+		checkStatus(t, b, http.StatusCreated)
+		if reportID(a) == reportID(b) {
+			t.Error("requests without a key were deduplicated")
+		}
+	})
+
+	t.Run("invalid key", func(t *testing.T) {
+		res := create(user, strings.Repeat("k", 256), report)
+		checkStatus(t, res, http.StatusBadRequest)
+	})
+
+	t.Run("in progress", func(t *testing.T) {
+		k := &data.IdempotencyKey{
+			Client:      fmt.Sprintf("user:%d", user.ID),
+			Key:         "retry-2",
+			RequestHash: requestHash(http.MethodPost, "/v1/reports", []byte(report)),
+			ExpiresAt:   time.Now().Add(time.Hour),
+		}
+		_, err := app.models.Idempotency.Reserve(context.Background(), k, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res := create(user, "retry-2", report)
+		checkStatus(t, res, http.StatusConflict)
+		if res.header.Get("Retry-After") == "" {
+			t.Error("the response has no Retry-After header")
+		}
+	})
+
+	t.Run("failed request", func(t *testing.T) {
+		// A request rejected by the handler is settled, the retry is replayed
+		res := create(user, "retry-4", `{"title": ""}`)
+		checkStatus(t, res, http.StatusUnprocessableEntity)
+		res = create(user, "retry-4", `{"title": ""}`)
+		checkStatus(t, res, http.StatusUnprocessableEntity)
+		if res.header.Get("Idempotent-Replayed") != "true" {
+			t.Error("the rejected request ran again")
+		}
+	})
+}
+
+func TestIdempotentAdminCreates(t *testing.T) {
+	app := newTestApplication(t)
+	admin := app.insertUser(t, "Admin Person", "9000000001", "pa55word-1234", "admin")
+	token := app.tokenFor(t, admin)
+
+	tests := []struct {
+		name  string
+		path  string
+		body  string
+		field string
+	}{
+		{"webhook", "/v1/admin/webhooks", `{"url": "https://hooks.example.com/citystars", "event_types": ["report.created"]}`, "webhook"},
+		{"api key", "/v1/admin/api-keys", `{"name": "Contractor", "scopes": ["reports:read"]}`, "api_key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			create := func() testResponse {
+				t.Helper()
+				r := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+				r.Header.Set("Authorization", "Bearer "+token)
+				r.Header.Set(idempotencyKeyHeader, "create-"+tt.field)
+				return app.send(t, r)
+			}
+			id := func(res testResponse) int64 {
+				t.Helper()
+				var created struct {
+					ID int64 `json:"id"`
+				}
+				decodeField(t, res, tt.field, &created)
+				return created.ID
+			}
+
+			first := create()
+			checkStatus(t, first, http.StatusCreated)
+
+			// The retry gets the secret of the first response back rather
+			// than creating a second one
+			retry := create()
+			checkStatus(t, retry, http.StatusCreated)
+			if retry.header.Get("Idempotent-Replayed") != "true" {
+				t.Error("the retry isn't marked as replayed")
+			}
+			if id(retry) != id(first) || fmt.Sprint(retry.body) != fmt.Sprint(first.body) {
+				t.Errorf("got %v, want the first response %v", retry.body, first.body)
+			}
+		})
+	}
+}
@@ -1,80 +1,111 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/VJ-2303/CityStars/internal/data"
+	"github.com/VJ-2303/CityStars/internal/events"
+	"github.com/VJ-2303/CityStars/internal/keyring"
+	"github.com/VJ-2303/CityStars/internal/migrate"
+	"github.com/VJ-2303/CityStars/internal/notifier"
+	"github.com/VJ-2303/CityStars/internal/ratelimit"
+	"github.com/VJ-2303/CityStars/internal/validator"
+	"github.com/VJ-2303/CityStars/internal/webhook"
+	"github.com/VJ-2303/CityStars/migrations"
 )
 
 const version = "1.0.0"
 
-// config holds configuration settings for the application,
-// including server port, environment, database connection string, and JWT secret.
-type config struct {
-	port      int    // Port number for HTTP server
-	env       string // Application environment ("development", "staging", "production")
-	dsn       string // PostgreSQL database connection string
-	jwtSecret string // Secret key for signing JWT tokens
-}
-
 // application aggregates the application's dependencies and configuration.
 type application struct {
-	config config       // Application configuration
-	logger *slog.Logger // Structured logger instance
-	models data.Models  // Data models for database access
+	config    config             // Application configuration
+	logger    *slog.Logger       // Structured logger instance
+	models    data.Models        // Data models for database access
+	keys      *keyring.Keyring   // Keys signing and verifying the JWTs
+	oidc      *oidcProvider      // Identity provider of city staff, nil when OIDC login is off
+	events    *events.Hub        // In-process hub the event streams subscribe to
+	publisher events.Publisher   // Publisher report writes hand their events to
+	webhooks  *webhook.Sender    // Client posting signed webhook deliveries
+	notifier  *notifier.Notifier // Email and SMS notification channels
+	limiter   ratelimit.Store    // Token buckets for request rate limiting
+	metrics   *metrics           // Prometheus collectors
+
+	outboxHandlers map[string][]namedOutboxHandler // Consumers of outbox events by event type
+
+	db          pinger             // Connection pool, pinged by the readiness check
+	pools       map[string]*sql.DB // Connection pools by role, reported by the healthcheck
+	tasks       context.Context    // Cancelled when background tasks must stop
+	stopTasks   context.CancelFunc // Cancels tasks
+	wg          sync.WaitGroup     // Tracks running background tasks
+	draining    atomic.Bool        // Set once the shutdown has started
+	streamsDone chan struct{}      // Closed once the shutdown has started
 }
 
 func main() {
-	var cfg config // Create a config struct to hold flag values
-
-	// Get port from environment variable (Railway sets this)
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "4000" // Default port if not set
+	// Manage the database schema instead of serving when asked to
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err := migrateCommand(os.Args[2:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
 	}
 
-	// Get database DSN from environment (Railway uses DATABASE_URL)
-	dsn := os.Getenv("DATABASE_URL")
-	if dsn == "" {
-		dsn = os.Getenv("DB_DSN") // Fallback
+	// Print the effective configuration instead of serving when asked to
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		err := configCommand(os.Args[2:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
 	}
 
-	// Get JWT secret from environment
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		jwtSecret = "mysecretkey" // Default for development only
+	// Layer the config file, the environment and the flags over the defaults,
+	// refusing to start with an invalid or insecure configuration.
+	cfg, _, err := loadConfig(os.Args[1:], os.Getenv)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
 
-	// Get environment from env variable
-	environment := os.Getenv("ENVIRONMENT")
-	if environment == "" {
-		environment = "production"
+	v := validator.New()
+	if validateConfig(v, cfg); !v.Valid() {
+		fmt.Fprintln(os.Stderr, configError(v.Errors))
+		os.Exit(2)
 	}
 
-	// Parse command-line flags into the config struct.
-	flag.IntVar(&cfg.port, "port", 4000, "API server port")
-	flag.StringVar(&cfg.env, "env", environment, "Environment (development|staging|production)")
-	flag.StringVar(&cfg.jwtSecret, "jwt-secret", jwtSecret, "JWT secret string")
-	flag.StringVar(&cfg.dsn, "db-dsn", dsn, "Postgres DB connection string")
-	flag.Parse()
-
-	// Initialize a new logger that writes structured logs to standard output.
-	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	// Initialize a new logger that writes structured logs to standard output,
+	// as JSON when the logs are collected by a log aggregator.
+	var handler slog.Handler = slog.NewTextHandler(os.Stdout, nil)
+	if cfg.logFormat == "json" {
+		handler = slog.NewJSONHandler(os.Stdout, nil)
+	}
+	logger := slog.New(handler)
 
 	// Log configuration for debugging
 	logger.Info("configuration loaded",
 		"port", cfg.port,
 		"env", cfg.env,
 		"has_dsn", cfg.dsn != "",
-		"has_jwt_secret", cfg.jwtSecret != "")
+		"has_jwt_signing_key", cfg.jwt.signingKey != "")
 
 	// Attempt to open a database connection using the provided DSN.
-	db, err := openDB(cfg.dsn)
+	db, err := openDB(cfg, cfg.dsn, logger)
 	if err != nil {
 		logger.Error("failed to connect to database", "error", err)
 		os.Exit(1)
@@ -83,29 +114,169 @@ func main() {
 
 	logger.Info("database connection pool established")
 
+	// Serve the report lists and stats from the read replica, if one is configured.
+	pools := map[string]*sql.DB{"primary": db}
+	models := data.NewModels(db, cfg.queryTimeout)
+	if cfg.replicaDSN != "" {
+		replica, err := openDB(cfg, cfg.replicaDSN, logger)
+		if err != nil {
+			logger.Error("failed to connect to read replica", "error", err)
+			os.Exit(1)
+		}
+		defer replica.Close()
+
+		pools["replica"] = replica
+		models.Reports = data.ReportModel{DB: db, ReadDB: replica, Timeout: cfg.queryTimeout}
+		logger.Info("read replica connection pool established")
+	}
+
+	// Bring the schema up to date, replicas starting together take turns
+	// through an advisory lock so the migrations are applied only once.
+	if cfg.autoMigrate {
+		migrator := migrate.Migrator{DB: db, FS: migrations.FS, Logger: logger}
+		applied, err := migrator.Up(context.Background())
+		if err != nil {
+			logger.Error("failed to apply migrations", "error", err)
+			os.Exit(1)
+		}
+		logger.Info("database schema up to date", "applied", applied)
+	}
+
+	// Load the keys signing and verifying the authentication tokens.
+	keys, err := loadKeyring(cfg, logger)
+	if err != nil {
+		logger.Error("failed to load JWT keys", "error", err)
+		os.Exit(1)
+	}
+
+	// Discover the identity provider of city staff, if one is configured.
+	var oidcProv *oidcProvider
+	if cfg.oidc.issuer != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		oidcProv, err = newOIDCProvider(ctx, cfg)
+		cancel()
+		if err != nil {
+			logger.Error("failed to set up the OIDC provider", "issuer", cfg.oidc.issuer, "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// Export traces to the OpenTelemetry collector, if one is configured.
+	shutdownTracing, err := setupTracing(*cfg)
+	if err != nil {
+		logger.Error("failed to set up tracing", "error", err)
+		os.Exit(1)
+	}
+
+	// Share stream events between replicas through Postgres LISTEN/NOTIFY.
+	hub := events.NewHub(1000)
+	broker := events.NewPGBroker(db, cfg.dsn, hub, logger)
+
+	// Set up the email and SMS channels, falling back to logging
+	// the messages for channels without a provider configured.
+	ntf, err := notifier.New()
+	if err != nil {
+		logger.Error("failed to load notification templates", "error", err)
+		os.Exit(1)
+	}
+	ntf.Register(notifier.ChannelEmail, notifier.LogDriver{Logger: logger})
+	ntf.Register(notifier.ChannelSMS, notifier.LogDriver{Logger: logger})
+	if cfg.smtp.host != "" {
+		ntf.Register(notifier.ChannelEmail, notifier.SMTPDriver{
+			Host:     cfg.smtp.host,
+			Port:     cfg.smtp.port,
+			Username: cfg.smtp.username,
+			Password: cfg.smtp.password,
+			Sender:   cfg.smtp.sender,
+		})
+	}
+	if cfg.sms.url != "" {
+		ntf.Register(notifier.ChannelSMS, notifier.SMSDriver{
+			URL:    cfg.sms.url,
+			APIKey: cfg.sms.apiKey,
+			Sender: cfg.sms.sender,
+			Client: &http.Client{Timeout: 10 * time.Second},
+		})
+	}
+
+	// Keep the rate limiter buckets in memory, or in Postgres
+	// when several replicas must share them.
+	var limiter ratelimit.Store
+	switch cfg.limiter.store {
+	case "memory":
+		limiter = ratelimit.NewMemoryStore()
+	case "postgres":
+		limiter = ratelimit.PostgresStore{DB: db}
+	}
+
+	// Background tasks run until this context is cancelled on shutdown.
+	tasks, stopTasks := context.WithCancel(context.Background())
+
 	// Create the application struct, injecting configuration, logger, and models.
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
+		config:      *cfg,
+		logger:      logger,
+		models:      models,
+		keys:        keys,
+		oidc:        oidcProv,
+		events:      hub,
+		publisher:   broker,
+		webhooks:    webhook.NewSender(10 * time.Second),
+		notifier:    ntf,
+		limiter:     limiter,
+		metrics:     newMetrics(),
+		db:          db,
+		pools:       pools,
+		tasks:       tasks,
+		stopTasks:   stopTasks,
+		streamsDone: make(chan struct{}),
 	}
 
-	// Configure the HTTP server with custom timeouts and error logging.
-	srv := &http.Server{
-		Addr:         fmt.Sprintf("0.0.0.0:%d", cfg.port),                  // Bind to all interfaces for Railway
-		Handler:      app.routes(),                                         // HTTP handler with application routes
-		IdleTimeout:  time.Minute,                                          // Maximum idle connection duration
-		ReadTimeout:  15 * time.Second,                                     // Maximum duration for reading the request
-		WriteTimeout: 15 * time.Second,                                     // Maximum duration for writing the response
-		ErrorLog:     slog.NewLogLogger(logger.Handler(), slog.LevelError), // Use structured logger for server errors
+	// Expose the connection pool statistics alongside the request metrics
+	for name, pool := range pools {
+		app.metrics.registerDB(pool, name)
 	}
 
-	logger.Info("starting server", "addr", srv.Addr, "env", cfg.env)
+	// Feed the events published by every replica into the local hub
+	app.background(func(ctx context.Context) {
+		err := broker.Listen(ctx)
+		if err != nil {
+			logger.Error("event listener stopped", "error", err)
+		}
+	})
+
+	// Dispatch outbox events to their handlers in the background
+	app.registerOutboxHandlers()
+	app.background(app.dispatchOutbox)
 
-	// Start the HTTP server and block until it stops.
-	err = srv.ListenAndServe()
+	// Prune old notifications in the background
+	app.background(app.pruneNotifications)
 
-	// Log any server error (e.g., shutdown or unexpected failure) and exit.
-	logger.Error("server stopped", "error", err)
-	os.Exit(1)
+	// Prune expired idempotency keys in the background
+	app.background(app.pruneIdempotencyKeys)
+
+	// Deliver queued webhooks in the background
+	app.background(app.deliverWebhooks)
+
+	// Send queued email and SMS notifications in the background
+	app.background(app.sendNotificationMessages)
+
+	// Forget idle rate limiter buckets in the background
+	app.background(app.cleanupRateLimiter)
+
+	// Run the server until it is told to shut down.
+	err = app.serve()
+	if err != nil {
+		logger.Error("server stopped", "error", err)
+		os.Exit(1)
+	}
+
+	// Flush the spans still buffered by the exporter.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = shutdownTracing(ctx)
+	if err != nil {
+		logger.Error("failed to flush traces", "error", err)
+	}
 }
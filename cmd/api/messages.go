@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/VJ-2303/CityStars/internal/data"
+	"github.com/VJ-2303/CityStars/internal/notifier"
+	"github.com/VJ-2303/CityStars/internal/validator"
+)
+
+// Message retry policy, failed messages are retried with an exponential
+// backoff starting at messageBaseBackoff until messageMaxAttempts is reached
+const (
+	messageMaxAttempts = 5
+	messageBaseBackoff = time.Minute
+)
+
+// messageData is the data the message templates are rendered with
+type messageData struct {
+	UserName       string
+	Report         data.ReportSummary
+	PreviousStatus string
+}
+
+// GetNotificationPreferencesHandler returns the authenticated user's channel preferences
+func (app *application) GetNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(userIDKey).(int64)
+
+	prefs, err := app.models.Preferences.Get(r.Context(), userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"preferences": prefs})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// UpdateNotificationPreferencesHandler partially updates the authenticated
+// user's channel preferences, fields left out of the body are kept
+func (app *application) UpdateNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(userIDKey).(int64)
+
+	prefs, err := app.models.Preferences.Get(r.Context(), userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var input struct {
+		Email           *string `json:"email"`
+		EmailEnabled    *bool   `json:"email_enabled"`
+		SMSEnabled      *bool   `json:"sms_enabled"`
+		Locale          *string `json:"locale"`
+		QuietHoursStart *string `json:"quiet_hours_start"`
+		QuietHoursEnd   *string `json:"quiet_hours_end"`
+		Timezone        *string `json:"timezone"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Email != nil {
+		prefs.Email = *input.Email
+	}
+	if input.EmailEnabled != nil {
+		prefs.EmailEnabled = *input.EmailEnabled
+	}
+	if input.SMSEnabled != nil {
+		prefs.SMSEnabled = *input.SMSEnabled
+	}
+	if input.Locale != nil {
+		prefs.Locale = *input.Locale
+	}
+	if input.QuietHoursStart != nil {
+		prefs.QuietHoursStart = *input.QuietHoursStart
+	}
+	if input.QuietHoursEnd != nil {
+		prefs.QuietHoursEnd = *input.QuietHoursEnd
+	}
+	if input.Timezone != nil {
+		prefs.Timezone = *input.Timezone
+	}
+
+	v := validator.New()
+	if data.ValidateNotificationPreferences(v, prefs); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Preferences.Upsert(r.Context(), prefs)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"preferences": prefs})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// ListNotificationMessagesHandler returns the emails and text messages
+// sent to the authenticated user along with their send status
+func (app *application) ListNotificationMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(userIDKey).(int64)
+
+	// Parse query parameters for pagination
+	qs := r.URL.Query()
+
+	limit := 50 // default limit
+	if limitStr := qs.Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 100 {
+			limit = l
+		}
+	}
+
+	offset := 0
+	if offsetStr := qs.Get("offset"); offsetStr != "" {
+		if o, err := strconv.Atoi(offsetStr); err == nil && o >= 0 {
+			offset = o
+		}
+	}
+
+	messages, err := app.models.Messages.GetForUser(r.Context(), userID, limit, offset)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"messages": messages})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// queueStatusChangeMessages renders and queues an email and/or text message
+// for the reporter when their report moves to a new status, according to
+// their channel preferences and quiet hours. Withdrawals are left out, the
+// reporter made them
+func (app *application) queueStatusChangeMessages(ctx context.Context, e *data.OutboxEvent) error {
+	var event data.ReportEvent
+	err := json.Unmarshal(e.Payload, &event)
+	if err != nil {
+		return err
+	}
+
+	if !event.StatusChanged() || event.Withdrawn() {
+		return nil
+	}
+
+	prefs, err := app.models.Preferences.Get(ctx, event.Report.UserID)
+	if err != nil {
+		return err
+	}
+	if !prefs.EmailEnabled && !prefs.SMSEnabled {
+		return nil
+	}
+
+	user, err := app.models.Users.GetByID(ctx, event.Report.UserID)
+	if err != nil {
+		if errors.Is(err, data.ErrUserNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	loc, err := time.LoadLocation(prefs.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	quiet := notifier.QuietHours{Start: prefs.QuietHoursStart, End: prefs.QuietHoursEnd, Location: loc}
+	sendAfter := quiet.NextAllowed(time.Now())
+
+	recipients := map[string]string{}
+	if prefs.EmailEnabled {
+		recipients[notifier.ChannelEmail] = prefs.Email
+	}
+	if prefs.SMSEnabled {
+		recipients[notifier.ChannelSMS] = user.PhoneNumber
+	}
+
+	templateData := messageData{
+		UserName:       user.Name,
+		Report:         event.Report,
+		PreviousStatus: event.PreviousStatus,
+	}
+
+	for channel, recipient := range recipients {
+		subject, body, err := app.notifier.Render(data.NotificationStatusChanged, prefs.Locale, channel, templateData)
+		if err != nil {
+			return err
+		}
+
+		msg := &data.NotificationMessage{
+			UserID:    user.ID,
+			Channel:   channel,
+			EventType: data.NotificationStatusChanged,
+			Recipient: recipient,
+			Subject:   subject,
+			Body:      body,
+			SendAfter: data.Time(sendAfter),
+			EventKey:  e.IdempotencyKey,
+		}
+
+		err = app.models.Messages.Enqueue(ctx, msg)
+		if err != nil && !errors.Is(err, data.ErrDuplicateEvent) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sendNotificationMessages polls the message queue and sends due messages
+// through their channel driver, it runs until ctx is cancelled
+func (app *application) sendNotificationMessages(ctx context.Context) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		for {
+			messages, err := app.models.Messages.ClaimDue(ctx, 20, 2*time.Minute)
+			if err != nil {
+				app.logger.Error("failed to claim notification messages", "error", err)
+				break
+			}
+			if len(messages) == 0 {
+				break
+			}
+			for _, msg := range messages {
+				app.sendNotificationMessage(msg)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sendNotificationMessage makes a single send attempt and records its outcome
+func (app *application) sendNotificationMessage(msg *data.NotificationMessage) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	err := app.notifier.Send(ctx, notifier.Message{
+		ID:        msg.ID,
+		Channel:   msg.Channel,
+		Recipient: msg.Recipient,
+		Subject:   msg.Subject,
+		Body:      msg.Body,
+	})
+	if err == nil {
+		err = app.models.Messages.MarkSent(ctx, msg.ID)
+		if err != nil {
+			app.logger.Error("failed to record notification message", "message_id", msg.ID, "error", err)
+		}
+		return
+	}
+
+	attempt := msg.Attempts + 1
+	failed := attempt >= messageMaxAttempts
+	backoff := time.Duration(float64(messageBaseBackoff) * math.Pow(2, float64(attempt-1)))
+
+	app.logger.Warn("notification message failed", "message_id", msg.ID, "channel", msg.Channel, "attempt", attempt, "failed", failed, "error", err)
+
+	err = app.models.Messages.MarkFailed(ctx, msg.ID, err.Error(), failed, time.Now().Add(backoff))
+	if err != nil {
+		app.logger.Error("failed to record notification message", "message_id", msg.ID, "error", err)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/VJ-2303/CityStars/internal/data"
+)
+
+func TestNotificationPreferences(t *testing.T) {
+	app := newTestApplication(t)
+	user := app.insertUser(t, "Priya Raman", "9000000001", "pa55word-1234", "user")
+	token := app.tokenFor(t, user)
+
+	res := app.do(t, http.MethodGet, "/v1/user/notification-preferences", token, nil)
+	checkStatus(t, res, http.StatusOK)
+
+	var prefs data.NotificationPreferences
+	decodeField(t, res, "preferences", &prefs)
+	if prefs.EmailEnabled || prefs.SMSEnabled {
+		t.Errorf("channels enabled by default: %+v", prefs)
+	}
+
+	tests := []struct {
+		name string
+		body any
+		want int
+	}{
+		{"email without address", map[string]any{"email_enabled": true}, http.StatusUnprocessableEntity},
+		{"invalid timezone", map[string]any{"timezone": "Mars/Olympus"}, http.StatusUnprocessableEntity},
+		{"half quiet hours", map[string]any{"quiet_hours_start": "22:00"}, http.StatusUnprocessableEntity},
+		{"unknown field", map[string]any{"pager_enabled": true}, http.StatusBadRequest},
+		{"valid", map[string]any{"email": "priya@example.com", "email_enabled": true, "locale": "ta"}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := app.do(t, http.MethodPatch, "/v1/user/notification-preferences", token, tt.body)
+			checkStatus(t, res, tt.want)
+		})
+	}
+
+	res = app.do(t, http.MethodGet, "/v1/user/notification-preferences", token, nil)
+	decodeField(t, res, "preferences", &prefs)
+	if !prefs.EmailEnabled || prefs.Email != "priya@example.com" || prefs.Locale != "ta" {
+		t.Errorf("preferences not saved: %+v", prefs)
+	}
+}
+
+func TestNotificationMessages(t *testing.T) {
+	app := newTestApplication(t)
+	user := app.insertUser(t, "Priya Raman", "9000000001", "pa55word-1234", "user")
+	token := app.tokenFor(t, user)
+
+	res := app.do(t, http.MethodPatch, "/v1/user/notification-preferences", token, map[string]any{
+		"email":         "priya@example.com",
+		"email_enabled": true,
+	})
+	checkStatus(t, res, http.StatusOK)
+
+	report := app.insertReport(t, user.ID, "Broken streetlight", "streetlight")
+	err := app.models.Reports.Update(t.Context(), report.ID, report.Version, "in-progress", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.dispatchPendingEvents(t)
+
+	res = app.do(t, http.MethodGet, "/v1/user/notification-messages", token, nil)
+	checkStatus(t, res, http.StatusOK)
+
+	var messages []struct {
+		Channel   string `json:"channel"`
+		Recipient string `json:"recipient"`
+		Status    string `json:"status"`
+	}
+	decodeField(t, res, "messages", &messages)
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(messages))
+	}
+	if messages[0].Channel != "email" || messages[0].Recipient != "priya@example.com" {
+		t.Errorf("unexpected message %+v", messages[0])
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/VJ-2303/CityStars/internal/data"
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics holds the Prometheus collectors exposed on /metrics. They are
+// kept in their own registry rather than the global one
+type metrics struct {
+	registry *prometheus.Registry
+
+	requests         *prometheus.CounterVec
+	requestDuration  *prometheus.HistogramVec
+	requestsInFlight prometheus.Gauge
+
+	reportsCreated    *prometheus.CounterVec
+	statusTransitions *prometheus.CounterVec
+}
+
+// newMetrics registers the HTTP, runtime and business metrics
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "citystars_http_requests_total",
+			Help: "HTTP requests served, by method, route pattern and status code.",
+		}, []string{"method", "route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "citystars_http_request_duration_seconds",
+			Help:    "HTTP request latency, by method, route pattern and status code.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		requestsInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "citystars_http_requests_in_flight",
+			Help: "HTTP requests currently being served.",
+		}),
+		reportsCreated: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "citystars_reports_created_total",
+			Help: "Reports created, by category.",
+		}, []string{"category"}),
+		statusTransitions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "citystars_report_status_transitions_total",
+			Help: "Report status changes, by previous and new status.",
+		}, []string{"from", "to"}),
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.requestDuration,
+		m.requestsInFlight,
+		m.reportsCreated,
+		m.statusTransitions,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return m
+}
+
+// registerDB adds the connection pool statistics of db, the pools other
+// than the primary are labelled with their role
+func (m *metrics) registerDB(db *sql.DB, role string) {
+	name := "citystars"
+	if role != "primary" {
+		name += "_" + role
+	}
+	m.registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// metricsHandler serves the registry in the Prometheus exposition format,
+// behind basic auth when a metrics username is configured
+func (app *application) metricsHandler() http.HandlerFunc {
+	handler := promhttp.HandlerFor(app.metrics.registry, promhttp.HandlerOpts{})
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if app.config.metrics.username != "" {
+			username, password, ok := r.BasicAuth()
+			if !ok ||
+				subtle.ConstantTimeCompare([]byte(username), []byte(app.config.metrics.username)) != 1 ||
+				subtle.ConstantTimeCompare([]byte(password), []byte(app.config.metrics.password)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="metrics", charset="UTF-8"`)
+				app.errorResponse(w, r, http.StatusUnauthorized, "invalid metrics credentials")
+				return
+			}
+		}
+		handler.ServeHTTP(w, r)
+	}
+}
+
+// instrument records the count and latency of every request, labelled by
+// the chi route pattern so that path parameters don't explode the series
+func (app *application) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		app.metrics.requestsInFlight.Inc()
+		defer app.metrics.requestsInFlight.Dec()
+
+		rec := newResponseRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		// The route pattern is only known once the router has matched
+		route := chi.RouteContext(r.Context()).RoutePattern()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(rec.status)
+
+		app.metrics.requests.WithLabelValues(r.Method, route, status).Inc()
+		app.metrics.requestDuration.WithLabelValues(r.Method, route, status).Observe(time.Since(start).Seconds())
+	})
+}
+
+// countReportEvent is an outbox handler updating the report business
+// counters. It is registered last so that it only runs once the other
+// handlers of the event have succeeded
+func (app *application) countReportEvent(ctx context.Context, e *data.OutboxEvent) error {
+	var event data.ReportEvent
+	err := json.Unmarshal(e.Payload, &event)
+	if err != nil {
+		return err
+	}
+
+	switch e.EventType {
+	case data.EventReportCreated:
+		app.metrics.reportsCreated.WithLabelValues(event.Report.Category).Inc()
+	case data.EventReportUpdated:
+		if event.StatusChanged() {
+			app.metrics.statusTransitions.WithLabelValues(event.PreviousStatus, event.Report.Status).Inc()
+		}
+	}
+
+	return nil
+}
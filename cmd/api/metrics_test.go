@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.metrics.username = "prometheus"
+	app.config.metrics.password = "scrape-secret"
+
+	// Route something first so that the request counter has a series
+	app.do(t, http.MethodGet, "/v1/healthcheck", "", nil)
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		want     int
+	}{
+		{"without credentials", "", "", http.StatusUnauthorized},
+		{"wrong password", "prometheus", "guess", http.StatusUnauthorized},
+		{"valid credentials", "prometheus", "scrape-secret", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.username != "" {
+				r.SetBasicAuth(tt.username, tt.password)
+			}
+
+			rr := httptest.NewRecorder()
+			app.routes().ServeHTTP(rr, r)
+
+			if rr.Code != tt.want {
+				t.Fatalf("got status %d, want %d", rr.Code, tt.want)
+			}
+			if tt.want == http.StatusOK && !strings.Contains(rr.Body.String(), `citystars_http_requests_total{method="GET",route="/v1/healthcheck",status="200"}`) {
+				t.Error("request counter missing from metrics")
+			}
+		})
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VJ-2303/CityStars/internal/data"
+	"github.com/VJ-2303/CityStars/internal/totp"
+	"github.com/VJ-2303/CityStars/internal/validator"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mfaPendingScope is the scope of the token issued after the password of a
+// user with two-factor authentication, which is only good for sending the code
+const mfaPendingScope = "mfa_pending"
+
+// GetMFAHandler reports whether the user has two-factor authentication
+// enabled, along with the number of recovery codes left
+func (app *application) GetMFAHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(userIDKey).(int64)
+
+	enabled := false
+	mfa, err := app.models.MFA.Get(r.Context(), userID)
+	switch {
+	case err == nil:
+		enabled = mfa.Enabled()
+	case !errors.Is(err, data.ErrMFANotEnrolled):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	recoveryCodes, err := app.models.MFA.CountRecoveryCodes(r.Context(), userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"mfa": envelope{"enabled": enabled, "recovery_codes_left": recoveryCodes}})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// EnrollMFAHandler generates a new TOTP secret for the user. The secret
+// only guards logins once it is confirmed with a code from the app
+func (app *application) EnrollMFAHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(userIDKey).(int64)
+
+	user, err := app.models.Users.GetByID(r.Context(), userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.MFA.Enroll(r.Context(), user.ID, secret)
+	if err != nil {
+		if errors.Is(err, data.ErrMFAAlreadyEnabled) {
+			app.mfaAlreadyEnabledResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{
+		"secret":           secret,
+		"provisioning_uri": totp.URI(app.config.mfa.issuer, user.PhoneNumber, secret),
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// ConfirmMFAHandler enables two-factor authentication once the user proves
+// their app generates the codes, and hands out the recovery codes
+func (app *application) ConfirmMFAHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(userIDKey).(int64)
+
+	code, ok := app.readMFACode(w, r)
+	if !ok {
+		return
+	}
+
+	mfa, err := app.models.MFA.Get(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, data.ErrMFANotEnrolled) {
+			app.failedValidationResponse(w, r, map[string]string{"code": "enroll before confirming"})
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if mfa.Enabled() {
+		app.mfaAlreadyEnabledResponse(w, r)
+		return
+	}
+
+	step, ok := totp.Validate(mfa.Secret, code, time.Now())
+	if !ok {
+		app.invalidMFACodeResponse(w, r)
+		return
+	}
+
+	codes, hashes, err := data.NewRecoveryCodes()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.MFA.Confirm(r.Context(), userID, step, hashes)
+	if err != nil {
+		if errors.Is(err, data.ErrMFANotEnrolled) {
+			app.mfaAlreadyEnabledResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.logger.Info("enabled two-factor authentication", "user_id", userID)
+	app.audit(r.Context(), data.AuditMFAEnabled, "user", userID, envelope{"mfa": false}, envelope{"mfa": true})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": codes})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// DisableMFAHandler turns two-factor authentication off, which takes a
+// current code so that a stolen session can't remove the second factor.
+// Wrong codes count as failed logins
+func (app *application) DisableMFAHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(userIDKey).(int64)
+
+	code, ok := app.readMFACode(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := app.models.Users.GetByID(r.Context(), userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !app.checkLoginLock(w, r, user.PhoneNumber) {
+		return
+	}
+
+	mfa, err := app.models.MFA.Get(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, data.ErrMFANotEnrolled) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.verifyMFA(w, r, user, mfa, code) {
+		return
+	}
+
+	err = app.models.MFA.Delete(r.Context(), userID)
+	if err != nil && !errors.Is(err, data.ErrMFANotEnrolled) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.logger.Info("disabled two-factor authentication", "user_id", userID)
+	app.audit(r.Context(), data.AuditMFADisabled, "user", userID, envelope{"mfa": true}, envelope{"mfa": false})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication disabled"})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// LoginMFAHandler completes the login of a user with two-factor
+// authentication, exchanging the mfa_pending token and a code from the app
+// or a recovery code for an authentication token
+func (app *application) LoginMFAHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Check(input.MFAToken != "", "mfa_token", "must be provided")
+	v.Check(input.Code != "", "code", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	claims, err := app.keys.Parse(input.MFAToken, mfaPendingScope)
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			app.expiredTokenResponse(w, r)
+		} else {
+			app.invalidAuthenticationTokenResponse(w, r)
+		}
+		return
+	}
+	userID, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	user, err := app.models.Users.GetByID(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, data.ErrUserNotFound) {
+			app.invalidAuthenticationTokenResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if user.Suspended() {
+		app.accountSuspendedResponse(w, r)
+		return
+	}
+	if !app.checkLoginLock(w, r, user.PhoneNumber) {
+		return
+	}
+
+	// 2FA may have been disabled since the password was checked
+	mfa, err := app.models.MFA.Get(r.Context(), user.ID)
+	if err != nil {
+		if errors.Is(err, data.ErrMFANotEnrolled) {
+			app.invalidAuthenticationTokenResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.verifyMFA(w, r, user, mfa, input.Code) {
+		return
+	}
+
+	err = app.models.LoginAttempts.Reset(r.Context(), user.PhoneNumber)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	token, err := app.newToken(user, "authentication", app.config.authTokenTTL, "pwd", "otp")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.audit(asUser(r.Context(), user), data.AuditLoginSucceeded, "user", user.ID, nil, envelope{"amr": []string{"pwd", "otp"}})
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"auth_token": token})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readMFACode reads the code of the request body, sending the error
+// response when it's missing
+func (app *application) readMFACode(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var input struct {
+		Code string `json:"code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return "", false
+	}
+	v := validator.New()
+	if v.Check(input.Code != "", "code", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return "", false
+	}
+	return strings.TrimSpace(input.Code), true
+}
+
+// checkLoginLock sends the 429 response when the phone number is locked
+// out after failed logins
+func (app *application) checkLoginLock(w http.ResponseWriter, r *http.Request, phoneNumber string) bool {
+	attempt, err := app.models.LoginAttempts.Get(r.Context(), phoneNumber)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	if attempt.Locked() {
+		app.loginLockedResponse(w, r, time.Until(*attempt.LockedUntil))
+		return false
+	}
+	return true
+}
+
+// verifyMFA checks the code of the enabled enrolment, counting a wrong code
+// as a failed login and sending the error response
+func (app *application) verifyMFA(w http.ResponseWriter, r *http.Request, user *data.User, mfa *data.MFA, code string) bool {
+	ok := false
+	var err error
+	if mfa.Enabled() {
+		ok, err = app.useMFACode(r.Context(), mfa, code)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return false
+		}
+	}
+	if ok {
+		return true
+	}
+
+	err = app.countFailedLogin(r.Context(), user.PhoneNumber)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	app.invalidMFACodeResponse(w, r)
+	return false
+}
+
+// useMFACode accepts a code from the app, or else a recovery code, and uses
+// it up so that it can't be accepted again
+func (app *application) useMFACode(ctx context.Context, mfa *data.MFA, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if step, ok := totp.Validate(mfa.Secret, code, time.Now()); ok {
+		return app.models.MFA.UseStep(ctx, mfa.UserID, step)
+	}
+	return app.models.MFA.UseRecoveryCode(ctx, mfa.UserID, data.HashRecoveryCode(code))
+}
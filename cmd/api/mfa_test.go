@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VJ-2303/CityStars/internal/totp"
+)
+
+// totpCode returns the code of the secret periods away from now
+func totpCode(t *testing.T, secret string, periods int64) string {
+	t.Helper()
+
+	code, err := totp.Code(secret, totp.Step(time.Now())+periods)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// enableMFA enrolls the user and confirms the enrolment with the current
+// code, returning the secret and the recovery codes
+func (app *application) enableMFA(t *testing.T, token string) (string, []string) {
+	t.Helper()
+
+	res := app.do(t, http.MethodPost, "/v1/user/mfa", token, nil)
+	checkStatus(t, res, http.StatusCreated)
+	secret, _ := res.body["secret"].(string)
+
+	res = app.do(t, http.MethodPost, "/v1/user/mfa/confirm", token, map[string]string{"code": totpCode(t, secret, 0)})
+	checkStatus(t, res, http.StatusOK)
+
+	var codes []string
+	decodeField(t, res, "recovery_codes", &codes)
+	return secret, codes
+}
+
+// loginMFAToken logs in with the password, which must ask for the second factor
+func (app *application) loginMFAToken(t *testing.T, phoneNumber string) string {
+	t.Helper()
+
+	res := app.do(t, http.MethodPost, "/v1/user/login", "", map[string]string{"phone_number": phoneNumber, "password": "pa55word-1234"})
+	checkStatus(t, res, http.StatusOK)
+	if _, ok := res.body["auth_token"]; ok || res.body["mfa_required"] != true {
+		t.Fatalf("login didn't ask for the second factor: %v", res.body)
+	}
+
+	var token struct {
+		Token string `json:"token"`
+	}
+	decodeField(t, res, "mfa_token", &token)
+	return token.Token
+}
+
+func TestMFAEnrollment(t *testing.T) {
+	app := newTestApplication(t)
+	user := app.insertUser(t, "Admin Person", "9000000001", "pa55word-1234", "admin")
+	token := app.tokenFor(t, user)
+
+	res := app.do(t, http.MethodPost, "/v1/user/mfa/confirm", token, map[string]string{"code": "123456"})
+	checkStatus(t, res, http.StatusUnprocessableEntity)
+
+	res = app.do(t, http.MethodPost, "/v1/user/mfa", token, nil)
+	checkStatus(t, res, http.StatusCreated)
+	secret, _ := res.body["secret"].(string)
+	uri, _ := res.body["provisioning_uri"].(string)
+	if !strings.HasPrefix(uri, "otpauth://totp/CityStars:9000000001?") || !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("unexpected provisioning URI %q", uri)
+	}
+
+	// The enrolment doesn't guard logins until it is confirmed
+	res = app.do(t, http.MethodPost, "/v1/user/login", "", map[string]string{"phone_number": "9000000001", "password": "pa55word-1234"})
+	checkStatus(t, res, http.StatusCreated)
+
+	tests := []struct {
+		name string
+		body any
+		want int
+	}{
+		{"missing code", map[string]string{}, http.StatusUnprocessableEntity},
+		{"wrong code", map[string]string{"code": totpCode(t, secret, -3)}, http.StatusUnprocessableEntity},
+		{"current code", map[string]string{"code": totpCode(t, secret, 0)}, http.StatusOK},
+		{"already enabled", map[string]string{"code": totpCode(t, secret, 0)}, http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := app.do(t, http.MethodPost, "/v1/user/mfa/confirm", token, tt.body)
+			checkStatus(t, res, tt.want)
+		})
+	}
+
+	res = app.do(t, http.MethodPost, "/v1/user/mfa", token, nil)
+	checkStatus(t, res, http.StatusConflict)
+
+	res = app.do(t, http.MethodGet, "/v1/user/mfa", token, nil)
+	checkStatus(t, res, http.StatusOK)
+	var status struct {
+		Enabled           bool `json:"enabled"`
+		RecoveryCodesLeft int  `json:"recovery_codes_left"`
+	}
+	decodeField(t, res, "mfa", &status)
+	if !status.Enabled || status.RecoveryCodesLeft != 10 {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+func TestLoginMFA(t *testing.T) {
+	app := newTestApplication(t)
+	user := app.insertUser(t, "Admin Person", "9000000001", "pa55word-1234", "admin")
+	secret, recoveryCodes := app.enableMFA(t, app.tokenFor(t, user))
+
+	mfaToken := app.loginMFAToken(t, "9000000001")
+
+	res := app.do(t, http.MethodGet, "/v1/user/me", mfaToken, nil)
+	checkStatus(t, res, http.StatusUnauthorized)
+
+	tests := []struct {
+		name  string
+		token string
+		code  string
+		want  int
+	}{
+		{"without token", "", totpCode(t, secret, 1), http.StatusUnprocessableEntity},
+		{"authentication token", app.tokenFor(t, user), totpCode(t, secret, 1), http.StatusUnauthorized},
+		{"code used to confirm", mfaToken, totpCode(t, secret, 0), http.StatusUnprocessableEntity},
+		{"wrong code", mfaToken, totpCode(t, secret, -3), http.StatusUnprocessableEntity},
+		{"next code", mfaToken, totpCode(t, secret, 1), http.StatusCreated},
+		{"replayed code", mfaToken, totpCode(t, secret, 1), http.StatusUnprocessableEntity},
+		{"recovery code", mfaToken, strings.ToUpper(recoveryCodes[0]), http.StatusCreated},
+		{"used recovery code", mfaToken, recoveryCodes[0], http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app.models.LoginAttempts.Reset(t.Context(), "9000000001")
+
+			res := app.do(t, http.MethodPost, "/v1/user/login/mfa", "", map[string]string{"mfa_token": tt.token, "code": tt.code})
+			checkStatus(t, res, tt.want)
+			if tt.want == http.StatusCreated {
+				var token struct {
+					Token string `json:"token"`
+				}
+				decodeField(t, res, "auth_token", &token)
+				res := app.do(t, http.MethodGet, "/v1/admin/me", token.Token, nil)
+				checkStatus(t, res, http.StatusOK)
+			}
+		})
+	}
+
+	t.Run("lockout", func(t *testing.T) {
+		app.models.LoginAttempts.Reset(t.Context(), "9000000001")
+
+		for range app.config.login.maxFailures {
+			res := app.do(t, http.MethodPost, "/v1/user/login/mfa", "", map[string]string{"mfa_token": mfaToken, "code": "000000"})
+			checkStatus(t, res, http.StatusUnprocessableEntity)
+		}
+
+		res := app.do(t, http.MethodPost, "/v1/user/login/mfa", "", map[string]string{"mfa_token": mfaToken, "code": recoveryCodes[1]})
+		checkStatus(t, res, http.StatusTooManyRequests)
+	})
+}
+
+func TestRequireAdminMFA(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.mfa.requireAdmins = true
+	admin := app.insertUser(t, "Admin Person", "9000000001", "pa55word-1234", "admin")
+	user := app.insertUser(t, "Priya Raman", "9000000002", "pa55word-1234", "user")
+	report := app.insertReport(t, user.ID, "Deep pothole", "pothole")
+
+	passwordOnly := app.tokenFor(t, admin)
+	res := app.do(t, http.MethodGet, "/v1/admin/me", passwordOnly, nil)
+	checkStatus(t, res, http.StatusForbidden)
+
+	// Enrolling is still possible, so that admins can comply with the policy
+	_, recoveryCodes := app.enableMFA(t, passwordOnly)
+
+	res = app.do(t, http.MethodPost, "/v1/user/login/mfa", "", map[string]string{"mfa_token": app.loginMFAToken(t, "9000000001"), "code": recoveryCodes[0]})
+	checkStatus(t, res, http.StatusCreated)
+	var token struct {
+		Token string `json:"token"`
+	}
+	decodeField(t, res, "auth_token", &token)
+
+	path := fmt.Sprintf("/v1/reports/%d", report.ID)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		body   any
+		want   int
+	}{
+		{"admin profile without 2FA", http.MethodGet, "/v1/admin/me", passwordOnly, nil, http.StatusForbidden},
+		{"update without 2FA", http.MethodPatch, path, passwordOnly, map[string]string{"status": "in-progress"}, http.StatusForbidden},
+		{"admin profile with 2FA", http.MethodGet, "/v1/admin/me", token.Token, nil, http.StatusOK},
+		{"update with 2FA", http.MethodPatch, path, token.Token, map[string]any{"status": "in-progress", "version": 1}, http.StatusOK},
+		{"user routes without 2FA", http.MethodGet, "/v1/user/me", app.tokenFor(t, user), nil, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := app.do(t, tt.method, tt.path, tt.token, tt.body)
+			checkStatus(t, res, tt.want)
+		})
+	}
+}
+
+func TestDisableMFA(t *testing.T) {
+	app := newTestApplication(t)
+	user := app.insertUser(t, "Priya Raman", "9000000001", "pa55word-1234", "user")
+	token := app.tokenFor(t, user)
+
+	res := app.do(t, http.MethodDelete, "/v1/user/mfa", token, map[string]string{"code": "123456"})
+	checkStatus(t, res, http.StatusNotFound)
+
+	_, recoveryCodes := app.enableMFA(t, token)
+
+	res = app.do(t, http.MethodDelete, "/v1/user/mfa", token, map[string]string{"code": "000000"})
+	checkStatus(t, res, http.StatusUnprocessableEntity)
+
+	res = app.do(t, http.MethodDelete, "/v1/user/mfa", token, map[string]string{"code": recoveryCodes[0]})
+	checkStatus(t, res, http.StatusOK)
+
+	res = app.do(t, http.MethodPost, "/v1/user/login", "", map[string]string{"phone_number": "9000000001", "password": "pa55word-1234"})
+	checkStatus(t, res, http.StatusCreated)
+}
@@ -2,46 +2,135 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/VJ-2303/CityStars/internal/data"
+	"github.com/VJ-2303/CityStars/internal/ratelimit"
 	"github.com/golang-jwt/jwt/v5"
 )
 
 type contextKey string
 
+// routeBudgets are the per route rate limits layered on top of the global
+// per IP limit, they apply per user or API key on authenticated routes and
+// per IP otherwise
+var routeBudgets = map[string]ratelimit.Limit{
+	"login":         ratelimit.Every(12*time.Second, 5), // 5 per minute
+	"register":      ratelimit.Every(time.Minute, 3),    // 1 per minute, 3 at once
+	"create_report": ratelimit.Every(6*time.Minute, 5),  // 10 per hour, 5 at once
+}
+
 const (
-	userIDKey   = contextKey("userID")
-	userRoleKey = contextKey("role")
+	userIDKey    = contextKey("userID")
+	userRoleKey  = contextKey("role")
+	mfaKey       = contextKey("mfa")
+	apiKeyKey    = contextKey("apiKey")
+	requestIDKey = contextKey("requestID")
+	accessLogKey = contextKey("accessLog")
 )
 
+// requestIDHeader carries the request ID in both directions
+const requestIDHeader = "X-Request-ID"
+
+// requestID tags the request with an ID, echoed back in the X-Request-ID
+// header and attached to its log lines. A well-formed incoming ID is kept
+// so that requests can be followed through the proxy in front of the API
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set(requestIDHeader, id)
+		ctx := context.WithValue(r.Context(), requestIDKey, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// validRequestID accepts short IDs made of printable ASCII characters,
+// so that client supplied IDs can't inject anything into the logs
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a random 128 bit hex encoded ID
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestIDFrom returns the ID of the request, empty outside of requestID
+func requestIDFrom(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey).(string)
+	return id
+}
+
+// accessLog collects the details of a request which are only known
+// further down the chain, authenticate fills in the user ID
+type accessLog struct {
+	userID   int64
+	apiKeyID int64
+}
+
+// logRequest writes a single access log line per request once the
+// response is done, with its status, size, duration and user. It also sets
+// up the actor the audit log attributes the request's actions to
 func (app *application) logRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		app.logger.Info("request received", "method", r.Method, "uri", r.RequestURI)
-		next.ServeHTTP(w, r)
+		start := time.Now()
+		entry := &accessLog{}
+		rec := newResponseRecorder(w)
+
+		actor := &data.Actor{Type: data.ActorAnonymous, IP: app.clientIP(r), RequestID: requestIDFrom(r)}
+		ctx := context.WithValue(r.Context(), accessLogKey, entry)
+		ctx = data.ContextWithActor(ctx, actor)
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		attrs := []any{
+			"request_id", requestIDFrom(r),
+			"method", r.Method,
+			"uri", r.URL.RequestURI(),
+			"status", rec.status,
+			"bytes", rec.bytes,
+			"duration", time.Since(start),
+			"remote_ip", app.clientIP(r),
+		}
+		if entry.userID != 0 {
+			attrs = append(attrs, "user_id", entry.userID)
+		}
+		if entry.apiKeyID != 0 {
+			attrs = append(attrs, "api_key_id", entry.apiKeyID)
+		}
+		app.logger.Info("request completed", attrs...)
 	})
 }
 
 func (app *application) authenticate(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			app.invalidAuthenticationTokenResponse(w, r)
-			return
-		}
-		headerParts := strings.Split(authHeader, " ")
-		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+		tokenString, ok := bearerToken(r)
+		if !ok {
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
 		}
-		tokenString := headerParts[1]
-		claims := jwt.MapClaims{}
 
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (any, error) {
-			return []byte(app.config.jwtSecret), nil
-		})
+		claims, err := app.keys.Parse(tokenString, "authentication")
 		if err != nil {
 			if errors.Is(err, jwt.ErrTokenExpired) {
 				app.expiredTokenResponse(w, r)
@@ -50,24 +139,41 @@ func (app *application) authenticate(next http.HandlerFunc) http.HandlerFunc {
 			}
 			return
 		}
-		if !token.Valid {
+		userID, err := strconv.ParseInt(claims.Subject, 10, 64)
+		if err != nil {
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
 		}
-		sub, err := claims.GetSubject()
+
+		// Look the user up so that suspensions and role changes take
+		// effect right away instead of once the token expires
+		user, err := app.models.Users.GetByID(r.Context(), userID)
 		if err != nil {
-			app.serverErrorResponse(w, r, err)
+			if errors.Is(err, data.ErrUserNotFound) {
+				app.invalidAuthenticationTokenResponse(w, r)
+			} else {
+				app.serverErrorResponse(w, r, err)
+			}
 			return
 		}
-		userID, err := strconv.ParseInt(sub, 10, 64)
-		if err != nil {
-			app.serverErrorResponse(w, r, err)
+		if user.Suspended() {
+			app.accountSuspendedResponse(w, r)
 			return
 		}
-		role := claims["role"].(string)
+		role := user.Role
+
+		if entry, ok := r.Context().Value(accessLogKey).(*accessLog); ok {
+			entry.userID = userID
+		}
+		actor := data.ActorFromContext(r.Context())
+		actor.Type = data.ActorUser
+		actor.ID = user.ID
+		actor.Role = user.Role
+		actor.Name = user.Name
 
 		ctx := context.WithValue(r.Context(), userIDKey, userID)
 		ctx2 := context.WithValue(ctx, userRoleKey, role)
+		ctx2 = context.WithValue(ctx2, mfaKey, mfaDone(claims.AMR))
 
 		newReq := r.WithContext(ctx2)
 
@@ -75,6 +181,107 @@ func (app *application) authenticate(next http.HandlerFunc) http.HandlerFunc {
 	})
 }
 
+// bearerToken returns the token of the Authorization header
+func bearerToken(r *http.Request) (string, bool) {
+	headerParts := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(headerParts) != 2 || headerParts[0] != "Bearer" || headerParts[1] == "" {
+		return "", false
+	}
+	return headerParts[1], true
+}
+
+// authenticateClient accepts an API key as well as the JWT of a user, API
+// keys are told apart from JWTs by their prefix
+func (app *application) authenticateClient(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, _ := bearerToken(r)
+		if !strings.HasPrefix(token, data.APIKeyPrefix) {
+			app.authenticate(next).ServeHTTP(w, r)
+			return
+		}
+
+		key, ok := app.authenticateAPIKey(w, r, token)
+		if !ok {
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyKey, key)))
+	})
+}
+
+// checkAPIKey verifies the API key of the requests to a public route which
+// carry one, so that a revoked or under-scoped key fails loudly instead of
+// silently being served as anonymous. Other requests pass through as they are
+func (app *application) checkAPIKey(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, _ := bearerToken(r)
+		if !strings.HasPrefix(token, data.APIKeyPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		app.authenticateClient(app.requireScope(scope, next)).ServeHTTP(w, r)
+	})
+}
+
+// authenticateAPIKey looks the key up and checks that it is active, sending
+// the 401 response otherwise
+func (app *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, plainText string) (*data.APIKey, bool) {
+	prefix, ok := data.ParseAPIKeyPrefix(plainText)
+	if !ok {
+		app.invalidAPIKeyResponse(w, r)
+		return nil, false
+	}
+
+	key, err := app.models.APIKeys.GetByPrefix(r.Context(), prefix)
+	if err != nil {
+		if errors.Is(err, data.ErrAPIKeyNotFound) {
+			app.invalidAPIKeyResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	if !key.Matches(plainText) || !key.Active() {
+		app.invalidAPIKeyResponse(w, r)
+		return nil, false
+	}
+
+	// Tracking the last use is best effort, it mustn't fail the request
+	err = app.models.APIKeys.TouchLastUsed(r.Context(), key.ID)
+	if err != nil {
+		app.logError(r, err)
+	}
+
+	if entry, ok := r.Context().Value(accessLogKey).(*accessLog); ok {
+		entry.apiKeyID = key.ID
+	}
+	actor := data.ActorFromContext(r.Context())
+	actor.Type = data.ActorAPIKey
+	actor.ID = key.ID
+	actor.Name = key.Name
+	return key, true
+}
+
+// requireScope lets through the API keys granted the scope, as well as
+// admins, who hold every scope
+func (app *application) requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, ok := r.Context().Value(apiKeyKey).(*data.APIKey)
+		if !ok {
+			app.requireAdmin(next).ServeHTTP(w, r)
+			return
+		}
+
+		if !key.HasScope(scope) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireAdmin lets through admins, who must have logged in with a second
+// factor when the policy requires it
 func (app *application) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		role, _ := r.Context().Value(userRoleKey).(string)
@@ -82,6 +289,157 @@ func (app *application) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
 		}
+		if mfa, _ := r.Context().Value(mfaKey).(bool); app.config.mfa.requireAdmins && !mfa {
+			app.mfaRequiredResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// timeout puts a deadline on the request context, which the queries run
+// for the request derive from. Event streams are long lived by design and
+// are left without a deadline
+func (app *application) timeout(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v1/stream/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), app.config.requestTimeout)
+		defer cancel()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// rateLimit applies the global per IP request budget
+func (app *application) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.config.limiter.enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		limit := ratelimit.Limit{Rate: app.config.limiter.rps, Burst: app.config.limiter.burst}
+		if !app.allow(w, r, "global:ip:"+app.clientIP(r), limit) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitRoute applies the named route budget, per user or API key when the
+// route is authenticated and per IP otherwise
+func (app *application) limitRoute(budget string, next http.HandlerFunc) http.HandlerFunc {
+	limit, ok := routeBudgets[budget]
+	if !ok {
+		panic("unknown rate limit budget " + budget)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.config.limiter.enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := budget + ":ip:" + app.clientIP(r)
+		if userID, ok := r.Context().Value(userIDKey).(int64); ok {
+			key = budget + ":user:" + strconv.FormatInt(userID, 10)
+		} else if apiKey, ok := r.Context().Value(apiKeyKey).(*data.APIKey); ok {
+			key = budget + ":api_key:" + strconv.FormatInt(apiKey.ID, 10)
+		}
+
+		if !app.allow(w, r, key, limit) {
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
 }
+
+// allow takes a token from the bucket and sends the 429 response when it is
+// empty. Store failures let the request through rather than taking the API down
+func (app *application) allow(w http.ResponseWriter, r *http.Request, key string, limit ratelimit.Limit) bool {
+	result, err := app.limiter.Allow(r.Context(), key, limit)
+	if err != nil {
+		app.logError(r, err)
+		return true
+	}
+	if !result.Allowed {
+		app.rateLimitExceededResponse(w, r, result.RetryAfter)
+		return false
+	}
+	return true
+}
+
+// clientIP returns the address of the client. Behind a trusted proxy the
+// last X-Forwarded-For entry is used, as that is the one the proxy appended
+func (app *application) clientIP(r *http.Request) string {
+	if app.config.limiter.trustProxy {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			parts := strings.Split(xff, ",")
+			return strings.TrimSpace(parts[len(parts)-1])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// cleanupRateLimiter periodically forgets the buckets of clients which
+// have been idle for a while, it runs until ctx is cancelled
+func (app *application) cleanupRateLimiter(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := app.limiter.Cleanup(ctx, 10*time.Minute)
+		if err != nil {
+			app.logger.Error("failed to clean up rate limiter", "error", err)
+		}
+	}
+}
+
+// responseRecorder wraps a ResponseWriter to capture the status code and
+// the number of body bytes written by the handler. Unwrap lets
+// http.ResponseController reach the underlying writer, which the event
+// streams rely on for flushing
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (rw *responseRecorder) WriteHeader(status int) {
+	if !rw.wroteHeader {
+		rw.status = status
+		rw.wroteHeader = true
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *responseRecorder) Write(b []byte) (int, error) {
+	rw.wroteHeader = true
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += n
+	return n, err
+}
+
+func (rw *responseRecorder) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUnknownRoutes(t *testing.T) {
+	app := newTestApplication(t)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		want   int
+	}{
+		{"not found", http.MethodGet, "/v1/nothing-here", http.StatusNotFound},
+		{"method not allowed", http.MethodDelete, "/v1/reports", http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := app.do(t, tt.method, tt.path, "", nil)
+			checkStatus(t, res, tt.want)
+		})
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	app := newTestApplication(t)
+
+	tests := []struct {
+		name string
+		sent string
+		kept bool
+	}{
+		{"generated", "", false},
+		{"echoed", "client-abc-123", true},
+		{"invalid replaced", "bad id\nwith newline", false},
+		{"too long replaced", strings.Repeat("a", 129), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/v1/healthcheck", nil)
+			if tt.sent != "" {
+				r.Header.Set(requestIDHeader, tt.sent)
+			}
+
+			rr := httptest.NewRecorder()
+			app.routes().ServeHTTP(rr, r)
+
+			got := rr.Header().Get(requestIDHeader)
+			if got == "" {
+				t.Fatal("no request ID in response")
+			}
+			if (got == tt.sent) != tt.kept {
+				t.Errorf("got request ID %q for %q", got, tt.sent)
+			}
+		})
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.limiter.enabled = true
+	app.config.limiter.rps = 1
+	app.config.limiter.burst = 2
+
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		res := app.do(t, http.MethodGet, "/v1/healthcheck", "", nil)
+		if res.status != want {
+			t.Fatalf("request %d got status %d, want %d", i, res.status, want)
+		}
+		if want == http.StatusTooManyRequests && res.header.Get("Retry-After") == "" {
+			t.Error("no Retry-After header on rate limited response")
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+
+	"github.com/VJ-2303/CityStars/internal/migrate"
+	"github.com/VJ-2303/CityStars/migrations"
+)
+
+const migrateUsage = `Usage: api migrate <command> [flags]
+
+Commands:
+  up             apply every pending migration
+  down           revert the latest migrations (-steps, default 1)
+  status         list the migrations and when they were applied
+  create <name>  add an empty pair of migration files to -dir
+
+Flags:`
+
+// migrateCommand runs the migrate subcommand with the arguments following it
+func migrateCommand(args []string) error {
+	// The DSN and pool settings come from the config file and environment
+	// like when serving, only the DSN can be overridden here
+	cfg, _, err := loadConfig(nil, os.Getenv)
+	if err != nil {
+		return err
+	}
+
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	flags.StringVar(&cfg.dsn, "db-dsn", cfg.dsn, "Postgres DB connection string")
+	steps := flags.Int("steps", 1, "Number of migrations reverted by down")
+	dir := flags.String("dir", "./migrations", "Directory create adds the migration files to")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), migrateUsage)
+		flags.PrintDefaults()
+	}
+
+	if len(args) == 0 {
+		flags.Usage()
+		return errors.New("missing migrate command")
+	}
+	command := args[0]
+	flags.Parse(args[1:])
+
+	if command == "create" {
+		if flags.NArg() != 1 {
+			return errors.New("usage: api migrate create <name>")
+		}
+		paths, err := migrate.Create(*dir, flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			fmt.Println("created", path)
+		}
+		return nil
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	db, err := openDB(cfg, cfg.dsn, logger)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	m := migrate.Migrator{
+		DB:     db,
+		FS:     migrations.FS,
+		Logger: logger,
+	}
+	ctx := context.Background()
+
+	switch command {
+	case "up":
+		applied, err := m.Up(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("applied %d migrations\n", applied)
+	case "down":
+		if *steps < 1 {
+			return errors.New("-steps must be at least 1")
+		}
+		reverted, err := m.Down(ctx, *steps)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("reverted %d migrations\n", reverted)
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if s.AppliedAt != nil {
+				appliedAt = s.AppliedAt.Format("2006-01-02 15:04:05 MST")
+			}
+			if s.Modified {
+				appliedAt += " (modified)"
+			}
+			fmt.Fprintf(tw, "%06d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+		return tw.Flush()
+	default:
+		flags.Usage()
+		return fmt.Errorf("unknown migrate command %q", command)
+	}
+
+	return nil
+}
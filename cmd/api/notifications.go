@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/VJ-2303/CityStars/internal/data"
+	"github.com/VJ-2303/CityStars/internal/events"
+)
+
+// ListNotificationsHandler returns the authenticated user's notifications
+// along with the number of unread ones
+func (app *application) ListNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(userIDKey).(int64)
+
+	// Parse query parameters for pagination
+	qs := r.URL.Query()
+
+	limit := 50 // default limit
+	if limitStr := qs.Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 100 {
+			limit = l
+		}
+	}
+
+	offset := 0
+	if offsetStr := qs.Get("offset"); offsetStr != "" {
+		if o, err := strconv.Atoi(offsetStr); err == nil && o >= 0 {
+			offset = o
+		}
+	}
+
+	unreadOnly := qs.Get("unread") == "true"
+
+	notifications, err := app.models.Notifications.GetForUser(r.Context(), userID, unreadOnly, limit, offset)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	unread, err := app.models.Notifications.CountUnread(r.Context(), userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"notifications": notifications, "unread_count": unread})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// MarkNotificationReadHandler marks one of the user's notifications as read
+func (app *application) MarkNotificationReadHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(userIDKey).(int64)
+
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Notifications.MarkRead(r.Context(), id, userID)
+	if err != nil {
+		if errors.Is(err, data.ErrNotificationNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	unread, err := app.models.Notifications.CountUnread(r.Context(), userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"unread_count": unread})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// MarkAllNotificationsReadHandler marks every unread notification of the user as read
+func (app *application) MarkAllNotificationsReadHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(userIDKey).(int64)
+
+	updated, err := app.models.Notifications.MarkAllRead(r.Context(), userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"updated": updated, "unread_count": 0})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// notifyStatusChange adds a notification to the reporter's inbox when an
+// admin moves their report to a new status and pushes it to their stream.
+// Reporters withdrawing their own report aren't notified
+func (app *application) notifyStatusChange(ctx context.Context, e *data.OutboxEvent) error {
+	var event data.ReportEvent
+	err := json.Unmarshal(e.Payload, &event)
+	if err != nil {
+		return err
+	}
+
+	if !event.StatusChanged() || event.Withdrawn() {
+		return nil
+	}
+
+	report := event.Report
+	message := fmt.Sprintf("Your report %q is now %s", report.Title, report.Status)
+	if report.Status == "completed" {
+		message = fmt.Sprintf("Your report %q has been fixed", report.Title)
+	}
+
+	notification := &data.Notification{
+		UserID:   report.UserID,
+		ReportID: &report.ID,
+		Type:     data.NotificationStatusChanged,
+		Message:  message,
+		EventKey: e.IdempotencyKey,
+	}
+
+	// A retry finds the notification stored by an earlier attempt, which
+	// may have failed to push it
+	err = app.models.Notifications.Insert(ctx, notification)
+	if errors.Is(err, data.ErrDuplicateEvent) {
+		notification, err = app.models.Notifications.GetByEventKey(ctx, e.IdempotencyKey)
+	}
+	if err != nil {
+		return err
+	}
+
+	js, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	return app.publisher.Publish(ctx, events.Event{
+		Type:   events.NotificationCreated,
+		UserID: notification.UserID,
+		Data:   js,
+	})
+}
+
+// pruneNotifications periodically deletes notifications older than
+// the configured retention period, it runs until ctx is cancelled
+func (app *application) pruneNotifications(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		deleted, err := app.models.Notifications.DeleteOlderThan(ctx, app.config.notificationRetention)
+		if err != nil {
+			app.logger.Error("failed to prune notifications", "error", err)
+		} else if deleted > 0 {
+			app.logger.Info("pruned old notifications", "deleted", deleted)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
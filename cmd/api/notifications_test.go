@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/VJ-2303/CityStars/internal/data"
+	"github.com/VJ-2303/CityStars/internal/events"
+)
+
+// testNotification holds the notification fields the tests look at
+type testNotification struct {
+	ID     int64   `json:"id"`
+	ReadAt *string `json:"read_at"`
+}
+
+func TestNotifications(t *testing.T) {
+	app := newTestApplication(t)
+	user := app.insertUser(t, "Priya Raman", "9000000001", "pa55word-1234", "user")
+	other := app.insertUser(t, "Karthik Subramanian", "9000000002", "pa55word-1234", "user")
+	token := app.tokenFor(t, user)
+
+	first := app.insertReport(t, user.ID, "Broken streetlight", "streetlight")
+	second := app.insertReport(t, user.ID, "Overflowing bin", "garbage")
+	app.insertReport(t, other.ID, "Pothole near school", "pothole")
+
+	for _, report := range []*data.Report{first, second} {
+		err := app.models.Reports.Update(t.Context(), report.ID, report.Version, "in-progress", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	app.dispatchPendingEvents(t)
+
+	res := app.do(t, http.MethodGet, "/v1/user/notifications", token, nil)
+	checkStatus(t, res, http.StatusOK)
+
+	var notifications []testNotification
+	decodeField(t, res, "notifications", &notifications)
+	if len(notifications) != 2 {
+		t.Fatalf("got %d notifications, want 2", len(notifications))
+	}
+	if res.body["unread_count"] != float64(2) {
+		t.Errorf("got unread count %v, want 2", res.body["unread_count"])
+	}
+
+	t.Run("mark read", func(t *testing.T) {
+		path := fmt.Sprintf("/v1/user/notifications/%d/read", notifications[0].ID)
+
+		res := app.do(t, http.MethodPost, path, app.tokenFor(t, other), nil)
+		checkStatus(t, res, http.StatusNotFound)
+
+		res = app.do(t, http.MethodPost, path, token, nil)
+		checkStatus(t, res, http.StatusOK)
+		if res.body["unread_count"] != float64(1) {
+			t.Errorf("got unread count %v, want 1", res.body["unread_count"])
+		}
+
+		res = app.do(t, http.MethodGet, "/v1/user/notifications?unread=true", token, nil)
+		var unread []testNotification
+		decodeField(t, res, "notifications", &unread)
+		if len(unread) != 1 || unread[0].ID != notifications[1].ID {
+			t.Errorf("got unread notifications %+v", unread)
+		}
+	})
+
+	t.Run("mark all read", func(t *testing.T) {
+		res := app.do(t, http.MethodPost, "/v1/user/notifications/read-all", token, nil)
+		checkStatus(t, res, http.StatusOK)
+		if res.body["updated"] != float64(1) {
+			t.Errorf("got %v updated, want 1", res.body["updated"])
+		}
+
+		res = app.do(t, http.MethodGet, "/v1/user/notifications", token, nil)
+		if res.body["unread_count"] != float64(0) {
+			t.Errorf("got unread count %v, want 0", res.body["unread_count"])
+		}
+	})
+
+	t.Run("without token", func(t *testing.T) {
+		res := app.do(t, http.MethodGet, "/v1/user/notifications", "", nil)
+		checkStatus(t, res, http.StatusUnauthorized)
+	})
+}
+
+// flakyPublisher fails to publish the first notifications it is given
+type flakyPublisher struct {
+	events.Publisher
+	failures int
+}
+
+func (p *flakyPublisher) Publish(ctx context.Context, e events.Event) error {
+	if e.Type == events.NotificationCreated && p.failures > 0 {
+		p.failures--
+		return errTest
+	}
+	return p.Publisher.Publish(ctx, e)
+}
+
+func TestNotifyStatusChangeRetry(t *testing.T) {
+	app := newTestApplication(t)
+	app.publisher = &flakyPublisher{Publisher: app.publisher, failures: 1}
+	user := app.insertUser(t, "Priya Raman", "9000000001", "pa55word-1234", "user")
+
+	sub, _ := app.events.Subscribe(0, func(e events.Event) bool { return e.Type == events.NotificationCreated })
+	defer app.events.Unsubscribe(sub)
+
+	report := app.insertReport(t, user.ID, "Broken streetlight", "streetlight")
+	err := app.models.Reports.Update(t.Context(), report.ID, report.Version, "in-progress", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The notification is stored on the first attempt and pushed on the retry
+	for range 2 {
+		_, err := app.models.Outbox.ProcessBatch(t.Context(), 10, outboxMaxAttempts, app.handleOutboxEvent, func(int) time.Duration { return 0 })
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	notifications, err := app.models.Notifications.GetForUser(t.Context(), user.ID, false, 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notifications) != 1 {
+		t.Fatalf("got %d notifications, want 1", len(notifications))
+	}
+
+	select {
+	case e := <-sub.C:
+		var pushed testNotification
+		err := json.Unmarshal(e.Data, &pushed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pushed.ID != notifications[0].ID {
+			t.Errorf("got notification %d pushed, want %d", pushed.ID, notifications[0].ID)
+		}
+	default:
+		t.Fatal("notification never pushed")
+	}
+	if n := len(sub.C); n != 0 {
+		t.Errorf("got %d more pushes, want the notification pushed once", n)
+	}
+}
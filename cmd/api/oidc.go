@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/VJ-2303/CityStars/internal/data"
+	"github.com/VJ-2303/CityStars/internal/validator"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// oidcStateTTL bounds how long a user may take at the identity provider
+	oidcStateTTL = 10 * time.Minute
+
+	// oidcStateCookie binds a pending login to the browser which started it,
+	// so that a callback URL can't be replayed in another browser
+	oidcStateCookie = "citystars_oidc_state"
+)
+
+var (
+	errOIDCNoPhoneNumber = errors.New("the identity provider didn't verify a phone number to link the account by")
+	errOIDCNoRole        = errors.New("none of the groups of the account are granted access to CityStars")
+)
+
+// oidcProvider is the OpenID Connect identity provider city staff log in with
+type oidcProvider struct {
+	oauth        oauth2.Config
+	verifier     *oidc.IDTokenVerifier
+	groupsClaim  string
+	groupRoles   map[string]string
+	postLoginURL string
+	secureCookie bool
+}
+
+// oidcClaims are the ID token claims used to find or create the user
+type oidcClaims struct {
+	Name                string   `json:"name"`
+	PhoneNumber         string   `json:"phone_number"`
+	PhoneNumberVerified bool     `json:"phone_number_verified"`
+	AMR                 []string `json:"amr"`
+}
+
+// newOIDCProvider discovers the provider at the configured issuer
+func newOIDCProvider(ctx context.Context, cfg *config) (*oidcProvider, error) {
+	groupRoles, err := parseGroupRoles(cfg.oidc.groupRoles)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := oidc.NewProvider(ctx, cfg.oidc.issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &oidcProvider{
+		oauth: oauth2.Config{
+			ClientID:     cfg.oidc.clientID,
+			ClientSecret: cfg.oidc.clientSecret,
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  cfg.oidc.redirectURL,
+			Scopes:       cfg.oidc.scopes,
+		},
+		verifier:     provider.Verifier(&oidc.Config{ClientID: cfg.oidc.clientID}),
+		groupsClaim:  cfg.oidc.groupsClaim,
+		groupRoles:   groupRoles,
+		postLoginURL: cfg.oidc.postLoginURL,
+		secureCookie: strings.HasPrefix(cfg.oidc.redirectURL, "https://"),
+	}, nil
+}
+
+// role returns the highest role granted to the groups, ok is false when
+// none of the groups is mapped to a role
+func (p *oidcProvider) role(groups []string) (role string, ok bool) {
+	for _, group := range groups {
+		switch p.groupRoles[group] {
+		case "admin":
+			return "admin", true
+		case "user":
+			role, ok = "user", true
+		}
+	}
+	return role, ok
+}
+
+// groups reads the groups claim of the ID token, a missing claim is no groups
+func (p *oidcProvider) groups(idToken *oidc.IDToken) ([]string, error) {
+	var claims map[string]any
+	err := idToken.Claims(&claims)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []string
+	switch value := claims[p.groupsClaim].(type) {
+	case []any:
+		for _, group := range value {
+			if s, ok := group.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+	case string:
+		groups = strings.Fields(value)
+	}
+	return groups, nil
+}
+
+// oidcStartHandler sends the browser to the identity provider, using the
+// authorization code flow with PKCE
+func (app *application) oidcStartHandler(w http.ResponseWriter, r *http.Request) {
+	pending := &data.OIDCState{
+		State:     rand.Text(),
+		Nonce:     rand.Text(),
+		Verifier:  oauth2.GenerateVerifier(),
+		ExpiresAt: time.Now().Add(oidcStateTTL),
+	}
+
+	err := app.models.OIDCStates.Insert(r.Context(), pending)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    pending.State,
+		Path:     "/v1/auth/oidc",
+		MaxAge:   int(oidcStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   app.oidc.secureCookie,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	authURL := app.oidc.oauth.AuthCodeURL(pending.State, oidc.Nonce(pending.Nonce), oauth2.S256ChallengeOption(pending.Verifier))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// oidcCallbackHandler completes the login the provider redirected back
+// with: the code is exchanged for an ID token, whose subject is looked up
+// or linked to a user, and the user's role is synced from their groups
+func (app *application) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if code := query.Get("error"); code != "" {
+		app.oidcLoginFailedResponse(w, r, fmt.Errorf("the identity provider refused the login: %s", code))
+		return
+	}
+
+	// The state must be the one of this browser's pending login
+	state := query.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if state == "" || err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		app.badRequestResponse(w, r, errors.New("the login state doesn't match this browser, start the login again"))
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/v1/auth/oidc", MaxAge: -1, HttpOnly: true, Secure: app.oidc.secureCookie})
+
+	pending, err := app.models.OIDCStates.Consume(r.Context(), state)
+	if err != nil {
+		if errors.Is(err, data.ErrOIDCStateNotFound) {
+			app.badRequestResponse(w, r, errors.New("the login expired or was already completed, start the login again"))
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	token, err := app.oidc.oauth.Exchange(r.Context(), query.Get("code"), oauth2.VerifierOption(pending.Verifier))
+	if err != nil {
+		app.oidcLoginFailedResponse(w, r, err)
+		return
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		app.oidcLoginFailedResponse(w, r, errors.New("the token response has no ID token"))
+		return
+	}
+	idToken, err := app.oidc.verifier.Verify(r.Context(), rawIDToken)
+	if err != nil {
+		app.oidcLoginFailedResponse(w, r, err)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(pending.Nonce)) != 1 {
+		app.oidcLoginFailedResponse(w, r, errors.New("the ID token nonce doesn't match"))
+		return
+	}
+
+	var claims oidcClaims
+	err = idToken.Claims(&claims)
+	if err != nil {
+		app.oidcLoginFailedResponse(w, r, err)
+		return
+	}
+	groups, err := app.oidc.groups(idToken)
+	if err != nil {
+		app.oidcLoginFailedResponse(w, r, err)
+		return
+	}
+	role, ok := app.oidc.role(groups)
+	if !ok {
+		app.oidcForbiddenResponse(w, r, errOIDCNoRole)
+		return
+	}
+
+	user, err := app.oidcUser(r.Context(), idToken.Issuer, idToken.Subject, claims)
+	if err != nil {
+		switch {
+		case errors.Is(err, errOIDCNoPhoneNumber), errors.Is(err, data.ErrIdentityLinked):
+			app.oidcForbiddenResponse(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if user.Suspended() {
+		app.accountSuspendedResponse(w, r)
+		return
+	}
+
+	// The provider is the authority on the roles of the staff logging in with it
+	if user.Role != role {
+		err = app.models.Users.UpdateRole(r.Context(), user.ID, role)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.logger.Info("synced role from identity provider groups", "user_id", user.ID, "from", user.Role, "to", role)
+		app.audit(asUser(r.Context(), user), data.AuditUserRoleChanged, "user", user.ID, envelope{"role": user.Role}, envelope{"role": role, "groups": groups})
+		user.Role = role
+	}
+
+	// A second factor at the provider counts towards the 2FA policy
+	amr := []string{"fed"}
+	if mfaDone(claims.AMR) {
+		amr = append(amr, "mfa")
+	}
+	authToken, err := app.newToken(user, "authentication", app.config.authTokenTTL, amr...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.audit(asUser(r.Context(), user), data.AuditLoginSucceeded, "user", user.ID, nil, envelope{"amr": amr, "issuer": idToken.Issuer})
+
+	if app.oidc.postLoginURL != "" {
+		fragment := url.Values{
+			"auth_token": {authToken.PlainText},
+			"expiry":     {authToken.Expiry.Format(time.RFC3339)},
+		}
+		http.Redirect(w, r, app.oidc.postLoginURL+"#"+fragment.Encode(), http.StatusSeeOther)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"auth_token": authToken, "user": user})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// oidcUser returns the user the identity is linked to. An identity seen for
+// the first time is linked to the user with the phone number the provider
+// verified, and that user is created when there is none yet
+func (app *application) oidcUser(ctx context.Context, issuer, subject string, claims oidcClaims) (*data.User, error) {
+	user, err := app.models.Identities.GetUser(ctx, issuer, subject)
+	switch {
+	case err == nil:
+		return user, app.models.Identities.Link(ctx, user.ID, issuer, subject)
+	case !errors.Is(err, data.ErrUserNotFound):
+		return nil, err
+	}
+
+	phoneNumber := normalizePhoneNumber(claims.PhoneNumber)
+	if !claims.PhoneNumberVerified || !validator.Matches(phoneNumber, validator.PhoneNumberRegex) {
+		return nil, errOIDCNoPhoneNumber
+	}
+
+	user, err = app.models.Users.GetByPhoneNumber(ctx, phoneNumber)
+	if errors.Is(err, data.ErrUserNotFound) {
+		user, err = app.createOIDCUser(ctx, claims.Name, phoneNumber)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.models.Identities.Link(ctx, user.ID, issuer, subject)
+	if err != nil {
+		return nil, err
+	}
+	app.logger.Info("linked identity provider account", "user_id", user.ID, "issuer", issuer)
+	app.audit(asUser(ctx, user), data.AuditIdentityLinked, "user", user.ID, nil, envelope{"issuer": issuer, "subject": subject})
+	return user, nil
+}
+
+// createOIDCUser creates the account of a staff member logging in for the
+// first time. The password is random, they keep logging in with the provider
+func (app *application) createOIDCUser(ctx context.Context, name, phoneNumber string) (*data.User, error) {
+	if name == "" {
+		name = phoneNumber
+	}
+	user := &data.User{Name: name, PhoneNumber: phoneNumber}
+	err := user.Password.Set(rand.Text())
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.models.Users.Insert(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	app.audit(asUser(ctx, user), data.AuditUserCreated, "user", user.ID, nil, user)
+	return user, nil
+}
+
+// normalizePhoneNumber reduces a phone number as formatted by the provider,
+// such as +91 90000 00001, to the ten digits CityStars stores. Numbers with
+// another country code are returned empty, their last ten digits may well
+// be the number of someone else in India
+func normalizePhoneNumber(phoneNumber string) string {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, phoneNumber)
+
+	switch {
+	case len(digits) == 12 && strings.HasPrefix(digits, "91"):
+		return digits[2:]
+	case len(digits) > 10 || strings.HasPrefix(strings.TrimSpace(phoneNumber), "+"):
+		return ""
+	}
+	return digits
+}
+
+// mfaDone reports whether the authentication methods include a second factor
+func mfaDone(amr []string) bool {
+	for _, method := range amr {
+		if method == "otp" || method == "mfa" {
+			return true
+		}
+	}
+	return false
+}
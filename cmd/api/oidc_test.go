@@ -0,0 +1,401 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VJ-2303/CityStars/internal/data"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockOIDCProvider is a local OpenID Connect provider. Instead of a login
+// page, tests authorize the redirect to it with the claims of the account
+type mockOIDCProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]mockAuthorization
+}
+
+// mockAuthorization is an authorization code along with what it was issued for
+type mockAuthorization struct {
+	challenge string
+	nonce     string
+	claims    jwt.MapClaims
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockOIDCProvider{key: key, codes: map[string]mockAuthorization{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                p.server.URL,
+			"authorization_endpoint":                p.server.URL + "/authorize",
+			"token_endpoint":                        p.server.URL + "/token",
+			"jwks_uri":                              p.server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "mock",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", p.token)
+
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+// token exchanges an authorization code for an ID token, checking the PKCE verifier
+func (p *mockOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	auth, ok := p.codes[r.PostFormValue("code")]
+	delete(p.codes, r.PostFormValue("code"))
+	p.mu.Unlock()
+
+	challenge := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(challenge[:]) != auth.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "invalid_grant"}`))
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"iss":   p.server.URL,
+		"aud":   "citystars",
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": auth.nonce,
+	}
+	for name, value := range auth.claims {
+		claims[name] = value
+	}
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	idToken.Header["kid"] = "mock"
+	signed, err := idToken.SignedString(p.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "mock-access-token",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     signed,
+	})
+}
+
+// authorize stands in for the user logging in at the provider, returning
+// the query of the redirect back to the callback
+func (p *mockOIDCProvider) authorize(t *testing.T, location string, claims jwt.MapClaims) url.Values {
+	t.Helper()
+
+	u, err := url.Parse(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if !strings.HasPrefix(location, p.server.URL+"/authorize?") || q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" || q.Get("client_id") != "citystars" {
+		t.Fatalf("unexpected authorization request %s", location)
+	}
+
+	nonce := q.Get("nonce")
+	if n, ok := claims["nonce"].(string); ok {
+		nonce = n
+	}
+
+	code := rand.Text()
+	p.mu.Lock()
+	p.codes[code] = mockAuthorization{challenge: q.Get("code_challenge"), nonce: nonce, claims: claims}
+	p.mu.Unlock()
+
+	return url.Values{"code": {code}, "state": {q.Get("state")}}
+}
+
+// newOIDCTestApplication returns a test application logging staff in with the provider
+func newOIDCTestApplication(t *testing.T, p *mockOIDCProvider) *application {
+	t.Helper()
+
+	app := newTestApplication(t)
+	app.config.oidc.issuer = p.server.URL
+	app.config.oidc.clientID = "citystars"
+	app.config.oidc.clientSecret = "mock-secret"
+	app.config.oidc.redirectURL = "https://api.citystars.example/v1/auth/oidc/callback"
+	app.config.oidc.scopes = []string{"openid", "profile", "phone"}
+	app.config.oidc.groupsClaim = "groups"
+	app.config.oidc.groupRoles = []string{"city-admins=admin", "city-staff=user"}
+
+	var err error
+	app.oidc, err = newOIDCProvider(t.Context(), &app.config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return app
+}
+
+// startOIDCLogin starts a login, returning the authorization request the
+// browser is sent to along with the state cookie
+func (app *application) startOIDCLogin(t *testing.T) (string, *http.Cookie) {
+	t.Helper()
+
+	res := app.do(t, http.MethodGet, "/v1/auth/oidc/start", "", nil)
+	checkStatus(t, res, http.StatusFound)
+
+	cookies, err := http.ParseSetCookie(res.header.Get("Set-Cookie"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cookies.HttpOnly || !cookies.Secure || cookies.SameSite != http.SameSiteLaxMode {
+		t.Errorf("unexpected state cookie %v", cookies)
+	}
+	return res.header.Get("Location"), cookies
+}
+
+// oidcCallback sends the redirect back from the provider with the cookie
+func (app *application) oidcCallback(t *testing.T, query url.Values, cookie *http.Cookie) testResponse {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/auth/oidc/callback?"+query.Encode(), nil)
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	return app.send(t, r)
+}
+
+// oidcLogin logs in at the provider with the claims
+func (app *application) oidcLogin(t *testing.T, p *mockOIDCProvider, claims jwt.MapClaims) testResponse {
+	t.Helper()
+
+	location, cookie := app.startOIDCLogin(t)
+	return app.oidcCallback(t, p.authorize(t, location, claims), cookie)
+}
+
+// staffClaims returns the claims of a staff account with a verified phone number
+func staffClaims(subject, phoneNumber string, groups ...string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub":                   subject,
+		"name":                  "Staff Member " + subject,
+		"phone_number":          phoneNumber,
+		"phone_number_verified": true,
+		"groups":                groups,
+	}
+}
+
+func TestOIDCLogin(t *testing.T) {
+	p := newMockOIDCProvider(t)
+	app := newOIDCTestApplication(t, p)
+	citizen := app.insertUser(t, "Priya Raman", "9000000001", "pa55word-1234", "user")
+
+	var loginUser struct {
+		ID          int64  `json:"id"`
+		PhoneNumber string `json:"phone_number"`
+		Role        string `json:"role"`
+	}
+	var authToken struct {
+		Token string `json:"token"`
+	}
+
+	t.Run("new staff member", func(t *testing.T) {
+		res := app.oidcLogin(t, p, staffClaims("staff-1", "+91 90000 00002", "city-admins", "unrelated"))
+		checkStatus(t, res, http.StatusCreated)
+		decodeField(t, res, "user", &loginUser)
+		decodeField(t, res, "auth_token", &authToken)
+		if loginUser.PhoneNumber != "9000000002" || loginUser.Role != "admin" {
+			t.Errorf("unexpected user %+v", loginUser)
+		}
+
+		res = app.do(t, http.MethodGet, "/v1/admin/me", authToken.Token, nil)
+		checkStatus(t, res, http.StatusOK)
+	})
+
+	t.Run("returning staff member", func(t *testing.T) {
+		firstID := loginUser.ID
+
+		// The identity is found by its subject, the phone number no longer matters
+		res := app.oidcLogin(t, p, staffClaims("staff-1", "", "city-staff"))
+		checkStatus(t, res, http.StatusCreated)
+		decodeField(t, res, "user", &loginUser)
+		if loginUser.ID != firstID || loginUser.Role != "user" {
+			t.Errorf("got user %+v, want user %d demoted to user", loginUser, firstID)
+		}
+	})
+
+	t.Run("existing account", func(t *testing.T) {
+		res := app.oidcLogin(t, p, staffClaims("staff-2", "9000000001", "city-admins"))
+		checkStatus(t, res, http.StatusCreated)
+		decodeField(t, res, "user", &loginUser)
+		if loginUser.ID != citizen.ID || loginUser.Role != "admin" {
+			t.Errorf("got user %+v, want user %d linked as admin", loginUser, citizen.ID)
+		}
+
+		// The password keeps working alongside the provider
+		res = app.do(t, http.MethodPost, "/v1/user/login", "", map[string]string{"phone_number": "9000000001", "password": "pa55word-1234"})
+		checkStatus(t, res, http.StatusCreated)
+	})
+
+	t.Run("foreign number", func(t *testing.T) {
+		// The last ten digits are those of another citizen, who mustn't be linked
+		other := app.insertUser(t, "Karthik Subramanian", "9000000006", "pa55word-1234", "user")
+		for _, phoneNumber := range []string{"+44 90000 00006", "+1 (900) 000-0006"} {
+			res := app.oidcLogin(t, p, staffClaims("staff-6", phoneNumber, "city-admins"))
+			checkStatus(t, res, http.StatusForbidden)
+		}
+
+		user, err := app.models.Users.GetByPhoneNumber(t.Context(), other.PhoneNumber)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Role != "user" {
+			t.Errorf("got role %q, want the citizen to stay a user", user.Role)
+		}
+		_, err = app.models.Identities.GetUser(t.Context(), p.server.URL, "staff-6")
+		if !errors.Is(err, data.ErrUserNotFound) {
+			t.Errorf("got error %v, want the identity left unlinked", err)
+		}
+	})
+
+	unverified := staffClaims("staff-3", "9000000003", "city-staff")
+	unverified["phone_number_verified"] = false
+	wrongNonce := staffClaims("staff-4", "9000000004", "city-staff")
+	wrongNonce["nonce"] = "forged"
+
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		want   int
+	}{
+		{"no mapped group", staffClaims("staff-5", "9000000005", "unrelated"), http.StatusForbidden},
+		{"no groups", staffClaims("staff-5", "9000000005"), http.StatusForbidden},
+		{"unverified phone number", unverified, http.StatusForbidden},
+		{"wrong nonce", wrongNonce, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := app.oidcLogin(t, p, tt.claims)
+			checkStatus(t, res, tt.want)
+		})
+	}
+}
+
+func TestOIDCCallbackState(t *testing.T) {
+	p := newMockOIDCProvider(t)
+	app := newOIDCTestApplication(t, p)
+	claims := staffClaims("staff-1", "9000000001", "city-staff")
+
+	t.Run("other browser", func(t *testing.T) {
+		location, _ := app.startOIDCLogin(t)
+		_, otherCookie := app.startOIDCLogin(t)
+
+		res := app.oidcCallback(t, p.authorize(t, location, claims), otherCookie)
+		checkStatus(t, res, http.StatusBadRequest)
+
+		res = app.oidcCallback(t, p.authorize(t, location, claims), nil)
+		checkStatus(t, res, http.StatusBadRequest)
+	})
+
+	t.Run("replayed callback", func(t *testing.T) {
+		location, cookie := app.startOIDCLogin(t)
+		query := p.authorize(t, location, claims)
+
+		res := app.oidcCallback(t, query, cookie)
+		checkStatus(t, res, http.StatusCreated)
+
+		res = app.oidcCallback(t, query, cookie)
+		checkStatus(t, res, http.StatusBadRequest)
+	})
+
+	t.Run("provider error", func(t *testing.T) {
+		location, cookie := app.startOIDCLogin(t)
+		query := p.authorize(t, location, claims)
+		query.Set("error", "access_denied")
+
+		res := app.oidcCallback(t, query, cookie)
+		checkStatus(t, res, http.StatusUnauthorized)
+	})
+
+	t.Run("unknown code", func(t *testing.T) {
+		location, cookie := app.startOIDCLogin(t)
+		query := p.authorize(t, location, claims)
+		query.Set("code", "forged")
+
+		res := app.oidcCallback(t, query, cookie)
+		checkStatus(t, res, http.StatusUnauthorized)
+	})
+
+	t.Run("post login redirect", func(t *testing.T) {
+		app.oidc.postLoginURL = "https://citystars.example/pages/login.html"
+		t.Cleanup(func() { app.oidc.postLoginURL = "" })
+
+		res := app.oidcLogin(t, p, claims)
+		checkStatus(t, res, http.StatusSeeOther)
+
+		location := res.header.Get("Location")
+		fragment, ok := strings.CutPrefix(location, "https://citystars.example/pages/login.html#")
+		if !ok {
+			t.Fatalf("got redirect to %s", location)
+		}
+		values, err := url.ParseQuery(fragment)
+		if err != nil || values.Get("auth_token") == "" || values.Get("expiry") == "" {
+			t.Errorf("unexpected fragment %q", fragment)
+		}
+	})
+}
+
+func TestOIDCDisabled(t *testing.T) {
+	app := newTestApplication(t)
+
+	res := app.do(t, http.MethodGet, "/v1/auth/oidc/start", "", nil)
+	checkStatus(t, res, http.StatusNotFound)
+}
+
+func TestNormalizePhoneNumber(t *testing.T) {
+	tests := map[string]string{
+		"9000000001":       "9000000001",
+		"+91 90000 00001":  "9000000001",
+		"+91-9000-000-001": "9000000001",
+		"919000000001":     "9000000001",
+		"+44 90000 00001":  "",
+		"+1 900 000 0001":  "",
+		"+9000000001":      "",
+		"09000000001":      "",
+		"12345":            "12345",
+		"":                 "",
+	}
+
+	for phoneNumber, want := range tests {
+		if got := normalizePhoneNumber(phoneNumber); got != want {
+			t.Errorf("got %q for %q, want %q", got, phoneNumber, want)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/VJ-2303/CityStars/internal/data"
+)
+
+// Outbox dispatch policy, failed events are retried with an exponential
+// backoff until outboxMaxAttempts is reached and they are left dead.
+// Processed events are kept for a while for troubleshooting
+const (
+	outboxBatchSize   = 50
+	outboxMaxAttempts = 20
+	outboxBaseRetry   = 5 * time.Second
+	outboxMaxRetry    = 10 * time.Minute
+	outboxRetention   = 7 * 24 * time.Hour
+	outboxPruneEvery  = time.Hour
+)
+
+// outboxHandler consumes a single outbox event. Events are delivered at
+// least once, so handlers must use the event's idempotency key to ignore
+// events they have already handled
+type outboxHandler func(ctx context.Context, e *data.OutboxEvent) error
+
+// namedOutboxHandler is a registered handler, the name records on the
+// event that the handler is done with it
+type namedOutboxHandler struct {
+	name   string
+	handle outboxHandler
+}
+
+// registerOutboxHandlers registers the consumers of the report events
+func (app *application) registerOutboxHandlers() {
+	app.outboxHandlers = map[string][]namedOutboxHandler{
+		data.EventReportCreated: {
+			{"stream", app.publishReportEvent},
+			{"webhooks", app.enqueueWebhooks},
+			{"metrics", app.countReportEvent},
+		},
+		data.EventReportUpdated: {
+			{"stream", app.publishReportEvent},
+			{"notifications", app.notifyStatusChange},
+			{"messages", app.queueStatusChangeMessages},
+			{"webhooks", app.enqueueWebhooks},
+			{"metrics", app.countReportEvent},
+		},
+	}
+}
+
+// handleOutboxEvent hands the event to every handler registered for its
+// type which hasn't handled it yet. The first failure stops the event, on
+// the retry only the handlers from the failed one on run again
+func (app *application) handleOutboxEvent(ctx context.Context, e *data.OutboxEvent) error {
+	for _, h := range app.outboxHandlers[e.EventType] {
+		if e.WasHandled(h.name) {
+			continue
+		}
+
+		err := h.handle(ctx, e)
+		if err != nil {
+			if e.Attempts+1 >= outboxMaxAttempts {
+				app.logger.Error("outbox event dead after repeated failures", "event_id", e.ID, "event_type", e.EventType, "handler", h.name, "attempts", e.Attempts+1, "error", err)
+			} else {
+				app.logger.Warn("outbox handler failed", "event_id", e.ID, "event_type", e.EventType, "handler", h.name, "attempts", e.Attempts, "error", err)
+			}
+			return fmt.Errorf("handling %s event %d in %s: %w", e.EventType, e.ID, h.name, err)
+		}
+		e.MarkHandled(h.name)
+	}
+	return nil
+}
+
+// dispatchOutbox polls the outbox and dispatches due events to their
+// handlers, it runs until ctx is cancelled
+func (app *application) dispatchOutbox(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	lastPrune := time.Time{}
+
+	for {
+		for {
+			n, err := app.models.Outbox.ProcessBatch(ctx, outboxBatchSize, outboxMaxAttempts, app.handleOutboxEvent, outboxBackoff)
+			if err != nil {
+				app.logger.Error("failed to dispatch outbox events", "error", err)
+				break
+			}
+			if n < outboxBatchSize {
+				break
+			}
+		}
+
+		if time.Since(lastPrune) > outboxPruneEvery {
+			_, err := app.models.Outbox.DeleteProcessedBefore(ctx, outboxRetention)
+			if err != nil {
+				app.logger.Error("failed to prune outbox", "error", err)
+			}
+			lastPrune = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// outboxBackoff returns how long to wait before retrying an event
+// which has failed the given number of times
+func outboxBackoff(attempts int) time.Duration {
+	backoff := time.Duration(float64(outboxBaseRetry) * math.Pow(2, float64(attempts-1)))
+	if backoff > outboxMaxRetry {
+		backoff = outboxMaxRetry
+	}
+	return backoff
+}
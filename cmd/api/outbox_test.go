@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VJ-2303/CityStars/internal/data"
+	"github.com/VJ-2303/CityStars/internal/events"
+)
+
+func TestOutboxBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 5 * time.Second},
+		{2, 10 * time.Second},
+		{5, 80 * time.Second},
+		{7, 320 * time.Second},
+		{8, outboxMaxRetry},
+		{outboxMaxAttempts, outboxMaxRetry},
+	}
+
+	for _, tt := range tests {
+		if got := outboxBackoff(tt.attempts); got != tt.want {
+			t.Errorf("got backoff %s after %d attempts, want %s", got, tt.attempts, tt.want)
+		}
+	}
+}
+
+func TestHandleOutboxEvent(t *testing.T) {
+	app := newTestApplication(t)
+	user := app.insertUser(t, "Priya Raman", "9000000001", "pa55word-1234", "user")
+
+	calls := map[string]int{}
+	failing := map[string]int{} // Failures left by handler
+	handler := func(name string) namedOutboxHandler {
+		return namedOutboxHandler{name, func(ctx context.Context, e *data.OutboxEvent) error {
+			calls[name]++
+			if failing[name] != 0 {
+				failing[name]--
+				return errTest
+			}
+			return nil
+		}}
+	}
+	app.outboxHandlers = map[string][]namedOutboxHandler{
+		data.EventReportCreated: {handler("stream"), handler("webhooks"), handler("metrics")},
+	}
+
+	// dispatch makes the next attempt of the pending events right away
+	dispatch := func(t *testing.T) int {
+		t.Helper()
+		n, err := app.models.Outbox.ProcessBatch(t.Context(), 10, outboxMaxAttempts, app.handleOutboxEvent, func(int) time.Duration { return 0 })
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	t.Run("retry skips handled", func(t *testing.T) {
+		clear(calls)
+		failing["webhooks"] = 2
+		app.insertReport(t, user.ID, "Broken streetlight", "streetlight")
+
+		for range 3 {
+			if n := dispatch(t); n != 1 {
+				t.Fatalf("dispatched %d events, want 1", n)
+			}
+		}
+		if n := dispatch(t); n != 0 {
+			t.Fatalf("dispatched %d events after the event succeeded", n)
+		}
+
+		want := map[string]int{"stream": 1, "webhooks": 3, "metrics": 1}
+		for name, n := range want {
+			if calls[name] != n {
+				t.Errorf("%s ran %d times, want %d", name, calls[name], n)
+			}
+		}
+	})
+
+	t.Run("dead after max attempts", func(t *testing.T) {
+		clear(calls)
+		failing["metrics"] = outboxMaxAttempts + 1
+		app.insertReport(t, user.ID, "Overflowing garbage bin", "garbage")
+
+		for range outboxMaxAttempts + 2 {
+			dispatch(t)
+		}
+
+		if calls["stream"] != 1 || calls["metrics"] != outboxMaxAttempts {
+			t.Errorf("got calls %v, want metrics to run %d times", calls, outboxMaxAttempts)
+		}
+	})
+}
+
+func TestReportWithdrawnEvent(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.metrics.username = "prometheus"
+	app.config.metrics.password = "scrape-secret"
+	user := app.insertUser(t, "Priya Raman", "9000000001", "pa55word-1234", "user")
+
+	prefs := data.DefaultNotificationPreferences(user.ID)
+	prefs.Email, prefs.EmailEnabled = "priya@example.com", true
+	err := app.models.Preferences.Upsert(t.Context(), prefs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wh := &data.Webhook{URL: "https://hooks.example.com/citystars", Secret: "0123456789abcdef0123456789abcdef", EventTypes: []string{data.WebhookReportStatusChanged}, Active: true}
+	err = app.models.Webhooks.Insert(t.Context(), wh)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub, _ := app.events.Subscribe(0, func(e events.Event) bool { return e.Type == data.EventReportUpdated })
+	defer app.events.Unsubscribe(sub)
+
+	// The reporter is only told about the report an admin moved on
+	updated := app.insertReport(t, user.ID, "Broken streetlight", "streetlight")
+	withdrawn := app.insertReport(t, user.ID, "Overflowing bin", "garbage")
+	err = app.models.Reports.Update(t.Context(), updated.ID, 0, "in-progress", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.models.Reports.Withdraw(t.Context(), withdrawn.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.dispatchPendingEvents(t)
+
+	notifications, err := app.models.Notifications.GetForUser(t.Context(), user.ID, false, 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notifications) != 1 || notifications[0].ReportID == nil || *notifications[0].ReportID != updated.ID {
+		t.Errorf("got notifications %+v, want one for report %d", notifications, updated.ID)
+	}
+
+	messages, err := app.models.Messages.GetForUser(t.Context(), user.ID, 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 {
+		t.Errorf("got %d messages, want 1", len(messages))
+	}
+
+	deliveries, err := app.models.Deliveries.GetForWebhook(t.Context(), wh.ID, "", 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || !strings.Contains(string(deliveries[0].Payload), `"status":"in-progress"`) {
+		t.Errorf("got deliveries %+v, want the in-progress status change only", deliveries)
+	}
+
+	// The public stream and the metrics still see the withdrawal
+	if n := len(sub.C); n != 2 {
+		t.Errorf("got %d report updates on the stream, want 2", n)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	r.SetBasicAuth("prometheus", "scrape-secret")
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, r)
+	if !strings.Contains(rr.Body.String(), `citystars_report_status_transitions_total{from="pending",to="withdrawn"} 1`) {
+		t.Error("withdrawal missing from the status transition metrics")
+	}
+}
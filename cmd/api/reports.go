@@ -43,7 +43,7 @@ func (app *application) CreateReportHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.models.Reports.Insert(report)
+	err = app.models.Reports.Insert(r.Context(), report)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -77,7 +77,7 @@ func (app *application) ListAllReportsHandler(w http.ResponseWriter, r *http.Req
 	status := qs.Get("status")
 	category := qs.Get("category")
 
-	reports, err := app.models.Reports.GetAll(limit, offset, status, category)
+	reports, err := app.models.Reports.GetAll(r.Context(), limit, offset, status, category)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -97,7 +97,7 @@ func (app *application) GetReportHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	report, err := app.models.Reports.Get(id)
+	report, err := app.models.Reports.Get(r.Context(), id)
 	if err != nil {
 		if errors.Is(err, data.ErrReportNotFound) {
 			app.notFoundResponse(w, r)
@@ -107,13 +107,16 @@ func (app *application) GetReportHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	w.Header().Set("ETag", versionETag(report.Version))
 	err = app.writeJSON(w, http.StatusOK, envelope{"report": report})
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
-// UpdateReportStatusHandler allows admins to update report status and add after image
+// UpdateReportStatusHandler allows admins to update report status and add after
+// image. The update names the version it applies to with If-Match or the
+// version field, so that concurrent updates don't overwrite each other
 func (app *application) UpdateReportStatusHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 	if err != nil {
@@ -124,6 +127,7 @@ func (app *application) UpdateReportStatusHandler(w http.ResponseWriter, r *http
 	var input struct {
 		Status     string `json:"status"`
 		AfterImage string `json:"after_image"`
+		Version    *int32 `json:"version"`
 	}
 
 	err = app.readJSON(w, r, &input)
@@ -139,23 +143,205 @@ func (app *application) UpdateReportStatusHandler(w http.ResponseWriter, r *http
 		return
 	}
 
-	err = app.models.Reports.Update(id, input.Status, input.AfterImage)
+	version, ok, err := app.readIfMatch(r, input.Version)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if !ok {
+		app.preconditionRequiredResponse(w, r)
+		return
+	}
+
+	err = app.models.Reports.Update(r.Context(), id, version, input.Status, input.AfterImage)
+	if err != nil {
+		if errors.Is(err, data.ErrReportNotEditable) {
+			app.reportWithdrawnResponse(w, r)
+			return
+		}
+		app.reportWriteFailedResponse(w, r, id, err)
+		return
+	}
+
+	app.writeReport(w, r, id)
+}
+
+// UpdateReportHandler sends the PATCH requests of admins and API keys to
+// the status update, and those of citizens to the edit of their own report
+func (app *application) UpdateReportHandler(w http.ResponseWriter, r *http.Request) {
+	role, _ := r.Context().Value(userRoleKey).(string)
+	_, isAPIKey := r.Context().Value(apiKeyKey).(*data.APIKey)
+
+	if isAPIKey || role == "admin" {
+		app.requireScope(data.ScopeReportsUpdateStatus, app.UpdateReportStatusHandler).ServeHTTP(w, r)
+		return
+	}
+	app.EditReportHandler(w, r)
+}
+
+// EditReportHandler lets the reporter fix the title, description, location
+// and photo of their report while it is still pending. The fields left out
+// of the body are kept, and like the status update the edit names the
+// version it applies to
+func (app *application) EditReportHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(userIDKey).(int64)
+
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var input struct {
+		Title       *string `json:"title"`
+		Description *string `json:"description"`
+		Location    *string `json:"location"`
+		BeforeImage *string `json:"before_image"`
+		Version     *int32  `json:"version"`
+
+		// Only admins update these, they're read to refuse the request
+		Status     *string `json:"status"`
+		AfterImage *string `json:"after_image"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Status != nil || input.AfterImage != nil {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	version, ok, err := app.readIfMatch(r, input.Version)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if !ok {
+		app.preconditionRequiredResponse(w, r)
+		return
+	}
+
+	report, ok := app.ownReport(w, r, id, userID)
+	if !ok {
+		return
+	}
+
+	if input.Title != nil {
+		report.Title = *input.Title
+	}
+	if input.Description != nil {
+		report.Description = *input.Description
+	}
+	if input.Location != nil {
+		report.Location = *input.Location
+	}
+	if input.BeforeImage != nil {
+		report.BeforeImage = *input.BeforeImage
+	}
+
+	v := validator.New()
+	if data.ValidateReport(v, report); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Reports.Edit(r.Context(), report, version)
+	if err != nil {
+		app.reportWriteFailedResponse(w, r, id, err)
+		return
+	}
+
+	w.Header().Set("ETag", versionETag(report.Version))
+	err = app.writeJSON(w, http.StatusOK, envelope{"report": report})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// WithdrawReportHandler lets the reporter withdraw their report until it is
+// resolved. An If-Match header makes the withdrawal apply to that version
+// only, without one it applies to whichever version is current
+func (app *application) WithdrawReportHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(userIDKey).(int64)
+
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	version, _, err := app.readIfMatch(r, nil)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if _, ok := app.ownReport(w, r, id, userID); !ok {
+		return
+	}
+
+	err = app.models.Reports.Withdraw(r.Context(), id, version)
+	if err != nil {
+		app.reportWriteFailedResponse(w, r, id, err)
+		return
+	}
+
+	app.writeReport(w, r, id)
+}
+
+// ownReport returns the report when it was submitted by the user, and
+// sends the 404 or 403 response otherwise
+func (app *application) ownReport(w http.ResponseWriter, r *http.Request, id, userID int64) (*data.Report, bool) {
+	report, err := app.models.Reports.Get(r.Context(), id)
 	if err != nil {
 		if errors.Is(err, data.ErrReportNotFound) {
 			app.notFoundResponse(w, r)
 		} else {
 			app.serverErrorResponse(w, r, err)
 		}
-		return
+		return nil, false
+	}
+
+	if report.UserID != userID {
+		app.notReportOwnerResponse(w, r)
+		return nil, false
+	}
+	return report, true
+}
+
+// reportWriteFailedResponse sends the response to a failed write of the
+// report, a conflict comes with the report as it is now
+func (app *application) reportWriteFailedResponse(w http.ResponseWriter, r *http.Request, id int64, err error) {
+	switch {
+	case errors.Is(err, data.ErrReportNotFound):
+		app.notFoundResponse(w, r)
+	case errors.Is(err, data.ErrReportNotEditable):
+		app.reportNotEditableResponse(w, r)
+	case errors.Is(err, data.ErrEditConflict):
+		current, err := app.models.Reports.Get(r.Context(), id)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.editConflictResponse(w, r, current)
+	default:
+		app.serverErrorResponse(w, r, err)
 	}
+}
 
-	// Retrieve the updated report
-	report, err := app.models.Reports.Get(id)
+// writeReport responds with the report as written, along with its ETag
+func (app *application) writeReport(w http.ResponseWriter, r *http.Request, id int64) {
+	report, err := app.models.Reports.Get(r.Context(), id)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	w.Header().Set("ETag", versionETag(report.Version))
 	err = app.writeJSON(w, http.StatusOK, envelope{"report": report})
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -183,7 +369,7 @@ func (app *application) GetUserReportsHandler(w http.ResponseWriter, r *http.Req
 		}
 	}
 
-	reports, err := app.models.Reports.GetByUserID(userID, limit, offset)
+	reports, err := app.models.Reports.GetByUserID(r.Context(), userID, limit, offset)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -197,7 +383,7 @@ func (app *application) GetUserReportsHandler(w http.ResponseWriter, r *http.Req
 
 // GetReportStatsHandler returns statistics about reports (public endpoint)
 func (app *application) GetReportStatsHandler(w http.ResponseWriter, r *http.Request) {
-	stats, err := app.models.Reports.GetStats()
+	stats, err := app.models.Reports.GetStats(r.Context())
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -211,7 +397,7 @@ func (app *application) GetReportStatsHandler(w http.ResponseWriter, r *http.Req
 
 // GetLeaderboardHandler returns the top 10 users with most reports (public endpoint)
 func (app *application) GetLeaderboardHandler(w http.ResponseWriter, r *http.Request) {
-	leaderboard, err := app.models.Reports.GetLeaderboard()
+	leaderboard, err := app.models.Reports.GetLeaderboard(r.Context())
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/VJ-2303/CityStars/internal/data"
+)
+
+// testReport holds the report fields the tests look at, data.Report can't
+// be decoded as data.Time only implements JSON encoding
+type testReport struct {
+	ID          int64   `json:"id"`
+	UserID      int64   `json:"user_id"`
+	Title       string  `json:"title"`
+	Description string  `json:"description"`
+	Status      string  `json:"status"`
+	AfterImage  string  `json:"after_image"`
+	CompletedAt *string `json:"completed_at"`
+	Version     int32   `json:"version"`
+	UserName    string  `json:"user_name"`
+}
+
+func TestCreateReport(t *testing.T) {
+	app := newTestApplication(t)
+	user := app.insertUser(t, "Priya Raman", "9000000001", "pa55word-1234", "user")
+	token := app.tokenFor(t, user)
+
+	valid := map[string]string{
+		"title":        "Pothole on Anna Salai",
+		"description":  "A deep pothole near the bus stop",
+		"category":     "pothole",
+		"location":     "Anna Salai, Chennai",
+		"before_image": "https://img.example.com/before.jpg",
+	}
+	invalid := map[string]string{
+		"title":        "Pothole on Anna Salai",
+		"description":  "A deep pothole near the bus stop",
+		"category":     "volcano",
+		"location":     "Anna Salai, Chennai",
+		"before_image": "https://img.example.com/before.jpg",
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		body  any
+		want  int
+	}{
+		{"valid", token, valid, http.StatusCreated},
+		{"invalid category", token, invalid, http.StatusUnprocessableEntity},
+		{"empty body", token, map[string]string{}, http.StatusUnprocessableEntity},
+		{"without token", "", valid, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := app.do(t, http.MethodPost, "/v1/reports", tt.token, tt.body)
+			checkStatus(t, res, tt.want)
+		})
+	}
+
+	t.Run("response", func(t *testing.T) {
+		res := app.do(t, http.MethodPost, "/v1/reports", token, valid)
+		checkStatus(t, res, http.StatusCreated)
+
+		var report testReport
+		decodeField(t, res, "report", &report)
+		if report.ID == 0 || report.UserID != user.ID || report.Status != "pending" {
+			t.Errorf("unexpected report %+v", report)
+		}
+	})
+}
+
+func TestListReports(t *testing.T) {
+	app := newTestApplication(t)
+	user := app.insertUser(t, "Priya Raman", "9000000001", "pa55word-1234", "user")
+
+	first := app.insertReport(t, user.ID, "Broken streetlight", "streetlight")
+	middle := app.insertReport(t, user.ID, "Pothole near school", "pothole")
+	last := app.insertReport(t, user.ID, "Overflowing bin", "garbage")
+
+	err := app.models.Reports.Update(t.Context(), first.ID, first.Version, "in-progress", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		query  string
+		wantID []int64
+	}{
+		{"newest first", "", []int64{last.ID, middle.ID, first.ID}},
+		{"by status", "?status=in-progress", []int64{first.ID}},
+		{"by category", "?category=garbage", []int64{last.ID}},
+		{"no match", "?status=completed", []int64{}},
+		{"paginated", "?limit=1&offset=1", []int64{middle.ID}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := app.do(t, http.MethodGet, "/v1/reports"+tt.query, "", nil)
+			checkStatus(t, res, http.StatusOK)
+
+			var reports []testReport
+			decodeField(t, res, "reports", &reports)
+			if len(reports) != len(tt.wantID) {
+				t.Fatalf("got %d reports, want %d", len(reports), len(tt.wantID))
+			}
+			for i, r := range reports {
+				if r.ID != tt.wantID[i] {
+					t.Errorf("report %d has id %d, want %d", i, r.ID, tt.wantID[i])
+				}
+				if r.UserName != user.Name {
+					t.Errorf("report %d has user name %q", i, r.UserName)
+				}
+			}
+		})
+	}
+}
+
+func TestGetReport(t *testing.T) {
+	app := newTestApplication(t)
+	user := app.insertUser(t, "Priya Raman", "9000000001", "pa55word-1234", "user")
+	report := app.insertReport(t, user.ID, "Broken streetlight", "streetlight")
+
+	tests := []struct {
+		name string
+		path string
+		want int
+	}{
+		{"existing", fmt.Sprintf("/v1/reports/%d", report.ID), http.StatusOK},
+		{"missing", "/v1/reports/9999", http.StatusNotFound},
+		{"invalid id", "/v1/reports/abc", http.StatusBadRequest},
+		{"negative id", "/v1/reports/-1", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := app.do(t, http.MethodGet, tt.path, "", nil)
+			checkStatus(t, res, tt.want)
+		})
+	}
+}
+
+func TestUpdateReportStatus(t *testing.T) {
+	app := newTestApplication(t)
+	user := app.insertUser(t, "Priya Raman", "9000000001", "pa55word-1234", "user")
+	admin := app.insertUser(t, "Admin Person", "9000000002", "pa55word-1234", "admin")
+	report := app.insertReport(t, user.ID, "Broken streetlight", "streetlight")
+	path := fmt.Sprintf("/v1/reports/%d", report.ID)
+
+	tests := []struct {
+		name  string
+		path  string
+		token string
+		body  any
+		want  int
+	}{
+		{"as user", path, app.tokenFor(t, user), map[string]any{"status": "in-progress", "version": 1}, http.StatusForbidden},
+		{"without token", path, "", map[string]any{"status": "in-progress", "version": 1}, http.StatusUnauthorized},
+		{"invalid status", path, app.tokenFor(t, admin), map[string]any{"status": "fixed", "version": 1}, http.StatusUnprocessableEntity},
+		{"completed without after image", path, app.tokenFor(t, admin), map[string]any{"status": "completed", "version": 1}, http.StatusUnprocessableEntity},
+		{"missing report", "/v1/reports/9999", app.tokenFor(t, admin), map[string]any{"status": "in-progress", "version": 1}, http.StatusNotFound},
+		{"without version", path, app.tokenFor(t, admin), map[string]any{"status": "in-progress"}, http.StatusPreconditionRequired},
+		{"invalid version", path, app.tokenFor(t, admin), map[string]any{"status": "in-progress", "version": 0}, http.StatusBadRequest},
+		{"in progress", path, app.tokenFor(t, admin), map[string]any{"status": "in-progress", "version": 1}, http.StatusOK},
+		{"stale version", path, app.tokenFor(t, admin), map[string]any{"status": "rejected", "version": 1}, http.StatusConflict},
+		{"completed", path, app.tokenFor(t, admin), map[string]any{"status": "completed", "after_image": "https://img.example.com/after.jpg", "version": 2}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := app.do(t, http.MethodPatch, tt.path, tt.token, tt.body)
+			checkStatus(t, res, tt.want)
+		})
+	}
+
+	res := app.do(t, http.MethodGet, path, "", nil)
+	var updated testReport
+	decodeField(t, res, "report", &updated)
+	if updated.Status != "completed" || updated.CompletedAt == nil || updated.AfterImage == "" || updated.Version != 3 {
+		t.Errorf("report not completed: %+v", updated)
+	}
+	if etag := res.header.Get("ETag"); etag != `"3"` {
+		t.Errorf("got ETag %s, want \"3\"", etag)
+	}
+}
+
+func TestUpdateReportIfMatch(t *testing.T) {
+	app := newTestApplication(t)
+	user := app.insertUser(t, "Priya Raman", "9000000001", "pa55word-1234", "user")
+	admin := app.insertUser(t, "Admin Person", "9000000002", "pa55word-1234", "admin")
+	report := app.insertReport(t, user.ID, "Broken streetlight", "streetlight")
+	path := fmt.Sprintf("/v1/reports/%d", report.ID)
+
+	// The steps run in order against the same report
+	tests := []struct {
+		name     string
+		ifMatch  string
+		body     string
+		want     int
+		wantETag string
+	}{
+		{"current ETag", `"1"`, `{"status": "in-progress"}`, http.StatusOK, `"2"`},
+		{"stale ETag", `"1"`, `{"status": "rejected"}`, http.StatusConflict, `"2"`},
+		{"weak ETag", `W/"2"`, `{"status": "rejected"}`, http.StatusBadRequest, ""},
+		{"ETag and version disagree", `"2"`, `{"status": "rejected", "version": 1}`, http.StatusBadRequest, ""},
+		{"any version", "*", `{"status": "rejected"}`, http.StatusOK, `"3"`},
+		{"any version with stale body version", "*", `{"status": "in-progress", "version": 2}`, http.StatusConflict, `"3"`},
+		{"any version with body version", "*", `{"status": "rejected", "version": 3}`, http.StatusOK, `"4"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPatch, path, strings.NewReader(tt.body))
+			r.Header.Set("Authorization", "Bearer "+app.tokenFor(t, admin))
+			r.Header.Set("If-Match", tt.ifMatch)
+
+			res := app.send(t, r)
+			checkStatus(t, res, tt.want)
+			if etag := res.header.Get("ETag"); etag != tt.wantETag {
+				t.Errorf("got ETag %s, want %s", etag, tt.wantETag)
+			}
+		})
+	}
+
+	// A conflict hands back the current report to retry against
+	r := httptest.NewRequest(http.MethodPatch, path, strings.NewReader(`{"status": "completed", "after_image": "https://img.example.com/after.jpg"}`))
+	r.Header.Set("Authorization", "Bearer "+app.tokenFor(t, admin))
+	r.Header.Set("If-Match", `"2"`)
+	res := app.send(t, r)
+	checkStatus(t, res, http.StatusConflict)
+
+	var current testReport
+	decodeField(t, res, "report", &current)
+	if current.Status != "rejected" || current.Version != 4 {
+		t.Errorf("got report %+v, want the rejected version 4", current)
+	}
+}
+
+func TestEditReport(t *testing.T) {
+	app := newTestApplication(t)
+	user := app.insertUser(t, "Priya Raman", "9000000001", "pa55word-1234", "user")
+	other := app.insertUser(t, "Karthik Subramanian", "9000000002", "pa55word-1234", "user")
+	report := app.insertReport(t, user.ID, "Broken streetlight", "streetlight")
+	path := fmt.Sprintf("/v1/reports/%d", report.ID)
+	token := app.tokenFor(t, user)
+
+	// The steps run in order against the same report
+	tests := []struct {
+		name  string
+		path  string
+		token string
+		body  any
+		want  int
+	}{
+		{"other user", path, app.tokenFor(t, other), map[string]any{"title": "Mine now", "version": 1}, http.StatusForbidden},
+		{"without token", path, "", map[string]any{"title": "Streetlight out", "version": 1}, http.StatusUnauthorized},
+		{"missing report", "/v1/reports/9999", token, map[string]any{"title": "Streetlight out", "version": 1}, http.StatusNotFound},
+		{"without version", path, token, map[string]any{"title": "Streetlight out"}, http.StatusPreconditionRequired},
+		{"status", path, token, map[string]any{"status": "completed", "version": 1}, http.StatusForbidden},
+		{"empty title", path, token, map[string]any{"title": "", "version": 1}, http.StatusUnprocessableEntity},
+		{"title", path, token, map[string]any{"title": "Streetlight out for a week", "version": 1}, http.StatusOK},
+		{"stale version", path, token, map[string]any{"location": "T. Nagar, Chennai", "version": 1}, http.StatusConflict},
+		{"location", path, token, map[string]any{"location": "T. Nagar, Chennai", "version": 2}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := app.do(t, http.MethodPatch, tt.path, tt.token, tt.body)
+			checkStatus(t, res, tt.want)
+		})
+	}
+
+	res := app.do(t, http.MethodGet, path, "", nil)
+	var edited testReport
+	decodeField(t, res, "report", &edited)
+	if edited.Title != "Streetlight out for a week" || edited.Description != report.Description || edited.Status != "pending" || edited.Version != 3 {
+		t.Errorf("got report %+v, want the edited title in version 3", edited)
+	}
+
+	events, err := app.models.Audit.GetAll(t.Context(), data.AuditFilter{Action: data.AuditReportEdited}, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[1].ActorID != user.ID || string(events[1].After) != `{"title":"Streetlight out for a week"}` {
+		t.Errorf("got audit events %+v, want the two edits by the reporter", events)
+	}
+
+	// Once the city picked the report up it can't be edited anymore
+	err = app.models.Reports.Update(t.Context(), report.ID, 0, "in-progress", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res = app.do(t, http.MethodPatch, path, token, map[string]any{"title": "Streetlight out", "version": 4})
+	checkStatus(t, res, http.StatusConflict)
+}
+
+func TestWithdrawReport(t *testing.T) {
+	app := newTestApplication(t)
+	user := app.insertUser(t, "Priya Raman", "9000000001", "pa55word-1234", "user")
+	other := app.insertUser(t, "Karthik Subramanian", "9000000002", "pa55word-1234", "user")
+	report := app.insertReport(t, user.ID, "Broken streetlight", "streetlight")
+	completed := app.insertReport(t, user.ID, "Overflowing bin", "garbage")
+	err := app.models.Reports.Update(t.Context(), completed.ID, 0, "completed", "https://img.example.com/after.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := fmt.Sprintf("/v1/reports/%d/withdraw", report.ID)
+	token := app.tokenFor(t, user)
+
+	// The steps run in order against the same report
+	tests := []struct {
+		name    string
+		path    string
+		token   string
+		ifMatch string
+		want    int
+	}{
+		{"other user", path, app.tokenFor(t, other), "", http.StatusForbidden},
+		{"without token", path, "", "", http.StatusUnauthorized},
+		{"missing report", "/v1/reports/9999/withdraw", token, "", http.StatusNotFound},
+		{"stale ETag", path, token, `"5"`, http.StatusConflict},
+		{"withdraw", path, token, `"1"`, http.StatusOK},
+		{"already withdrawn", path, token, "", http.StatusConflict},
+		{"resolved report", fmt.Sprintf("/v1/reports/%d/withdraw", completed.ID), token, "", http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			if tt.ifMatch != "" {
+				r.Header.Set("If-Match", tt.ifMatch)
+			}
+			checkStatus(t, app.send(t, r), tt.want)
+		})
+	}
+
+	t.Run("admin update", func(t *testing.T) {
+		admin := app.insertUser(t, "Admin Person", "9000000003", "pa55word-1234", "admin")
+		r := httptest.NewRequest(http.MethodPatch, fmt.Sprintf("/v1/reports/%d", report.ID), strings.NewReader(`{"status": "in-progress"}`))
+		r.Header.Set("Authorization", "Bearer "+app.tokenFor(t, admin))
+		r.Header.Set("If-Match", "*")
+		checkStatus(t, app.send(t, r), http.StatusConflict)
+	})
+
+	res := app.do(t, http.MethodGet, fmt.Sprintf("/v1/reports/%d", report.ID), "", nil)
+	var withdrawn testReport
+	decodeField(t, res, "report", &withdrawn)
+	if withdrawn.Status != "withdrawn" || withdrawn.Version != 2 {
+		t.Errorf("got report %+v, want it withdrawn in version 2", withdrawn)
+	}
+
+	res = app.do(t, http.MethodGet, "/v1/reports/stats", "", nil)
+	var stats data.ReportStats
+	decodeField(t, res, "stats", &stats)
+	if stats.WithdrawnReports != 1 || stats.TotalReports != 2 {
+		t.Errorf("got stats %+v, want 1 of 2 reports withdrawn", stats)
+	}
+}
+
+func TestGetUserReports(t *testing.T) {
+	app := newTestApplication(t)
+	user := app.insertUser(t, "Priya Raman", "9000000001", "pa55word-1234", "user")
+	other := app.insertUser(t, "Karthik Subramanian", "9000000002", "pa55word-1234", "user")
+
+	mine := app.insertReport(t, user.ID, "Broken streetlight", "streetlight")
+	app.insertReport(t, other.ID, "Pothole near school", "pothole")
+
+	res := app.do(t, http.MethodGet, "/v1/user/reports", app.tokenFor(t, user), nil)
+	checkStatus(t, res, http.StatusOK)
+
+	var reports []testReport
+	decodeField(t, res, "reports", &reports)
+	if len(reports) != 1 || reports[0].ID != mine.ID {
+		t.Errorf("got reports %+v, want only report %d", reports, mine.ID)
+	}
+
+	res = app.do(t, http.MethodGet, "/v1/user/reports", "", nil)
+	checkStatus(t, res, http.StatusUnauthorized)
+}
+
+func TestReportStatsAndLeaderboard(t *testing.T) {
+	app := newTestApplication(t)
+	user := app.insertUser(t, "Priya Raman", "9000000001", "pa55word-1234", "user")
+	other := app.insertUser(t, "Karthik Subramanian", "9000000002", "pa55word-1234", "user")
+	app.insertUser(t, "Lurking Larry", "9000000003", "pa55word-1234", "user")
+
+	app.insertReport(t, user.ID, "Broken streetlight", "streetlight")
+	app.insertReport(t, user.ID, "Overflowing bin", "garbage")
+	done := app.insertReport(t, other.ID, "Pothole near school", "pothole")
+
+	err := app.models.Reports.Update(t.Context(), done.ID, done.Version, "completed", "https://img.example.com/after.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("stats", func(t *testing.T) {
+		res := app.do(t, http.MethodGet, "/v1/reports/stats", "", nil)
+		checkStatus(t, res, http.StatusOK)
+
+		var stats data.ReportStats
+		decodeField(t, res, "stats", &stats)
+		want := data.ReportStats{TotalReports: 3, PendingReports: 2, CompletedReports: 1}
+		if stats != want {
+			t.Errorf("got stats %+v, want %+v", stats, want)
+		}
+	})
+
+	t.Run("leaderboard", func(t *testing.T) {
+		res := app.do(t, http.MethodGet, "/v1/leaderboard", "", nil)
+		checkStatus(t, res, http.StatusOK)
+
+		var leaderboard []data.LeaderboardEntry
+		decodeField(t, res, "leaderboard", &leaderboard)
+		if len(leaderboard) != 2 {
+			t.Fatalf("got %d entries, want 2", len(leaderboard))
+		}
+		if leaderboard[0].UserID != user.ID || leaderboard[0].ReportCount != 2 {
+			t.Errorf("unexpected leader %+v", leaderboard[0])
+		}
+	})
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"net/http"
 
+	"github.com/VJ-2303/CityStars/internal/data"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
@@ -12,45 +13,102 @@ func (app *application) routes() http.Handler {
 	// Initialize a new chi router
 	router := chi.NewRouter()
 
-	// Use chi's built-in middleware
+	// Trace every request and record its metrics, then recover from panics so they are counted as 500s
+	router.Use(app.trace)
+	router.Use(app.instrument)
 	router.Use(middleware.Recoverer)
 
 	// Enable CORS for frontend
 	router.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   []string{"*"},
+		AllowedOrigins:   app.config.cors.trustedOrigins,
 		AllowedMethods:   []string{"GET", "POST", "PATCH", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "Idempotency-Key", "If-Match", "Last-Event-ID", "X-Request-ID"},
+		ExposedHeaders:   []string{"ETag", "Idempotent-Replayed", "Link", "X-Request-ID"},
 		AllowCredentials: true,
 		MaxAge:           300, // Maximum value not ignored by any of major browsers
 	}))
 
+	// Bound how long a request may take
+	router.Use(app.timeout)
+
+	// Limit the request rate of every client
+	router.Use(app.rateLimit)
+
 	// Set custom handlers for 404 and 405
 	router.NotFound(app.notFoundResponse)
 	router.MethodNotAllowed(app.methodNotAllowedResponse)
 
 	// Healthcheck route
 	router.Get("/v1/healthcheck", app.healtchCheckHandler)
+	router.Get("/v1/readyz", app.readyzHandler)
+
+	// Public keys verifying the issued tokens
+	router.Get("/.well-known/jwks.json", app.jwksHandler)
+
+	// Prometheus metrics
+	router.Get("/metrics", app.metricsHandler())
 
 	// User routes
-	router.Post("/v1/user/register", app.CreateUserHandler)
-	router.Post("/v1/user/login", app.LoginUserHandler)
+	router.Post("/v1/user/register", app.limitRoute("register", app.CreateUserHandler))
+	router.Post("/v1/user/login", app.limitRoute("login", app.LoginUserHandler))
+	router.Post("/v1/user/login/mfa", app.limitRoute("login", app.LoginMFAHandler))
 	router.Get("/v1/user/me", app.authenticate(app.userProfileHandler))
 	router.Get("/v1/user/reports", app.authenticate(app.GetUserReportsHandler))
+	router.Get("/v1/user/notifications", app.authenticate(app.ListNotificationsHandler))
+	router.Post("/v1/user/notifications/read-all", app.authenticate(app.idempotent(app.MarkAllNotificationsReadHandler)))
+	router.Post("/v1/user/notifications/{id}/read", app.authenticate(app.idempotent(app.MarkNotificationReadHandler)))
+	router.Get("/v1/user/notification-preferences", app.authenticate(app.GetNotificationPreferencesHandler))
+	router.Patch("/v1/user/notification-preferences", app.authenticate(app.idempotent(app.UpdateNotificationPreferencesHandler)))
+	router.Get("/v1/user/notification-messages", app.authenticate(app.ListNotificationMessagesHandler))
+
+	// OpenID Connect login of city staff
+	if app.oidc != nil {
+		router.Get("/v1/auth/oidc/start", app.limitRoute("login", app.oidcStartHandler))
+		router.Get("/v1/auth/oidc/callback", app.limitRoute("login", app.oidcCallbackHandler))
+	}
+
+	// Two-factor authentication of the user's own account
+	router.Get("/v1/user/mfa", app.authenticate(app.GetMFAHandler))
+	router.Post("/v1/user/mfa", app.authenticate(app.EnrollMFAHandler))
+	router.Post("/v1/user/mfa/confirm", app.authenticate(app.ConfirmMFAHandler))
+	router.Delete("/v1/user/mfa", app.authenticate(app.limitRoute("login", app.DisableMFAHandler)))
+
 	router.Get("/v1/admin/me", app.authenticate(app.requireAdmin(app.AdminProfileHandler)))
 
 	// Report routes (Public - anyone can view)
-	router.Get("/v1/reports", app.ListAllReportsHandler)
+	router.Get("/v1/reports", app.checkAPIKey(data.ScopeReportsRead, app.ListAllReportsHandler))
 	router.Get("/v1/reports/stats", app.GetReportStatsHandler)
-	router.Get("/v1/reports/{id}", app.GetReportHandler)
+	router.Get("/v1/reports/{id}", app.checkAPIKey(data.ScopeReportsRead, app.GetReportHandler))
 	router.Get("/v1/leaderboard", app.GetLeaderboardHandler)
 
-	// Report routes (Authenticated users - create)
-	router.Post("/v1/reports", app.authenticate(app.CreateReportHandler))
+	// Real-time streams (Server-Sent Events)
+	router.Get("/v1/stream/reports", app.StreamReportsHandler)
+	router.Get("/v1/stream/me", app.authenticate(app.StreamUserEventsHandler))
+
+	// Report routes (Authenticated users - create), retries with the same
+	// Idempotency-Key get the first response instead of a duplicate report
+	router.Post("/v1/reports", app.authenticate(app.idempotent(app.limitRoute("create_report", app.CreateReportHandler))))
+
+	// Update a report - admins and API keys with the scope update the status,
+	// citizens edit or withdraw their own reports
+	router.Patch("/v1/reports/{id}", app.authenticateClient(app.idempotent(app.UpdateReportHandler)))
+	router.Post("/v1/reports/{id}/withdraw", app.authenticate(app.idempotent(app.WithdrawReportHandler)))
+
+	// Admin routes - webhook subscriptions
+	router.Get("/v1/admin/webhooks", app.authenticate(app.requireAdmin(app.ListWebhooksHandler)))
+	router.Post("/v1/admin/webhooks", app.authenticate(app.requireAdmin(app.idempotent(app.CreateWebhookHandler))))
+	router.Delete("/v1/admin/webhooks/{id}", app.authenticate(app.requireAdmin(app.DeleteWebhookHandler)))
+	router.Get("/v1/admin/webhooks/{id}/deliveries", app.authenticate(app.requireAdmin(app.ListWebhookDeliveriesHandler)))
+
+	// Admin routes - API keys of machine integrations
+	router.Get("/v1/admin/api-keys", app.authenticate(app.requireAdmin(app.ListAPIKeysHandler)))
+	router.Post("/v1/admin/api-keys", app.authenticate(app.requireAdmin(app.idempotent(app.CreateAPIKeyHandler))))
+	router.Delete("/v1/admin/api-keys/{id}", app.authenticate(app.requireAdmin(app.RevokeAPIKeyHandler)))
 
-	// Admin routes - update report status
-	router.Patch("/v1/reports/{id}", app.authenticate(app.requireAdmin(app.UpdateReportStatusHandler)))
+	// Admin routes - audit log of privileged actions and auth events
+	router.Get("/v1/admin/audit-events", app.authenticate(app.requireAdmin(app.ListAuditEventsHandler)))
+	router.Get("/v1/admin/audit-events/verify", app.authenticate(app.requireAdmin(app.VerifyAuditLogHandler)))
 
-	// Return the router with logging
-	return app.logRequest(router)
+	// Return the router with request IDs and access logging
+	return app.requestID(app.logRequest(router))
 }
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// serve runs the HTTP server until SIGINT or SIGTERM is received and then
+// shuts down gracefully: readiness starts failing, in-flight requests are
+// given the drain timeout to finish, open event streams are closed and the
+// background tasks are stopped and waited for
+func (app *application) serve() error {
+	// Configure the HTTP server with custom timeouts and error logging.
+	srv := &http.Server{
+		Addr:         fmt.Sprintf("0.0.0.0:%d", app.config.port),               // Bind to all interfaces for Railway
+		Handler:      app.routes(),                                             // HTTP handler with application routes
+		IdleTimeout:  time.Minute,                                              // Maximum idle connection duration
+		ReadTimeout:  15 * time.Second,                                         // Maximum duration for reading the request
+		WriteTimeout: 15 * time.Second,                                         // Maximum duration for writing the response
+		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError), // Use structured logger for server errors
+	}
+
+	// Event streams never go idle on their own, so they are told to
+	// return as soon as the shutdown starts
+	srv.RegisterOnShutdown(func() {
+		close(app.streamsDone)
+	})
+
+	shutdownError := make(chan error)
+
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		s := <-quit
+
+		app.logger.Info("shutting down server", "signal", s.String(), "timeout", app.config.shutdownTimeout)
+		app.draining.Store(true)
+
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.shutdownTimeout)
+		defer cancel()
+
+		shutdownError <- app.shutdown(ctx, srv)
+	}()
+
+	app.logger.Info("starting server", "addr", srv.Addr, "env", app.config.env)
+
+	// Start the HTTP server and block until it stops, ErrServerClosed
+	// means the graceful shutdown has started
+	err := srv.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	err = <-shutdownError
+	if err != nil {
+		return err
+	}
+
+	app.logger.Info("stopped server", "addr", srv.Addr)
+	return nil
+}
+
+// shutdown drains the server and then stops the background tasks, waiting
+// for them until ctx is done. The tasks are stopped even when the requests
+// didn't finish in time, so that they don't get cut off mid-write
+func (app *application) shutdown(ctx context.Context, srv *http.Server) error {
+	err := srv.Shutdown(ctx)
+
+	app.logger.Info("completing background tasks")
+	app.stopTasks()
+
+	done := make(chan struct{})
+	go func() {
+		app.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+		return errors.Join(err, errors.New("timed out waiting for background tasks"))
+	}
+}
+
+// background runs fn in its own goroutine and tracks it so that the
+// shutdown waits for it to finish. fn must return once ctx is cancelled
+func (app *application) background(fn func(ctx context.Context)) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.Error("background task panicked", "error", fmt.Sprintf("%v", err))
+			}
+		}()
+
+		fn(app.tasks)
+	}()
+}
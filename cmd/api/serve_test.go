@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestShutdown(t *testing.T) {
+	app := newTestApplication(t)
+
+	// The request outlives the shutdown deadline
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	go http.Get("http://" + ln.Addr().String())
+	<-started
+
+	stopped := make(chan struct{})
+	app.background(func(ctx context.Context) {
+		<-ctx.Done()
+		close(stopped)
+	})
+
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+
+	err = app.shutdown(ctx, srv)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want the drain timeout", err)
+	}
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("background tasks weren't stopped after the drain timed out")
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VJ-2303/CityStars/internal/data"
+	"github.com/VJ-2303/CityStars/internal/events"
+)
+
+// heartbeatInterval is how often a comment line is sent on idle
+// streams so that proxies don't close the connection
+const heartbeatInterval = 15 * time.Second
+
+// StreamReportsHandler streams public report events, optionally
+// restricted to a comma separated list of categories
+func (app *application) StreamReportsHandler(w http.ResponseWriter, r *http.Request) {
+	categories := map[string]bool{}
+	for _, c := range strings.Split(r.URL.Query().Get("category"), ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			categories[c] = true
+		}
+	}
+
+	app.streamEvents(w, r, func(e events.Event) bool {
+		if !e.Public {
+			return false
+		}
+		return len(categories) == 0 || categories[e.Category]
+	})
+}
+
+// StreamUserEventsHandler streams the events addressed to the authenticated user
+func (app *application) StreamUserEventsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(userIDKey).(int64)
+
+	app.streamEvents(w, r, func(e events.Event) bool {
+		return e.UserID == userID
+	})
+}
+
+// streamEvents writes the events accepted by filter to the client as
+// Server-Sent Events until the client goes away. Clients reconnecting
+// with a Last-Event-ID header first receive the events they missed
+func (app *application) streamEvents(w http.ResponseWriter, r *http.Request, filter func(events.Event) bool) {
+	rc := http.NewResponseController(w)
+
+	// Streams outlive the server's write timeout, so lift it for this response
+	err := rc.SetWriteDeadline(time.Time{})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var lastID int64
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	if lastEventID != "" {
+		lastID, err = strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil || lastID < 0 {
+			app.badRequestResponse(w, r, fmt.Errorf("invalid Last-Event-ID %q", lastEventID))
+			return
+		}
+	}
+
+	sub, backlog := app.events.Subscribe(lastID, filter)
+	defer app.events.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", 3000)
+	for _, e := range backlog {
+		writeEvent(w, e)
+	}
+	rc.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-app.streamsDone:
+			return
+		case e, ok := <-sub.C:
+			if !ok {
+				// The hub dropped us for falling behind, the client
+				// reconnects and resumes from its last event ID
+				return
+			}
+			writeEvent(w, e)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// writeEvent writes a single event in the text/event-stream format
+func writeEvent(w http.ResponseWriter, e events.Event) {
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, e.Data)
+}
+
+// publishReportEvent publishes a report write to the public and the
+// reporter's personal stream
+func (app *application) publishReportEvent(ctx context.Context, e *data.OutboxEvent) error {
+	var event data.ReportEvent
+	err := json.Unmarshal(e.Payload, &event)
+	if err != nil {
+		return err
+	}
+
+	js, err := json.Marshal(event.Report)
+	if err != nil {
+		return err
+	}
+
+	return app.publisher.Publish(ctx, events.Event{
+		Type:     e.EventType,
+		UserID:   event.Report.UserID,
+		Public:   true,
+		Category: event.Report.Category,
+		Data:     js,
+	})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VJ-2303/CityStars/internal/events"
+)
+
+func TestStreamReports(t *testing.T) {
+	app := newTestApplication(t)
+
+	srv := httptest.NewServer(app.routes())
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/v1/stream/reports?category=pothole", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("got content type %q", ct)
+	}
+
+	lines := bufio.NewScanner(res.Body)
+
+	// The retry line is written once the subscription is in place
+	if !lines.Scan() || !strings.HasPrefix(lines.Text(), "retry:") {
+		t.Fatalf("stream didn't start with a retry line: %q", lines.Text())
+	}
+
+	for _, e := range []events.Event{
+		{Type: events.ReportCreated, Public: true, Category: "garbage", Data: []byte(`{"id":1}`)},
+		{Type: events.ReportCreated, UserID: 7, Category: "pothole", Data: []byte(`{"id":2}`)},
+		{Type: events.ReportCreated, Public: true, Category: "pothole", Data: []byte(`{"id":3}`)},
+	} {
+		err = app.publisher.Publish(ctx, e)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for lines.Scan() {
+		if data, ok := strings.CutPrefix(lines.Text(), "data: "); ok {
+			if data != `{"id":3}` {
+				t.Errorf("got event data %s, want only the public pothole report", data)
+			}
+			return
+		}
+	}
+	t.Fatalf("stream ended without an event: %v", lines.Err())
+}
+
+func TestStreamUserEvents(t *testing.T) {
+	app := newTestApplication(t)
+
+	res := app.do(t, http.MethodGet, "/v1/stream/me", "", nil)
+	checkStatus(t, res, http.StatusUnauthorized)
+}
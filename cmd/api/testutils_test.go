@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/VJ-2303/CityStars/internal/data"
+	"github.com/VJ-2303/CityStars/internal/events"
+	"github.com/VJ-2303/CityStars/internal/keyring"
+	"github.com/VJ-2303/CityStars/internal/notifier"
+	"github.com/VJ-2303/CityStars/internal/ratelimit"
+	"github.com/VJ-2303/CityStars/internal/webhook"
+)
+
+// fakePinger stands in for the database in the readiness check
+type fakePinger struct {
+	err error
+}
+
+func (p *fakePinger) PingContext(ctx context.Context) error {
+	return p.err
+}
+
+// newTestApplication returns an application backed by the in-memory models,
+// with rate limiting disabled and logs discarded
+func newTestApplication(t *testing.T) *application {
+	t.Helper()
+
+	var cfg config
+	cfg.env = "testing"
+	cfg.authTokenTTL = time.Hour
+	cfg.requestTimeout = 5 * time.Second
+	cfg.shutdownTimeout = 5 * time.Second
+	cfg.notificationRetention = 24 * time.Hour
+	cfg.limiter.rps = 10
+	cfg.limiter.burst = 40
+	cfg.login.maxFailures = 3
+	cfg.login.lockout = time.Minute
+	cfg.login.maxLockout = time.Hour
+	cfg.mfa.issuer = "CityStars"
+	cfg.mfa.pendingTTL = 5 * time.Minute
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	ntf, err := notifier.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ntf.Register(notifier.ChannelEmail, notifier.LogDriver{Logger: logger})
+	ntf.Register(notifier.ChannelSMS, notifier.LogDriver{Logger: logger})
+
+	tasks, stopTasks := context.WithCancel(context.Background())
+	t.Cleanup(stopTasks)
+
+	hub := events.NewHub(100)
+
+	key, err := keyring.GenerateKey(keyring.EdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := keyring.New("citystars", "citystars", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := &application{
+		config:      cfg,
+		logger:      logger,
+		models:      data.NewMemoryModels(),
+		keys:        keys,
+		events:      hub,
+		publisher:   hub,
+		webhooks:    webhook.NewSender(time.Second),
+		notifier:    ntf,
+		limiter:     ratelimit.NewMemoryStore(),
+		metrics:     newMetrics(),
+		db:          &fakePinger{},
+		tasks:       tasks,
+		stopTasks:   stopTasks,
+		streamsDone: make(chan struct{}),
+	}
+	app.registerOutboxHandlers()
+
+	return app
+}
+
+// testResponse is a recorded response along with its decoded JSON body
+type testResponse struct {
+	status int
+	header http.Header
+	body   map[string]any
+}
+
+// do sends a request through the application's routes. body is encoded as
+// JSON unless it is a string, and token is sent as a bearer token when set
+func (app *application) do(t *testing.T, method, path, token string, body any) testResponse {
+	t.Helper()
+
+	var reader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		reader = bytes.NewBufferString(b)
+	default:
+		js, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(js)
+	}
+
+	r := httptest.NewRequest(method, path, reader)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return app.send(t, r)
+}
+
+// send sends the request through the application's routes and decodes the
+// JSON body of the response
+func (app *application) send(t *testing.T, r *http.Request) testResponse {
+	t.Helper()
+
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, r)
+
+	res := testResponse{status: rr.Code, header: rr.Header()}
+	if rr.Body.Len() > 0 && rr.Body.Bytes()[0] == '{' {
+		err := json.Unmarshal(rr.Body.Bytes(), &res.body)
+		if err != nil {
+			t.Fatalf("decoding response of %s %s: %v", r.Method, r.URL, err)
+		}
+	}
+	return res
+}
+
+// checkStatus fails the test when the response doesn't have the wanted status
+func checkStatus(t *testing.T, res testResponse, want int) {
+	t.Helper()
+
+	if res.status != want {
+		t.Fatalf("got status %d, want %d (body %v)", res.status, want, res.body)
+	}
+}
+
+// decodeField re-encodes a field of the response body into dst
+func decodeField(t *testing.T, res testResponse, field string, dst any) {
+	t.Helper()
+
+	value, ok := res.body[field]
+	if !ok {
+		t.Fatalf("response has no %q field: %v", field, res.body)
+	}
+	js, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = json.Unmarshal(js, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// insertUser adds a user with the given role to the in-memory store
+func (app *application) insertUser(t *testing.T, name, phoneNumber, password, role string) *data.User {
+	t.Helper()
+
+	user := &data.User{Name: name, PhoneNumber: phoneNumber}
+	err := user.Password.Set(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.models.Users.Insert(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role != user.Role {
+		err = app.models.Users.UpdateRole(context.Background(), user.ID, role)
+		if err != nil {
+			t.Fatal(err)
+		}
+		user.Role = role
+	}
+	return user
+}
+
+// tokenFor returns an authentication token for the user
+func (app *application) tokenFor(t *testing.T, user *data.User) string {
+	t.Helper()
+
+	token, err := app.newToken(user, "authentication", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token.PlainText
+}
+
+// insertReport adds a pending report by the user to the in-memory store
+func (app *application) insertReport(t *testing.T, userID int64, title, category string) *data.Report {
+	t.Helper()
+
+	report := &data.Report{
+		UserID:      userID,
+		Title:       title,
+		Description: "Found while walking to work",
+		Category:    category,
+		Location:    "Anna Salai, Chennai",
+		BeforeImage: "https://img.example.com/before.jpg",
+	}
+	err := app.models.Reports.Insert(context.Background(), report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+// dispatchPendingEvents hands every pending outbox event to its handlers
+func (app *application) dispatchPendingEvents(t *testing.T) {
+	t.Helper()
+
+	_, err := app.models.Outbox.ProcessBatch(context.Background(), 100, outboxMaxAttempts, func(ctx context.Context, e *data.OutboxEvent) error {
+		err := app.handleOutboxEvent(ctx, e)
+		if err != nil {
+			t.Errorf("handling outbox event: %v", err)
+		}
+		return err
+	}, outboxBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+var errTest = errors.New("test error")
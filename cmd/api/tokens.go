@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VJ-2303/CityStars/internal/data"
+	"github.com/VJ-2303/CityStars/internal/keyring"
+)
+
+// loadKeyring builds the keyring from the configured keys. In development
+// a key is generated when none is configured, so that tokens only last
+// until the server restarts
+func loadKeyring(cfg *config, logger *slog.Logger) (*keyring.Keyring, error) {
+	var active *keyring.Key
+	var err error
+
+	if cfg.jwt.signingKey == "" {
+		logger.Warn("no JWT signing key configured, using a generated key")
+		active, err = keyring.GenerateKey(keyring.EdDSA)
+	} else {
+		active, err = readKey(cfg.jwt.signingKey)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("jwt-signing-key: %w", err)
+	}
+
+	previous := make([]*keyring.Key, len(cfg.jwt.previousKeys))
+	for i, value := range cfg.jwt.previousKeys {
+		previous[i], err = readKey(value)
+		if err != nil {
+			return nil, fmt.Errorf("jwt-previous-keys: %w", err)
+		}
+	}
+
+	return keyring.New(cfg.jwt.issuer, cfg.jwt.audience, active, previous...)
+}
+
+// readKey parses a PEM key given inline or as the path of a file
+func readKey(value string) (*keyring.Key, error) {
+	if strings.Contains(value, "-----BEGIN") {
+		return keyring.ParseKey([]byte(value))
+	}
+
+	pemData, err := os.ReadFile(value)
+	if err != nil {
+		return nil, err
+	}
+	return keyring.ParseKey(pemData)
+}
+
+// newToken signs a token of the scope for the user, who authenticated with
+// the methods amr
+func (app *application) newToken(user *data.User, scope string, ttl time.Duration, amr ...string) (*data.Token, error) {
+	plainText, expiry, err := app.keys.Issue(strconv.FormatInt(user.ID, 10), scope, user.Role, ttl, amr...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &data.Token{
+		PlainText: plainText,
+		UserID:    user.ID,
+		Expiry:    expiry,
+		Scope:     scope,
+	}, nil
+}
+
+// jwksHandler publishes the public keys verifying the tokens, so that other
+// services can check them without calling the API
+func (app *application) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"keys": app.keys.JWKS()})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
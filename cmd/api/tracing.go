@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// setupTracing installs the global tracer provider exporting spans over
+// OTLP/HTTP to the configured collector. Without an endpoint tracing stays
+// disabled and the returned shutdown function does nothing
+func setupTracing(cfg config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	if cfg.otel.endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(cfg.otel.endpoint))
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName("citystars-api"),
+		semconv.ServiceVersion(version),
+		semconv.DeploymentName(cfg.env),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.otel.sampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// trace starts a server span for every request, continuing the trace of
+// an incoming traceparent header. The span is named after the chi route
+// pattern once the router has matched it
+func (app *application) trace(next http.Handler) http.Handler {
+	tracer := otel.Tracer("github.com/VJ-2303/CityStars/cmd/api")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		ctx, span := tracer.Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+				semconv.ClientAddress(app.clientIP(r)),
+				semconv.UserAgentOriginal(r.UserAgent()),
+				attribute.String("request.id", requestIDFrom(r)),
+			),
+		)
+		defer span.End()
+
+		rec := newResponseRecorder(w)
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		if route := chi.RouteContext(r.Context()).RoutePattern(); route != "" {
+			span.SetName(r.Method + " " + route)
+			span.SetAttributes(semconv.HTTPRoute(route))
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(rec.status))
+		if rec.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	})
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"time"
@@ -37,7 +38,7 @@ func (app *application) CreateUserHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = app.models.Users.Insert(user)
+	err = app.models.Users.Insert(r.Context(), user)
 	if err != nil {
 		if errors.Is(err, data.ErrDuplicatePhoneNumber) {
 			v.AddError("phone_number", "This phone number is already exists")
@@ -70,10 +71,19 @@ func (app *application) LoginUserHandler(w http.ResponseWriter, r *http.Request)
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	user, err := app.models.Users.GetByPhoneNumber(input.PhoneNumber)
+	attempt, err := app.models.LoginAttempts.Get(r.Context(), input.PhoneNumber)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if attempt.Locked() {
+		app.loginLockedResponse(w, r, time.Until(*attempt.LockedUntil))
+		return
+	}
+	user, err := app.models.Users.GetByPhoneNumber(r.Context(), input.PhoneNumber)
 	if err != nil {
 		if errors.Is(err, data.ErrUserNotFound) {
-			app.authenticationErrorResponse(w, r)
+			app.recordFailedLogin(w, r, input.PhoneNumber)
 		} else {
 			app.serverErrorResponse(w, r, err)
 		}
@@ -85,20 +95,92 @@ func (app *application) LoginUserHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	if !match {
-		app.authenticationErrorResponse(w, r)
+		app.recordFailedLogin(w, r, input.PhoneNumber)
+		return
+	}
+	if user.Suspended() {
+		app.accountSuspendedResponse(w, r)
+		return
+	}
+
+	// Users with two-factor authentication get a short-lived token to send
+	// along with their code instead. The failed logins are only reset once
+	// the code is right, so that the lockout also bounds guessing codes
+	mfa, err := app.models.MFA.Get(r.Context(), user.ID)
+	if err != nil && !errors.Is(err, data.ErrMFANotEnrolled) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if mfa != nil && mfa.Enabled() {
+		token, err := app.newToken(user, mfaPendingScope, app.config.mfa.pendingTTL, "pwd")
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		err = app.writeJSON(w, http.StatusOK, envelope{"mfa_required": true, "mfa_token": token})
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.LoginAttempts.Reset(r.Context(), input.PhoneNumber)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 		return
 	}
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, "authentication", app.config.jwtSecret, user.Role)
+	token, err := app.newToken(user, "authentication", app.config.authTokenTTL, "pwd")
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	app.audit(asUser(r.Context(), user), data.AuditLoginSucceeded, "user", user.ID, nil, envelope{"amr": []string{"pwd"}})
+
 	err = app.writeJSON(w, http.StatusCreated, envelope{"auth_token": token})
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// recordFailedLogin counts the failed login against the phone number and
+// sends the 401 response
+func (app *application) recordFailedLogin(w http.ResponseWriter, r *http.Request, phoneNumber string) {
+	err := app.countFailedLogin(r.Context(), phoneNumber)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.authenticationErrorResponse(w, r)
+}
+
+// countFailedLogin counts a failed login against the phone number, and once
+// the allowed failures are used up locks the phone number out for a period
+// which doubles with every further failure
+func (app *application) countFailedLogin(ctx context.Context, phoneNumber string) error {
+	failures, err := app.models.LoginAttempts.RecordFailure(ctx, phoneNumber, app.config.login.maxLockout)
+	if err != nil {
+		return err
+	}
+	app.audit(ctx, data.AuditLoginFailed, "phone_number", phoneNumber, nil, envelope{"failures": failures})
+
+	if failures >= app.config.login.maxFailures {
+		lockout := app.config.login.lockout << (failures - app.config.login.maxFailures)
+		if lockout <= 0 || lockout > app.config.login.maxLockout {
+			lockout = app.config.login.maxLockout
+		}
+		lockedUntil := time.Now().Add(lockout)
+		err = app.models.LoginAttempts.Lock(ctx, phoneNumber, lockedUntil)
+		if err != nil {
+			return err
+		}
+		app.audit(ctx, data.AuditLoginLocked, "phone_number", phoneNumber, nil, envelope{"locked_until": lockedUntil})
+		app.logger.Warn("locked out phone number after failed logins", "phone_number", phoneNumber, "failures", failures, "lockout", lockout)
+	}
+
+	return nil
+}
+
 func (app *application) userProfileHandler(w http.ResponseWriter, r *http.Request) {
 	userID, _ := r.Context().Value(userIDKey).(int64)
 	userRole, _ := r.Context().Value(userRoleKey).(string)
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VJ-2303/CityStars/internal/keyring"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestCreateUser(t *testing.T) {
+	app := newTestApplication(t)
+	app.insertUser(t, "Existing User", "9000000001", "pa55word-1234", "user")
+
+	tests := []struct {
+		name string
+		body any
+		want int
+	}{
+		{"valid", map[string]string{"name": "Priya Raman", "phone_number": "9000000002", "password": "pa55word-1234"}, http.StatusCreated},
+		{"duplicate phone number", map[string]string{"name": "Priya Raman", "phone_number": "9000000001", "password": "pa55word-1234"}, http.StatusUnprocessableEntity},
+		{"invalid phone number", map[string]string{"name": "Priya Raman", "phone_number": "12345", "password": "pa55word-1234"}, http.StatusUnprocessableEntity},
+		{"short password", map[string]string{"name": "Priya Raman", "phone_number": "9000000003", "password": "short"}, http.StatusUnprocessableEntity},
+		{"unknown field", map[string]string{"name": "Priya Raman", "phone_number": "9000000004", "password": "pa55word-1234", "role": "admin"}, http.StatusBadRequest},
+		{"malformed JSON", `{"name": `, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := app.do(t, http.MethodPost, "/v1/user/register", "", tt.body)
+			checkStatus(t, res, tt.want)
+		})
+	}
+
+	t.Run("response", func(t *testing.T) {
+		res := app.do(t, http.MethodPost, "/v1/user/register", "", map[string]string{
+			"name": "Karthik Subramanian", "phone_number": "9000000005", "password": "pa55word-1234",
+		})
+		checkStatus(t, res, http.StatusCreated)
+
+		var user map[string]any
+		decodeField(t, res, "user", &user)
+		if user["role"] != "user" || user["phone_number"] != "9000000005" {
+			t.Errorf("unexpected user %v", user)
+		}
+		if _, ok := user["password"]; ok {
+			t.Error("password leaked in the response")
+		}
+	})
+}
+
+func TestLoginUser(t *testing.T) {
+	app := newTestApplication(t)
+	user := app.insertUser(t, "Priya Raman", "9000000001", "pa55word-1234", "user")
+
+	t.Run("valid credentials", func(t *testing.T) {
+		res := app.do(t, http.MethodPost, "/v1/user/login", "", map[string]string{
+			"phone_number": user.PhoneNumber, "password": "pa55word-1234",
+		})
+		checkStatus(t, res, http.StatusCreated)
+
+		var token struct {
+			Token string `json:"token"`
+		}
+		decodeField(t, res, "auth_token", &token)
+		if token.Token == "" {
+			t.Fatal("no token returned")
+		}
+
+		me := app.do(t, http.MethodGet, "/v1/user/me", token.Token, nil)
+		checkStatus(t, me, http.StatusOK)
+	})
+
+	t.Run("invalid credentials", func(t *testing.T) {
+		tests := []struct {
+			name        string
+			phoneNumber string
+			password    string
+			want        int
+		}{
+			{"wrong password", user.PhoneNumber, "wrong-password", http.StatusUnauthorized},
+			{"unknown phone number", "9999999999", "pa55word-1234", http.StatusUnauthorized},
+			{"invalid phone number", "123", "pa55word-1234", http.StatusUnprocessableEntity},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				res := app.do(t, http.MethodPost, "/v1/user/login", "", map[string]string{
+					"phone_number": tt.phoneNumber, "password": tt.password,
+				})
+				checkStatus(t, res, tt.want)
+			})
+		}
+	})
+}
+
+func TestLoginLockout(t *testing.T) {
+	app := newTestApplication(t)
+	user := app.insertUser(t, "Priya Raman", "9000000001", "pa55word-1234", "user")
+
+	wrong := map[string]string{"phone_number": user.PhoneNumber, "password": "wrong-password"}
+	for i := 0; i < app.config.login.maxFailures; i++ {
+		res := app.do(t, http.MethodPost, "/v1/user/login", "", wrong)
+		checkStatus(t, res, http.StatusUnauthorized)
+	}
+
+	// The right password is refused too while the lockout lasts
+	res := app.do(t, http.MethodPost, "/v1/user/login", "", map[string]string{
+		"phone_number": user.PhoneNumber, "password": "pa55word-1234",
+	})
+	checkStatus(t, res, http.StatusTooManyRequests)
+	if res.header.Get("Retry-After") == "" {
+		t.Error("missing Retry-After header")
+	}
+
+	t.Run("released after the lockout", func(t *testing.T) {
+		attempt, err := app.models.LoginAttempts.Get(t.Context(), user.PhoneNumber)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if until := time.Until(*attempt.LockedUntil); until <= 0 || until > app.config.login.lockout {
+			t.Fatalf("locked for %s, want the first lockout of %s", until, app.config.login.lockout)
+		}
+
+		// Move the lockout into the past rather than waiting for it
+		err = app.models.LoginAttempts.Lock(t.Context(), user.PhoneNumber, time.Now().Add(-time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res := app.do(t, http.MethodPost, "/v1/user/login", "", map[string]string{
+			"phone_number": user.PhoneNumber, "password": "pa55word-1234",
+		})
+		checkStatus(t, res, http.StatusCreated)
+
+		// The successful login forgets the failures
+		attempt, err = app.models.LoginAttempts.Get(t.Context(), user.PhoneNumber)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attempt.FailedCount != 0 || attempt.Locked() {
+			t.Errorf("got %+v after logging in, want the failures forgotten", attempt)
+		}
+		res = app.do(t, http.MethodPost, "/v1/user/login", "", wrong)
+		checkStatus(t, res, http.StatusUnauthorized)
+	})
+}
+
+func TestAuthentication(t *testing.T) {
+	app := newTestApplication(t)
+	user := app.insertUser(t, "Priya Raman", "9000000001", "pa55word-1234", "user")
+	admin := app.insertUser(t, "Admin Person", "9000000002", "pa55word-1234", "admin")
+
+	expired, _, err := app.keys.Issue(strconv.FormatInt(user.ID, 10), "authentication", "user", -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Signed with a key the API doesn't know
+	otherKey, err := keyring.GenerateKey(keyring.EdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKeys, err := keyring.New("citystars", "citystars", otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, _, err := otherKeys.Issue(strconv.FormatInt(admin.ID, 10), "authentication", "admin", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Signed with a shared secret, like the tokens issued before the keyring
+	hmac, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":   strconv.FormatInt(user.ID, 10),
+		"scope": "authentication",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte("mysecretkey"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrongScope, _, err := app.keys.Issue(strconv.FormatInt(user.ID, 10), "activation", "user", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		path  string
+		token string
+		want  int
+	}{
+		{"profile", "/v1/user/me", app.tokenFor(t, user), http.StatusOK},
+		{"profile without token", "/v1/user/me", "", http.StatusUnauthorized},
+		{"profile with garbage token", "/v1/user/me", "not-a-jwt", http.StatusUnauthorized},
+		{"profile with expired token", "/v1/user/me", expired, http.StatusUnauthorized},
+		{"profile with forged token", "/v1/user/me", forged, http.StatusUnauthorized},
+		{"profile with HMAC token", "/v1/user/me", hmac, http.StatusUnauthorized},
+		{"profile with token of another scope", "/v1/user/me", wrongScope, http.StatusUnauthorized},
+		{"admin profile", "/v1/admin/me", app.tokenFor(t, admin), http.StatusOK},
+		{"admin profile as user", "/v1/admin/me", app.tokenFor(t, user), http.StatusUnauthorized},
+		{"admin profile without token", "/v1/admin/me", "", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := app.do(t, http.MethodGet, tt.path, tt.token, nil)
+			checkStatus(t, res, tt.want)
+		})
+	}
+
+	t.Run("expired message", func(t *testing.T) {
+		res := app.do(t, http.MethodGet, "/v1/user/me", expired, nil)
+		if res.body["error"] != "Auth token is expired" {
+			t.Errorf("got error %v", res.body["error"])
+		}
+	})
+}
+
+func TestSuspensionAndRoleChanges(t *testing.T) {
+	app := newTestApplication(t)
+	user := app.insertUser(t, "Priya Raman", "9000000001", "pa55word-1234", "user")
+	token := app.tokenFor(t, user)
+
+	// Tokens issued before a promotion pick up the new role
+	err := app.models.Users.UpdateRole(t.Context(), user.ID, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := app.do(t, http.MethodGet, "/v1/admin/me", token, nil)
+	checkStatus(t, res, http.StatusOK)
+
+	err = app.models.Users.SetSuspended(t.Context(), user.ID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res = app.do(t, http.MethodGet, "/v1/user/me", token, nil)
+	checkStatus(t, res, http.StatusForbidden)
+
+	login := map[string]string{"phone_number": user.PhoneNumber, "password": "pa55word-1234"}
+	res = app.do(t, http.MethodPost, "/v1/user/login", "", login)
+	checkStatus(t, res, http.StatusForbidden)
+
+	err = app.models.Users.SetSuspended(t.Context(), user.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res = app.do(t, http.MethodGet, "/v1/user/me", token, nil)
+	checkStatus(t, res, http.StatusOK)
+	res = app.do(t, http.MethodPost, "/v1/user/login", "", login)
+	checkStatus(t, res, http.StatusCreated)
+}
+
+func TestJWKS(t *testing.T) {
+	app := newTestApplication(t)
+	user := app.insertUser(t, "Priya Raman", "9000000001", "pa55word-1234", "user")
+
+	res := app.do(t, http.MethodGet, "/.well-known/jwks.json", "", nil)
+	checkStatus(t, res, http.StatusOK)
+	if !strings.Contains(res.header.Get("Cache-Control"), "max-age") {
+		t.Errorf("got Cache-Control %q", res.header.Get("Cache-Control"))
+	}
+
+	var keys []keyring.JWK
+	decodeField(t, res, "keys", &keys)
+	if len(keys) != 1 || keys[0].KeyType != "OKP" || keys[0].Algorithm != keyring.EdDSA || keys[0].Use != "sig" {
+		t.Fatalf("unexpected keys %+v", keys)
+	}
+
+	// Tokens name the published key
+	token, _, err := jwt.NewParser().ParseUnverified(app.tokenFor(t, user), jwt.MapClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Header["kid"] != keys[0].ID {
+		t.Errorf("token signed by %v, want %s", token.Header["kid"], keys[0].ID)
+	}
+}
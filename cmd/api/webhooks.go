@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/VJ-2303/CityStars/internal/data"
+	"github.com/VJ-2303/CityStars/internal/validator"
+)
+
+// Delivery retry policy, failed deliveries are retried with an exponential
+// backoff starting at webhookBaseBackoff until webhookMaxAttempts is reached
+const (
+	webhookMaxAttempts = 10
+	webhookBaseBackoff = 30 * time.Second
+	webhookMaxBackoff  = 6 * time.Hour
+)
+
+// webhookPayload is the JSON body posted to webhook receivers
+type webhookPayload struct {
+	Event      string    `json:"event"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Data       any       `json:"data"`
+}
+
+// CreateWebhookHandler registers a new webhook subscription. When no secret
+// is provided one is generated, the secret is only returned in this response
+func (app *application) CreateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		URL        string   `json:"url"`
+		Secret     string   `json:"secret"`
+		EventTypes []string `json:"event_types"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Secret == "" {
+		b := make([]byte, 32)
+		_, err = rand.Read(b)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		input.Secret = hex.EncodeToString(b)
+	}
+
+	webhook := &data.Webhook{
+		URL:        input.URL,
+		Secret:     input.Secret,
+		EventTypes: input.EventTypes,
+		Active:     true,
+	}
+
+	v := validator.New()
+	if data.ValidateWebhook(v, webhook); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Webhooks.Insert(r.Context(), webhook)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.audit(r.Context(), data.AuditWebhookCreated, "webhook", webhook.ID, nil, webhook)
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"webhook": webhook, "secret": webhook.Secret})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// ListWebhooksHandler returns every webhook subscription
+func (app *application) ListWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := app.models.Webhooks.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"webhooks": webhooks})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// DeleteWebhookHandler removes a webhook subscription
+func (app *application) DeleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	webhook, err := app.models.Webhooks.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, data.ErrWebhookNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Webhooks.Delete(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, data.ErrWebhookNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.audit(r.Context(), data.AuditWebhookDeleted, "webhook", id, webhook, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "webhook successfully deleted"})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// ListWebhookDeliveriesHandler returns the delivery log of a webhook,
+// optionally filtered by delivery status
+func (app *application) ListWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	_, err = app.models.Webhooks.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, data.ErrWebhookNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Parse query parameters for pagination
+	qs := r.URL.Query()
+
+	limit := 50 // default limit
+	if limitStr := qs.Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 100 {
+			limit = l
+		}
+	}
+
+	offset := 0
+	if offsetStr := qs.Get("offset"); offsetStr != "" {
+		if o, err := strconv.Atoi(offsetStr); err == nil && o >= 0 {
+			offset = o
+		}
+	}
+
+	status := qs.Get("status")
+
+	deliveries, err := app.models.Deliveries.GetForWebhook(r.Context(), id, status, limit, offset)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"deliveries": deliveries})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// enqueueWebhooks queues a delivery of the report event for every
+// subscribed webhook, only status changes other than withdrawals are sent
+// for report updates
+func (app *application) enqueueWebhooks(ctx context.Context, e *data.OutboxEvent) error {
+	var event data.ReportEvent
+	err := json.Unmarshal(e.Payload, &event)
+	if err != nil {
+		return err
+	}
+
+	var eventType string
+	var eventData envelope
+
+	switch {
+	case e.EventType == data.EventReportCreated:
+		eventType = data.WebhookReportCreated
+		eventData = envelope{"report": event.Report}
+	case e.EventType == data.EventReportUpdated && event.StatusChanged() && !event.Withdrawn():
+		eventType = data.WebhookReportStatusChanged
+		eventData = envelope{"report": event.Report, "previous_status": event.PreviousStatus}
+	default:
+		return nil
+	}
+
+	payload, err := json.Marshal(webhookPayload{
+		Event:      eventType,
+		OccurredAt: e.CreatedAt.UTC(),
+		Data:       eventData,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = app.models.Deliveries.Enqueue(ctx, eventType, payload, e.IdempotencyKey)
+	return err
+}
+
+// deliverWebhooks polls the delivery queue and posts due deliveries
+// to their receivers, it runs until ctx is cancelled
+func (app *application) deliverWebhooks(ctx context.Context) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		for {
+			deliveries, err := app.models.Deliveries.ClaimDue(ctx, 20, 2*time.Minute)
+			if err != nil {
+				app.logger.Error("failed to claim webhook deliveries", "error", err)
+				break
+			}
+			if len(deliveries) == 0 {
+				break
+			}
+			for _, d := range deliveries {
+				app.deliverWebhook(d)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// deliverWebhook makes a single delivery attempt and records its outcome
+func (app *application) deliverWebhook(d *data.WebhookDelivery) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	statusCode, err := app.webhooks.Send(ctx, d.URL, d.Secret, d.EventType, d.ID, d.Payload)
+	if err == nil {
+		err = app.models.Deliveries.MarkSucceeded(ctx, d.ID, statusCode)
+		if err != nil {
+			app.logger.Error("failed to record webhook delivery", "delivery_id", d.ID, "error", err)
+		}
+		return
+	}
+
+	var code *int
+	if statusCode != 0 {
+		code = &statusCode
+	}
+
+	attempt := d.Attempts + 1
+	dead := attempt >= webhookMaxAttempts
+	backoff := webhookBackoff(attempt)
+
+	app.logger.Warn("webhook delivery failed", "delivery_id", d.ID, "webhook_id", d.WebhookID, "attempt", attempt, "dead", dead, "error", err)
+
+	err = app.models.Deliveries.MarkFailed(ctx, d.ID, code, err.Error(), dead, time.Now().Add(backoff))
+	if err != nil {
+		app.logger.Error("failed to record webhook delivery", "delivery_id", d.ID, "error", err)
+	}
+}
+
+// webhookBackoff returns how long to wait before retrying a delivery
+// which failed its attempt-th attempt
+func webhookBackoff(attempt int) time.Duration {
+	// Compared as a float, as the duration overflows for late attempts
+	backoff := float64(webhookBaseBackoff) * math.Pow(2, float64(attempt-1))
+	if backoff > float64(webhookMaxBackoff) {
+		return webhookMaxBackoff
+	}
+	return time.Duration(backoff)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VJ-2303/CityStars/internal/data"
+	"github.com/VJ-2303/CityStars/internal/webhook"
+)
+
+func TestWebhooks(t *testing.T) {
+	app := newTestApplication(t)
+	user := app.insertUser(t, "Priya Raman", "9000000001", "pa55word-1234", "user")
+	admin := app.insertUser(t, "Admin Person", "9000000002", "pa55word-1234", "admin")
+	token := app.tokenFor(t, admin)
+
+	valid := map[string]any{
+		"url":         "https://hooks.example.com/citystars",
+		"event_types": []string{"report.created"},
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		body  any
+		want  int
+	}{
+		{"as user", app.tokenFor(t, user), valid, http.StatusUnauthorized},
+		{"invalid url", token, map[string]any{"url": "ftp://example.com", "event_types": []string{"report.created"}}, http.StatusUnprocessableEntity},
+		{"unknown event type", token, map[string]any{"url": "https://example.com", "event_types": []string{"report.deleted"}}, http.StatusUnprocessableEntity},
+		{"short secret", token, map[string]any{"url": "https://example.com", "secret": "hunter2", "event_types": []string{"report.created"}}, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := app.do(t, http.MethodPost, "/v1/admin/webhooks", tt.token, tt.body)
+			checkStatus(t, res, tt.want)
+		})
+	}
+
+	res := app.do(t, http.MethodPost, "/v1/admin/webhooks", token, valid)
+	checkStatus(t, res, http.StatusCreated)
+	if secret, _ := res.body["secret"].(string); len(secret) != 64 {
+		t.Errorf("got secret %q, want a generated 64 character secret", secret)
+	}
+
+	var webhook struct {
+		ID int64 `json:"id"`
+	}
+	decodeField(t, res, "webhook", &webhook)
+
+	t.Run("list", func(t *testing.T) {
+		res := app.do(t, http.MethodGet, "/v1/admin/webhooks", token, nil)
+		checkStatus(t, res, http.StatusOK)
+
+		var webhooks []map[string]any
+		decodeField(t, res, "webhooks", &webhooks)
+		if len(webhooks) != 1 {
+			t.Fatalf("got %d webhooks, want 1", len(webhooks))
+		}
+		if _, ok := webhooks[0]["secret"]; ok {
+			t.Error("webhook secret is listed")
+		}
+	})
+
+	t.Run("deliveries", func(t *testing.T) {
+		app.insertReport(t, user.ID, "Broken streetlight", "streetlight")
+		app.dispatchPendingEvents(t)
+
+		res := app.do(t, http.MethodGet, fmt.Sprintf("/v1/admin/webhooks/%d/deliveries", webhook.ID), token, nil)
+		checkStatus(t, res, http.StatusOK)
+
+		var deliveries []struct {
+			EventType string `json:"event_type"`
+			Status    string `json:"status"`
+		}
+		decodeField(t, res, "deliveries", &deliveries)
+		if len(deliveries) != 1 || deliveries[0].EventType != "report.created" || deliveries[0].Status != "pending" {
+			t.Errorf("unexpected deliveries %+v", deliveries)
+		}
+
+		res = app.do(t, http.MethodGet, "/v1/admin/webhooks/9999/deliveries", token, nil)
+		checkStatus(t, res, http.StatusNotFound)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		path := fmt.Sprintf("/v1/admin/webhooks/%d", webhook.ID)
+
+		res := app.do(t, http.MethodDelete, path, token, nil)
+		checkStatus(t, res, http.StatusOK)
+
+		res = app.do(t, http.MethodDelete, path, token, nil)
+		checkStatus(t, res, http.StatusNotFound)
+	})
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{5, 8 * time.Minute},
+		{9, 128 * time.Minute},
+		{10, 256 * time.Minute},
+		{11, webhookMaxBackoff},
+		{30, webhookMaxBackoff},
+	}
+
+	for _, tt := range tests {
+		if got := webhookBackoff(tt.attempt); got != tt.want {
+			t.Errorf("got backoff %s after attempt %d, want %s", got, tt.attempt, tt.want)
+		}
+	}
+}
+
+func TestDeliverWebhook(t *testing.T) {
+	app := newTestApplication(t)
+	user := app.insertUser(t, "Priya Raman", "9000000001", "pa55word-1234", "user")
+
+	const secret = "0123456789abcdef0123456789abcdef"
+
+	// The receiver fails until it's told to accept deliveries
+	var mu sync.Mutex
+	var received []*http.Request
+	status := http.StatusInternalServerError
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get(webhook.HeaderTimestamp), 10, 64)
+		if !webhook.Verify(secret, timestamp, body, r.Header.Get(webhook.HeaderSignature)) {
+			t.Errorf("delivery %s has an invalid signature", r.Header.Get(webhook.HeaderDelivery))
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, r)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	wh := &data.Webhook{URL: srv.URL, Secret: secret, EventTypes: []string{data.WebhookReportCreated}, Active: true}
+	err := app.models.Webhooks.Insert(t.Context(), wh)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// deliver makes the next attempt of every queued delivery, regardless of
+	// when it's due, and returns the deliveries as recorded afterwards
+	deliver := func(t *testing.T) []*data.WebhookDelivery {
+		t.Helper()
+		pending, err := app.models.Deliveries.GetForWebhook(t.Context(), wh.ID, data.DeliveryPending, 100, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, d := range pending {
+			d.URL, d.Secret = wh.URL, wh.Secret
+			app.deliverWebhook(d)
+		}
+		deliveries, err := app.models.Deliveries.GetForWebhook(t.Context(), wh.ID, "", 100, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return deliveries
+	}
+
+	t.Run("dead after max attempts", func(t *testing.T) {
+		app.insertReport(t, user.ID, "Broken streetlight", "streetlight")
+		app.dispatchPendingEvents(t)
+
+		for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+			start := time.Now()
+			deliveries := deliver(t)
+			if len(deliveries) != 1 {
+				t.Fatalf("got %d deliveries, want 1", len(deliveries))
+			}
+			d := deliveries[0]
+
+			if d.Attempts != attempt || d.LastStatusCode == nil || *d.LastStatusCode != http.StatusInternalServerError {
+				t.Fatalf("got %d attempts with status %v, want %d failed attempts", d.Attempts, d.LastStatusCode, attempt)
+			}
+			if attempt < webhookMaxAttempts {
+				if d.Status != data.DeliveryPending {
+					t.Fatalf("got status %s after attempt %d, want %s", d.Status, attempt, data.DeliveryPending)
+				}
+				next := time.Time(d.NextAttemptAt).Sub(start)
+				if next < webhookBackoff(attempt) || next > webhookBackoff(attempt)+time.Second {
+					t.Errorf("retried %s after attempt %d, want %s", next, attempt, webhookBackoff(attempt))
+				}
+			} else if d.Status != data.DeliveryDead {
+				t.Fatalf("got status %s after the last attempt, want %s", d.Status, data.DeliveryDead)
+			}
+		}
+
+		// Dead deliveries aren't attempted again
+		deliver(t)
+		if len(received) != webhookMaxAttempts {
+			t.Errorf("receiver got %d deliveries, want %d", len(received), webhookMaxAttempts)
+		}
+		for _, r := range received {
+			if r.Header.Get(webhook.HeaderEvent) != data.WebhookReportCreated {
+				t.Errorf("got event header %q, want %q", r.Header.Get(webhook.HeaderEvent), data.WebhookReportCreated)
+			}
+		}
+	})
+
+	t.Run("succeeds on retry", func(t *testing.T) {
+		mu.Lock()
+		received = nil
+		mu.Unlock()
+
+		app.insertReport(t, user.ID, "Overflowing drain", "water")
+		app.dispatchPendingEvents(t)
+		deliver(t)
+
+		mu.Lock()
+		status = http.StatusAccepted
+		mu.Unlock()
+
+		deliveries := deliver(t)
+		var d *data.WebhookDelivery
+		for _, delivery := range deliveries {
+			if delivery.Status != data.DeliveryDead {
+				d = delivery
+			}
+		}
+		if d == nil || d.Status != data.DeliverySucceeded || d.Attempts != 2 || d.LastError != "" {
+			t.Fatalf("got delivery %+v, want it succeeded on the second attempt", d)
+		}
+		if len(received) != 2 {
+			t.Errorf("receiver got %d deliveries, want 2", len(received))
+		}
+	})
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/VJ-2303/CityStars/internal/data"
+	"github.com/VJ-2303/CityStars/internal/validator"
+	"golang.org/x/term"
+)
+
+func createAdminCommand(flags *flag.FlagSet) func(ctx context.Context, c *cli) error {
+	name := flags.String("name", "", "Name of the admin")
+	phone := flags.String("phone", "", "Phone number the admin logs in with")
+
+	return func(ctx context.Context, c *cli) error {
+		password, err := c.newPassword()
+		if err != nil {
+			return err
+		}
+
+		user := &data.User{Name: *name, PhoneNumber: *phone, Role: "admin"}
+		err = user.Password.Set(password)
+		if err != nil {
+			return err
+		}
+
+		v := validator.New()
+		if data.ValidateUser(v, user); !v.Valid() {
+			return validationError(v)
+		}
+
+		err = c.models.Users.Insert(ctx, user)
+		if err != nil {
+			if errors.Is(err, data.ErrDuplicatePhoneNumber) {
+				return fmt.Errorf("phone number %s is already registered, promote the user with set-role", *phone)
+			}
+			return err
+		}
+
+		err = c.audit(ctx, data.AuditUserCreated, user, nil, user)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(c.out, "created admin %s with id %d\n", user.Name, user.ID)
+		return nil
+	}
+}
+
+func setRoleCommand(flags *flag.FlagSet) func(ctx context.Context, c *cli) error {
+	phone := flags.String("phone", "", "Phone number of the user")
+	role := flags.String("role", "", "New role (user|admin)")
+
+	return func(ctx context.Context, c *cli) error {
+		v := validator.New()
+		if data.ValidateRole(v, *role); !v.Valid() {
+			return validationError(v)
+		}
+
+		user, err := c.user(ctx, *phone)
+		if err != nil {
+			return err
+		}
+
+		err = c.models.Users.UpdateRole(ctx, user.ID, *role)
+		if err != nil {
+			return err
+		}
+
+		err = c.audit(ctx, data.AuditUserRoleChanged, user, map[string]string{"role": user.Role}, map[string]string{"role": *role})
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(c.out, "%s is now %s (was %s)\n", user.Name, *role, user.Role)
+		return nil
+	}
+}
+
+// suspendCommand returns the setup of the suspend or the unsuspend command
+func suspendCommand(suspend bool) func(flags *flag.FlagSet) func(ctx context.Context, c *cli) error {
+	return func(flags *flag.FlagSet) func(ctx context.Context, c *cli) error {
+		phone := flags.String("phone", "", "Phone number of the user")
+
+		return func(ctx context.Context, c *cli) error {
+			user, err := c.user(ctx, *phone)
+			if err != nil {
+				return err
+			}
+
+			err = c.models.Users.SetSuspended(ctx, user.ID, suspend)
+			if err != nil {
+				return err
+			}
+
+			action := data.AuditUserUnsuspended
+			if suspend {
+				action = data.AuditUserSuspended
+			}
+			err = c.audit(ctx, action, user, map[string]bool{"suspended": user.Suspended()}, map[string]bool{"suspended": suspend})
+			if err != nil {
+				return err
+			}
+
+			if suspend {
+				fmt.Fprintf(c.out, "suspended %s\n", user.Name)
+			} else {
+				fmt.Fprintf(c.out, "lifted the suspension of %s\n", user.Name)
+			}
+			return nil
+		}
+	}
+}
+
+func resetPasswordCommand(flags *flag.FlagSet) func(ctx context.Context, c *cli) error {
+	phone := flags.String("phone", "", "Phone number of the user")
+
+	return func(ctx context.Context, c *cli) error {
+		user, err := c.user(ctx, *phone)
+		if err != nil {
+			return err
+		}
+
+		password, err := c.newPassword()
+		if err != nil {
+			return err
+		}
+
+		err = user.Password.Set(password)
+		if err != nil {
+			return err
+		}
+
+		v := validator.New()
+		if data.ValidateUser(v, user); !v.Valid() {
+			return validationError(v)
+		}
+
+		err = c.models.Users.UpdatePassword(ctx, user)
+		if err != nil {
+			return err
+		}
+
+		// Lift any lockout from failed logins so the new password works right away
+		err = c.models.LoginAttempts.Reset(ctx, user.PhoneNumber)
+		if err != nil {
+			return err
+		}
+
+		err = c.audit(ctx, data.AuditUserPasswordReset, user, nil, nil)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(c.out, "reset the password of %s\n", user.Name)
+		return nil
+	}
+}
+
+func statsCommand(flags *flag.FlagSet) func(ctx context.Context, c *cli) error {
+	return func(ctx context.Context, c *cli) error {
+		stats, err := c.models.Reports.GetStats(ctx)
+		if err != nil {
+			return err
+		}
+
+		leaderboard, err := c.models.Reports.GetLeaderboard(ctx)
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "total\t%d\n", stats.TotalReports)
+		fmt.Fprintf(tw, "pending\t%d\n", stats.PendingReports)
+		fmt.Fprintf(tw, "in progress\t%d\n", stats.InProgressReports)
+		fmt.Fprintf(tw, "completed\t%d\n", stats.CompletedReports)
+		fmt.Fprintf(tw, "rejected\t%d\n", stats.RejectedReports)
+		fmt.Fprintf(tw, "withdrawn\t%d\n", stats.WithdrawnReports)
+		fmt.Fprintln(tw)
+		fmt.Fprintln(tw, "RANK\tNAME\tREPORTS")
+		for i, entry := range leaderboard {
+			fmt.Fprintf(tw, "%d\t%s\t%d\n", i+1, entry.UserName, entry.ReportCount)
+		}
+		return tw.Flush()
+	}
+}
+
+// demoUsers and demoReports are the data added by seed, reports refer to
+// their author by index in demoUsers
+var (
+	demoUsers = []struct{ name, phone string }{
+		{"Priya Raman", "9000000101"},
+		{"Karthik Subramanian", "9000000102"},
+		{"Meena Krishnan", "9000000103"},
+	}
+	demoReports = []struct {
+		user     int
+		title    string
+		category string
+		location string
+		status   string
+	}{
+		{0, "Deep pothole near the bus stop", "pothole", "Anna Salai, Chennai", "pending"},
+		{0, "Streetlight out for a week", "streetlight", "T. Nagar, Chennai", "in-progress"},
+		{1, "Overflowing garbage bin", "garbage", "Adyar, Chennai", "completed"},
+		{1, "Leaking water main", "water", "Velachery, Chennai", "pending"},
+		{2, "Broken road divider", "road", "Guindy, Chennai", "rejected"},
+	}
+)
+
+func seedCommand(flags *flag.FlagSet) func(ctx context.Context, c *cli) error {
+	password := flags.String("password", "demo-pa55word", "Password of the demo users")
+
+	return func(ctx context.Context, c *cli) error {
+		users := make([]*data.User, len(demoUsers))
+		for i, demo := range demoUsers {
+			user := &data.User{Name: demo.name, PhoneNumber: demo.phone}
+			err := user.Password.Set(*password)
+			if err != nil {
+				return err
+			}
+
+			v := validator.New()
+			if data.ValidateUser(v, user); !v.Valid() {
+				return validationError(v)
+			}
+
+			err = c.models.Users.Insert(ctx, user)
+			if err != nil {
+				if errors.Is(err, data.ErrDuplicatePhoneNumber) {
+					fmt.Fprintf(c.out, "skipping %s, already seeded\n", demo.name)
+					continue
+				}
+				return err
+			}
+			users[i] = user
+			fmt.Fprintf(c.out, "created user %s (%s)\n", user.Name, user.PhoneNumber)
+		}
+
+		for _, demo := range demoReports {
+			user := users[demo.user]
+			if user == nil {
+				continue
+			}
+
+			report := &data.Report{
+				UserID:      user.ID,
+				Title:       demo.title,
+				Description: demo.title + ", reported while seeding demo data",
+				Category:    demo.category,
+				Location:    demo.location,
+				BeforeImage: "https://placehold.co/600x400?text=before",
+			}
+			err := c.models.Reports.Insert(ctx, report)
+			if err != nil {
+				return err
+			}
+
+			if demo.status != "pending" {
+				afterImage := ""
+				if demo.status == "completed" {
+					afterImage = "https://placehold.co/600x400?text=after"
+				}
+				err = c.models.Reports.Update(ctx, report.ID, report.Version, demo.status, afterImage)
+				if err != nil {
+					return err
+				}
+			}
+			fmt.Fprintf(c.out, "created report %q\n", report.Title)
+		}
+
+		return nil
+	}
+}
+
+// user returns the user with the phone number
+func (c *cli) user(ctx context.Context, phone string) (*data.User, error) {
+	if phone == "" {
+		return nil, errors.New("-phone must be provided")
+	}
+
+	user, err := c.models.Users.GetByPhoneNumber(ctx, phone)
+	if err != nil {
+		if errors.Is(err, data.ErrUserNotFound) {
+			return nil, fmt.Errorf("no user with phone number %s", phone)
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// audit records the command's action on the user in the audit log
+func (c *cli) audit(ctx context.Context, action string, user *data.User, before, after any) error {
+	e, err := data.NewAuditEvent(data.ActorFromContext(ctx), action, "user", strconv.FormatInt(user.ID, 10), before, after)
+	if err != nil {
+		return err
+	}
+	return c.models.Audit.Record(ctx, e)
+}
+
+// newPassword prompts for a password twice and checks both match
+func (c *cli) newPassword() (string, error) {
+	password, err := c.readPassword("Password: ")
+	if err != nil {
+		return "", err
+	}
+
+	confirmation, err := c.readPassword("Confirm password: ")
+	if err != nil {
+		return "", err
+	}
+
+	if password != confirmation {
+		return "", errors.New("passwords don't match")
+	}
+	return password, nil
+}
+
+// terminalPassword prompts for a password, it is read without echo from
+// a terminal and as a plain line otherwise so that scripts can pipe it in
+func (c *cli) terminalPassword(prompt string) (string, error) {
+	fmt.Fprint(c.out, prompt)
+
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		b, err := term.ReadPassword(fd)
+		fmt.Fprintln(c.out)
+		return string(b), err
+	}
+
+	line, err := c.in.ReadString('\n')
+	if err != nil && (!errors.Is(err, io.EOF) || line == "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// validationError turns the validator errors into a single error
+func validationError(v *validator.Validator) error {
+	fields := make([]string, 0, len(v.Errors))
+	for field := range v.Errors {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	messages := make([]string, 0, len(fields))
+	for _, field := range fields {
+		messages = append(messages, field+": "+v.Errors[field])
+	}
+	return errors.New(strings.Join(messages, "; "))
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"slices"
+	"testing"
+
+	"github.com/VJ-2303/CityStars/internal/data"
+)
+
+// newTestCLI returns a cli backed by the in-memory models which answers
+// every password prompt with password
+func newTestCLI(password string) *cli {
+	return &cli{
+		models: data.NewMemoryModels(),
+		out:    io.Discard,
+		readPassword: func(prompt string) (string, error) {
+			return password, nil
+		},
+	}
+}
+
+// run parses args with the command's flags and runs it
+func (c *cli) run(t *testing.T, name string, args ...string) error {
+	t.Helper()
+
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	run := commands[name].setup(flags)
+	err := flags.Parse(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return run(data.ContextWithActor(context.Background(), operator()), c)
+}
+
+// noRoleUpdates fails every role update of the wrapped users
+type noRoleUpdates struct {
+	data.UserRepository
+}
+
+func (u noRoleUpdates) UpdateRole(ctx context.Context, id int64, role string) error {
+	return errors.New("role updated")
+}
+
+func TestCreateAdmin(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		args     []string
+		wantErr  bool
+	}{
+		{"valid", "pa55word-1234", []string{"-name", "Admin Person", "-phone", "9000000001"}, false},
+		{"short password", "short", []string{"-name", "Admin Person", "-phone", "9000000001"}, true},
+		{"invalid phone", "pa55word-1234", []string{"-name", "Admin Person", "-phone", "12"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCLI(tt.password)
+			err := c.run(t, "create-admin", tt.args...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			user, err := c.models.Users.GetByPhoneNumber(context.Background(), "9000000001")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.Role != "admin" {
+				t.Errorf("got role %q, want admin", user.Role)
+			}
+		})
+	}
+
+	t.Run("single write", func(t *testing.T) {
+		// The admin is created with its role, not promoted after the insert
+		c := newTestCLI("pa55word-1234")
+		c.models.Users = noRoleUpdates{c.models.Users}
+
+		err := c.run(t, "create-admin", "-name", "Admin Person", "-phone", "9000000001")
+		if err != nil {
+			t.Fatal(err)
+		}
+		user, err := c.models.Users.GetByPhoneNumber(context.Background(), "9000000001")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Role != "admin" {
+			t.Errorf("got role %q, want admin", user.Role)
+		}
+	})
+
+	t.Run("mismatched confirmation", func(t *testing.T) {
+		c := newTestCLI("")
+		answers := []string{"pa55word-1234", "pa55word-4321"}
+		c.readPassword = func(prompt string) (string, error) {
+			answer := answers[0]
+			answers = answers[1:]
+			return answer, nil
+		}
+		err := c.run(t, "create-admin", "-name", "Admin Person", "-phone", "9000000001")
+		if err == nil {
+			t.Error("created an admin with mismatched passwords")
+		}
+	})
+}
+
+func TestUserMaintenance(t *testing.T) {
+	c := newTestCLI("new-pa55word")
+	ctx := context.Background()
+
+	user := &data.User{Name: "Priya Raman", PhoneNumber: "9000000001"}
+	err := user.Password.Set("old-pa55word")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.models.Users.Insert(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		command string
+		args    []string
+		wantErr bool
+		check   func(u *data.User) bool
+	}{
+		{"promote", "set-role", []string{"-phone", "9000000001", "-role", "admin"}, false, func(u *data.User) bool { return u.Role == "admin" }},
+		{"invalid role", "set-role", []string{"-phone", "9000000001", "-role", "root"}, true, nil},
+		{"unknown user", "set-role", []string{"-phone", "9999999999", "-role", "user"}, true, nil},
+		{"suspend", "suspend", []string{"-phone", "9000000001"}, false, func(u *data.User) bool { return u.Suspended() }},
+		{"unsuspend", "unsuspend", []string{"-phone", "9000000001"}, false, func(u *data.User) bool { return !u.Suspended() }},
+		{"reset password", "reset-password", []string{"-phone", "9000000001"}, false, func(u *data.User) bool {
+			ok, _ := u.Password.Matches("new-pa55word")
+			return ok
+		}},
+		{"missing phone", "suspend", nil, true, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := c.run(t, tt.command, tt.args...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if tt.check == nil {
+				return
+			}
+
+			u, err := c.models.Users.GetByID(ctx, user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.check(u) {
+				t.Errorf("unexpected user after %s: %+v", tt.command, u)
+			}
+		})
+	}
+
+	// Every change is in the audit log, attributed to the operator
+	events, err := c.models.Audit.GetAll(ctx, data.AuditFilter{ActorType: data.ActorCLI, TargetID: "1"}, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, e := range events {
+		actions = append(actions, e.Action)
+	}
+	want := []string{data.AuditUserPasswordReset, data.AuditUserUnsuspended, data.AuditUserSuspended, data.AuditUserRoleChanged}
+	if !slices.Equal(actions, want) {
+		t.Fatalf("got audit actions %v, want %v", actions, want)
+	}
+	if events[3].ActorName != operator().Name || string(events[3].After) != `{"role":"admin"}` {
+		t.Errorf("unexpected audit event %+v", events[3])
+	}
+}
+
+func TestSeedAndStats(t *testing.T) {
+	c := newTestCLI("")
+
+	// Seeding twice adds the demo data once
+	for range 2 {
+		err := c.run(t, "seed")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := c.models.Reports.GetStats(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := data.ReportStats{TotalReports: 5, PendingReports: 2, InProgressReports: 1, CompletedReports: 1, RejectedReports: 1}
+	if *stats != want {
+		t.Errorf("got stats %+v, want %+v", *stats, want)
+	}
+
+	var out bytes.Buffer
+	c.out = &out
+	err = c.run(t, "stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(out.Bytes(), []byte("Priya Raman")) {
+		t.Errorf("leaderboard missing from stats output:\n%s", out.String())
+	}
+}
@@ -0,0 +1,147 @@
+// Command citystars-admin runs administrative tasks against the CityStars
+// database, such as creating the first admin or suspending users
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"sort"
+	"time"
+
+	"github.com/VJ-2303/CityStars/internal/data"
+	_ "github.com/lib/pq"
+)
+
+// command is a single subcommand, flags are registered on the flag set
+// before run is called with the remaining arguments
+type command struct {
+	summary string
+	setup   func(flags *flag.FlagSet) func(ctx context.Context, c *cli) error
+}
+
+// cli holds what the commands work with
+type cli struct {
+	models data.Models
+	in     *bufio.Reader
+	out    io.Writer
+
+	// readPassword reads a password without echoing it when stdin is a terminal
+	readPassword func(prompt string) (string, error)
+}
+
+var commands = map[string]command{
+	"create-admin":   {"create an admin user, prompting for the password", createAdminCommand},
+	"set-role":       {"change the role of a user", setRoleCommand},
+	"suspend":        {"suspend a user, blocking logins and issued tokens", suspendCommand(true)},
+	"unsuspend":      {"lift the suspension of a user", suspendCommand(false)},
+	"reset-password": {"set a new password for a user, prompting for it", resetPasswordCommand},
+	"stats":          {"recompute the report statistics and leaderboard", statsCommand},
+	"seed":           {"add demo users and reports", seedCommand},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	name := os.Args[1]
+	cmd, ok := commands[name]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	dsn := flags.String("db-dsn", envDSN(), "Postgres DB connection string")
+	queryTimeout := flags.Duration("db-query-timeout", data.DefaultQueryTimeout, "Deadline of a single database query")
+	run := cmd.setup(flags)
+	flags.Parse(os.Args[2:])
+
+	db, err := openDB(*dsn)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to connect to database:", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	c := &cli{
+		models: data.NewModels(db, *queryTimeout),
+		in:     bufio.NewReader(os.Stdin),
+		out:    os.Stdout,
+	}
+	c.readPassword = c.terminalPassword
+
+	ctx := data.ContextWithActor(context.Background(), operator())
+	err = run(ctx, c)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		db.Close()
+		os.Exit(1)
+	}
+}
+
+// usage lists the commands
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: citystars-admin <command> [flags]")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", name, commands[name].summary)
+	}
+
+	fmt.Fprintln(os.Stderr, "\nRun citystars-admin <command> -h for the flags of a command")
+}
+
+// operator returns the actor the audit log attributes the command to, named
+// after the OS user running it
+func operator() *data.Actor {
+	name := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	return &data.Actor{Type: data.ActorCLI, Name: name}
+}
+
+// envDSN returns the database DSN from the environment, like the API does
+func envDSN() string {
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		dsn = os.Getenv("DB_DSN")
+	}
+	return dsn
+}
+
+// openDB opens a connection pool and checks the database is reachable
+func openDB(dsn string) (*sql.DB, error) {
+	if dsn == "" {
+		return nil, errors.New("no DSN, set -db-dsn or DATABASE_URL")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
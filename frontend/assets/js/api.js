@@ -45,10 +45,11 @@ class ApiService {
     }
 
     // POST request
-    async post(endpoint, data) {
+    async post(endpoint, data, headers = {}) {
         return this.request(endpoint, {
             method: 'POST',
-            body: JSON.stringify(data)
+            body: JSON.stringify(data),
+            headers
         });
     }
 
@@ -75,6 +76,7 @@ const api = new ApiService(API_CONFIG.BASE_URL);
 const authApi = {
     register: (data) => api.post(API_CONFIG.ENDPOINTS.REGISTER, data),
     login: (data) => api.post(API_CONFIG.ENDPOINTS.LOGIN, data),
+    loginMFA: (data) => api.post(API_CONFIG.ENDPOINTS.LOGIN_MFA, data),
     getProfile: () => api.get(API_CONFIG.ENDPOINTS.PROFILE),
     getAdminProfile: () => api.get(API_CONFIG.ENDPOINTS.ADMIN_PROFILE)
 };
@@ -84,8 +86,9 @@ const reportsApi = {
     getAll: (params) => api.get(API_CONFIG.ENDPOINTS.REPORTS, params),
     getById: (id) => api.get(API_CONFIG.ENDPOINTS.REPORT_BY_ID(id)),
     getUserReports: (params) => api.get(API_CONFIG.ENDPOINTS.USER_REPORTS, params),
-    create: (data) => api.post(API_CONFIG.ENDPOINTS.CREATE_REPORT, data),
-    update: (id, data) => api.patch(API_CONFIG.ENDPOINTS.UPDATE_REPORT(id), data)
+    create: (data, idempotencyKey) => api.post(API_CONFIG.ENDPOINTS.CREATE_REPORT, data, { 'Idempotency-Key': idempotencyKey }),
+    update: (id, data) => api.patch(API_CONFIG.ENDPOINTS.UPDATE_REPORT(id), data),
+    withdraw: (id, version) => api.post(API_CONFIG.ENDPOINTS.WITHDRAW_REPORT(id), {}, { 'If-Match': `"${version}"` })
 };
 
 // Stats & Leaderboard APIs
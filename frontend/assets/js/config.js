@@ -6,6 +6,8 @@ const API_CONFIG = {
         // Auth
         REGISTER: '/v1/user/register',
         LOGIN: '/v1/user/login',
+        LOGIN_MFA: '/v1/user/login/mfa',
+        OIDC_START: '/v1/auth/oidc/start',
         PROFILE: '/v1/user/me',
         ADMIN_PROFILE: '/v1/admin/me',
         
@@ -15,6 +17,7 @@ const API_CONFIG = {
         USER_REPORTS: '/v1/user/reports',
         CREATE_REPORT: '/v1/reports',
         UPDATE_REPORT: (id) => `/v1/reports/${id}`,
+        WITHDRAW_REPORT: (id) => `/v1/reports/${id}/withdraw`,
         
         // Stats & Leaderboard
         STATS: '/v1/reports/stats',
@@ -47,5 +50,6 @@ const STATUS_CLASSES = {
     pending: 'pending',
     'in-progress': 'in-progress',
     completed: 'completed',
-    rejected: 'rejected'
+    rejected: 'rejected',
+    withdrawn: 'withdrawn'
 };
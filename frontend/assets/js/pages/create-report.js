@@ -53,6 +53,10 @@ document.addEventListener('DOMContentLoaded', () => {
     setupMobileNav();
 });
 
+// Idempotency key of the report being submitted, kept while the request
+// never reached the server so that resubmitting can't create a duplicate
+let reportIdempotencyKey = null;
+
 async function handleCreateReport(e) {
     e.preventDefault();
     clearAllErrors();
@@ -103,13 +107,17 @@ async function handleCreateReport(e) {
         // Convert image to base64
         const beforeImageBase64 = await fileToBase64(beforeImageFile);
 
+        if (!reportIdempotencyKey) {
+            reportIdempotencyKey = crypto.randomUUID();
+        }
+
         const data = await reportsApi.create({
             title,
             category,
             location,
             description,
             before_image: beforeImageBase64
-        });
+        }, reportIdempotencyKey);
 
         if (data.report) {
             showToast('Report created successfully!', 'success');
@@ -119,6 +127,10 @@ async function handleCreateReport(e) {
         }
     } catch (error) {
         console.error('Create report error:', error);
+        // The server answered, so a corrected form is a new request
+        if (!(error instanceof TypeError)) {
+            reportIdempotencyKey = null;
+        }
         handleApiError(error, {
             before_image: 'beforeImage'
         });
@@ -1,6 +1,14 @@
 // Login Page
 
 document.addEventListener('DOMContentLoaded', () => {
+    // Staff logging in with the city's identity provider come back with the token in the fragment
+    const fragment = new URLSearchParams(window.location.hash.slice(1));
+    if (fragment.get('auth_token')) {
+        const token = fragment.get('auth_token');
+        history.replaceState(null, '', window.location.pathname);
+        saveAuthData(token, parseJWT(token)?.role || 'user');
+    }
+
     // Redirect if already authenticated
     if (isAuthenticated()) {
         window.location.href = '../index.html';
@@ -25,6 +33,11 @@ document.addEventListener('DOMContentLoaded', () => {
     if (loginForm) {
         loginForm.addEventListener('submit', handleLogin);
     }
+
+    const staffLogin = document.getElementById('staffLogin');
+    if (staffLogin) {
+        staffLogin.href = API_CONFIG.BASE_URL + API_CONFIG.ENDPOINTS.OIDC_START;
+    }
 });
 
 async function handleLogin(e) {
@@ -53,11 +66,22 @@ async function handleLogin(e) {
     setLoadingState(submitBtn, true);
 
     try {
-        const data = await authApi.login({
+        let data = await authApi.login({
             phone_number: phoneNumber,
             password: password
         });
 
+        // Accounts with two-factor authentication send a code next
+        if (data.mfa_required) {
+            const code = window.prompt('Enter the code from your authenticator app, or a recovery code');
+            if (!code) return;
+
+            data = await authApi.loginMFA({
+                mfa_token: data.mfa_token.token,
+                code: code.trim()
+            });
+        }
+
         if (data.auth_token) {
             // Parse token to get role
             const payload = parseJWT(data.auth_token.token);
@@ -19,6 +19,8 @@ document.addEventListener('DOMContentLoaded', () => {
     // Setup update form if admin
     if (isAdmin()) {
         setupAdminPanel();
+    } else if (isAuthenticated()) {
+        setupOwnerPanel();
     }
 });
 
@@ -37,6 +39,8 @@ async function loadReportDetail() {
             
             if (isAdmin()) {
                 showAdminPanel(data.report);
+            } else if (isAuthenticated()) {
+                showOwnerPanel(data.report);
             }
         }
     } catch (error) {
@@ -221,9 +225,12 @@ async function handleUpdateReport(e) {
             afterImageBase64 = await fileToBase64(afterImageFile);
         }
 
+        // The version makes the update fail instead of overwriting a
+        // change another admin made since the report was loaded
         await reportsApi.update(reportId, {
             status,
-            after_image: afterImageBase64
+            after_image: afterImageBase64,
+            version: currentReport.version
         });
 
         showToast('Report updated successfully!', 'success');
@@ -236,7 +243,128 @@ async function handleUpdateReport(e) {
         console.error('Update error:', error);
         updateError.textContent = error.error || 'Failed to update report';
         updateError.classList.add('show');
+
+        // On a conflict show the report as it is now, to update it again
+        if (error.report) {
+            currentReport = error.report;
+            displayReportDetail(error.report);
+        }
     } finally {
         setLoadingState(updateBtn, false);
     }
 }
+
+function setupOwnerPanel() {
+    const editForm = document.getElementById('editForm');
+    const withdrawBtn = document.getElementById('withdrawBtn');
+
+    if (editForm) {
+        editForm.addEventListener('submit', handleEditReport);
+    }
+    if (withdrawBtn) {
+        withdrawBtn.addEventListener('click', handleWithdrawReport);
+    }
+}
+
+// getCurrentUserId returns the id of the logged in user, looked up once
+async function getCurrentUserId() {
+    let userId = localStorage.getItem(STORAGE_KEYS.USER_ID);
+    if (!userId) {
+        const data = await authApi.getProfile();
+        userId = data.userID;
+        localStorage.setItem(STORAGE_KEYS.USER_ID, userId);
+    }
+    return Number(userId);
+}
+
+async function showOwnerPanel(report) {
+    const ownerPanel = document.getElementById('ownerPanel');
+    if (!ownerPanel) return;
+
+    let userId;
+    try {
+        userId = await getCurrentUserId();
+    } catch (error) {
+        console.error('Error loading profile:', error);
+        return;
+    }
+
+    // Reports can be edited while pending and withdrawn until resolved
+    const editable = report.status === 'pending';
+    const withdrawable = editable || report.status === 'in-progress';
+
+    if (report.user_id !== userId || !withdrawable) {
+        ownerPanel.classList.add('hidden');
+        return;
+    }
+
+    ownerPanel.classList.remove('hidden');
+    document.getElementById('editForm').classList.toggle('hidden', !editable);
+    document.getElementById('editTitle').value = report.title;
+    document.getElementById('editLocation').value = report.location;
+    document.getElementById('editDescription').value = report.description;
+}
+
+function showOwnerError(error, fallback) {
+    const ownerError = document.getElementById('ownerError');
+    ownerError.textContent = typeof error.error === 'string' ? error.error : fallback;
+    ownerError.classList.add('show');
+
+    // On a conflict show the report as it is now
+    if (error.report) {
+        currentReport = error.report;
+        displayReportDetail(error.report);
+        showOwnerPanel(error.report);
+    }
+}
+
+async function handleEditReport(e) {
+    e.preventDefault();
+
+    const editBtn = document.getElementById('editBtn');
+    const ownerError = document.getElementById('ownerError');
+    ownerError.textContent = '';
+    ownerError.classList.remove('show');
+
+    setLoadingState(editBtn, true);
+
+    try {
+        await reportsApi.update(reportId, {
+            title: document.getElementById('editTitle').value.trim(),
+            location: document.getElementById('editLocation').value.trim(),
+            description: document.getElementById('editDescription').value.trim(),
+            version: currentReport.version
+        });
+
+        showToast('Report updated successfully!', 'success');
+        loadReportDetail();
+    } catch (error) {
+        console.error('Edit error:', error);
+        showOwnerError(error, 'Failed to update report');
+    } finally {
+        setLoadingState(editBtn, false);
+    }
+}
+
+async function handleWithdrawReport() {
+    if (!confirm('Withdraw this report? The city will stop working on it.')) return;
+
+    const withdrawBtn = document.getElementById('withdrawBtn');
+    const ownerError = document.getElementById('ownerError');
+    ownerError.textContent = '';
+    ownerError.classList.remove('show');
+
+    setLoadingState(withdrawBtn, true);
+
+    try {
+        await reportsApi.withdraw(reportId, currentReport.version);
+
+        showToast('Report withdrawn', 'success');
+        loadReportDetail();
+    } catch (error) {
+        console.error('Withdraw error:', error);
+        showOwnerError(error, 'Failed to withdraw report');
+    } finally {
+        setLoadingState(withdrawBtn, false);
+    }
+}
@@ -0,0 +1,290 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/VJ-2303/CityStars/internal/validator"
+	"github.com/lib/pq"
+)
+
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+// Scopes an API key can be granted
+const (
+	ScopeReportsRead         = "reports:read"
+	ScopeReportsUpdateStatus = "reports:update_status"
+)
+
+// APIKeyPrefix starts every API key, which tells them apart from JWTs
+const APIKeyPrefix = "cs_"
+
+// apiKeyEncoding encodes the random parts of the keys, lower case so that
+// keys survive case insensitive handling
+var apiKeyEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// APIKey is an admin issued credential for machine integrations. Only the
+// SHA-256 of the key is stored, the prefix identifies the key in listings
+// and when looking it up
+type APIKey struct {
+	ID         int64    `json:"id"`
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"`
+	Hash       []byte   `json:"-"`
+	Scopes     []string `json:"scopes"`
+	CreatedBy  int64    `json:"created_by"`
+	CreatedAt  Time     `json:"created_at"`
+	ExpiresAt  *Time    `json:"expires_at,omitempty"`
+	LastUsedAt *Time    `json:"last_used_at,omitempty"`
+	RevokedAt  *Time    `json:"revoked_at,omitempty"`
+}
+
+// NewAPIKey returns a key with a random prefix and secret, along with the
+// plain text key which is shown to the admin once and never stored
+func NewAPIKey(name string, scopes []string, createdBy int64, expiresAt *time.Time) (*APIKey, string, error) {
+	b := make([]byte, 5+20)
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, "", err
+	}
+
+	prefix := apiKeyEncoding.EncodeToString(b[:5])
+	plainText := APIKeyPrefix + prefix + "_" + apiKeyEncoding.EncodeToString(b[5:])
+	hash := sha256.Sum256([]byte(plainText))
+
+	key := &APIKey{
+		Name:      name,
+		Prefix:    prefix,
+		Hash:      hash[:],
+		Scopes:    scopes,
+		CreatedBy: createdBy,
+	}
+	if expiresAt != nil {
+		t := Time(*expiresAt)
+		key.ExpiresAt = &t
+	}
+	return key, plainText, nil
+}
+
+// ParseAPIKeyPrefix returns the prefix of a plain text key, ok is false
+// when the text isn't shaped like an API key
+func ParseAPIKeyPrefix(plainText string) (prefix string, ok bool) {
+	rest, found := strings.CutPrefix(plainText, APIKeyPrefix)
+	if !found {
+		return "", false
+	}
+	prefix, secret, found := strings.Cut(rest, "_")
+	if !found || prefix == "" || secret == "" {
+		return "", false
+	}
+	return prefix, true
+}
+
+// Matches reports whether the plain text is this key
+func (k *APIKey) Matches(plainText string) bool {
+	hash := sha256.Sum256([]byte(plainText))
+	return subtle.ConstantTimeCompare(hash[:], k.Hash) == 1
+}
+
+// Active reports whether the key is neither revoked nor expired
+func (k *APIKey) Active() bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || time.Now().Before(time.Time(*k.ExpiresAt))
+}
+
+// HasScope reports whether the key was granted the scope
+func (k *APIKey) HasScope(scope string) bool {
+	return slices.Contains(k.Scopes, scope)
+}
+
+// ValidateAPIKey validates the key data
+func ValidateAPIKey(v *validator.Validator, k *APIKey) {
+	v.Check(len(k.Name) > 0, "name", "name must be provided")
+	v.Check(len(k.Name) <= 100, "name", "name must not be more than 100 characters")
+	v.Check(len(k.Scopes) > 0, "scopes", "at least one scope must be provided")
+	for _, scope := range k.Scopes {
+		v.Check(validator.PermittedValue(scope, ScopeReportsRead, ScopeReportsUpdateStatus), "scopes", "invalid scope "+scope)
+	}
+	if k.ExpiresAt != nil {
+		v.Check(time.Time(*k.ExpiresAt).After(time.Now()), "expires_at", "must be in the future")
+	}
+}
+
+// APIKeyModel wraps the database connection
+type APIKeyModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// Insert stores a new API key
+func (m APIKeyModel) Insert(ctx context.Context, k *APIKey) error {
+	query := `
+		INSERT INTO api_keys (name, prefix, hash, scopes, created_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`
+
+	var expiresAt *time.Time
+	if k.ExpiresAt != nil {
+		t := time.Time(*k.ExpiresAt)
+		expiresAt = &t
+	}
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, k.Name, k.Prefix, k.Hash, pq.Array(k.Scopes), k.CreatedBy, expiresAt).Scan(
+		&k.ID,
+		&k.CreatedAt,
+	)
+}
+
+// GetByPrefix retrieves the API key with the prefix, revoked keys included
+func (m APIKeyModel) GetByPrefix(ctx context.Context, prefix string) (*APIKey, error) {
+	query := `
+		SELECT id, name, prefix, hash, scopes, created_by, created_at, expires_at, last_used_at, revoked_at
+		FROM api_keys
+		WHERE prefix = $1
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	k, err := scanAPIKey(m.DB.QueryRowContext(ctx, query, prefix))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, err
+	}
+	return k, nil
+}
+
+// GetAll retrieves every API key, newest first
+func (m APIKeyModel) GetAll(ctx context.Context) ([]*APIKey, error) {
+	query := `
+		SELECT id, name, prefix, hash, scopes, created_by, created_at, expires_at, last_used_at, revoked_at
+		FROM api_keys
+		ORDER BY id DESC
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Revoke marks the API key as revoked, revoking a key twice keeps the
+// time of the first revocation
+func (m APIKeyModel) Revoke(ctx context.Context, id int64) error {
+	query := `
+		UPDATE api_keys
+		SET revoked_at = COALESCE(revoked_at, NOW())
+		WHERE id = $1
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+// TouchLastUsed records that the key was just used. The time is written at
+// most once a minute so that busy integrations don't write on every request
+func (m APIKeyModel) TouchLastUsed(ctx context.Context, id int64) error {
+	query := `
+		UPDATE api_keys
+		SET last_used_at = NOW()
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanAPIKey reads an API key selected with every column
+func scanAPIKey(row rowScanner) (*APIKey, error) {
+	var k APIKey
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+
+	err := row.Scan(
+		&k.ID,
+		&k.Name,
+		&k.Prefix,
+		&k.Hash,
+		pq.Array(&k.Scopes),
+		&k.CreatedBy,
+		&k.CreatedAt,
+		&expiresAt,
+		&lastUsedAt,
+		&revokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	k.ExpiresAt = nullTime(expiresAt)
+	k.LastUsedAt = nullTime(lastUsedAt)
+	k.RevokedAt = nullTime(revokedAt)
+	return &k, nil
+}
+
+// nullTime converts a nullable column to an optional Time
+func nullTime(t sql.NullTime) *Time {
+	if !t.Valid {
+		return nil
+	}
+	tm := Time(t.Time)
+	return &tm
+}
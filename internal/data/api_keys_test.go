@@ -0,0 +1,101 @@
+package data
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNewAPIKey(t *testing.T) {
+	key, plainText, err := NewAPIKey("Contractor", []string{ScopeReportsRead}, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prefix, ok := ParseAPIKeyPrefix(plainText)
+	if !ok || prefix != key.Prefix {
+		t.Fatalf("got prefix %q from %q, want %q", prefix, plainText, key.Prefix)
+	}
+	if !key.Matches(plainText) || key.Matches(plainText+"x") {
+		t.Error("key doesn't match only its plain text")
+	}
+	if !key.Active() || !key.HasScope(ScopeReportsRead) || key.HasScope(ScopeReportsUpdateStatus) {
+		t.Errorf("unexpected key %+v", key)
+	}
+
+	for _, plainText := range []string{"", "cs_", "cs_prefix", "cs__secret", "eyJhbGciOiJFZERTQSJ9.e30.sig"} {
+		if _, ok := ParseAPIKeyPrefix(plainText); ok {
+			t.Errorf("parsed %q as an API key", plainText)
+		}
+	}
+
+	past := time.Now().Add(-time.Minute)
+	expired, _, err := NewAPIKey("Contractor", []string{ScopeReportsRead}, 1, &past)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expired.Active() {
+		t.Error("expired key is active")
+	}
+}
+
+func TestAPIKeyModel(t *testing.T) {
+	db := newTestDB(t)
+	m := APIKeyModel{DB: db, Timeout: DefaultQueryTimeout}
+	admin := insertTestUser(t, UserModel{DB: db, Timeout: DefaultQueryTimeout}, "Admin Person", "9000000001")
+
+	expiry := time.Now().Add(24 * time.Hour)
+	key, plainText, err := NewAPIKey("Contractor", []string{ScopeReportsRead, ScopeReportsUpdateStatus}, admin.ID, &expiry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Insert(t.Context(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := m.GetByPrefix(t.Context(), key.Prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Matches(plainText) || len(got.Scopes) != 2 || got.CreatedBy != admin.ID || got.ExpiresAt == nil || got.LastUsedAt != nil {
+		t.Errorf("unexpected key %+v", got)
+	}
+
+	_, err = m.GetByPrefix(t.Context(), "missing")
+	if !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("got error %v, want %v", err, ErrAPIKeyNotFound)
+	}
+
+	err = m.TouchLastUsed(t.Context(), key.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		id      int64
+		wantErr error
+	}{
+		{"revoke", key.ID, nil},
+		{"revoke again", key.ID, nil},
+		{"revoke missing key", key.ID + 1000, ErrAPIKeyNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := m.Revoke(t.Context(), tt.id)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	keys, err := m.GetAll(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].LastUsedAt == nil || keys[0].RevokedAt == nil || keys[0].Active() {
+		t.Errorf("unexpected keys %+v", keys)
+	}
+}
@@ -0,0 +1,563 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Audited actions, named <target>.<what happened>
+const (
+	AuditReportStatusUpdated = "report.status_updated"
+	AuditReportEdited        = "report.edited"
+	AuditReportWithdrawn     = "report.withdrawn"
+	AuditAPIKeyCreated       = "api_key.created"
+	AuditAPIKeyRevoked       = "api_key.revoked"
+	AuditWebhookCreated      = "webhook.created"
+	AuditWebhookDeleted      = "webhook.deleted"
+	AuditUserCreated         = "user.created"
+	AuditUserRoleChanged     = "user.role_changed"
+	AuditUserSuspended       = "user.suspended"
+	AuditUserUnsuspended     = "user.unsuspended"
+	AuditUserPasswordReset   = "user.password_reset"
+	AuditLoginSucceeded      = "auth.login_succeeded"
+	AuditLoginFailed         = "auth.login_failed"
+	AuditLoginLocked         = "auth.login_locked"
+	AuditMFAEnabled          = "auth.mfa_enabled"
+	AuditMFADisabled         = "auth.mfa_disabled"
+	AuditIdentityLinked      = "auth.identity_linked"
+)
+
+// Kinds of actor behind an audited action
+const (
+	ActorAnonymous = "anonymous"
+	ActorUser      = "user"
+	ActorAPIKey    = "api_key"
+	ActorCLI       = "cli"
+	ActorSystem    = "system"
+)
+
+// auditChainLock is the advisory lock serializing the appends to the hash
+// chain, so that every event links to the one inserted right before it
+const auditChainLock = 0x61756469745f6c6f // "audit_lo"
+
+// auditVerifyBatch is the number of events read at once when verifying
+const auditVerifyBatch = 500
+
+// ErrAuditEventNotFound is returned when verifying from an unknown event
+var ErrAuditEventNotFound = errors.New("audit event not found")
+
+// Actor is who performed an action, along with where the request came from
+type Actor struct {
+	Type      string
+	ID        int64
+	Role      string
+	Name      string
+	IP        string
+	RequestID string
+}
+
+type actorContextKey struct{}
+
+// ContextWithActor returns a copy of ctx carrying the actor. The actor is a
+// pointer so that middleware further down the chain can fill it in
+func ContextWithActor(ctx context.Context, actor *Actor) context.Context {
+	return context.WithValue(ctx, actorContextKey{}, actor)
+}
+
+// ActorFromContext returns the actor of ctx, writes made outside of a
+// request or a CLI command are attributed to the system
+func ActorFromContext(ctx context.Context) *Actor {
+	actor, ok := ctx.Value(actorContextKey{}).(*Actor)
+	if !ok {
+		return &Actor{Type: ActorSystem}
+	}
+	return actor
+}
+
+// Digest is a SHA-256 hash, hex encoded in JSON
+type Digest []byte
+
+func (d Digest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(d))
+}
+
+// AuditEvent is an entry of the audit log. Before and After hold the
+// fields of the target which the action changed. Every event carries the
+// hash of the one before it, so that editing or removing an event breaks
+// the chain from there on
+type AuditEvent struct {
+	ID         int64           `json:"id"`
+	CreatedAt  time.Time       `json:"created_at"`
+	ActorType  string          `json:"actor_type"`
+	ActorID    int64           `json:"actor_id,omitempty"`
+	ActorRole  string          `json:"actor_role,omitempty"`
+	ActorName  string          `json:"actor_name,omitempty"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type,omitempty"`
+	TargetID   string          `json:"target_id,omitempty"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	IP         string          `json:"ip,omitempty"`
+	RequestID  string          `json:"request_id,omitempty"`
+	PrevHash   Digest          `json:"prev_hash,omitempty"`
+	Hash       Digest          `json:"hash"`
+}
+
+// AuditFilter selects audit events, zero fields match every event
+type AuditFilter struct {
+	ActorType  string
+	ActorID    int64
+	Action     string
+	TargetType string
+	TargetID   string
+	Since      *time.Time
+	Until      *time.Time
+}
+
+// AuditVerification is the outcome of checking a stretch of the hash chain
+type AuditVerification struct {
+	Valid    bool   `json:"valid"`
+	Checked  int    `json:"checked"`
+	BrokenAt int64  `json:"broken_at,omitempty"` // ID of the first event failing the check
+	LastID   int64  `json:"last_id,omitempty"`   // ID of the last event checked
+	LastHash Digest `json:"last_hash,omitempty"`
+	More     bool   `json:"more"` // Whether events past LastID are left to check
+}
+
+// NewAuditEvent returns the event of the actor's action on the target,
+// before and after are the states of the target which are diffed
+func NewAuditEvent(actor *Actor, action, targetType, targetID string, before, after any) (*AuditEvent, error) {
+	b, a, err := AuditDiff(before, after)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuditEvent{
+		ActorType:  actor.Type,
+		ActorID:    actor.ID,
+		ActorRole:  actor.Role,
+		ActorName:  actor.Name,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Before:     b,
+		After:      a,
+		IP:         actor.IP,
+		RequestID:  actor.RequestID,
+	}, nil
+}
+
+// AuditDiff marshals both states to JSON objects and keeps only the top
+// level fields which differ. A nil state is left out, so that creations
+// and deletions record the whole target
+func AuditDiff(before, after any) (json.RawMessage, json.RawMessage, error) {
+	b, err := auditObject(before)
+	if err != nil {
+		return nil, nil, err
+	}
+	a, err := auditObject(after)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if b != nil && a != nil {
+		for key, value := range b {
+			if bytes.Equal(value, a[key]) {
+				delete(b, key)
+				delete(a, key)
+			}
+		}
+	}
+
+	bjs, err := marshalAuditObject(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	ajs, err := marshalAuditObject(a)
+	if err != nil {
+		return nil, nil, err
+	}
+	return bjs, ajs, nil
+}
+
+// auditObject returns the fields of the JSON object v marshals to
+func auditObject(v any) (map[string]json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var object map[string]json.RawMessage
+	err = json.Unmarshal(js, &object)
+	if err != nil {
+		return nil, err
+	}
+	return object, nil
+}
+
+func marshalAuditObject(object map[string]json.RawMessage) (json.RawMessage, error) {
+	if len(object) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(object)
+}
+
+// canonicalJSON rewrites the JSON in a single form, with sorted keys and no
+// spaces. JSONB doesn't keep the text it was given, so the hashes are
+// computed over the canonical form of what is stored
+func canonicalJSON(js json.RawMessage) (json.RawMessage, error) {
+	if len(js) == 0 {
+		return nil, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+
+	var v any
+	err := dec.Decode(&v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// computeHash returns the hash of the event chained to the previous hash
+func (e *AuditEvent) computeHash() ([]byte, error) {
+	before, err := canonicalJSON(e.Before)
+	if err != nil {
+		return nil, err
+	}
+	after, err := canonicalJSON(e.After)
+	if err != nil {
+		return nil, err
+	}
+
+	js, err := json.Marshal(struct {
+		CreatedAt  string          `json:"created_at"`
+		ActorType  string          `json:"actor_type"`
+		ActorID    int64           `json:"actor_id"`
+		ActorRole  string          `json:"actor_role"`
+		ActorName  string          `json:"actor_name"`
+		Action     string          `json:"action"`
+		TargetType string          `json:"target_type"`
+		TargetID   string          `json:"target_id"`
+		Before     json.RawMessage `json:"before"`
+		After      json.RawMessage `json:"after"`
+		IP         string          `json:"ip"`
+		RequestID  string          `json:"request_id"`
+	}{
+		CreatedAt:  e.CreatedAt.UTC().Format(time.RFC3339Nano),
+		ActorType:  e.ActorType,
+		ActorID:    e.ActorID,
+		ActorRole:  e.ActorRole,
+		ActorName:  e.ActorName,
+		Action:     e.Action,
+		TargetType: e.TargetType,
+		TargetID:   e.TargetID,
+		Before:     before,
+		After:      after,
+		IP:         e.IP,
+		RequestID:  e.RequestID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+	h.Write(e.PrevHash)
+	h.Write(js)
+	return h.Sum(nil), nil
+}
+
+// seal links the event to the previous hash and hashes it. Timestamps are
+// cut to the microseconds Postgres stores, so that the hash can be recomputed
+func (e *AuditEvent) seal(prevHash []byte) error {
+	e.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	e.PrevHash = prevHash
+
+	hash, err := e.computeHash()
+	if err != nil {
+		return err
+	}
+	e.Hash = hash
+	return nil
+}
+
+// auditChain checks events one after the other, in id order
+type auditChain struct {
+	result AuditVerification
+}
+
+// check verifies the event follows the previous one, it returns false once
+// the chain is broken
+func (c *auditChain) check(e *AuditEvent) (bool, error) {
+	hash, err := e.computeHash()
+	if err != nil {
+		return false, err
+	}
+
+	if !bytes.Equal(e.PrevHash, c.result.LastHash) || !bytes.Equal(e.Hash, hash) {
+		c.result.Valid = false
+		c.result.BrokenAt = e.ID
+		return false, nil
+	}
+
+	c.result.Checked++
+	c.result.LastID = e.ID
+	c.result.LastHash = e.Hash
+	return true, nil
+}
+
+// insertAuditEvent appends the event to the log inside the given
+// transaction, which holds the chain lock until it ends
+func insertAuditEvent(ctx context.Context, tx *sql.Tx, e *AuditEvent) error {
+	_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, int64(auditChainLock))
+	if err != nil {
+		return err
+	}
+
+	var prevHash []byte
+	err = tx.QueryRowContext(ctx, `SELECT hash FROM audit_events ORDER BY id DESC LIMIT 1`).Scan(&prevHash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	err = e.seal(prevHash)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO audit_events (created_at, actor_type, actor_id, actor_role, actor_name, action,
+		                          target_type, target_id, before, after, ip, request_id, prev_hash, hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		RETURNING id
+	`
+
+	args := []any{
+		e.CreatedAt,
+		e.ActorType,
+		sql.NullInt64{Int64: e.ActorID, Valid: e.ActorID != 0},
+		e.ActorRole,
+		e.ActorName,
+		e.Action,
+		e.TargetType,
+		e.TargetID,
+		sql.NullString{String: string(e.Before), Valid: e.Before != nil},
+		sql.NullString{String: string(e.After), Valid: e.After != nil},
+		e.IP,
+		e.RequestID,
+		[]byte(e.PrevHash),
+		[]byte(e.Hash),
+	}
+	return tx.QueryRowContext(ctx, query, args...).Scan(&e.ID)
+}
+
+// AuditModel wraps the database connection
+type AuditModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// Record appends an event to the log
+func (m AuditModel) Record(ctx context.Context, e *AuditEvent) error {
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertAuditEvent(ctx, tx, e)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const auditColumns = `
+	id, created_at, actor_type, actor_id, actor_role, actor_name, action,
+	target_type, target_id, before, after, ip, request_id, prev_hash, hash
+`
+
+// GetAll retrieves the events matching the filter, newest first
+func (m AuditModel) GetAll(ctx context.Context, filter AuditFilter, limit, offset int) ([]*AuditEvent, error) {
+	query := `
+		SELECT ` + auditColumns + `
+		FROM audit_events
+		WHERE ($1 = '' OR actor_type = $1)
+		  AND ($2::bigint = 0 OR actor_id = $2)
+		  AND ($3 = '' OR action = $3)
+		  AND ($4 = '' OR target_type = $4)
+		  AND ($5 = '' OR target_id = $5)
+		  AND ($6::timestamptz IS NULL OR created_at >= $6)
+		  AND ($7::timestamptz IS NULL OR created_at < $7)
+		ORDER BY id DESC
+		LIMIT $8 OFFSET $9
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query,
+		filter.ActorType,
+		filter.ActorID,
+		filter.Action,
+		filter.TargetType,
+		filter.TargetID,
+		filter.Since,
+		filter.Until,
+		limit,
+		offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanAuditEvents(rows)
+}
+
+// Verify recomputes the hashes of up to limit events after the afterID one
+// in id order, starting from the start of the chain when afterID is zero.
+// The events are read in batches each bounded by the query timeout, and a
+// chain too long for one call is verified over several calls by passing the
+// LastID of the previous result as afterID
+func (m AuditModel) Verify(ctx context.Context, afterID int64, limit int) (*AuditVerification, error) {
+	query := `
+		SELECT ` + auditColumns + `
+		FROM audit_events
+		WHERE id > $1
+		ORDER BY id
+		LIMIT $2
+	`
+
+	chain := auditChain{result: AuditVerification{Valid: true, LastID: afterID}}
+	if afterID > 0 {
+		hash, err := m.hash(ctx, afterID)
+		if err != nil {
+			return nil, err
+		}
+		chain.result.LastHash = hash
+	}
+
+	for chain.result.Checked < limit {
+		events, err := m.verifyBatch(ctx, query, chain.result.LastID, min(auditVerifyBatch, limit-chain.result.Checked))
+		if err != nil {
+			return nil, err
+		}
+		if len(events) == 0 {
+			return &chain.result, nil
+		}
+
+		for _, e := range events {
+			ok, err := chain.check(e)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				return &chain.result, nil
+			}
+		}
+	}
+
+	more, err := m.hasAfter(ctx, chain.result.LastID)
+	if err != nil {
+		return nil, err
+	}
+	chain.result.More = more
+	return &chain.result, nil
+}
+
+// hash returns the hash of the event, which the verification starts from
+func (m AuditModel) hash(ctx context.Context, id int64) (Digest, error) {
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	var hash []byte
+	err := m.DB.QueryRowContext(ctx, `SELECT hash FROM audit_events WHERE id = $1`, id).Scan(&hash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrAuditEventNotFound
+		}
+		return nil, err
+	}
+	return hash, nil
+}
+
+// hasAfter reports whether there are events after the given one
+func (m AuditModel) hasAfter(ctx context.Context, id int64) (bool, error) {
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	var exists bool
+	err := m.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM audit_events WHERE id > $1)`, id).Scan(&exists)
+	return exists, err
+}
+
+func (m AuditModel) verifyBatch(ctx context.Context, query string, afterID int64, limit int) ([]*AuditEvent, error) {
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanAuditEvents(rows)
+}
+
+// scanAuditEvents reads and closes the rows
+func scanAuditEvents(rows *sql.Rows) ([]*AuditEvent, error) {
+	defer rows.Close()
+
+	events := []*AuditEvent{}
+
+	for rows.Next() {
+		var e AuditEvent
+		var actorID sql.NullInt64
+		var before, after, prevHash, hash []byte
+
+		err := rows.Scan(
+			&e.ID,
+			&e.CreatedAt,
+			&e.ActorType,
+			&actorID,
+			&e.ActorRole,
+			&e.ActorName,
+			&e.Action,
+			&e.TargetType,
+			&e.TargetID,
+			&before,
+			&after,
+			&e.IP,
+			&e.RequestID,
+			&prevHash,
+			&hash,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		e.ActorID = actorID.Int64
+		e.Before = before
+		e.After = after
+		e.PrevHash = prevHash
+		e.Hash = hash
+		events = append(events, &e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
@@ -0,0 +1,206 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAuditDiff(t *testing.T) {
+	type state struct {
+		Status     string `json:"status"`
+		AfterImage string `json:"after_image"`
+	}
+
+	tests := []struct {
+		name       string
+		before     any
+		after      any
+		wantBefore string
+		wantAfter  string
+	}{
+		{"changed field", state{"pending", ""}, state{"in-progress", ""}, `{"status":"pending"}`, `{"status":"in-progress"}`},
+		{"every field", state{"in-progress", ""}, state{"completed", "after.jpg"}, `{"after_image":"","status":"in-progress"}`, `{"after_image":"after.jpg","status":"completed"}`},
+		{"nothing changed", state{"pending", ""}, state{"pending", ""}, "", ""},
+		{"created", nil, map[string]string{"name": "Dispatch"}, "", `{"name":"Dispatch"}`},
+		{"deleted", map[string]string{"name": "Dispatch"}, nil, `{"name":"Dispatch"}`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, after, err := AuditDiff(tt.before, tt.after)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(before) != tt.wantBefore || string(after) != tt.wantAfter {
+				t.Errorf("got %s -> %s, want %s -> %s", before, after, tt.wantBefore, tt.wantAfter)
+			}
+		})
+	}
+}
+
+// recordTestEvents appends an event per action, by an admin from a request
+func recordTestEvents(t *testing.T, m AuditRepository, actions ...string) {
+	t.Helper()
+
+	actor := &Actor{Type: ActorUser, ID: 1, Role: "admin", IP: "203.0.113.7", RequestID: "req-1"}
+	for i, action := range actions {
+		e, err := NewAuditEvent(actor, action, "report", "42", map[string]int{"n": i}, map[string]int{"n": i + 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = m.Record(t.Context(), e)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testAuditVerifySteps verifies the chain of n events one event at a time,
+// every step starting from the last event of the previous one
+func testAuditVerifySteps(t *testing.T, m AuditRepository, n int) {
+	t.Helper()
+
+	var afterID int64
+	for step := 1; step <= n; step++ {
+		result, err := m.Verify(t.Context(), afterID, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Valid || result.Checked != 1 || result.LastID <= afterID || result.More != (step < n) {
+			t.Fatalf("got %+v at step %d, want one valid event checked", result, step)
+		}
+		afterID = result.LastID
+	}
+
+	_, err := m.Verify(t.Context(), afterID+1000, 1)
+	if !errors.Is(err, ErrAuditEventNotFound) {
+		t.Errorf("got error %v verifying from an unknown event, want %v", err, ErrAuditEventNotFound)
+	}
+}
+
+func TestAuditChain(t *testing.T) {
+	models := NewMemoryModels()
+	store := models.Audit.(memoryAudit).s
+	recordTestEvents(t, models.Audit, AuditReportStatusUpdated, AuditAPIKeyCreated, AuditWebhookDeleted)
+
+	result, err := models.Audit.Verify(t.Context(), 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid || result.Checked != 3 {
+		t.Fatalf("got %+v, want a valid chain of 3 events", result)
+	}
+
+	testAuditVerifySteps(t, models.Audit, 3)
+
+	tests := []struct {
+		name   string
+		tamper func(events []*AuditEvent) []*AuditEvent
+		broken int64
+	}{
+		{"edited diff", func(events []*AuditEvent) []*AuditEvent {
+			events[1].After = json.RawMessage(`{"n":5}`)
+			return events
+		}, 2},
+		{"edited actor", func(events []*AuditEvent) []*AuditEvent {
+			events[0].ActorID = 2
+			return events
+		}, 1},
+		{"removed event", func(events []*AuditEvent) []*AuditEvent {
+			return append(events[:1], events[2:]...)
+		}, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := store.audit
+			defer func() { store.audit = original }()
+
+			events := make([]*AuditEvent, len(original))
+			for i, e := range original {
+				event := *e
+				events[i] = &event
+			}
+			store.audit = tt.tamper(events)
+
+			result, err := models.Audit.Verify(t.Context(), 0, 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Valid || result.BrokenAt != tt.broken {
+				t.Errorf("got %+v, want the chain broken at %d", result, tt.broken)
+			}
+		})
+	}
+}
+
+func TestAuditModel(t *testing.T) {
+	db := newTestDB(t)
+	m := AuditModel{DB: db, Timeout: DefaultQueryTimeout}
+	recordTestEvents(t, m, AuditReportStatusUpdated, AuditReportStatusUpdated, AuditAPIKeyCreated)
+
+	// The canonical JSON hashed on insert survives the trip through JSONB
+	result, err := m.Verify(t.Context(), 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid || result.Checked != 3 {
+		t.Fatalf("got %+v, want a valid chain of 3 events", result)
+	}
+	testAuditVerifySteps(t, m, 3)
+
+	hour := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name   string
+		filter AuditFilter
+		want   int
+	}{
+		{"all", AuditFilter{}, 3},
+		{"action", AuditFilter{Action: AuditReportStatusUpdated}, 2},
+		{"actor", AuditFilter{ActorType: ActorUser, ActorID: 1}, 3},
+		{"other actor", AuditFilter{ActorID: 2}, 0},
+		{"target", AuditFilter{TargetType: "report", TargetID: "42"}, 3},
+		{"since", AuditFilter{Since: &hour}, 3},
+		{"until", AuditFilter{Until: &hour}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := m.GetAll(t.Context(), tt.filter, 10, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(events) != tt.want {
+				t.Errorf("got %d events, want %d", len(events), tt.want)
+			}
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	_, err = db.ExecContext(ctx, `UPDATE audit_events SET actor_id = 2 WHERE id = 2`)
+	if err == nil {
+		t.Fatal("updating an audit event succeeded, want the append only trigger to reject it")
+	}
+
+	// Someone able to switch the trigger off still breaks the chain
+	_, err = db.ExecContext(ctx, `
+		ALTER TABLE audit_events DISABLE TRIGGER audit_events_no_update_or_delete;
+		UPDATE audit_events SET actor_id = 2 WHERE id = 2;
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err = m.Verify(t.Context(), 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Valid || result.BrokenAt != 2 {
+		t.Errorf("got %+v, want the chain broken at 2", result)
+	}
+}
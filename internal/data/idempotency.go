@@ -0,0 +1,157 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// IdempotencyKey is a client supplied key along with the request first sent
+// with it and, once that request is done, its response. Retries with the
+// same key are answered with the stored response instead of running again
+type IdempotencyKey struct {
+	Client      string // Who sent the request, keys are scoped to them
+	Key         string
+	RequestHash []byte
+	Status      int // Zero while the first request is in progress
+	Header      map[string]string
+	Body        []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+// Completed reports whether the response of the first request is stored
+func (k *IdempotencyKey) Completed() bool {
+	return k.Status != 0
+}
+
+// IdempotencyKeyModel wraps the database connection
+type IdempotencyKeyModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// Reserve claims the key for a request, it returns nil once the caller owns
+// the key and the existing key otherwise. Expired keys, and keys whose
+// request has been in progress for longer than staleAfter because the
+// replica serving it went away, are claimed again
+func (m IdempotencyKeyModel) Reserve(ctx context.Context, k *IdempotencyKey, staleAfter time.Duration) (*IdempotencyKey, error) {
+	query := `
+		INSERT INTO idempotency_keys (client, key, request_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (client, key) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash,
+		    status = NULL,
+		    header = NULL,
+		    body = NULL,
+		    created_at = NOW(),
+		    expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at < NOW()
+		   OR (idempotency_keys.status IS NULL AND idempotency_keys.created_at < $5)
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, k.Client, k.Key, k.RequestHash, k.ExpiresAt, time.Now().Add(-staleAfter))
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 1 {
+		return nil, nil
+	}
+
+	query = `
+		SELECT client, key, request_hash, COALESCE(status, 0), header, body, created_at, expires_at
+		FROM idempotency_keys
+		WHERE client = $1 AND key = $2
+	`
+
+	var existing IdempotencyKey
+	var header []byte
+
+	err = m.DB.QueryRowContext(ctx, query, k.Client, k.Key).Scan(
+		&existing.Client,
+		&existing.Key,
+		&existing.RequestHash,
+		&existing.Status,
+		&header,
+		&existing.Body,
+		&existing.CreatedAt,
+		&existing.ExpiresAt,
+	)
+	if err != nil {
+		// Released since the insert, which reads as another request holding it
+		if errors.Is(err, sql.ErrNoRows) {
+			return &IdempotencyKey{Client: k.Client, Key: k.Key, RequestHash: k.RequestHash}, nil
+		}
+		return nil, err
+	}
+
+	if header != nil {
+		err = json.Unmarshal(header, &existing.Header)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &existing, nil
+}
+
+// Complete stores the response of the request holding the key
+func (m IdempotencyKeyModel) Complete(ctx context.Context, client, key string, status int, header map[string]string, body []byte) error {
+	query := `
+		UPDATE idempotency_keys
+		SET status = $3, header = $4, body = $5
+		WHERE client = $1 AND key = $2 AND status IS NULL
+	`
+
+	js, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, client, key, status, string(js), body)
+	return err
+}
+
+// Release gives up the key of a request which failed, so that a retry runs again
+func (m IdempotencyKeyModel) Release(ctx context.Context, client, key string) error {
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE client = $1 AND key = $2 AND status IS NULL
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, client, key)
+	return err
+}
+
+// DeleteExpired prunes the keys past their expiry
+func (m IdempotencyKeyModel) DeleteExpired(ctx context.Context) (int64, error) {
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE expires_at < NOW()
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
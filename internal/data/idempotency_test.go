@@ -0,0 +1,98 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+// testIdempotencyRepository runs the key lifecycle against m
+func testIdempotencyRepository(t *testing.T, m IdempotencyRepository) {
+	newKey := func(client, key string, ttl time.Duration) *IdempotencyKey {
+		return &IdempotencyKey{Client: client, Key: key, RequestHash: []byte(client + key), ExpiresAt: time.Now().Add(ttl)}
+	}
+	reserve := func(k *IdempotencyKey, staleAfter time.Duration) *IdempotencyKey {
+		t.Helper()
+		existing, err := m.Reserve(t.Context(), k, staleAfter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return existing
+	}
+
+	if existing := reserve(newKey("user:1", "a", time.Hour), time.Minute); existing != nil {
+		t.Fatalf("got existing key %+v for a new key", existing)
+	}
+	existing := reserve(newKey("user:1", "a", time.Hour), time.Minute)
+	if existing == nil || existing.Completed() {
+		t.Fatalf("got %+v, want the key in progress", existing)
+	}
+
+	err := m.Complete(t.Context(), "user:1", "a", 201, map[string]string{"Content-Type": "application/json"}, []byte(`{"id":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing = reserve(newKey("user:1", "a", time.Hour), time.Minute)
+	if existing == nil || existing.Status != 201 || string(existing.Body) != `{"id":1}` ||
+		existing.Header["Content-Type"] != "application/json" || string(existing.RequestHash) != "user:1a" {
+		t.Fatalf("got %+v, want the completed key", existing)
+	}
+
+	tests := []struct {
+		name       string
+		key        *IdempotencyKey
+		staleAfter time.Duration
+		before     func(k *IdempotencyKey) error
+	}{
+		{"other client", newKey("api_key:1", "a", time.Hour), time.Minute, nil},
+		{"released", newKey("user:1", "b", time.Hour), time.Minute, func(k *IdempotencyKey) error {
+			return m.Release(t.Context(), k.Client, k.Key)
+		}},
+		{"abandoned", newKey("user:1", "c", time.Hour), -time.Minute, nil},
+		{"expired", newKey("user:1", "d", -time.Hour), time.Minute, func(k *IdempotencyKey) error {
+			k.ExpiresAt = time.Now().Add(time.Hour)
+			return nil
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.before != nil {
+				reserve(tt.key, tt.staleAfter)
+				err := tt.before(tt.key)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if existing := reserve(tt.key, tt.staleAfter); existing != nil {
+				t.Errorf("got existing key %+v, want the key reserved", existing)
+			}
+		})
+	}
+
+	reserve(newKey("user:1", "e", -time.Hour), time.Minute)
+	deleted, err := m.DeleteExpired(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1 {
+		t.Errorf("deleted %d keys, want 1", deleted)
+	}
+}
+
+func TestIdempotencyRepository(t *testing.T) {
+	repositories := []struct {
+		name string
+		repo func(t *testing.T) IdempotencyRepository
+	}{
+		{"memory", func(t *testing.T) IdempotencyRepository { return NewMemoryModels().Idempotency }},
+		{"postgres", func(t *testing.T) IdempotencyRepository {
+			return IdempotencyKeyModel{DB: newTestDB(t), Timeout: DefaultQueryTimeout}
+		}},
+	}
+
+	for _, r := range repositories {
+		t.Run(r.name, func(t *testing.T) {
+			testIdempotencyRepository(t, r.repo(t))
+		})
+	}
+}
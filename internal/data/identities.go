@@ -0,0 +1,162 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrIdentityLinked    = errors.New("identity linked to another user")
+	ErrOIDCStateNotFound = errors.New("oidc state not found")
+)
+
+// Identity links an account of an OpenID Connect provider, named by the
+// issuer and the subject of its ID tokens, to a user
+type Identity struct {
+	ID          int64
+	UserID      int64
+	Issuer      string
+	Subject     string
+	CreatedAt   Time
+	LastLoginAt Time
+}
+
+// OIDCState is a pending OpenID Connect login, kept between the redirect to
+// the provider and the callback
+type OIDCState struct {
+	State     string
+	Nonce     string
+	Verifier  string // PKCE code verifier
+	ExpiresAt time.Time
+}
+
+// IdentityModel wraps the database connection
+type IdentityModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// GetUser retrieves the user the identity is linked to
+func (m IdentityModel) GetUser(ctx context.Context, issuer, subject string) (*User, error) {
+	query := `
+		SELECT u.id, u.name, u.phone_number, u.password_hash, u.role, u.created_at, u.suspended_at
+		FROM user_identities i
+		INNER JOIN users u ON u.id = i.user_id
+		WHERE i.issuer = $1 AND i.subject = $2
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	var u User
+	var suspendedAt sql.NullTime
+
+	err := m.DB.QueryRowContext(ctx, query, issuer, subject).Scan(
+		&u.ID,
+		&u.Name,
+		&u.PhoneNumber,
+		&u.Password.hash,
+		&u.Role,
+		&u.CreatedAt,
+		&suspendedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	u.SuspendedAt = nullTime(suspendedAt)
+	return &u, nil
+}
+
+// Link links the identity to the user, or records a login when it already
+// is. An identity linked to another user is left alone and ErrIdentityLinked returned
+func (m IdentityModel) Link(ctx context.Context, userID int64, issuer, subject string) error {
+	query := `
+		INSERT INTO user_identities (user_id, issuer, subject)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (issuer, subject) DO UPDATE
+		SET last_login_at = NOW()
+		WHERE user_identities.user_id = EXCLUDED.user_id
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, issuer, subject)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrIdentityLinked
+	}
+
+	return nil
+}
+
+// OIDCStateModel wraps the database connection
+type OIDCStateModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// Insert stores a pending login, pruning the expired ones on the way
+func (m OIDCStateModel) Insert(ctx context.Context, s *OIDCState) error {
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM oidc_states WHERE expires_at < NOW()`)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO oidc_states (state, nonce, verifier, expires_at)
+		VALUES ($1, $2, $3, $4)
+	`
+
+	_, err = m.DB.ExecContext(ctx, query, s.State, s.Nonce, s.Verifier, s.ExpiresAt)
+	return err
+}
+
+// Consume removes the pending login and returns it, so that every state is
+// accepted once. Expired logins are reported as ErrOIDCStateNotFound
+func (m OIDCStateModel) Consume(ctx context.Context, state string) (*OIDCState, error) {
+	query := `
+		DELETE FROM oidc_states
+		WHERE state = $1
+		RETURNING state, nonce, verifier, expires_at
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	var s OIDCState
+
+	err := m.DB.QueryRowContext(ctx, query, state).Scan(
+		&s.State,
+		&s.Nonce,
+		&s.Verifier,
+		&s.ExpiresAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrOIDCStateNotFound
+		}
+		return nil, err
+	}
+
+	if time.Now().After(s.ExpiresAt) {
+		return nil, ErrOIDCStateNotFound
+	}
+	return &s, nil
+}
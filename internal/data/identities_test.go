@@ -0,0 +1,79 @@
+package data
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestIdentityModel(t *testing.T) {
+	db := newTestDB(t)
+	m := IdentityModel{DB: db, Timeout: DefaultQueryTimeout}
+	users := UserModel{DB: db, Timeout: DefaultQueryTimeout}
+	staff := insertTestUser(t, users, "Staff Member", "9000000001")
+	other := insertTestUser(t, users, "Priya Raman", "9000000002")
+
+	_, err := m.GetUser(t.Context(), "https://id.city.example", "staff-1")
+	if !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("got error %v, want %v", err, ErrUserNotFound)
+	}
+
+	tests := []struct {
+		name    string
+		userID  int64
+		subject string
+		wantErr error
+	}{
+		{"link", staff.ID, "staff-1", nil},
+		{"login again", staff.ID, "staff-1", nil},
+		{"linked to another user", other.ID, "staff-1", ErrIdentityLinked},
+		{"second identity", other.ID, "staff-2", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := m.Link(t.Context(), tt.userID, "https://id.city.example", tt.subject)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	user, err := m.GetUser(t.Context(), "https://id.city.example", "staff-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != staff.ID || user.PhoneNumber != "9000000001" {
+		t.Errorf("got user %+v, want %d", user, staff.ID)
+	}
+}
+
+func TestOIDCStateModel(t *testing.T) {
+	db := newTestDB(t)
+	m := OIDCStateModel{DB: db, Timeout: DefaultQueryTimeout}
+
+	for _, s := range []*OIDCState{
+		{State: "pending", Nonce: "nonce", Verifier: "verifier", ExpiresAt: time.Now().Add(time.Minute)},
+		{State: "expired", Nonce: "nonce", Verifier: "verifier", ExpiresAt: time.Now().Add(-time.Minute)},
+	} {
+		err := m.Insert(t.Context(), s)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := m.Consume(t.Context(), "pending")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Nonce != "nonce" || s.Verifier != "verifier" {
+		t.Errorf("unexpected state %+v", s)
+	}
+
+	for _, state := range []string{"pending", "expired", "unknown"} {
+		_, err := m.Consume(t.Context(), state)
+		if !errors.Is(err, ErrOIDCStateNotFound) {
+			t.Errorf("got error %v consuming %s, want %v", err, state, ErrOIDCStateNotFound)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// LoginAttempt tracks the recent failed logins for a phone number
+type LoginAttempt struct {
+	PhoneNumber  string
+	FailedCount  int
+	LastFailedAt time.Time
+	LockedUntil  *time.Time
+}
+
+// Locked reports whether logins for the phone number are currently refused
+func (a *LoginAttempt) Locked() bool {
+	return a.LockedUntil != nil && a.LockedUntil.After(time.Now())
+}
+
+// LoginAttemptModel wraps the database connection
+type LoginAttemptModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// Get retrieves the failed login record of the phone number, a phone
+// number without failures gets an empty record
+func (m LoginAttemptModel) Get(ctx context.Context, phoneNumber string) (*LoginAttempt, error) {
+	query := `
+		SELECT phone_number, failed_count, last_failed_at, locked_until
+		FROM login_attempts
+		WHERE phone_number = $1
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	var a LoginAttempt
+	var lockedUntil sql.NullTime
+
+	err := m.DB.QueryRowContext(ctx, query, phoneNumber).Scan(
+		&a.PhoneNumber,
+		&a.FailedCount,
+		&a.LastFailedAt,
+		&lockedUntil,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return &LoginAttempt{PhoneNumber: phoneNumber}, nil
+		}
+		return nil, err
+	}
+
+	if lockedUntil.Valid {
+		a.LockedUntil = &lockedUntil.Time
+	}
+
+	return &a, nil
+}
+
+// RecordFailure counts a failed login for the phone number and returns the
+// updated number of failures. Failures older than window are forgotten
+func (m LoginAttemptModel) RecordFailure(ctx context.Context, phoneNumber string, window time.Duration) (int, error) {
+	query := `
+		INSERT INTO login_attempts (phone_number, failed_count, last_failed_at)
+		VALUES ($1, 1, NOW())
+		ON CONFLICT (phone_number) DO UPDATE
+		SET failed_count = CASE
+		        WHEN login_attempts.last_failed_at < NOW() - $2 * INTERVAL '1 second' THEN 1
+		        ELSE login_attempts.failed_count + 1
+		    END,
+		    last_failed_at = NOW()
+		RETURNING failed_count
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	var count int
+	err := m.DB.QueryRowContext(ctx, query, phoneNumber, window.Seconds()).Scan(&count)
+	return count, err
+}
+
+// Lock refuses logins for the phone number until the given time
+func (m LoginAttemptModel) Lock(ctx context.Context, phoneNumber string, until time.Time) error {
+	query := `
+		UPDATE login_attempts
+		SET locked_until = $2
+		WHERE phone_number = $1
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, phoneNumber, until)
+	return err
+}
+
+// Reset forgets the failed logins of the phone number after a successful login
+func (m LoginAttemptModel) Reset(ctx context.Context, phoneNumber string) error {
+	query := `
+		DELETE FROM login_attempts
+		WHERE phone_number = $1
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, phoneNumber)
+	return err
+}
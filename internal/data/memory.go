@@ -0,0 +1,1407 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryStore holds the tables of the in-memory models. It mirrors the
+// semantics of the Postgres models closely enough for handler tests:
+// unique constraints, not found errors, ordering and the outbox events
+// written by report writes
+type memoryStore struct {
+	mu  sync.Mutex
+	ids map[string]int64
+
+	users         []*User
+	reports       []*Report
+	notifications []*Notification
+	webhooks      []*Webhook
+	deliveries    []*memoryDelivery
+	outbox        []*memoryOutboxEvent
+	preferences   map[int64]NotificationPreferences
+	messages      []*NotificationMessage
+	loginAttempts map[string]LoginAttempt
+	apiKeys       []*APIKey
+	mfa           map[int64]*memoryMFA
+	identities    []*Identity
+	oidcStates    map[string]OIDCState
+	audit         []*AuditEvent
+	idempotency   map[[2]string]IdempotencyKey // By client and key
+}
+
+// memoryDelivery is a webhook delivery along with the event key it was queued for
+type memoryDelivery struct {
+	WebhookDelivery
+	eventKey string
+}
+
+// memoryMFA is an enrolment along with the recovery codes, by hash
+type memoryMFA struct {
+	MFA
+	recoveryCodes map[string]bool // Whether the code was used
+}
+
+// memoryOutboxEvent is an outbox event along with its dispatch state
+type memoryOutboxEvent struct {
+	OutboxEvent
+	availableAt time.Time
+	processedAt *time.Time
+	deadAt      *time.Time
+	lastError   string
+}
+
+// NewMemoryModels returns Models backed by memory instead of Postgres,
+// meant for tests. The models share a single store, so that for example
+// report writes show up in the outbox
+func NewMemoryModels() Models {
+	s := &memoryStore{
+		ids:           map[string]int64{},
+		preferences:   map[int64]NotificationPreferences{},
+		loginAttempts: map[string]LoginAttempt{},
+		mfa:           map[int64]*memoryMFA{},
+		oidcStates:    map[string]OIDCState{},
+		idempotency:   map[[2]string]IdempotencyKey{},
+	}
+
+	return Models{
+		Users:         memoryUsers{s},
+		Reports:       memoryReports{s},
+		Notifications: memoryNotifications{s},
+		Webhooks:      memoryWebhooks{s},
+		Deliveries:    memoryDeliveries{s},
+		Outbox:        memoryOutbox{s},
+		Preferences:   memoryPreferences{s},
+		Messages:      memoryMessages{s},
+		LoginAttempts: memoryLoginAttempts{s},
+		APIKeys:       memoryAPIKeys{s},
+		MFA:           memoryMFAs{s},
+		Identities:    memoryIdentities{s},
+		OIDCStates:    memoryOIDCStates{s},
+		Audit:         memoryAudit{s},
+		Idempotency:   memoryIdempotency{s},
+	}
+}
+
+// id returns the next row id of the table, like a BIGSERIAL column
+func (s *memoryStore) id(table string) int64 {
+	s.ids[table]++
+	return s.ids[table]
+}
+
+// paginate returns the page of items selected by limit and offset
+func paginate[T any](items []T, limit, offset int) []T {
+	if offset >= len(items) {
+		return []T{}
+	}
+	items = items[offset:]
+	if limit < len(items) {
+		items = items[:limit]
+	}
+	return items
+}
+
+// newestFirst orders rows by creation time and then id, both descending
+func newestFirst(aCreated, bCreated Time, aID, bID int64) bool {
+	a, b := time.Time(aCreated), time.Time(bCreated)
+	if !a.Equal(b) {
+		return a.After(b)
+	}
+	return aID > bID
+}
+
+// insertAuditEvent appends an event to the audit log, chained like in Postgres
+func (s *memoryStore) insertAuditEvent(e *AuditEvent) error {
+	var prevHash []byte
+	if len(s.audit) > 0 {
+		prevHash = s.audit[len(s.audit)-1].Hash
+	}
+
+	err := e.seal(prevHash)
+	if err != nil {
+		return err
+	}
+
+	e.ID = s.id("audit_events")
+	event := *e
+	s.audit = append(s.audit, &event)
+	return nil
+}
+
+// insertOutboxEvent records an event the way the report writes do in Postgres
+func (s *memoryStore) insertOutboxEvent(eventType string, aggregateID int64, payload any) error {
+	js, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	key, err := newIdempotencyKey()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	s.outbox = append(s.outbox, &memoryOutboxEvent{
+		OutboxEvent: OutboxEvent{
+			ID:             s.id("outbox"),
+			IdempotencyKey: key,
+			EventType:      eventType,
+			AggregateID:    aggregateID,
+			Payload:        js,
+			CreatedAt:      now,
+		},
+		availableAt: now,
+	})
+	return nil
+}
+
+type memoryUsers struct{ s *memoryStore }
+
+func (m memoryUsers) Insert(ctx context.Context, user *User) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	for _, u := range m.s.users {
+		if u.PhoneNumber == user.PhoneNumber {
+			return ErrDuplicatePhoneNumber
+		}
+	}
+
+	user.ID = m.s.id("users")
+	if user.Role == "" {
+		user.Role = "user"
+	}
+	user.CreatedAt = Time(time.Now())
+
+	u := *user
+	m.s.users = append(m.s.users, &u)
+	return nil
+}
+
+func (m memoryUsers) GetByPhoneNumber(ctx context.Context, phoneNumber string) (*User, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	for _, u := range m.s.users {
+		if u.PhoneNumber == phoneNumber {
+			user := *u
+			return &user, nil
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+func (m memoryUsers) GetByID(ctx context.Context, id int64) (*User, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	user := m.s.user(id)
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	u := *user
+	return &u, nil
+}
+
+func (m memoryUsers) UpdateRole(ctx context.Context, id int64, role string) error {
+	return m.update(id, func(u *User) { u.Role = role })
+}
+
+func (m memoryUsers) UpdatePassword(ctx context.Context, user *User) error {
+	return m.update(user.ID, func(u *User) { u.Password = user.Password })
+}
+
+func (m memoryUsers) SetSuspended(ctx context.Context, id int64, suspended bool) error {
+	return m.update(id, func(u *User) {
+		if !suspended {
+			u.SuspendedAt = nil
+		} else if u.SuspendedAt == nil {
+			now := Time(time.Now())
+			u.SuspendedAt = &now
+		}
+	})
+}
+
+// update applies fn to the stored user with the given id
+func (m memoryUsers) update(id int64, fn func(*User)) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	user := m.s.user(id)
+	if user == nil {
+		return ErrUserNotFound
+	}
+	fn(user)
+	return nil
+}
+
+// user returns the stored user with the given id, or nil
+func (s *memoryStore) user(id int64) *User {
+	for _, u := range s.users {
+		if u.ID == id {
+			return u
+		}
+	}
+	return nil
+}
+
+type memoryReports struct{ s *memoryStore }
+
+func (m memoryReports) Insert(ctx context.Context, report *Report) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	now := Time(time.Now())
+	report.ID = m.s.id("reports")
+	report.Status = "pending"
+	report.CreatedAt = now
+	report.UpdatedAt = now
+	report.Version = 1
+
+	r := *report
+	r.UserName = ""
+	m.s.reports = append(m.s.reports, &r)
+
+	return m.s.insertOutboxEvent(EventReportCreated, r.ID, ReportEvent{Report: r.summary()})
+}
+
+func (m memoryReports) Get(ctx context.Context, id int64) (*Report, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	for _, r := range m.s.reports {
+		if r.ID == id {
+			if report, ok := m.s.withUserName(r); ok {
+				return report, nil
+			}
+		}
+	}
+	return nil, ErrReportNotFound
+}
+
+func (m memoryReports) GetAll(ctx context.Context, limit, offset int, status, category string) ([]*Report, error) {
+	return m.list(limit, offset, func(r *Report) bool {
+		return (status == "" || r.Status == status) && (category == "" || r.Category == category)
+	}), nil
+}
+
+func (m memoryReports) GetByUserID(ctx context.Context, userID int64, limit, offset int) ([]*Report, error) {
+	return m.list(limit, offset, func(r *Report) bool {
+		return r.UserID == userID
+	}), nil
+}
+
+// list returns a page of the reports accepted by filter, newest first
+func (m memoryReports) list(limit, offset int, filter func(*Report) bool) []*Report {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	reports := []*Report{}
+	for _, r := range m.s.reports {
+		if !filter(r) {
+			continue
+		}
+		if report, ok := m.s.withUserName(r); ok {
+			reports = append(reports, report)
+		}
+	}
+
+	sort.SliceStable(reports, func(i, j int) bool {
+		return newestFirst(reports[i].CreatedAt, reports[j].CreatedAt, reports[i].ID, reports[j].ID)
+	})
+
+	return paginate(reports, limit, offset)
+}
+
+// withUserName returns a copy of the report with the name of its author,
+// reports without an author are left out like the SQL inner join does
+func (s *memoryStore) withUserName(r *Report) (*Report, bool) {
+	user := s.user(r.UserID)
+	if user == nil {
+		return nil, false
+	}
+	report := *r
+	report.UserName = user.Name
+	return &report, true
+}
+
+func (m memoryReports) Update(ctx context.Context, id int64, version int32, status, afterImage string) error {
+	return m.changeStatus(ctx, id, version, AuditReportStatusUpdated, updateStatus(status, afterImage))
+}
+
+func (m memoryReports) Withdraw(ctx context.Context, id int64, version int32) error {
+	return m.changeStatus(ctx, id, version, AuditReportWithdrawn, withdraw)
+}
+
+func (m memoryReports) changeStatus(ctx context.Context, id int64, version int32, action string, transition func(reportAuditState) (reportAuditState, error)) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	r := m.s.report(id)
+	if r == nil {
+		return ErrReportNotFound
+	}
+	if version != 0 && version != r.Version {
+		return ErrEditConflict
+	}
+
+	previous := reportAuditState{Status: r.Status, AfterImage: r.AfterImage}
+	next, err := transition(previous)
+	if err != nil {
+		return err
+	}
+	now := Time(time.Now())
+
+	r.Status = next.Status
+	r.AfterImage = next.AfterImage
+	r.UpdatedAt = now
+	r.Version++
+	if next.Status == "completed" {
+		r.CompletedAt = &now
+	}
+
+	event := ReportEvent{Report: r.summary(), PreviousStatus: previous.Status}
+	err = m.s.insertOutboxEvent(EventReportUpdated, id, event)
+	if err != nil {
+		return err
+	}
+
+	audit, err := reportAuditEvent(ctx, action, id, previous, next)
+	if err != nil {
+		return err
+	}
+	return m.s.insertAuditEvent(audit)
+}
+
+func (m memoryReports) Edit(ctx context.Context, report *Report, version int32) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	r := m.s.report(report.ID)
+	if r == nil {
+		return ErrReportNotFound
+	}
+	if version != 0 && version != r.Version {
+		return ErrEditConflict
+	}
+	if r.Status != "pending" {
+		return ErrReportNotEditable
+	}
+
+	previous := r.editAuditState()
+
+	r.Title = report.Title
+	r.Description = report.Description
+	r.Location = report.Location
+	r.BeforeImage = report.BeforeImage
+	r.UpdatedAt = Time(time.Now())
+	r.Version++
+
+	report.UserID = r.UserID
+	report.Category = r.Category
+	report.Status = r.Status
+	report.CreatedAt = r.CreatedAt
+	report.UpdatedAt = r.UpdatedAt
+	report.Version = r.Version
+
+	event := ReportEvent{Report: r.summary(), PreviousStatus: r.Status}
+	err := m.s.insertOutboxEvent(EventReportUpdated, r.ID, event)
+	if err != nil {
+		return err
+	}
+
+	audit, err := reportAuditEvent(ctx, AuditReportEdited, r.ID, previous, r.editAuditState())
+	if err != nil {
+		return err
+	}
+	return m.s.insertAuditEvent(audit)
+}
+
+// report returns the stored report with the id, nil when there is none
+func (s *memoryStore) report(id int64) *Report {
+	for _, r := range s.reports {
+		if r.ID == id {
+			return r
+		}
+	}
+	return nil
+}
+
+func (m memoryReports) GetStats(ctx context.Context) (*ReportStats, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	var stats ReportStats
+	for _, r := range m.s.reports {
+		stats.TotalReports++
+		switch r.Status {
+		case "pending":
+			stats.PendingReports++
+		case "in-progress":
+			stats.InProgressReports++
+		case "completed":
+			stats.CompletedReports++
+		case "rejected":
+			stats.RejectedReports++
+		case "withdrawn":
+			stats.WithdrawnReports++
+		}
+	}
+	return &stats, nil
+}
+
+func (m memoryReports) GetLeaderboard(ctx context.Context) ([]*LeaderboardEntry, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	counts := map[int64]int{}
+	for _, r := range m.s.reports {
+		if r.Status != "withdrawn" {
+			counts[r.UserID]++
+		}
+	}
+
+	leaderboard := []*LeaderboardEntry{}
+	for _, u := range m.s.users {
+		if counts[u.ID] == 0 {
+			continue
+		}
+		leaderboard = append(leaderboard, &LeaderboardEntry{
+			UserID:      u.ID,
+			UserName:    u.Name,
+			PhoneNumber: u.PhoneNumber,
+			ReportCount: counts[u.ID],
+		})
+	}
+
+	sort.SliceStable(leaderboard, func(i, j int) bool {
+		return leaderboard[i].ReportCount > leaderboard[j].ReportCount
+	})
+
+	return paginate(leaderboard, 10, 0), nil
+}
+
+// summary returns the representation of the report carried by its events
+func (r *Report) summary() ReportSummary {
+	summary := ReportSummary{
+		ID:        r.ID,
+		UserID:    r.UserID,
+		Title:     r.Title,
+		Category:  r.Category,
+		Location:  r.Location,
+		Status:    r.Status,
+		CreatedAt: time.Time(r.CreatedAt),
+		UpdatedAt: time.Time(r.UpdatedAt),
+	}
+	if r.CompletedAt != nil {
+		t := time.Time(*r.CompletedAt)
+		summary.CompletedAt = &t
+	}
+	return summary
+}
+
+type memoryNotifications struct{ s *memoryStore }
+
+func (m memoryNotifications) Insert(ctx context.Context, n *Notification) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	if n.EventKey != "" {
+		for _, existing := range m.s.notifications {
+			if existing.EventKey == n.EventKey {
+				return ErrDuplicateEvent
+			}
+		}
+	}
+
+	n.ID = m.s.id("notifications")
+	n.CreatedAt = Time(time.Now())
+
+	stored := *n
+	m.s.notifications = append(m.s.notifications, &stored)
+	return nil
+}
+
+func (m memoryNotifications) GetByEventKey(ctx context.Context, eventKey string) (*Notification, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	for _, n := range m.s.notifications {
+		if eventKey != "" && n.EventKey == eventKey {
+			notification := *n
+			return &notification, nil
+		}
+	}
+	return nil, ErrNotificationNotFound
+}
+
+func (m memoryNotifications) GetForUser(ctx context.Context, userID int64, unreadOnly bool, limit, offset int) ([]*Notification, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	notifications := []*Notification{}
+	for _, n := range m.s.notifications {
+		if n.UserID != userID || (unreadOnly && n.ReadAt != nil) {
+			continue
+		}
+		notification := *n
+		notification.EventKey = ""
+		notifications = append(notifications, &notification)
+	}
+
+	sort.SliceStable(notifications, func(i, j int) bool {
+		return newestFirst(notifications[i].CreatedAt, notifications[j].CreatedAt, notifications[i].ID, notifications[j].ID)
+	})
+
+	return paginate(notifications, limit, offset), nil
+}
+
+func (m memoryNotifications) CountUnread(ctx context.Context, userID int64) (int, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	count := 0
+	for _, n := range m.s.notifications {
+		if n.UserID == userID && n.ReadAt == nil {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m memoryNotifications) MarkRead(ctx context.Context, id, userID int64) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	for _, n := range m.s.notifications {
+		if n.ID == id && n.UserID == userID {
+			if n.ReadAt == nil {
+				now := Time(time.Now())
+				n.ReadAt = &now
+			}
+			return nil
+		}
+	}
+	return ErrNotificationNotFound
+}
+
+func (m memoryNotifications) MarkAllRead(ctx context.Context, userID int64) (int64, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	var updated int64
+	now := Time(time.Now())
+	for _, n := range m.s.notifications {
+		if n.UserID == userID && n.ReadAt == nil {
+			n.ReadAt = &now
+			updated++
+		}
+	}
+	return updated, nil
+}
+
+func (m memoryNotifications) DeleteOlderThan(ctx context.Context, age time.Duration) (int64, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	cutoff := time.Now().Add(-age)
+	before := len(m.s.notifications)
+	m.s.notifications = slices.DeleteFunc(m.s.notifications, func(n *Notification) bool {
+		return time.Time(n.CreatedAt).Before(cutoff)
+	})
+	return int64(before - len(m.s.notifications)), nil
+}
+
+type memoryWebhooks struct{ s *memoryStore }
+
+func (m memoryWebhooks) Insert(ctx context.Context, wh *Webhook) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	wh.ID = m.s.id("webhooks")
+	wh.CreatedAt = Time(time.Now())
+
+	stored := *wh
+	stored.EventTypes = slices.Clone(wh.EventTypes)
+	m.s.webhooks = append(m.s.webhooks, &stored)
+	return nil
+}
+
+func (m memoryWebhooks) GetAll(ctx context.Context) ([]*Webhook, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	webhooks := []*Webhook{}
+	for _, wh := range m.s.webhooks {
+		webhook := *wh
+		webhooks = append(webhooks, &webhook)
+	}
+	return webhooks, nil
+}
+
+func (m memoryWebhooks) Get(ctx context.Context, id int64) (*Webhook, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	wh := m.s.webhook(id)
+	if wh == nil {
+		return nil, ErrWebhookNotFound
+	}
+	webhook := *wh
+	return &webhook, nil
+}
+
+// webhook returns the stored webhook with the given id, or nil
+func (s *memoryStore) webhook(id int64) *Webhook {
+	for _, wh := range s.webhooks {
+		if wh.ID == id {
+			return wh
+		}
+	}
+	return nil
+}
+
+func (m memoryWebhooks) Delete(ctx context.Context, id int64) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	if m.s.webhook(id) == nil {
+		return ErrWebhookNotFound
+	}
+
+	m.s.webhooks = slices.DeleteFunc(m.s.webhooks, func(wh *Webhook) bool {
+		return wh.ID == id
+	})
+	m.s.deliveries = slices.DeleteFunc(m.s.deliveries, func(d *memoryDelivery) bool {
+		return d.WebhookID == id
+	})
+	return nil
+}
+
+type memoryDeliveries struct{ s *memoryStore }
+
+func (m memoryDeliveries) Enqueue(ctx context.Context, eventType string, payload []byte, eventKey string) (int64, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	var queued int64
+	now := Time(time.Now())
+
+	for _, wh := range m.s.webhooks {
+		if !wh.Active || !slices.Contains(wh.EventTypes, eventType) {
+			continue
+		}
+		duplicate := slices.ContainsFunc(m.s.deliveries, func(d *memoryDelivery) bool {
+			return d.WebhookID == wh.ID && d.eventKey == eventKey
+		})
+		if duplicate {
+			continue
+		}
+
+		m.s.deliveries = append(m.s.deliveries, &memoryDelivery{
+			WebhookDelivery: WebhookDelivery{
+				ID:            m.s.id("webhook_deliveries"),
+				WebhookID:     wh.ID,
+				EventType:     eventType,
+				Payload:       slices.Clone(payload),
+				Status:        DeliveryPending,
+				NextAttemptAt: now,
+				CreatedAt:     now,
+				UpdatedAt:     now,
+			},
+			eventKey: eventKey,
+		})
+		queued++
+	}
+	return queued, nil
+}
+
+func (m memoryDeliveries) ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]*WebhookDelivery, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	now := time.Now()
+	due := []*memoryDelivery{}
+	for _, d := range m.s.deliveries {
+		if d.Status == DeliveryPending && !time.Time(d.NextAttemptAt).After(now) {
+			due = append(due, d)
+		}
+	}
+
+	sort.SliceStable(due, func(i, j int) bool {
+		return time.Time(due[i].NextAttemptAt).Before(time.Time(due[j].NextAttemptAt))
+	})
+
+	deliveries := []*WebhookDelivery{}
+	for _, d := range paginate(due, limit, 0) {
+		d.NextAttemptAt = Time(now.Add(lease))
+
+		delivery := d.WebhookDelivery
+		if wh := m.s.webhook(d.WebhookID); wh != nil {
+			delivery.URL = wh.URL
+			delivery.Secret = wh.Secret
+		}
+		deliveries = append(deliveries, &delivery)
+	}
+	return deliveries, nil
+}
+
+func (m memoryDeliveries) MarkSucceeded(ctx context.Context, id int64, statusCode int) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	for _, d := range m.s.deliveries {
+		if d.ID == id {
+			d.Status = DeliverySucceeded
+			d.Attempts++
+			d.LastStatusCode = &statusCode
+			d.LastError = ""
+			d.UpdatedAt = Time(time.Now())
+		}
+	}
+	return nil
+}
+
+func (m memoryDeliveries) MarkFailed(ctx context.Context, id int64, statusCode *int, lastError string, dead bool, nextAttempt time.Time) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	for _, d := range m.s.deliveries {
+		if d.ID == id {
+			d.Status = DeliveryPending
+			if dead {
+				d.Status = DeliveryDead
+			}
+			d.Attempts++
+			d.LastStatusCode = statusCode
+			d.LastError = lastError
+			d.NextAttemptAt = Time(nextAttempt)
+			d.UpdatedAt = Time(time.Now())
+		}
+	}
+	return nil
+}
+
+func (m memoryDeliveries) GetForWebhook(ctx context.Context, webhookID int64, status string, limit, offset int) ([]*WebhookDelivery, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	deliveries := []*WebhookDelivery{}
+	for _, d := range m.s.deliveries {
+		if d.WebhookID == webhookID && (status == "" || d.Status == status) {
+			delivery := d.WebhookDelivery
+			deliveries = append(deliveries, &delivery)
+		}
+	}
+
+	sort.SliceStable(deliveries, func(i, j int) bool {
+		return newestFirst(deliveries[i].CreatedAt, deliveries[j].CreatedAt, deliveries[i].ID, deliveries[j].ID)
+	})
+
+	return paginate(deliveries, limit, offset), nil
+}
+
+type memoryOutbox struct{ s *memoryStore }
+
+// ProcessBatch hands the due events to handle in id order. Like in
+// Postgres the store isn't locked while the handlers run, claimed events
+// are leased instead
+func (m memoryOutbox) ProcessBatch(ctx context.Context, limit, maxAttempts int, handle func(context.Context, *OutboxEvent) error, retryAfter func(attempts int) time.Duration) (int, error) {
+	m.s.mu.Lock()
+	now := time.Now()
+	due := []*memoryOutboxEvent{}
+	for _, e := range m.s.outbox {
+		if e.processedAt == nil && e.deadAt == nil && !e.availableAt.After(now) {
+			due = append(due, e)
+		}
+	}
+	due = paginate(due, limit, 0)
+
+	events := make([]OutboxEvent, len(due))
+	for i, e := range due {
+		e.availableAt = now.Add(outboxLease)
+		events[i] = e.OutboxEvent
+		events[i].Handled = slices.Clone(e.Handled)
+	}
+	m.s.mu.Unlock()
+
+	for i, e := range due {
+		event := &events[i]
+		handleErr := handle(ctx, event)
+
+		m.s.mu.Lock()
+		e.Handled = slices.Clone(event.Handled)
+		if handleErr == nil {
+			processedAt := time.Now()
+			e.processedAt = &processedAt
+			e.lastError = ""
+		} else {
+			e.Attempts++
+			e.lastError = handleErr.Error()
+			e.availableAt = time.Now().Add(retryAfter(e.Attempts))
+			if e.Attempts >= maxAttempts {
+				deadAt := time.Now()
+				e.deadAt = &deadAt
+			}
+		}
+		m.s.mu.Unlock()
+	}
+
+	return len(due), nil
+}
+
+func (m memoryOutbox) DeleteProcessedBefore(ctx context.Context, age time.Duration) (int64, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	cutoff := time.Now().Add(-age)
+	before := len(m.s.outbox)
+	m.s.outbox = slices.DeleteFunc(m.s.outbox, func(e *memoryOutboxEvent) bool {
+		return e.processedAt != nil && e.processedAt.Before(cutoff)
+	})
+	return int64(before - len(m.s.outbox)), nil
+}
+
+type memoryPreferences struct{ s *memoryStore }
+
+func (m memoryPreferences) Get(ctx context.Context, userID int64) (*NotificationPreferences, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	p, ok := m.s.preferences[userID]
+	if !ok {
+		return DefaultNotificationPreferences(userID), nil
+	}
+	return &p, nil
+}
+
+func (m memoryPreferences) Upsert(ctx context.Context, p *NotificationPreferences) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	m.s.preferences[p.UserID] = *p
+	return nil
+}
+
+type memoryMessages struct{ s *memoryStore }
+
+func (m memoryMessages) Enqueue(ctx context.Context, msg *NotificationMessage) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	if msg.EventKey != "" {
+		duplicate := slices.ContainsFunc(m.s.messages, func(existing *NotificationMessage) bool {
+			return existing.EventKey == msg.EventKey && existing.Channel == msg.Channel
+		})
+		if duplicate {
+			return ErrDuplicateEvent
+		}
+	}
+
+	msg.ID = m.s.id("notification_messages")
+	msg.Status = MessageQueued
+	msg.CreatedAt = Time(time.Now())
+
+	stored := *msg
+	m.s.messages = append(m.s.messages, &stored)
+	return nil
+}
+
+func (m memoryMessages) ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]*NotificationMessage, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	now := time.Now()
+	due := []*NotificationMessage{}
+	for _, msg := range m.s.messages {
+		if msg.Status == MessageQueued && !time.Time(msg.SendAfter).After(now) {
+			due = append(due, msg)
+		}
+	}
+
+	sort.SliceStable(due, func(i, j int) bool {
+		return time.Time(due[i].SendAfter).Before(time.Time(due[j].SendAfter))
+	})
+
+	messages := []*NotificationMessage{}
+	for _, msg := range paginate(due, limit, 0) {
+		msg.SendAfter = Time(now.Add(lease))
+		claimed := *msg
+		messages = append(messages, &claimed)
+	}
+	return messages, nil
+}
+
+func (m memoryMessages) MarkSent(ctx context.Context, id int64) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	for _, msg := range m.s.messages {
+		if msg.ID == id {
+			now := Time(time.Now())
+			msg.Status = MessageSent
+			msg.Attempts++
+			msg.LastError = ""
+			msg.SentAt = &now
+		}
+	}
+	return nil
+}
+
+func (m memoryMessages) MarkFailed(ctx context.Context, id int64, lastError string, failed bool, nextAttempt time.Time) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	for _, msg := range m.s.messages {
+		if msg.ID == id {
+			msg.Status = MessageQueued
+			if failed {
+				msg.Status = MessageFailed
+			}
+			msg.Attempts++
+			msg.LastError = lastError
+			msg.SendAfter = Time(nextAttempt)
+		}
+	}
+	return nil
+}
+
+func (m memoryMessages) GetForUser(ctx context.Context, userID int64, limit, offset int) ([]*NotificationMessage, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	messages := []*NotificationMessage{}
+	for _, msg := range m.s.messages {
+		if msg.UserID == userID {
+			message := *msg
+			message.EventKey = ""
+			messages = append(messages, &message)
+		}
+	}
+
+	sort.SliceStable(messages, func(i, j int) bool {
+		return newestFirst(messages[i].CreatedAt, messages[j].CreatedAt, messages[i].ID, messages[j].ID)
+	})
+
+	return paginate(messages, limit, offset), nil
+}
+
+type memoryLoginAttempts struct{ s *memoryStore }
+
+func (m memoryLoginAttempts) Get(ctx context.Context, phoneNumber string) (*LoginAttempt, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	a, ok := m.s.loginAttempts[phoneNumber]
+	if !ok {
+		return &LoginAttempt{PhoneNumber: phoneNumber}, nil
+	}
+	return &a, nil
+}
+
+func (m memoryLoginAttempts) RecordFailure(ctx context.Context, phoneNumber string, window time.Duration) (int, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	now := time.Now()
+	a, ok := m.s.loginAttempts[phoneNumber]
+	if !ok || a.LastFailedAt.Before(now.Add(-window)) {
+		a.PhoneNumber = phoneNumber
+		a.FailedCount = 0
+	}
+	a.FailedCount++
+	a.LastFailedAt = now
+
+	m.s.loginAttempts[phoneNumber] = a
+	return a.FailedCount, nil
+}
+
+func (m memoryLoginAttempts) Lock(ctx context.Context, phoneNumber string, until time.Time) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	if a, ok := m.s.loginAttempts[phoneNumber]; ok {
+		a.LockedUntil = &until
+		m.s.loginAttempts[phoneNumber] = a
+	}
+	return nil
+}
+
+func (m memoryLoginAttempts) Reset(ctx context.Context, phoneNumber string) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	delete(m.s.loginAttempts, phoneNumber)
+	return nil
+}
+
+type memoryAPIKeys struct{ s *memoryStore }
+
+func (m memoryAPIKeys) Insert(ctx context.Context, k *APIKey) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	k.ID = m.s.id("api_keys")
+	k.CreatedAt = Time(time.Now())
+
+	stored := *k
+	stored.Scopes = slices.Clone(k.Scopes)
+	m.s.apiKeys = append(m.s.apiKeys, &stored)
+	return nil
+}
+
+func (m memoryAPIKeys) GetByPrefix(ctx context.Context, prefix string) (*APIKey, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	for _, k := range m.s.apiKeys {
+		if k.Prefix == prefix {
+			key := *k
+			return &key, nil
+		}
+	}
+	return nil, ErrAPIKeyNotFound
+}
+
+func (m memoryAPIKeys) GetAll(ctx context.Context) ([]*APIKey, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	keys := []*APIKey{}
+	for i := len(m.s.apiKeys) - 1; i >= 0; i-- {
+		key := *m.s.apiKeys[i]
+		keys = append(keys, &key)
+	}
+	return keys, nil
+}
+
+func (m memoryAPIKeys) Revoke(ctx context.Context, id int64) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	for _, k := range m.s.apiKeys {
+		if k.ID == id {
+			if k.RevokedAt == nil {
+				now := Time(time.Now())
+				k.RevokedAt = &now
+			}
+			return nil
+		}
+	}
+	return ErrAPIKeyNotFound
+}
+
+func (m memoryAPIKeys) TouchLastUsed(ctx context.Context, id int64) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	for _, k := range m.s.apiKeys {
+		if k.ID == id {
+			now := Time(time.Now())
+			k.LastUsedAt = &now
+		}
+	}
+	return nil
+}
+
+type memoryMFAs struct{ s *memoryStore }
+
+func (m memoryMFAs) Get(ctx context.Context, userID int64) (*MFA, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	stored, ok := m.s.mfa[userID]
+	if !ok {
+		return nil, ErrMFANotEnrolled
+	}
+	mfa := stored.MFA
+	return &mfa, nil
+}
+
+func (m memoryMFAs) Enroll(ctx context.Context, userID int64, secret string) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	if stored, ok := m.s.mfa[userID]; ok && stored.Enabled() {
+		return ErrMFAAlreadyEnabled
+	}
+	m.s.mfa[userID] = &memoryMFA{
+		MFA:           MFA{UserID: userID, Secret: secret, CreatedAt: Time(time.Now())},
+		recoveryCodes: map[string]bool{},
+	}
+	return nil
+}
+
+func (m memoryMFAs) Confirm(ctx context.Context, userID, step int64, recoveryHashes [][]byte) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	stored, ok := m.s.mfa[userID]
+	if !ok || stored.Enabled() {
+		return ErrMFANotEnrolled
+	}
+	now := Time(time.Now())
+	stored.ConfirmedAt = &now
+	stored.LastStep = step
+	stored.recoveryCodes = map[string]bool{}
+	for _, hash := range recoveryHashes {
+		stored.recoveryCodes[string(hash)] = false
+	}
+	return nil
+}
+
+func (m memoryMFAs) UseStep(ctx context.Context, userID, step int64) (bool, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	stored, ok := m.s.mfa[userID]
+	if !ok || stored.LastStep >= step {
+		return false, nil
+	}
+	stored.LastStep = step
+	return true, nil
+}
+
+func (m memoryMFAs) UseRecoveryCode(ctx context.Context, userID int64, hash []byte) (bool, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	stored, ok := m.s.mfa[userID]
+	if !ok {
+		return false, nil
+	}
+	used, ok := stored.recoveryCodes[string(hash)]
+	if !ok || used {
+		return false, nil
+	}
+	stored.recoveryCodes[string(hash)] = true
+	return true, nil
+}
+
+func (m memoryMFAs) CountRecoveryCodes(ctx context.Context, userID int64) (int, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	count := 0
+	if stored, ok := m.s.mfa[userID]; ok {
+		for _, used := range stored.recoveryCodes {
+			if !used {
+				count++
+			}
+		}
+	}
+	return count, nil
+}
+
+func (m memoryMFAs) Delete(ctx context.Context, userID int64) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	if _, ok := m.s.mfa[userID]; !ok {
+		return ErrMFANotEnrolled
+	}
+	delete(m.s.mfa, userID)
+	return nil
+}
+
+type memoryIdentities struct{ s *memoryStore }
+
+func (m memoryIdentities) GetUser(ctx context.Context, issuer, subject string) (*User, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	for _, i := range m.s.identities {
+		if i.Issuer == issuer && i.Subject == subject {
+			if u := m.s.user(i.UserID); u != nil {
+				user := *u
+				return &user, nil
+			}
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+func (m memoryIdentities) Link(ctx context.Context, userID int64, issuer, subject string) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	for _, i := range m.s.identities {
+		if i.Issuer == issuer && i.Subject == subject {
+			if i.UserID != userID {
+				return ErrIdentityLinked
+			}
+			i.LastLoginAt = Time(time.Now())
+			return nil
+		}
+	}
+
+	now := Time(time.Now())
+	m.s.identities = append(m.s.identities, &Identity{
+		ID:          m.s.id("user_identities"),
+		UserID:      userID,
+		Issuer:      issuer,
+		Subject:     subject,
+		CreatedAt:   now,
+		LastLoginAt: now,
+	})
+	return nil
+}
+
+type memoryOIDCStates struct{ s *memoryStore }
+
+func (m memoryOIDCStates) Insert(ctx context.Context, s *OIDCState) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	m.s.oidcStates[s.State] = *s
+	return nil
+}
+
+func (m memoryOIDCStates) Consume(ctx context.Context, state string) (*OIDCState, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	s, ok := m.s.oidcStates[state]
+	if !ok {
+		return nil, ErrOIDCStateNotFound
+	}
+	delete(m.s.oidcStates, state)
+
+	if time.Now().After(s.ExpiresAt) {
+		return nil, ErrOIDCStateNotFound
+	}
+	return &s, nil
+}
+
+type memoryAudit struct{ s *memoryStore }
+
+func (m memoryAudit) Record(ctx context.Context, e *AuditEvent) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	return m.s.insertAuditEvent(e)
+}
+
+func (m memoryAudit) GetAll(ctx context.Context, filter AuditFilter, limit, offset int) ([]*AuditEvent, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	events := []*AuditEvent{}
+	for i := len(m.s.audit) - 1; i >= 0; i-- {
+		e := m.s.audit[i]
+		if (filter.ActorType != "" && e.ActorType != filter.ActorType) ||
+			(filter.ActorID != 0 && e.ActorID != filter.ActorID) ||
+			(filter.Action != "" && e.Action != filter.Action) ||
+			(filter.TargetType != "" && e.TargetType != filter.TargetType) ||
+			(filter.TargetID != "" && e.TargetID != filter.TargetID) ||
+			(filter.Since != nil && e.CreatedAt.Before(*filter.Since)) ||
+			(filter.Until != nil && !e.CreatedAt.Before(*filter.Until)) {
+			continue
+		}
+		event := *e
+		events = append(events, &event)
+	}
+	return paginate(events, limit, offset), nil
+}
+
+func (m memoryAudit) Verify(ctx context.Context, afterID int64, limit int) (*AuditVerification, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	chain := auditChain{result: AuditVerification{Valid: true, LastID: afterID}}
+	events := m.s.audit
+	if afterID > 0 {
+		i := slices.IndexFunc(events, func(e *AuditEvent) bool { return e.ID == afterID })
+		if i < 0 {
+			return nil, ErrAuditEventNotFound
+		}
+		chain.result.LastHash = events[i].Hash
+		events = events[i+1:]
+	}
+
+	for i, e := range events {
+		if i == limit {
+			chain.result.More = true
+			break
+		}
+		ok, err := chain.check(e)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+	}
+	return &chain.result, nil
+}
+
+type memoryIdempotency struct{ s *memoryStore }
+
+func (m memoryIdempotency) Reserve(ctx context.Context, k *IdempotencyKey, staleAfter time.Duration) (*IdempotencyKey, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	now := time.Now()
+	id := [2]string{k.Client, k.Key}
+	if existing, ok := m.s.idempotency[id]; ok {
+		stale := !existing.Completed() && existing.CreatedAt.Before(now.Add(-staleAfter))
+		if !existing.ExpiresAt.Before(now) && !stale {
+			return &existing, nil
+		}
+	}
+
+	m.s.idempotency[id] = IdempotencyKey{
+		Client:      k.Client,
+		Key:         k.Key,
+		RequestHash: k.RequestHash,
+		CreatedAt:   now,
+		ExpiresAt:   k.ExpiresAt,
+	}
+	return nil, nil
+}
+
+func (m memoryIdempotency) Complete(ctx context.Context, client, key string, status int, header map[string]string, body []byte) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	id := [2]string{client, key}
+	k, ok := m.s.idempotency[id]
+	if !ok || k.Completed() {
+		return nil
+	}
+	k.Status = status
+	k.Header = header
+	k.Body = body
+	m.s.idempotency[id] = k
+	return nil
+}
+
+func (m memoryIdempotency) Release(ctx context.Context, client, key string) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	id := [2]string{client, key}
+	if k, ok := m.s.idempotency[id]; ok && !k.Completed() {
+		delete(m.s.idempotency, id)
+	}
+	return nil
+}
+
+func (m memoryIdempotency) DeleteExpired(ctx context.Context) (int64, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	var deleted int64
+	now := time.Now()
+	for id, k := range m.s.idempotency {
+		if k.ExpiresAt.Before(now) {
+			delete(m.s.idempotency, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,238 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Message states, a message that keeps failing ends up failed
+const (
+	MessageQueued = "queued"
+	MessageSent   = "sent"
+	MessageFailed = "failed"
+)
+
+// NotificationMessage is an email or SMS sent to a user, along with its send status
+type NotificationMessage struct {
+	ID        int64  `json:"id"`
+	UserID    int64  `json:"user_id"`
+	Channel   string `json:"channel"`
+	EventType string `json:"event_type"`
+	Recipient string `json:"recipient"`
+	Subject   string `json:"subject,omitempty"`
+	Body      string `json:"body"`
+	Status    string `json:"status"`
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"last_error,omitempty"`
+	SendAfter Time   `json:"send_after"`
+	SentAt    *Time  `json:"sent_at,omitempty"`
+	CreatedAt Time   `json:"created_at"`
+
+	// EventKey is the idempotency key of the event which caused the message,
+	// queueing the same key twice on a channel returns ErrDuplicateEvent
+	EventKey string `json:"-"`
+}
+
+// NotificationMessageModel wraps the database connection
+type NotificationMessageModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// Enqueue queues the message to be sent once its SendAfter time has come
+func (m NotificationMessageModel) Enqueue(ctx context.Context, msg *NotificationMessage) error {
+	query := `
+		INSERT INTO notification_messages (user_id, channel, event_type, recipient,
+		                                   subject, body, send_after, event_key)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''))
+		ON CONFLICT (event_key, channel) DO NOTHING
+		RETURNING id, status, created_at
+	`
+	args := []any{
+		msg.UserID,
+		msg.Channel,
+		msg.EventType,
+		msg.Recipient,
+		msg.Subject,
+		msg.Body,
+		time.Time(msg.SendAfter),
+		msg.EventKey,
+	}
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&msg.ID,
+		&msg.Status,
+		&msg.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrDuplicateEvent
+		}
+		return err
+	}
+
+	return nil
+}
+
+// ClaimDue leases up to limit queued messages whose send time has come.
+// Claimed messages are pushed back by lease so that other workers skip
+// them, if the worker dies they become due again once the lease runs out
+func (m NotificationMessageModel) ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]*NotificationMessage, error) {
+	query := `
+		UPDATE notification_messages
+		SET send_after = NOW() + $2 * INTERVAL '1 second'
+		WHERE id IN (
+			SELECT id FROM notification_messages
+			WHERE status = 'queued' AND send_after <= NOW()
+			ORDER BY send_after
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, user_id, channel, event_type, recipient, subject, body,
+		          status, attempts, last_error, send_after, created_at
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []*NotificationMessage{}
+
+	for rows.Next() {
+		var msg NotificationMessage
+		err := rows.Scan(
+			&msg.ID,
+			&msg.UserID,
+			&msg.Channel,
+			&msg.EventType,
+			&msg.Recipient,
+			&msg.Subject,
+			&msg.Body,
+			&msg.Status,
+			&msg.Attempts,
+			&msg.LastError,
+			&msg.SendAfter,
+			&msg.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		messages = append(messages, &msg)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// MarkSent records that the message was handed to its channel
+func (m NotificationMessageModel) MarkSent(ctx context.Context, id int64) error {
+	query := `
+		UPDATE notification_messages
+		SET status = 'sent',
+		    attempts = attempts + 1,
+		    last_error = '',
+		    sent_at = NOW(),
+		    updated_at = NOW()
+		WHERE id = $1
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+// MarkFailed records a failed attempt. The message is retried at
+// nextAttempt, or moved to the failed state when failed is true
+func (m NotificationMessageModel) MarkFailed(ctx context.Context, id int64, lastError string, failed bool, nextAttempt time.Time) error {
+	query := `
+		UPDATE notification_messages
+		SET status = CASE WHEN $3 THEN 'failed' ELSE 'queued' END,
+		    attempts = attempts + 1,
+		    last_error = $2,
+		    send_after = $4,
+		    updated_at = NOW()
+		WHERE id = $1
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id, lastError, failed, nextAttempt)
+	return err
+}
+
+// GetForUser retrieves the messages sent to the user, newest first
+func (m NotificationMessageModel) GetForUser(ctx context.Context, userID int64, limit, offset int) ([]*NotificationMessage, error) {
+	query := `
+		SELECT id, user_id, channel, event_type, recipient, subject, body,
+		       status, attempts, last_error, send_after, sent_at, created_at
+		FROM notification_messages
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2 OFFSET $3
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []*NotificationMessage{}
+
+	for rows.Next() {
+		var msg NotificationMessage
+		var sentAt sql.NullTime
+
+		err := rows.Scan(
+			&msg.ID,
+			&msg.UserID,
+			&msg.Channel,
+			&msg.EventType,
+			&msg.Recipient,
+			&msg.Subject,
+			&msg.Body,
+			&msg.Status,
+			&msg.Attempts,
+			&msg.LastError,
+			&msg.SendAfter,
+			&sentAt,
+			&msg.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if sentAt.Valid {
+			t := Time(sentAt.Time)
+			msg.SentAt = &t
+		}
+
+		messages = append(messages, &msg)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
@@ -0,0 +1,261 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMFANotEnrolled    = errors.New("mfa not enrolled")
+	ErrMFAAlreadyEnabled = errors.New("mfa already enabled")
+)
+
+// RecoveryCodeCount is the number of recovery codes handed out when
+// two-factor authentication is confirmed
+const RecoveryCodeCount = 10
+
+// MFA is the TOTP enrolment of a user. The enrolment only guards logins
+// once it is confirmed with a first code
+type MFA struct {
+	UserID      int64
+	Secret      string
+	LastStep    int64 // Time step of the last accepted code, which can't be used again
+	CreatedAt   Time
+	ConfirmedAt *Time
+}
+
+// Enabled reports whether the enrolment was confirmed
+func (m *MFA) Enabled() bool {
+	return m.ConfirmedAt != nil
+}
+
+// NewRecoveryCodes returns RecoveryCodeCount random codes shaped like
+// xxxxx-xxxxx along with their hashes, only the hashes are stored
+func NewRecoveryCodes() (codes []string, hashes [][]byte, err error) {
+	for range RecoveryCodeCount {
+		b := make([]byte, 7)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		code := apiKeyEncoding.EncodeToString(b)[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode hashes a recovery code as typed by the user, ignoring
+// case, spaces and dashes
+func HashRecoveryCode(code string) []byte {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	hash := sha256.Sum256([]byte(code))
+	return hash[:]
+}
+
+// MFAModel wraps the database connection
+type MFAModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// Get retrieves the enrolment of the user
+func (m MFAModel) Get(ctx context.Context, userID int64) (*MFA, error) {
+	query := `
+		SELECT user_id, secret, last_step, created_at, confirmed_at
+		FROM user_mfa
+		WHERE user_id = $1
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	var mfa MFA
+	var confirmedAt sql.NullTime
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&mfa.UserID,
+		&mfa.Secret,
+		&mfa.LastStep,
+		&mfa.CreatedAt,
+		&confirmedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrMFANotEnrolled
+		}
+		return nil, err
+	}
+
+	mfa.ConfirmedAt = nullTime(confirmedAt)
+	return &mfa, nil
+}
+
+// Enroll stores a new unconfirmed secret for the user, replacing an earlier
+// unconfirmed one. A confirmed enrolment is kept and ErrMFAAlreadyEnabled returned
+func (m MFAModel) Enroll(ctx context.Context, userID int64, secret string) error {
+	query := `
+		INSERT INTO user_mfa (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, last_step = 0, created_at = NOW()
+		WHERE user_mfa.confirmed_at IS NULL
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrMFAAlreadyEnabled
+	}
+
+	return nil
+}
+
+// Confirm enables the enrolment, recording the step of the confirming code
+// and replacing the recovery codes with the hashes
+func (m MFAModel) Confirm(ctx context.Context, userID, step int64, recoveryHashes [][]byte) error {
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE user_mfa
+		SET confirmed_at = NOW(), last_step = $2
+		WHERE user_id = $1 AND confirmed_at IS NULL`,
+		userID, step)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrMFANotEnrolled
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	for _, hash := range recoveryHashes {
+		_, err = tx.ExecContext(ctx, `INSERT INTO mfa_recovery_codes (user_id, hash) VALUES ($1, $2)`, userID, hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UseStep records that a code of the time step was accepted. It reports
+// false when a code of the step or a later one was already accepted, so
+// that an intercepted code can't be replayed
+func (m MFAModel) UseStep(ctx context.Context, userID, step int64) (bool, error) {
+	query := `
+		UPDATE user_mfa
+		SET last_step = $2
+		WHERE user_id = $1 AND last_step < $2
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
+
+// UseRecoveryCode marks the unused recovery code with the hash as used,
+// reporting false when the user has no such code
+func (m MFAModel) UseRecoveryCode(ctx context.Context, userID int64, hash []byte) (bool, error) {
+	query := `
+		UPDATE mfa_recovery_codes
+		SET used_at = NOW()
+		WHERE user_id = $1 AND hash = $2 AND used_at IS NULL
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, hash)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
+
+// CountRecoveryCodes returns the number of unused recovery codes of the user
+func (m MFAModel) CountRecoveryCodes(ctx context.Context, userID int64) (int, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM mfa_recovery_codes
+		WHERE user_id = $1 AND used_at IS NULL
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	var count int
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&count)
+	return count, err
+}
+
+// Delete removes the enrolment of the user along with the recovery codes
+func (m MFAModel) Delete(ctx context.Context, userID int64) error {
+	query := `
+		DELETE FROM user_mfa
+		WHERE user_id = $1
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrMFANotEnrolled
+	}
+
+	return nil
+}
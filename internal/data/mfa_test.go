@@ -0,0 +1,116 @@
+package data
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNewRecoveryCodes(t *testing.T) {
+	codes, hashes, err := NewRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != RecoveryCodeCount || len(hashes) != RecoveryCodeCount {
+		t.Fatalf("got %d codes and %d hashes, want %d", len(codes), len(hashes), RecoveryCodeCount)
+	}
+	if len(codes[0]) != 11 || codes[0][5] != '-' {
+		t.Errorf("got code %q, want xxxxx-xxxxx", codes[0])
+	}
+
+	// Codes are accepted however they are typed
+	for _, typed := range []string{codes[0], codes[0][:5] + codes[0][6:], " " + strings.ToUpper(codes[0])} {
+		if !bytes.Equal(HashRecoveryCode(typed), hashes[0]) {
+			t.Errorf("hash of %q doesn't match", typed)
+		}
+	}
+}
+
+func TestMFAModel(t *testing.T) {
+	db := newTestDB(t)
+	m := MFAModel{DB: db, Timeout: DefaultQueryTimeout}
+	user := insertTestUser(t, UserModel{DB: db, Timeout: DefaultQueryTimeout}, "Admin Person", "9000000001")
+
+	_, err := m.Get(t.Context(), user.ID)
+	if !errors.Is(err, ErrMFANotEnrolled) {
+		t.Fatalf("got error %v, want %v", err, ErrMFANotEnrolled)
+	}
+
+	// Enrolling again before confirming replaces the secret
+	for _, secret := range []string{"FIRSTSECRET", "SECONDSECRET"} {
+		err = m.Enroll(t.Context(), user.ID, secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	mfa, err := m.Get(t.Context(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mfa.Secret != "SECONDSECRET" || mfa.Enabled() {
+		t.Errorf("unexpected enrolment %+v", mfa)
+	}
+
+	codes, hashes, err := NewRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Confirm(t.Context(), user.ID, 100, hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Enroll(t.Context(), user.ID, "THIRDSECRET")
+	if !errors.Is(err, ErrMFAAlreadyEnabled) {
+		t.Errorf("got error %v, want %v", err, ErrMFAAlreadyEnabled)
+	}
+
+	steps := []struct {
+		step int64
+		want bool
+	}{
+		{100, false},
+		{99, false},
+		{101, true},
+		{101, false},
+	}
+	for _, tt := range steps {
+		ok, err := m.UseStep(t.Context(), user.ID, tt.step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != tt.want {
+			t.Errorf("got %t using step %d, want %t", ok, tt.step, tt.want)
+		}
+	}
+
+	for _, want := range []bool{true, false} {
+		ok, err := m.UseRecoveryCode(t.Context(), user.ID, HashRecoveryCode(codes[0]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != want {
+			t.Errorf("got %t using the recovery code, want %t", ok, want)
+		}
+	}
+	count, err := m.CountRecoveryCodes(t.Context(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != RecoveryCodeCount-1 {
+		t.Errorf("got %d recovery codes left, want %d", count, RecoveryCodeCount-1)
+	}
+
+	err = m.Delete(t.Context(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err = m.CountRecoveryCodes(t.Context(), user.ID)
+	if err != nil || count != 0 {
+		t.Errorf("got %d recovery codes and error %v after deleting", count, err)
+	}
+	err = m.Delete(t.Context(), user.ID)
+	if !errors.Is(err, ErrMFANotEnrolled) {
+		t.Errorf("got error %v, want %v", err, ErrMFANotEnrolled)
+	}
+}
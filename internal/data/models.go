@@ -1,20 +1,192 @@
 package data
 
-import "database/sql"
+import (
+	"context"
+	"database/sql"
+	"time"
+)
 
-// Models encloses all the DB Models for easy access using application struct
+// DefaultQueryTimeout bounds the queries of models built without a timeout
+const DefaultQueryTimeout = 12 * time.Second
+
+// Models encloses all the DB Models for easy access using application struct.
+// The fields are interfaces so that the handlers can run against the
+// Postgres models as well as the in-memory ones built by NewMemoryModels
 type Models struct {
-	Users   UserModel
-	Tokens  TokenModel
-	Reports ReportModel
+	Users         UserRepository
+	Reports       ReportRepository
+	Notifications NotificationRepository
+	Webhooks      WebhookRepository
+	Deliveries    WebhookDeliveryRepository
+	Outbox        OutboxRepository
+	Preferences   NotificationPreferenceRepository
+	Messages      NotificationMessageRepository
+	LoginAttempts LoginAttemptRepository
+	APIKeys       APIKeyRepository
+	MFA           MFARepository
+	Identities    IdentityRepository
+	OIDCStates    OIDCStateRepository
+	Audit         AuditRepository
+	Idempotency   IdempotencyRepository
+}
+
+// UserRepository stores the user accounts
+type UserRepository interface {
+	Insert(ctx context.Context, user *User) error
+	GetByPhoneNumber(ctx context.Context, phoneNumber string) (*User, error)
+	GetByID(ctx context.Context, id int64) (*User, error)
+	UpdateRole(ctx context.Context, id int64, role string) error
+	UpdatePassword(ctx context.Context, user *User) error
+	SetSuspended(ctx context.Context, id int64, suspended bool) error
+}
+
+// ReportRepository stores the reports, writes also record a report
+// event in the outbox
+type ReportRepository interface {
+	Insert(ctx context.Context, report *Report) error
+	Get(ctx context.Context, id int64) (*Report, error)
+	GetAll(ctx context.Context, limit, offset int, status, category string) ([]*Report, error)
+	GetByUserID(ctx context.Context, userID int64, limit, offset int) ([]*Report, error)
+	Update(ctx context.Context, id int64, version int32, status, afterImage string) error
+	Edit(ctx context.Context, report *Report, version int32) error
+	Withdraw(ctx context.Context, id int64, version int32) error
+	GetStats(ctx context.Context) (*ReportStats, error)
+	GetLeaderboard(ctx context.Context) ([]*LeaderboardEntry, error)
+}
+
+// NotificationRepository stores the in-app notifications
+type NotificationRepository interface {
+	Insert(ctx context.Context, n *Notification) error
+	GetByEventKey(ctx context.Context, eventKey string) (*Notification, error)
+	GetForUser(ctx context.Context, userID int64, unreadOnly bool, limit, offset int) ([]*Notification, error)
+	CountUnread(ctx context.Context, userID int64) (int, error)
+	MarkRead(ctx context.Context, id, userID int64) error
+	MarkAllRead(ctx context.Context, userID int64) (int64, error)
+	DeleteOlderThan(ctx context.Context, age time.Duration) (int64, error)
+}
+
+// WebhookRepository stores the webhook subscriptions
+type WebhookRepository interface {
+	Insert(ctx context.Context, wh *Webhook) error
+	GetAll(ctx context.Context) ([]*Webhook, error)
+	Get(ctx context.Context, id int64) (*Webhook, error)
+	Delete(ctx context.Context, id int64) error
+}
+
+// WebhookDeliveryRepository stores the webhook delivery queue and log
+type WebhookDeliveryRepository interface {
+	Enqueue(ctx context.Context, eventType string, payload []byte, eventKey string) (int64, error)
+	ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]*WebhookDelivery, error)
+	MarkSucceeded(ctx context.Context, id int64, statusCode int) error
+	MarkFailed(ctx context.Context, id int64, statusCode *int, lastError string, dead bool, nextAttempt time.Time) error
+	GetForWebhook(ctx context.Context, webhookID int64, status string, limit, offset int) ([]*WebhookDelivery, error)
+}
+
+// OutboxRepository dispatches the events recorded in the outbox
+type OutboxRepository interface {
+	ProcessBatch(ctx context.Context, limit, maxAttempts int, handle func(context.Context, *OutboxEvent) error, retryAfter func(attempts int) time.Duration) (int, error)
+	DeleteProcessedBefore(ctx context.Context, age time.Duration) (int64, error)
+}
+
+// NotificationPreferenceRepository stores the users' channel preferences
+type NotificationPreferenceRepository interface {
+	Get(ctx context.Context, userID int64) (*NotificationPreferences, error)
+	Upsert(ctx context.Context, p *NotificationPreferences) error
+}
+
+// NotificationMessageRepository stores the email and SMS queue and log
+type NotificationMessageRepository interface {
+	Enqueue(ctx context.Context, msg *NotificationMessage) error
+	ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]*NotificationMessage, error)
+	MarkSent(ctx context.Context, id int64) error
+	MarkFailed(ctx context.Context, id int64, lastError string, failed bool, nextAttempt time.Time) error
+	GetForUser(ctx context.Context, userID int64, limit, offset int) ([]*NotificationMessage, error)
+}
+
+// LoginAttemptRepository tracks the failed logins per phone number
+type LoginAttemptRepository interface {
+	Get(ctx context.Context, phoneNumber string) (*LoginAttempt, error)
+	RecordFailure(ctx context.Context, phoneNumber string, window time.Duration) (int, error)
+	Lock(ctx context.Context, phoneNumber string, until time.Time) error
+	Reset(ctx context.Context, phoneNumber string) error
+}
+
+// APIKeyRepository stores the API keys of machine integrations
+type APIKeyRepository interface {
+	Insert(ctx context.Context, k *APIKey) error
+	GetByPrefix(ctx context.Context, prefix string) (*APIKey, error)
+	GetAll(ctx context.Context) ([]*APIKey, error)
+	Revoke(ctx context.Context, id int64) error
+	TouchLastUsed(ctx context.Context, id int64) error
+}
+
+// MFARepository stores the TOTP enrolments and recovery codes
+type MFARepository interface {
+	Get(ctx context.Context, userID int64) (*MFA, error)
+	Enroll(ctx context.Context, userID int64, secret string) error
+	Confirm(ctx context.Context, userID, step int64, recoveryHashes [][]byte) error
+	UseStep(ctx context.Context, userID, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, userID int64, hash []byte) (bool, error)
+	CountRecoveryCodes(ctx context.Context, userID int64) (int, error)
+	Delete(ctx context.Context, userID int64) error
+}
+
+// IdentityRepository stores the OpenID Connect identities linked to users
+type IdentityRepository interface {
+	GetUser(ctx context.Context, issuer, subject string) (*User, error)
+	Link(ctx context.Context, userID int64, issuer, subject string) error
+}
+
+// OIDCStateRepository stores the pending OpenID Connect logins
+type OIDCStateRepository interface {
+	Insert(ctx context.Context, s *OIDCState) error
+	Consume(ctx context.Context, state string) (*OIDCState, error)
+}
+
+// AuditRepository stores the hash chained audit log, which is append only
+type AuditRepository interface {
+	Record(ctx context.Context, e *AuditEvent) error
+	GetAll(ctx context.Context, filter AuditFilter, limit, offset int) ([]*AuditEvent, error)
+	Verify(ctx context.Context, afterID int64, limit int) (*AuditVerification, error)
+}
+
+// IdempotencyRepository stores the idempotency keys and the responses
+// replayed for them
+type IdempotencyRepository interface {
+	Reserve(ctx context.Context, k *IdempotencyKey, staleAfter time.Duration) (*IdempotencyKey, error)
+	Complete(ctx context.Context, client, key string, status int, header map[string]string, body []byte) error
+	Release(ctx context.Context, client, key string) error
+	DeleteExpired(ctx context.Context) (int64, error)
 }
 
 // NewModels returns an Modles struct by
-// initilizing it using the provided db connection
-func NewModels(db *sql.DB) Models {
+// initilizing it using the provided db connection,
+// every query is bounded by queryTimeout
+func NewModels(db *sql.DB, queryTimeout time.Duration) Models {
 	return Models{
-		Users:   UserModel{db},
-		Tokens:  TokenModel{db},
-		Reports: ReportModel{db},
+		Users:         UserModel{DB: db, Timeout: queryTimeout},
+		Reports:       ReportModel{DB: db, Timeout: queryTimeout},
+		Notifications: NotificationModel{DB: db, Timeout: queryTimeout},
+		Webhooks:      WebhookModel{DB: db, Timeout: queryTimeout},
+		Deliveries:    WebhookDeliveryModel{DB: db, Timeout: queryTimeout},
+		Outbox:        OutboxModel{DB: db, Timeout: queryTimeout},
+		Preferences:   NotificationPreferenceModel{DB: db, Timeout: queryTimeout},
+		Messages:      NotificationMessageModel{DB: db, Timeout: queryTimeout},
+		LoginAttempts: LoginAttemptModel{DB: db, Timeout: queryTimeout},
+		APIKeys:       APIKeyModel{DB: db, Timeout: queryTimeout},
+		MFA:           MFAModel{DB: db, Timeout: queryTimeout},
+		Identities:    IdentityModel{DB: db, Timeout: queryTimeout},
+		OIDCStates:    OIDCStateModel{DB: db, Timeout: queryTimeout},
+		Audit:         AuditModel{DB: db, Timeout: queryTimeout},
+		Idempotency:   IdempotencyKeyModel{DB: db, Timeout: queryTimeout},
+	}
+}
+
+// queryContext derives the context of a single query from the caller's
+// context, so that the query is cancelled with the request it serves
+func queryContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		timeout = DefaultQueryTimeout
 	}
+	return context.WithTimeout(ctx, timeout)
 }
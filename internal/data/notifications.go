@@ -0,0 +1,243 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrNotificationNotFound = errors.New("notification not found")
+
+// Notification types, one per event a citizen can be told about
+const (
+	NotificationStatusChanged = "status_changed"
+)
+
+// Notification is a single entry in a user's in-app inbox
+type Notification struct {
+	ID        int64  `json:"id"`
+	UserID    int64  `json:"user_id"`
+	ReportID  *int64 `json:"report_id,omitempty"`
+	Type      string `json:"type"`
+	Message   string `json:"message"`
+	ReadAt    *Time  `json:"read_at,omitempty"`
+	CreatedAt Time   `json:"created_at"`
+
+	// EventKey is the idempotency key of the event which caused the
+	// notification, inserting the same key twice returns ErrDuplicateEvent
+	EventKey string `json:"-"`
+}
+
+// NotificationModel wraps the database connection
+type NotificationModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// Insert adds a new notification to the user's inbox
+func (m NotificationModel) Insert(ctx context.Context, n *Notification) error {
+	query := `
+		INSERT INTO notifications (user_id, report_id, type, message, event_key)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''))
+		ON CONFLICT (event_key) DO NOTHING
+		RETURNING id, created_at
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, n.UserID, n.ReportID, n.Type, n.Message, n.EventKey).Scan(
+		&n.ID,
+		&n.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrDuplicateEvent
+		}
+		return err
+	}
+
+	return nil
+}
+
+// GetByEventKey retrieves the notification caused by the event with the
+// given idempotency key
+func (m NotificationModel) GetByEventKey(ctx context.Context, eventKey string) (*Notification, error) {
+	query := `
+		SELECT id, user_id, report_id, type, message, read_at, created_at
+		FROM notifications
+		WHERE event_key = $1
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	n := Notification{EventKey: eventKey}
+	var reportID sql.NullInt64
+	var readAt sql.NullTime
+
+	err := m.DB.QueryRowContext(ctx, query, eventKey).Scan(
+		&n.ID,
+		&n.UserID,
+		&reportID,
+		&n.Type,
+		&n.Message,
+		&readAt,
+		&n.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotificationNotFound
+		}
+		return nil, err
+	}
+
+	if reportID.Valid {
+		n.ReportID = &reportID.Int64
+	}
+	if readAt.Valid {
+		t := Time(readAt.Time)
+		n.ReadAt = &t
+	}
+
+	return &n, nil
+}
+
+// GetForUser retrieves the user's notifications, newest first,
+// optionally restricted to the unread ones
+func (m NotificationModel) GetForUser(ctx context.Context, userID int64, unreadOnly bool, limit, offset int) ([]*Notification, error) {
+	query := `
+		SELECT id, user_id, report_id, type, message, read_at, created_at
+		FROM notifications
+		WHERE user_id = $1
+		  AND (NOT $2 OR read_at IS NULL)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3 OFFSET $4
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, unreadOnly, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []*Notification{}
+
+	for rows.Next() {
+		var n Notification
+		var reportID sql.NullInt64
+		var readAt sql.NullTime
+
+		err := rows.Scan(
+			&n.ID,
+			&n.UserID,
+			&reportID,
+			&n.Type,
+			&n.Message,
+			&readAt,
+			&n.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if reportID.Valid {
+			n.ReportID = &reportID.Int64
+		}
+		if readAt.Valid {
+			t := Time(readAt.Time)
+			n.ReadAt = &t
+		}
+
+		notifications = append(notifications, &n)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return notifications, nil
+}
+
+// CountUnread returns how many notifications the user hasn't read yet
+func (m NotificationModel) CountUnread(ctx context.Context, userID int64) (int, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM notifications
+		WHERE user_id = $1 AND read_at IS NULL
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	var count int
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&count)
+	return count, err
+}
+
+// MarkRead marks a single notification as read, the notification
+// must belong to the given user otherwise ErrNotificationNotFound is returned
+func (m NotificationModel) MarkRead(ctx context.Context, id, userID int64) error {
+	query := `
+		UPDATE notifications
+		SET read_at = COALESCE(read_at, NOW())
+		WHERE id = $1 AND user_id = $2
+		RETURNING id
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	var returnedID int64
+	err := m.DB.QueryRowContext(ctx, query, id, userID).Scan(&returnedID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotificationNotFound
+		}
+		return err
+	}
+
+	return nil
+}
+
+// MarkAllRead marks every unread notification of the user as read
+// and returns how many were updated
+func (m NotificationModel) MarkAllRead(ctx context.Context, userID int64) (int64, error) {
+	query := `
+		UPDATE notifications
+		SET read_at = NOW()
+		WHERE user_id = $1 AND read_at IS NULL
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// DeleteOlderThan prunes notifications created before now minus the given age
+func (m NotificationModel) DeleteOlderThan(ctx context.Context, age time.Duration) (int64, error) {
+	query := `
+		DELETE FROM notifications
+		WHERE created_at < $1
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, time.Now().Add(-age))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,273 @@
+package data
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrDuplicateEvent is returned by consumers when an outbox event
+// with the same idempotency key has already been handled
+var ErrDuplicateEvent = errors.New("event already handled")
+
+// Outbox event types written alongside report writes
+const (
+	EventReportCreated = "report.created"
+	EventReportUpdated = "report.updated"
+)
+
+// outboxLease is how long claimed events are hidden from other
+// dispatchers, it has to outlast the handling of a whole batch
+const outboxLease = 5 * time.Minute
+
+// OutboxEvent is an event recorded in the same transaction as the write
+// that caused it, it is handed to the registered handlers at least once
+type OutboxEvent struct {
+	ID             int64
+	IdempotencyKey string
+	EventType      string
+	AggregateID    int64
+	Payload        json.RawMessage
+	Attempts       int
+	CreatedAt      time.Time
+
+	// Handled names the handlers which handled the event in an earlier
+	// attempt, handlers add themselves once they succeed so that a retry
+	// only runs the ones that failed
+	Handled []string
+}
+
+// MarkHandled records that the named handler handled the event
+func (e *OutboxEvent) MarkHandled(handler string) {
+	if !e.WasHandled(handler) {
+		e.Handled = append(e.Handled, handler)
+	}
+}
+
+// WasHandled reports whether the named handler already handled the event
+func (e *OutboxEvent) WasHandled(handler string) bool {
+	return slices.Contains(e.Handled, handler)
+}
+
+// ReportSummary is the report representation carried by report events,
+// images are left out to keep event payloads small
+type ReportSummary struct {
+	ID          int64      `json:"id"`
+	UserID      int64      `json:"user_id"`
+	Title       string     `json:"title"`
+	Category    string     `json:"category"`
+	Location    string     `json:"location"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// ReportEvent is the payload of the report outbox events
+type ReportEvent struct {
+	Report         ReportSummary `json:"report"`
+	PreviousStatus string        `json:"previous_status,omitempty"`
+}
+
+// StatusChanged reports whether the event moved the report to a new status
+func (e ReportEvent) StatusChanged() bool {
+	return e.PreviousStatus != "" && e.PreviousStatus != e.Report.Status
+}
+
+// Withdrawn reports whether the event is the reporter withdrawing the report,
+// which the reporter needn't be told about
+func (e ReportEvent) Withdrawn() bool {
+	return e.StatusChanged() && e.Report.Status == "withdrawn"
+}
+
+// newIdempotencyKey returns a random key identifying a single event
+func newIdempotencyKey() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// insertOutboxEvent records an event inside the given transaction
+func insertOutboxEvent(ctx context.Context, tx *sql.Tx, eventType string, aggregateID int64, payload any) error {
+	js, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	key, err := newIdempotencyKey()
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO outbox (idempotency_key, event_type, aggregate_id, payload)
+		VALUES ($1, $2, $3, $4)
+	`
+
+	_, err = tx.ExecContext(ctx, query, key, eventType, aggregateID, string(js))
+	return err
+}
+
+// OutboxModel wraps the database connection
+type OutboxModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// ProcessBatch claims up to limit unprocessed events that are due and calls
+// handle for each of them in id order. Events handled without error are
+// marked processed, failed ones are retried after the backoff returned by
+// retryAfter, until they have failed maxAttempts times and are left dead.
+// Claimed events are leased rather than kept locked while the handlers run,
+// so other dispatchers skip them and a crash mid-batch releases them for
+// another try once the lease runs out
+func (m OutboxModel) ProcessBatch(ctx context.Context, limit, maxAttempts int, handle func(context.Context, *OutboxEvent) error, retryAfter func(attempts int) time.Duration) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	events, err := m.claim(ctx, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, e := range events {
+		handleErr := handle(ctx, e)
+		if handleErr == nil {
+			err = m.markProcessed(ctx, e)
+		} else {
+			err = m.markFailed(ctx, e, handleErr, maxAttempts, retryAfter)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(events), nil
+}
+
+// claim leases up to limit due events to the caller
+func (m OutboxModel) claim(ctx context.Context, limit int) ([]*OutboxEvent, error) {
+	query := `
+		UPDATE outbox
+		SET available_at = $2
+		WHERE id IN (
+			SELECT id
+			FROM outbox
+			WHERE processed_at IS NULL AND dead_at IS NULL AND available_at <= NOW()
+			ORDER BY id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, idempotency_key, event_type, aggregate_id, payload, attempts, handled, created_at
+	`
+
+	qctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(qctx, query, limit, time.Now().Add(outboxLease))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*OutboxEvent{}
+
+	for rows.Next() {
+		var e OutboxEvent
+		var payload []byte
+		err := rows.Scan(
+			&e.ID,
+			&e.IdempotencyKey,
+			&e.EventType,
+			&e.AggregateID,
+			&payload,
+			&e.Attempts,
+			pq.Array(&e.Handled),
+			&e.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		e.Payload = payload
+		events = append(events, &e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// RETURNING doesn't keep the order of the subquery
+	slices.SortFunc(events, func(a, b *OutboxEvent) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	return events, nil
+}
+
+// markProcessed records that every handler handled the event
+func (m OutboxModel) markProcessed(ctx context.Context, e *OutboxEvent) error {
+	query := `
+		UPDATE outbox
+		SET processed_at = NOW(), last_error = '', handled = $2
+		WHERE id = $1
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, e.ID, pq.Array(e.Handled))
+	return err
+}
+
+// markFailed records a failed attempt along with the handlers which did
+// succeed, and schedules the retry or leaves the event dead
+func (m OutboxModel) markFailed(ctx context.Context, e *OutboxEvent, handleErr error, maxAttempts int, retryAfter func(attempts int) time.Duration) error {
+	query := `
+		UPDATE outbox
+		SET attempts = attempts + 1,
+		    last_error = $2,
+		    handled = $3,
+		    available_at = $4,
+		    dead_at = CASE WHEN $5 THEN NOW() END
+		WHERE id = $1
+	`
+
+	attempts := e.Attempts + 1
+	dead := attempts >= maxAttempts
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, e.ID, handleErr.Error(), pq.Array(e.Handled), time.Now().Add(retryAfter(attempts)), dead)
+	return err
+}
+
+// DeleteProcessedBefore prunes events processed before now minus the given age
+func (m OutboxModel) DeleteProcessedBefore(ctx context.Context, age time.Duration) (int64, error) {
+	query := `
+		DELETE FROM outbox
+		WHERE processed_at < $1
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, time.Now().Add(-age))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
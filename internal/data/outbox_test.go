@@ -0,0 +1,112 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testOutboxRepository runs the dispatch of the event recorded by insert
+// against m, retries are due right away
+func testOutboxRepository(t *testing.T, m OutboxRepository, insert func(t *testing.T)) {
+	errHandler := errors.New("handler failed")
+	// Postgres rounds the retry time to the second, which may be ahead of now
+	retryNow := func(attempts int) time.Duration { return -time.Second }
+
+	process := func(t *testing.T, handle func(context.Context, *OutboxEvent) error) int {
+		t.Helper()
+		n, err := m.ProcessBatch(t.Context(), 10, 3, handle, retryNow)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	insert(t)
+
+	var seen []OutboxEvent
+	n := process(t, func(ctx context.Context, e *OutboxEvent) error {
+		// A second dispatcher skips the claimed event
+		if n := process(t, func(context.Context, *OutboxEvent) error { return nil }); n != 0 {
+			t.Errorf("another dispatcher got %d claimed events", n)
+		}
+		seen = append(seen, *e)
+		e.MarkHandled("first")
+		return errHandler
+	})
+	if n != 1 || len(seen) != 1 || seen[0].EventType != EventReportCreated || seen[0].Attempts != 0 {
+		t.Fatalf("got %d events %+v, want the report event", n, seen)
+	}
+
+	// The retry knows which handler is done with the event
+	process(t, func(ctx context.Context, e *OutboxEvent) error {
+		seen = append(seen, *e)
+		return errHandler
+	})
+	if len(seen) != 2 || seen[1].Attempts != 1 || !slices.Equal(seen[1].Handled, []string{"first"}) {
+		t.Fatalf("got retried event %+v, want one attempt handled by first", seen[len(seen)-1])
+	}
+
+	// The third failure leaves the event dead
+	process(t, func(ctx context.Context, e *OutboxEvent) error {
+		seen = append(seen, *e)
+		return errHandler
+	})
+	if n := process(t, func(ctx context.Context, e *OutboxEvent) error {
+		seen = append(seen, *e)
+		return nil
+	}); n != 0 || len(seen) != 3 {
+		t.Errorf("got %d events after the last attempt, want the event dead", len(seen))
+	}
+
+	insert(t)
+
+	n = process(t, func(ctx context.Context, e *OutboxEvent) error {
+		e.MarkHandled("first")
+		return nil
+	})
+	if n != 1 {
+		t.Fatalf("processed %d events, want 1", n)
+	}
+	if n := process(t, func(context.Context, *OutboxEvent) error { return nil }); n != 0 {
+		t.Errorf("processed %d events again", n)
+	}
+}
+
+func TestOutboxRepository(t *testing.T) {
+	report := func(userID int64) *Report {
+		return &Report{
+			UserID:      userID,
+			Title:       "Broken streetlight",
+			Description: "Dark for a week now",
+			Category:    "streetlight",
+			Location:    "Anna Salai, Chennai",
+			BeforeImage: "https://img.example.com/before.jpg",
+		}
+	}
+
+	t.Run("memory", func(t *testing.T) {
+		models := NewMemoryModels()
+		testOutboxRepository(t, models.Outbox, func(t *testing.T) {
+			err := models.Reports.Insert(t.Context(), report(1))
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		db := newTestDB(t)
+		reports := ReportModel{DB: db, Timeout: DefaultQueryTimeout}
+		user := insertTestUser(t, UserModel{DB: db, Timeout: DefaultQueryTimeout}, "Priya Raman", "9000000001")
+
+		testOutboxRepository(t, OutboxModel{DB: db, Timeout: DefaultQueryTimeout}, func(t *testing.T) {
+			err := reports.Insert(t.Context(), report(user.ID))
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	})
+}
@@ -0,0 +1,130 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/VJ-2303/CityStars/internal/validator"
+)
+
+var (
+	emailRegex = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+	clockRegex = regexp.MustCompile(`^([01][0-9]|2[0-3]):[0-5][0-9]$`)
+)
+
+// NotificationPreferences holds how a user wants to be told about their
+// reports outside of the in-app inbox
+type NotificationPreferences struct {
+	UserID          int64  `json:"-"`
+	Email           string `json:"email"`
+	EmailEnabled    bool   `json:"email_enabled"`
+	SMSEnabled      bool   `json:"sms_enabled"`
+	Locale          string `json:"locale"`
+	QuietHoursStart string `json:"quiet_hours_start"`
+	QuietHoursEnd   string `json:"quiet_hours_end"`
+	Timezone        string `json:"timezone"`
+}
+
+// DefaultNotificationPreferences returns the preferences of a user who never
+// set any, only the in-app inbox is used until the user opts in to a channel
+func DefaultNotificationPreferences(userID int64) *NotificationPreferences {
+	return &NotificationPreferences{
+		UserID:   userID,
+		Locale:   "en",
+		Timezone: "UTC",
+	}
+}
+
+// ValidateNotificationPreferences validates the preferences data
+func ValidateNotificationPreferences(v *validator.Validator, p *NotificationPreferences) {
+	if p.Email != "" {
+		v.Check(validator.Matches(p.Email, emailRegex), "email", "must be a valid email address")
+	}
+	v.Check(!p.EmailEnabled || p.Email != "", "email", "email must be provided to enable email notifications")
+	v.Check(validator.PermittedValue(p.Locale, "en", "ta"), "locale", "unsupported locale")
+	v.Check((p.QuietHoursStart == "") == (p.QuietHoursEnd == ""), "quiet_hours", "both start and end must be provided")
+	if p.QuietHoursStart != "" {
+		v.Check(validator.Matches(p.QuietHoursStart, clockRegex), "quiet_hours_start", "must be a time of day in HH:MM format")
+	}
+	if p.QuietHoursEnd != "" {
+		v.Check(validator.Matches(p.QuietHoursEnd, clockRegex), "quiet_hours_end", "must be a time of day in HH:MM format")
+	}
+	_, err := time.LoadLocation(p.Timezone)
+	v.Check(p.Timezone != "" && err == nil, "timezone", "must be a valid IANA time zone")
+}
+
+// NotificationPreferenceModel wraps the database connection
+type NotificationPreferenceModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// Get retrieves the user's preferences, or the defaults when none were saved
+func (m NotificationPreferenceModel) Get(ctx context.Context, userID int64) (*NotificationPreferences, error) {
+	query := `
+		SELECT user_id, email, email_enabled, sms_enabled, locale,
+		       quiet_hours_start, quiet_hours_end, timezone
+		FROM notification_preferences
+		WHERE user_id = $1
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	var p NotificationPreferences
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&p.UserID,
+		&p.Email,
+		&p.EmailEnabled,
+		&p.SMSEnabled,
+		&p.Locale,
+		&p.QuietHoursStart,
+		&p.QuietHoursEnd,
+		&p.Timezone,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return DefaultNotificationPreferences(userID), nil
+		}
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// Upsert saves the user's preferences
+func (m NotificationPreferenceModel) Upsert(ctx context.Context, p *NotificationPreferences) error {
+	query := `
+		INSERT INTO notification_preferences (user_id, email, email_enabled, sms_enabled,
+		                                      locale, quiet_hours_start, quiet_hours_end, timezone)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (user_id) DO UPDATE
+		SET email = EXCLUDED.email,
+		    email_enabled = EXCLUDED.email_enabled,
+		    sms_enabled = EXCLUDED.sms_enabled,
+		    locale = EXCLUDED.locale,
+		    quiet_hours_start = EXCLUDED.quiet_hours_start,
+		    quiet_hours_end = EXCLUDED.quiet_hours_end,
+		    timezone = EXCLUDED.timezone,
+		    updated_at = NOW()
+	`
+	args := []any{
+		p.UserID,
+		p.Email,
+		p.EmailEnabled,
+		p.SMSEnabled,
+		p.Locale,
+		p.QuietHoursStart,
+		p.QuietHoursEnd,
+		p.Timezone,
+	}
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
@@ -2,14 +2,25 @@ package data
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"strconv"
 	"time"
 
 	"github.com/VJ-2303/CityStars/internal/validator"
 )
 
-var ErrReportNotFound = errors.New("report not found")
+var (
+	ErrReportNotFound = errors.New("report not found")
+	ErrEditConflict   = errors.New("edit conflict")
+
+	// ErrReportNotEditable is returned for edits and withdrawals of a
+	// report whose status no longer allows them
+	ErrReportNotEditable = errors.New("report not editable")
+)
 
 // Report represents a problem report submitted by a citizen
 type Report struct {
@@ -25,6 +36,7 @@ type Report struct {
 	CreatedAt   Time   `json:"created_at"`
 	UpdatedAt   Time   `json:"updated_at"`
 	CompletedAt *Time  `json:"completed_at,omitempty"`
+	Version     int32  `json:"version"`
 	UserName    string `json:"user_name,omitempty"`
 }
 
@@ -50,17 +62,31 @@ func ValidateReportUpdate(v *validator.Validator, status, afterImage string) {
 	}
 }
 
-// ReportModel wraps the database connection
+// ReportModel wraps the database connection, the public list and stats
+// queries go to the read replica when one is set. A user's own reports are
+// read from the primary so that a report shows up right after it is created
 type ReportModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	ReadDB  *sql.DB
+	Timeout time.Duration
+}
+
+// readDB returns the pool the read-only queries run on, which may lag
+// behind the primary
+func (m ReportModel) readDB() *sql.DB {
+	if m.ReadDB != nil {
+		return m.ReadDB
+	}
+	return m.DB
 }
 
-// Insert creates a new report in the database
-func (m ReportModel) Insert(report *Report) error {
+// Insert creates a new report in the database and records a
+// report.created event in the outbox within the same transaction
+func (m ReportModel) Insert(ctx context.Context, report *Report) error {
 	query := `
 		INSERT INTO reports (user_id, title, description, category, location, before_image, status)
 		VALUES ($1, $2, $3, $4, $5, $6, $7)
-		RETURNING id, created_at, updated_at
+		RETURNING id, created_at, updated_at, version
 	`
 	args := []any{
 		report.UserID,
@@ -72,22 +98,55 @@ func (m ReportModel) Insert(report *Report) error {
 		"pending",
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 12*time.Second)
+	ctx, span := startSpan(ctx, "ReportModel.Insert", query)
+	defer span.End()
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
 	defer cancel()
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return recordError(span, err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(
 		&report.ID,
 		&report.CreatedAt,
 		&report.UpdatedAt,
+		&report.Version,
 	)
+	if err != nil {
+		return recordError(span, err)
+	}
+
+	event := ReportEvent{
+		Report: ReportSummary{
+			ID:        report.ID,
+			UserID:    report.UserID,
+			Title:     report.Title,
+			Category:  report.Category,
+			Location:  report.Location,
+			Status:    "pending",
+			CreatedAt: time.Time(report.CreatedAt),
+			UpdatedAt: time.Time(report.UpdatedAt),
+		},
+	}
+
+	err = insertOutboxEvent(ctx, tx, EventReportCreated, report.ID, event)
+	if err != nil {
+		return recordError(span, err)
+	}
+
+	return recordError(span, tx.Commit())
 }
 
 // Get retrieves a single report by ID with user information
-func (m ReportModel) Get(id int64) (*Report, error) {
+func (m ReportModel) Get(ctx context.Context, id int64) (*Report, error) {
 	query := `
 		SELECT r.id, r.user_id, r.title, r.description, r.category, r.location,
 		       r.before_image, r.after_image, r.status, r.created_at, r.updated_at,
-		       r.completed_at, u.name as user_name
+		       r.completed_at, r.version, u.name as user_name
 		FROM reports r
 		INNER JOIN users u ON r.user_id = u.id
 		WHERE r.id = $1
@@ -96,7 +155,10 @@ func (m ReportModel) Get(id int64) (*Report, error) {
 	var report Report
 	var completedAt sql.NullTime
 
-	ctx, cancel := context.WithTimeout(context.Background(), 12*time.Second)
+	ctx, span := startSpan(ctx, "ReportModel.Get", query)
+	defer span.End()
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, id).Scan(
@@ -112,13 +174,14 @@ func (m ReportModel) Get(id int64) (*Report, error) {
 		&report.CreatedAt,
 		&report.UpdatedAt,
 		&completedAt,
+		&report.Version,
 		&report.UserName,
 	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrReportNotFound
 		}
-		return nil, err
+		return nil, recordError(span, err)
 	}
 
 	if completedAt.Valid {
@@ -130,11 +193,11 @@ func (m ReportModel) Get(id int64) (*Report, error) {
 }
 
 // GetAll retrieves all reports with pagination
-func (m ReportModel) GetAll(limit, offset int, status, category string) ([]*Report, error) {
+func (m ReportModel) GetAll(ctx context.Context, limit, offset int, status, category string) ([]*Report, error) {
 	query := `
 		SELECT r.id, r.user_id, r.title, r.description, r.category, r.location,
 		       r.before_image, r.after_image, r.status, r.created_at, r.updated_at,
-		       r.completed_at, u.name as user_name
+		       r.completed_at, r.version, u.name as user_name
 		FROM reports r
 		INNER JOIN users u ON r.user_id = u.id
 		WHERE ($3 = '' OR r.status = $3)
@@ -143,12 +206,15 @@ func (m ReportModel) GetAll(limit, offset int, status, category string) ([]*Repo
 		LIMIT $1 OFFSET $2
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 12*time.Second)
+	ctx, span := startSpan(ctx, "ReportModel.GetAll", query)
+	defer span.End()
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query, limit, offset, status, category)
+	rows, err := m.readDB().QueryContext(ctx, query, limit, offset, status, category)
 	if err != nil {
-		return nil, err
+		return nil, recordError(span, err)
 	}
 	defer rows.Close()
 
@@ -171,10 +237,11 @@ func (m ReportModel) GetAll(limit, offset int, status, category string) ([]*Repo
 			&report.CreatedAt,
 			&report.UpdatedAt,
 			&completedAt,
+			&report.Version,
 			&report.UserName,
 		)
 		if err != nil {
-			return nil, err
+			return nil, recordError(span, err)
 		}
 
 		if completedAt.Valid {
@@ -186,18 +253,18 @@ func (m ReportModel) GetAll(limit, offset int, status, category string) ([]*Repo
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, recordError(span, err)
 	}
 
 	return reports, nil
 }
 
 // GetByUserID retrieves all reports by a specific user
-func (m ReportModel) GetByUserID(userID int64, limit, offset int) ([]*Report, error) {
+func (m ReportModel) GetByUserID(ctx context.Context, userID int64, limit, offset int) ([]*Report, error) {
 	query := `
 		SELECT r.id, r.user_id, r.title, r.description, r.category, r.location,
 		       r.before_image, r.after_image, r.status, r.created_at, r.updated_at,
-		       r.completed_at, u.name as user_name
+		       r.completed_at, r.version, u.name as user_name
 		FROM reports r
 		INNER JOIN users u ON r.user_id = u.id
 		WHERE r.user_id = $1
@@ -205,12 +272,15 @@ func (m ReportModel) GetByUserID(userID int64, limit, offset int) ([]*Report, er
 		LIMIT $2 OFFSET $3
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 12*time.Second)
+	ctx, span := startSpan(ctx, "ReportModel.GetByUserID", query)
+	defer span.End()
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
 	defer cancel()
 
 	rows, err := m.DB.QueryContext(ctx, query, userID, limit, offset)
 	if err != nil {
-		return nil, err
+		return nil, recordError(span, err)
 	}
 	defer rows.Close()
 
@@ -233,10 +303,11 @@ func (m ReportModel) GetByUserID(userID int64, limit, offset int) ([]*Report, er
 			&report.CreatedAt,
 			&report.UpdatedAt,
 			&completedAt,
+			&report.Version,
 			&report.UserName,
 		)
 		if err != nil {
-			return nil, err
+			return nil, recordError(span, err)
 		}
 
 		if completedAt.Valid {
@@ -248,37 +319,267 @@ func (m ReportModel) GetByUserID(userID int64, limit, offset int) ([]*Report, er
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, recordError(span, err)
 	}
 
 	return reports, nil
 }
 
 // Update updates a report's status and after image (only admin can do this)
-func (m ReportModel) Update(id int64, status, afterImage string) error {
+// and records a report.updated event in the outbox within the same transaction.
+// The update only applies to the given version of the report, ErrEditConflict
+// is returned once another update got there first. A zero version updates
+// whichever version is current. Withdrawn reports stay withdrawn, updating
+// them returns ErrReportNotEditable
+func (m ReportModel) Update(ctx context.Context, id int64, version int32, status, afterImage string) error {
+	return m.changeStatus(ctx, "ReportModel.Update", id, version, AuditReportStatusUpdated, updateStatus(status, afterImage))
+}
+
+// updateStatus returns the transition of a report the city updates
+func updateStatus(status, afterImage string) func(reportAuditState) (reportAuditState, error) {
+	return func(current reportAuditState) (reportAuditState, error) {
+		if current.Status == "withdrawn" {
+			return current, ErrReportNotEditable
+		}
+		return reportAuditState{Status: status, AfterImage: afterImage}, nil
+	}
+}
+
+// Withdraw marks the report as withdrawn by its reporter, which is only
+// possible until the report is resolved. Like Update it applies to the
+// given version, or whichever is current for a zero version
+func (m ReportModel) Withdraw(ctx context.Context, id int64, version int32) error {
+	return m.changeStatus(ctx, "ReportModel.Withdraw", id, version, AuditReportWithdrawn, withdraw)
+}
+
+// withdraw is the transition of a report its reporter withdraws
+func withdraw(current reportAuditState) (reportAuditState, error) {
+	if !Withdrawable(current.Status) {
+		return current, ErrReportNotEditable
+	}
+	current.Status = "withdrawn"
+	return current, nil
+}
+
+// changeStatus moves the report from its current status to the one returned
+// by transition, recording the report.updated event and the audit event of
+// the action in the same transaction
+func (m ReportModel) changeStatus(ctx context.Context, spanName string, id int64, version int32, action string, transition func(reportAuditState) (reportAuditState, error)) error {
 	query := `
 		UPDATE reports
 		SET status = $1,
 		    after_image = $2,
 		    updated_at = NOW(),
-		    completed_at = CASE WHEN $1 = 'completed' THEN NOW() ELSE completed_at END
+		    completed_at = CASE WHEN $1 = 'completed' THEN NOW() ELSE completed_at END,
+		    version = version + 1
 		WHERE id = $3
-		RETURNING id
+		RETURNING id, user_id, title, category, location, status, created_at, updated_at, completed_at
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 12*time.Second)
+	ctx, span := startSpan(ctx, spanName, query)
+	defer span.End()
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
 	defer cancel()
 
-	var returnedID int64
-	err := m.DB.QueryRowContext(ctx, query, status, afterImage, id).Scan(&returnedID)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return recordError(span, err)
+	}
+	defer tx.Rollback()
+
+	// Lock the report so the version check and the previous status recorded
+	// in the events are accurate
+	var previous reportAuditState
+	var currentVersion int32
+	err = tx.QueryRowContext(ctx, `SELECT status, COALESCE(after_image, ''), version FROM reports WHERE id = $1 FOR UPDATE`, id).Scan(&previous.Status, &previous.AfterImage, &currentVersion)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return ErrReportNotFound
 		}
+		return recordError(span, err)
+	}
+	if version != 0 && version != currentVersion {
+		return ErrEditConflict
+	}
+
+	next, err := transition(previous)
+	if err != nil {
 		return err
 	}
 
-	return nil
+	var summary ReportSummary
+	var completedAt sql.NullTime
+
+	err = tx.QueryRowContext(ctx, query, next.Status, next.AfterImage, id).Scan(
+		&summary.ID,
+		&summary.UserID,
+		&summary.Title,
+		&summary.Category,
+		&summary.Location,
+		&summary.Status,
+		&summary.CreatedAt,
+		&summary.UpdatedAt,
+		&completedAt,
+	)
+	if err != nil {
+		return recordError(span, err)
+	}
+
+	if completedAt.Valid {
+		summary.CompletedAt = &completedAt.Time
+	}
+
+	event := ReportEvent{Report: summary, PreviousStatus: previous.Status}
+	err = insertOutboxEvent(ctx, tx, EventReportUpdated, id, event)
+	if err != nil {
+		return recordError(span, err)
+	}
+
+	audit, err := reportAuditEvent(ctx, action, id, previous, next)
+	if err != nil {
+		return recordError(span, err)
+	}
+	err = insertAuditEvent(ctx, tx, audit)
+	if err != nil {
+		return recordError(span, err)
+	}
+
+	return recordError(span, tx.Commit())
+}
+
+// Edit saves the changes the reporter made to the title, description,
+// location and photo of the report, which is only possible while the report
+// is pending. Like Update it applies to the given version, and on success
+// the version and update time of report are set to the new ones
+func (m ReportModel) Edit(ctx context.Context, report *Report, version int32) error {
+	query := `
+		UPDATE reports
+		SET title = $1,
+		    description = $2,
+		    location = $3,
+		    before_image = $4,
+		    updated_at = NOW(),
+		    version = version + 1
+		WHERE id = $5
+		RETURNING user_id, category, status, created_at, updated_at, version
+	`
+
+	ctx, span := startSpan(ctx, "ReportModel.Edit", query)
+	defer span.End()
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return recordError(span, err)
+	}
+	defer tx.Rollback()
+
+	var current Report
+	err = tx.QueryRowContext(ctx, `SELECT title, description, location, before_image, status, version FROM reports WHERE id = $1 FOR UPDATE`, report.ID).Scan(
+		&current.Title,
+		&current.Description,
+		&current.Location,
+		&current.BeforeImage,
+		&current.Status,
+		&current.Version,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrReportNotFound
+		}
+		return recordError(span, err)
+	}
+	if version != 0 && version != current.Version {
+		return ErrEditConflict
+	}
+	if current.Status != "pending" {
+		return ErrReportNotEditable
+	}
+	previous := current.editAuditState()
+
+	err = tx.QueryRowContext(ctx, query, report.Title, report.Description, report.Location, report.BeforeImage, report.ID).Scan(
+		&report.UserID,
+		&report.Category,
+		&report.Status,
+		&report.CreatedAt,
+		&report.UpdatedAt,
+		&report.Version,
+	)
+	if err != nil {
+		return recordError(span, err)
+	}
+
+	// The status didn't change, so the event only refreshes the report
+	// for the streams rather than notifying anyone
+	event := ReportEvent{Report: report.summary(), PreviousStatus: current.Status}
+	err = insertOutboxEvent(ctx, tx, EventReportUpdated, report.ID, event)
+	if err != nil {
+		return recordError(span, err)
+	}
+
+	audit, err := reportAuditEvent(ctx, AuditReportEdited, report.ID, previous, report.editAuditState())
+	if err != nil {
+		return recordError(span, err)
+	}
+	err = insertAuditEvent(ctx, tx, audit)
+	if err != nil {
+		return recordError(span, err)
+	}
+
+	return recordError(span, tx.Commit())
+}
+
+// Withdrawable reports whether the reporter may still withdraw a report
+// with the status, which is the case until it is resolved
+func Withdrawable(status string) bool {
+	return status == "pending" || status == "in-progress"
+}
+
+// reportAuditState is the part of a report a status change changes
+type reportAuditState struct {
+	Status     string
+	AfterImage string
+}
+
+// MarshalJSON returns the state as recorded in the audit log, with the
+// digest of the image rather than the image itself
+func (s reportAuditState) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Status     string `json:"status"`
+		AfterImage string `json:"after_image_sha256"`
+	}{s.Status, imageDigest(s.AfterImage)})
+}
+
+// reportEditAuditState is the part of a report its reporter may edit, as
+// recorded in the audit log
+type reportEditAuditState struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Location    string `json:"location"`
+	BeforeImage string `json:"before_image_sha256"`
+}
+
+func (r *Report) editAuditState() reportEditAuditState {
+	return reportEditAuditState{Title: r.Title, Description: r.Description, Location: r.Location, BeforeImage: imageDigest(r.BeforeImage)}
+}
+
+// imageDigest returns the hex SHA-256 of an image, or "" without one. Images
+// may be inline data URLs, so the audit log records whether they changed
+// without copying them
+func imageDigest(image string) string {
+	if image == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(image))
+	return hex.EncodeToString(sum[:])
+}
+
+// reportAuditEvent returns the audit event of the action on the report by the actor of ctx
+func reportAuditEvent(ctx context.Context, action string, id int64, before, after any) (*AuditEvent, error) {
+	return NewAuditEvent(ActorFromContext(ctx), action, "report", strconv.FormatInt(id, 10), before, after)
 }
 
 // ReportStats represents the statistics of reports
@@ -288,33 +589,39 @@ type ReportStats struct {
 	InProgressReports int `json:"in_progress_reports"`
 	CompletedReports  int `json:"completed_reports"`
 	RejectedReports   int `json:"rejected_reports"`
+	WithdrawnReports  int `json:"withdrawn_reports"`
 }
 
 // GetStats retrieves report statistics
-func (m ReportModel) GetStats() (*ReportStats, error) {
+func (m ReportModel) GetStats(ctx context.Context) (*ReportStats, error) {
 	query := `
 		SELECT
 			COUNT(*) as total,
 			COUNT(CASE WHEN status = 'pending' THEN 1 END) as pending,
 			COUNT(CASE WHEN status = 'in-progress' THEN 1 END) as in_progress,
 			COUNT(CASE WHEN status = 'completed' THEN 1 END) as completed,
-			COUNT(CASE WHEN status = 'rejected' THEN 1 END) as rejected
+			COUNT(CASE WHEN status = 'rejected' THEN 1 END) as rejected,
+			COUNT(CASE WHEN status = 'withdrawn' THEN 1 END) as withdrawn
 		FROM reports
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 12*time.Second)
+	ctx, span := startSpan(ctx, "ReportModel.GetStats", query)
+	defer span.End()
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
 	defer cancel()
 
 	var stats ReportStats
-	err := m.DB.QueryRowContext(ctx, query).Scan(
+	err := m.readDB().QueryRowContext(ctx, query).Scan(
 		&stats.TotalReports,
 		&stats.PendingReports,
 		&stats.InProgressReports,
 		&stats.CompletedReports,
 		&stats.RejectedReports,
+		&stats.WithdrawnReports,
 	)
 	if err != nil {
-		return nil, err
+		return nil, recordError(span, err)
 	}
 
 	return &stats, nil
@@ -328,23 +635,27 @@ type LeaderboardEntry struct {
 	PhoneNumber string `json:"phone_number"`
 }
 
-// GetLeaderboard retrieves top 10 users with most reports
-func (m ReportModel) GetLeaderboard() ([]*LeaderboardEntry, error) {
+// GetLeaderboard retrieves top 10 users with most reports, withdrawn
+// reports don't count
+func (m ReportModel) GetLeaderboard(ctx context.Context) ([]*LeaderboardEntry, error) {
 	query := `
 		SELECT u.id, u.name, u.phone_number, COUNT(r.id) as report_count
 		FROM users u
-		INNER JOIN reports r ON u.id = r.user_id
+		INNER JOIN reports r ON u.id = r.user_id AND r.status <> 'withdrawn'
 		GROUP BY u.id, u.name, u.phone_number
 		ORDER BY report_count DESC
 		LIMIT 10
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 12*time.Second)
+	ctx, span := startSpan(ctx, "ReportModel.GetLeaderboard", query)
+	defer span.End()
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.readDB().QueryContext(ctx, query)
 	if err != nil {
-		return nil, err
+		return nil, recordError(span, err)
 	}
 	defer rows.Close()
 
@@ -359,14 +670,14 @@ func (m ReportModel) GetLeaderboard() ([]*LeaderboardEntry, error) {
 			&entry.ReportCount,
 		)
 		if err != nil {
-			return nil, err
+			return nil, recordError(span, err)
 		}
 
 		leaderboard = append(leaderboard, &entry)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, recordError(span, err)
 	}
 
 	return leaderboard, nil
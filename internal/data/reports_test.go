@@ -0,0 +1,422 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReportModelInsertAndGet(t *testing.T) {
+	db := newTestDB(t)
+	users := UserModel{DB: db, Timeout: DefaultQueryTimeout}
+	m := ReportModel{DB: db, Timeout: DefaultQueryTimeout}
+
+	user := insertTestUser(t, users, "Priya Raman", "9000000001")
+	report := &Report{
+		UserID:      user.ID,
+		Title:       "Broken streetlight",
+		Description: "Dark for a week now",
+		Category:    "streetlight",
+		Location:    "Anna Salai, Chennai",
+		BeforeImage: "https://img.example.com/before.jpg",
+	}
+
+	err := m.Insert(t.Context(), report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.ID == 0 || time.Time(report.CreatedAt).IsZero() {
+		t.Errorf("insert didn't return the generated columns: %+v", report)
+	}
+
+	var events int
+	err = db.QueryRowContext(t.Context(), `SELECT COUNT(*) FROM outbox WHERE event_type = $1 AND aggregate_id = $2`, EventReportCreated, report.ID).Scan(&events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events != 1 {
+		t.Errorf("got %d outbox events, want 1", events)
+	}
+
+	tests := []struct {
+		name    string
+		id      int64
+		wantErr error
+	}{
+		{"existing", report.ID, nil},
+		{"missing", report.ID + 1000, ErrReportNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := m.Get(t.Context(), tt.id)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if got.Title != report.Title || got.Status != "pending" || got.UserName != user.Name {
+				t.Errorf("unexpected report %+v", got)
+			}
+			if got.AfterImage != "" || got.CompletedAt != nil {
+				t.Errorf("new report has completion data: %+v", got)
+			}
+		})
+	}
+}
+
+func TestReportModelList(t *testing.T) {
+	db := newTestDB(t)
+	users := UserModel{DB: db, Timeout: DefaultQueryTimeout}
+	m := ReportModel{DB: db, Timeout: DefaultQueryTimeout}
+
+	priya := insertTestUser(t, users, "Priya Raman", "9000000001")
+	karthik := insertTestUser(t, users, "Karthik Subramanian", "9000000002")
+
+	now := time.Now().Truncate(time.Second)
+	oldest := insertTestReport(t, m, priya.ID, "pothole", now.Add(-3*time.Hour))
+	middle := insertTestReport(t, m, karthik.ID, "garbage", now.Add(-2*time.Hour))
+	newest := insertTestReport(t, m, priya.ID, "pothole", now.Add(-time.Hour))
+
+	err := m.Update(t.Context(), middle.ID, middle.Version, "in-progress", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("GetAll", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			limit    int
+			offset   int
+			status   string
+			category string
+			wantIDs  []int64
+		}{
+			{"unfiltered", 10, 0, "", "", []int64{newest.ID, middle.ID, oldest.ID}},
+			{"by status", 10, 0, "pending", "", []int64{newest.ID, oldest.ID}},
+			{"by category", 10, 0, "", "garbage", []int64{middle.ID}},
+			{"by status and category", 10, 0, "pending", "garbage", []int64{}},
+			{"limited", 2, 0, "", "", []int64{newest.ID, middle.ID}},
+			{"offset", 10, 2, "", "", []int64{oldest.ID}},
+			{"past the end", 10, 3, "", "", []int64{}},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				reports, err := m.GetAll(t.Context(), tt.limit, tt.offset, tt.status, tt.category)
+				if err != nil {
+					t.Fatal(err)
+				}
+				checkReportIDs(t, reports, tt.wantIDs)
+			})
+		}
+	})
+
+	t.Run("GetByUserID", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			userID  int64
+			limit   int
+			offset  int
+			wantIDs []int64
+		}{
+			{"all of a user", priya.ID, 10, 0, []int64{newest.ID, oldest.ID}},
+			{"paginated", priya.ID, 1, 1, []int64{oldest.ID}},
+			{"other user", karthik.ID, 10, 0, []int64{middle.ID}},
+			{"user without reports", karthik.ID + 1000, 10, 0, []int64{}},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				reports, err := m.GetByUserID(t.Context(), tt.userID, tt.limit, tt.offset)
+				if err != nil {
+					t.Fatal(err)
+				}
+				checkReportIDs(t, reports, tt.wantIDs)
+			})
+		}
+	})
+}
+
+func TestReportModelUpdate(t *testing.T) {
+	db := newTestDB(t)
+	users := UserModel{DB: db, Timeout: DefaultQueryTimeout}
+	m := ReportModel{DB: db, Timeout: DefaultQueryTimeout}
+
+	user := insertTestUser(t, users, "Priya Raman", "9000000001")
+	report := insertTestReport(t, m, user.ID, "pothole", time.Now())
+
+	// The steps run in order against the same report
+	tests := []struct {
+		name          string
+		id            int64
+		version       int32
+		status        string
+		afterImage    string
+		wantErr       error
+		wantCompleted bool
+	}{
+		{"missing report", report.ID + 1000, 1, "in-progress", "", ErrReportNotFound, false},
+		{"in progress", report.ID, 1, "in-progress", "", nil, false},
+		{"stale version", report.ID, 1, "rejected", "", ErrEditConflict, false},
+		{"completed", report.ID, 2, "completed", "https://img.example.com/after.jpg", nil, true},
+		{"reopened keeps completion time", report.ID, 0, "in-progress", "https://img.example.com/after.jpg", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := m.Update(t.Context(), tt.id, tt.version, tt.status, tt.afterImage)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			got, err := m.Get(t.Context(), tt.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != tt.status || got.AfterImage != tt.afterImage {
+				t.Errorf("got status %q and after image %q", got.Status, got.AfterImage)
+			}
+			if (got.CompletedAt != nil) != tt.wantCompleted {
+				t.Errorf("got completed at %v, want set %t", got.CompletedAt, tt.wantCompleted)
+			}
+		})
+	}
+
+	var events int
+	err := db.QueryRowContext(t.Context(), `SELECT COUNT(*) FROM outbox WHERE event_type = $1 AND aggregate_id = $2`, EventReportUpdated, report.ID).Scan(&events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events != 3 {
+		t.Errorf("got %d outbox events, want 3", events)
+	}
+
+	got, err := m.Get(t.Context(), report.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != 4 {
+		t.Errorf("got version %d, want 4", got.Version)
+	}
+}
+
+func TestReportModelEditAndWithdraw(t *testing.T) {
+	db := newTestDB(t)
+	users := UserModel{DB: db, Timeout: DefaultQueryTimeout}
+	m := ReportModel{DB: db, Timeout: DefaultQueryTimeout}
+
+	user := insertTestUser(t, users, "Priya Raman", "9000000001")
+	report := insertTestReport(t, m, user.ID, "pothole", time.Now())
+
+	edit := func(version int32, title string) error {
+		r := *report
+		r.Title = title
+		return m.Edit(t.Context(), &r, version)
+	}
+
+	// The steps run in order against the same report
+	tests := []struct {
+		name    string
+		step    func() error
+		wantErr error
+	}{
+		{"edit", func() error { return edit(1, "Deep pothole near the bus stop") }, nil},
+		{"stale edit", func() error { return edit(1, "Pothole") }, ErrEditConflict},
+		{"missing report", func() error { return m.Withdraw(t.Context(), report.ID+1000, 0) }, ErrReportNotFound},
+		{"stale withdrawal", func() error { return m.Withdraw(t.Context(), report.ID, 1) }, ErrEditConflict},
+		{"in progress", func() error { return m.Update(t.Context(), report.ID, 2, "in-progress", "") }, nil},
+		{"edit in progress", func() error { return edit(3, "Pothole") }, ErrReportNotEditable},
+		{"withdraw", func() error { return m.Withdraw(t.Context(), report.ID, 3) }, nil},
+		{"withdraw again", func() error { return m.Withdraw(t.Context(), report.ID, 0) }, ErrReportNotEditable},
+		{"update withdrawn", func() error { return m.Update(t.Context(), report.ID, 0, "in-progress", "") }, ErrReportNotEditable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.step()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	got, err := m.Get(t.Context(), report.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "Deep pothole near the bus stop" || got.Status != "withdrawn" || got.Version != 4 {
+		t.Errorf("got report %+v, want the edited title withdrawn in version 4", got)
+	}
+
+	var actions []string
+	rows, err := db.QueryContext(t.Context(), `SELECT action FROM audit_events WHERE target_id = $1 ORDER BY id`, strconv.FormatInt(report.ID, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var action string
+		if err := rows.Scan(&action); err != nil {
+			t.Fatal(err)
+		}
+		actions = append(actions, action)
+	}
+	want := []string{AuditReportEdited, AuditReportStatusUpdated, AuditReportWithdrawn}
+	if fmt.Sprint(actions) != fmt.Sprint(want) {
+		t.Errorf("got audit actions %v, want %v", actions, want)
+	}
+}
+
+func TestReportModelStatsAndLeaderboard(t *testing.T) {
+	db := newTestDB(t)
+	users := UserModel{DB: db, Timeout: DefaultQueryTimeout}
+	m := ReportModel{DB: db, Timeout: DefaultQueryTimeout}
+
+	priya := insertTestUser(t, users, "Priya Raman", "9000000001")
+	karthik := insertTestUser(t, users, "Karthik Subramanian", "9000000002")
+	insertTestUser(t, users, "Lurking Larry", "9000000003")
+
+	now := time.Now()
+	statuses := []struct {
+		userID int64
+		status string
+	}{
+		{priya.ID, "pending"},
+		{priya.ID, "in-progress"},
+		{priya.ID, "completed"},
+		{karthik.ID, "rejected"},
+	}
+	for _, s := range statuses {
+		report := insertTestReport(t, m, s.userID, "road", now)
+		if s.status == "pending" {
+			continue
+		}
+		err := m.Update(t.Context(), report.ID, report.Version, s.status, "https://img.example.com/after.jpg")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := m.GetStats(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ReportStats{TotalReports: 4, PendingReports: 1, InProgressReports: 1, CompletedReports: 1, RejectedReports: 1}
+	if *stats != want {
+		t.Errorf("got stats %+v, want %+v", *stats, want)
+	}
+
+	leaderboard, err := m.GetLeaderboard(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantEntries := []LeaderboardEntry{
+		{UserID: priya.ID, UserName: priya.Name, ReportCount: 3, PhoneNumber: priya.PhoneNumber},
+		{UserID: karthik.ID, UserName: karthik.Name, ReportCount: 1, PhoneNumber: karthik.PhoneNumber},
+	}
+	if len(leaderboard) != len(wantEntries) {
+		t.Fatalf("got %d leaderboard entries, want %d", len(leaderboard), len(wantEntries))
+	}
+	for i, entry := range leaderboard {
+		if *entry != wantEntries[i] {
+			t.Errorf("entry %d is %+v, want %+v", i, *entry, wantEntries[i])
+		}
+	}
+}
+
+// checkReportIDs fails the test unless the reports have the wanted ids in order
+func checkReportIDs(t *testing.T, reports []*Report, want []int64) {
+	t.Helper()
+
+	if len(reports) != len(want) {
+		t.Fatalf("got %d reports, want %d", len(reports), len(want))
+	}
+	for i, r := range reports {
+		if r.ID != want[i] {
+			t.Errorf("report %d has id %d, want %d", i, r.ID, want[i])
+		}
+	}
+}
+
+func TestReportModelReadReplica(t *testing.T) {
+	db := newTestDB(t)
+	users := UserModel{DB: db, Timeout: DefaultQueryTimeout}
+
+	// A closed replica makes every query routed to it fail
+	replica, err := sql.Open("postgres", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	replica.Close()
+	m := ReportModel{DB: db, ReadDB: replica, Timeout: DefaultQueryTimeout}
+
+	user := insertTestUser(t, users, "Priya Raman", "9000000001")
+	report := insertTestReport(t, m, user.ID, "road", time.Now())
+
+	tests := []struct {
+		name        string
+		query       func() error
+		wantReplica bool
+	}{
+		{"get", func() error { _, err := m.Get(t.Context(), report.ID); return err }, false},
+		{"by user", func() error { _, err := m.GetByUserID(t.Context(), user.ID, 10, 0); return err }, false},
+		{"all", func() error { _, err := m.GetAll(t.Context(), 10, 0, "", ""); return err }, true},
+		{"stats", func() error { _, err := m.GetStats(t.Context()); return err }, true},
+		{"leaderboard", func() error { _, err := m.GetLeaderboard(t.Context()); return err }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.query()
+			if tt.wantReplica && err == nil {
+				t.Error("query didn't go to the replica")
+			}
+			if !tt.wantReplica && err != nil {
+				t.Errorf("query went to the replica: %v", err)
+			}
+		})
+	}
+}
+
+func TestReportAuditStates(t *testing.T) {
+	// Images may be inline data URLs, the audit log only gets their digest
+	image := "data:image/jpeg;base64," + strings.Repeat("/9j/4AAQSkZJRgABAQ", 1000)
+	digest := imageDigest(image)
+	if len(digest) != 64 {
+		t.Fatalf("got digest %q, want a hex SHA-256", digest)
+	}
+
+	tests := []struct {
+		name  string
+		state any
+		want  string
+	}{
+		{"status without image", reportAuditState{Status: "pending"}, `{"status":"pending","after_image_sha256":""}`},
+		{"status with image", reportAuditState{Status: "completed", AfterImage: image}, `{"status":"completed","after_image_sha256":"` + digest + `"}`},
+		{"edit", (&Report{Title: "Pothole", Description: "Deep", Location: "Anna Salai", BeforeImage: image}).editAuditState(),
+			`{"title":"Pothole","description":"Deep","location":"Anna Salai","before_image_sha256":"` + digest + `"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			js, err := json.Marshal(tt.state)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(js) != tt.want {
+				t.Errorf("got %s, want %s", js, tt.want)
+			}
+		})
+	}
+}
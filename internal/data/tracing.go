@@ -0,0 +1,36 @@
+package data
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of the model methods, it is a no-op until the
+// application installs a tracer provider
+var tracer = otel.Tracer("github.com/VJ-2303/CityStars/internal/data")
+
+// startSpan starts a client span for a model method, named after the
+// method and carrying the SQL statement it runs
+func startSpan(ctx context.Context, name, query string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemNamePostgreSQL,
+			semconv.DBOperationName(name),
+			semconv.DBQueryText(query),
+		),
+	)
+}
+
+// recordError marks the span as failed when err isn't nil and returns err
+func recordError(span trace.Span, err error) error {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
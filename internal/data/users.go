@@ -23,6 +23,12 @@ type User struct {
 	Password    password `json:"-"`
 	Role        string   `json:"role"`
 	CreatedAt   Time     `json:"created_at"`
+	SuspendedAt *Time    `json:"suspended_at,omitempty"`
+}
+
+// Suspended reports whether the user was suspended by an admin
+func (u *User) Suspended() bool {
+	return u.SuspendedAt != nil
 }
 
 func ValidateUser(v *validator.Validator, u *User) {
@@ -32,6 +38,11 @@ func ValidateUser(v *validator.Validator, u *User) {
 	v.Check(validator.Matches(u.PhoneNumber, validator.PhoneNumberRegex), "phone_number", "provide an valid phone number")
 }
 
+// ValidateRole checks that role is one of the roles users can have
+func ValidateRole(v *validator.Validator, role string) {
+	v.Check(validator.PermittedValue(role, "user", "admin"), "role", "role must be user or admin")
+}
+
 type password struct {
 	PlainText string
 	hash      []byte
@@ -60,17 +71,22 @@ func (p *password) Matches(plainText string) (bool, error) {
 }
 
 type UserModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Timeout time.Duration
 }
 
-func (m UserModel) Insert(user *User) error {
-	query := `INSERT into users (name,phone_number,password_hash)
-					 VALUES($1,$2,$3)
+// Insert creates the user with the given role, or the user role when unset
+func (m UserModel) Insert(ctx context.Context, user *User) error {
+	query := `INSERT into users (name,phone_number,password_hash,role)
+					 VALUES($1,$2,$3,COALESCE(NULLIF($4,''),'user'))
 					 RETURNING id,role,created_at
 	`
-	args := []any{user.Name, user.PhoneNumber, user.Password.hash}
+	args := []any{user.Name, user.PhoneNumber, user.Password.hash, user.Role}
+
+	ctx, span := startSpan(ctx, "UserModel.Insert", query)
+	defer span.End()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 12*time.Second)
+	ctx, cancel := queryContext(ctx, m.Timeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
@@ -82,20 +98,24 @@ func (m UserModel) Insert(user *User) error {
 		if strings.Contains(err.Error(), "duplicate") {
 			return ErrDuplicatePhoneNumber
 		}
-		return err
+		return recordError(span, err)
 	}
 	return nil
 }
 
-func (m UserModel) GetByPhoneNumber(phoneNumber string) (*User, error) {
+func (m UserModel) GetByPhoneNumber(ctx context.Context, phoneNumber string) (*User, error) {
 	query := `
-				SELECT id, name, phone_number, password_hash,role, created_at
+				SELECT id, name, phone_number, password_hash,role, created_at, suspended_at
 				FROM users
 				WHERE phone_number = $1
 				    `
 	var u User
+	var suspendedAt sql.NullTime
+
+	ctx, span := startSpan(ctx, "UserModel.GetByPhoneNumber", query)
+	defer span.End()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 12*time.Second)
+	ctx, cancel := queryContext(ctx, m.Timeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, phoneNumber).Scan(
@@ -105,12 +125,136 @@ func (m UserModel) GetByPhoneNumber(phoneNumber string) (*User, error) {
 		&u.Password.hash,
 		&u.Role,
 		&u.CreatedAt,
+		&suspendedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, recordError(span, err)
+	}
+	if suspendedAt.Valid {
+		t := Time(suspendedAt.Time)
+		u.SuspendedAt = &t
+	}
+	return &u, nil
+}
+
+func (m UserModel) GetByID(ctx context.Context, id int64) (*User, error) {
+	query := `
+				SELECT id, name, phone_number, password_hash,role, created_at, suspended_at
+				FROM users
+				WHERE id = $1
+				    `
+	var u User
+	var suspendedAt sql.NullTime
+
+	ctx, span := startSpan(ctx, "UserModel.GetByID", query)
+	defer span.End()
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&u.ID,
+		&u.Name,
+		&u.PhoneNumber,
+		&u.Password.hash,
+		&u.Role,
+		&u.CreatedAt,
+		&suspendedAt,
 	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrUserNotFound
 		}
-		return nil, err
+		return nil, recordError(span, err)
+	}
+	if suspendedAt.Valid {
+		t := Time(suspendedAt.Time)
+		u.SuspendedAt = &t
 	}
 	return &u, nil
 }
+
+// UpdateRole changes the role of the user
+func (m UserModel) UpdateRole(ctx context.Context, id int64, role string) error {
+	query := `UPDATE users SET role = $1 WHERE id = $2`
+
+	ctx, span := startSpan(ctx, "UserModel.UpdateRole", query)
+	defer span.End()
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, role, id)
+	if err != nil {
+		return recordError(span, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return recordError(span, err)
+	}
+	if rowsAffected == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// UpdatePassword stores the password hash of the user
+func (m UserModel) UpdatePassword(ctx context.Context, user *User) error {
+	query := `UPDATE users SET password_hash = $1 WHERE id = $2`
+
+	ctx, span := startSpan(ctx, "UserModel.UpdatePassword", query)
+	defer span.End()
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, user.Password.hash, user.ID)
+	if err != nil {
+		return recordError(span, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return recordError(span, err)
+	}
+	if rowsAffected == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// SetSuspended suspends the user, or lifts the suspension
+func (m UserModel) SetSuspended(ctx context.Context, id int64, suspended bool) error {
+	query := `
+		UPDATE users
+		SET suspended_at = CASE WHEN $1 THEN COALESCE(suspended_at, NOW()) END
+		WHERE id = $2
+	`
+
+	ctx, span := startSpan(ctx, "UserModel.SetSuspended", query)
+	defer span.End()
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, suspended, id)
+	if err != nil {
+		return recordError(span, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return recordError(span, err)
+	}
+	if rowsAffected == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
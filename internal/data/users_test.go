@@ -0,0 +1,144 @@
+package data
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestUserModelInsert(t *testing.T) {
+	m := UserModel{DB: newTestDB(t), Timeout: DefaultQueryTimeout}
+	existing := insertTestUser(t, m, "Priya Raman", "9000000001")
+
+	if existing.ID == 0 || existing.Role != "user" {
+		t.Errorf("unexpected inserted user %+v", existing)
+	}
+
+	tests := []struct {
+		name        string
+		phoneNumber string
+		role        string
+		wantErr     error
+	}{
+		{"new phone number", "9000000002", "", nil},
+		{"with role", "9000000003", "admin", nil},
+		{"duplicate phone number", "9000000001", "", ErrDuplicatePhoneNumber},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &User{Name: "Karthik Subramanian", PhoneNumber: tt.phoneNumber, Role: tt.role}
+			err := user.Password.Set("pa55word-1234")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = m.Insert(t.Context(), user)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			got, err := m.GetByID(t.Context(), user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := tt.role
+			if want == "" {
+				want = "user"
+			}
+			if user.Role != want || got.Role != want {
+				t.Errorf("got role %q stored as %q, want %q", user.Role, got.Role, want)
+			}
+		})
+	}
+}
+
+func TestUserModelGet(t *testing.T) {
+	m := UserModel{DB: newTestDB(t), Timeout: DefaultQueryTimeout}
+	user := insertTestUser(t, m, "Priya Raman", "9000000001")
+
+	tests := []struct {
+		name    string
+		get     func() (*User, error)
+		wantErr error
+	}{
+		{"by phone number", func() (*User, error) { return m.GetByPhoneNumber(t.Context(), "9000000001") }, nil},
+		{"by missing phone number", func() (*User, error) { return m.GetByPhoneNumber(t.Context(), "9999999999") }, ErrUserNotFound},
+		{"by id", func() (*User, error) { return m.GetByID(t.Context(), user.ID) }, nil},
+		{"by missing id", func() (*User, error) { return m.GetByID(t.Context(), user.ID+1000) }, ErrUserNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.get()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if got.ID != user.ID || got.Name != user.Name || got.PhoneNumber != user.PhoneNumber || got.Role != "user" {
+				t.Errorf("got user %+v, want %+v", got, user)
+			}
+			matches, err := got.Password.Matches("pa55word-1234")
+			if err != nil || !matches {
+				t.Errorf("stored password hash doesn't match: %v", err)
+			}
+		})
+	}
+}
+
+func TestUserModelUpdates(t *testing.T) {
+	m := UserModel{DB: newTestDB(t), Timeout: DefaultQueryTimeout}
+	user := insertTestUser(t, m, "Priya Raman", "9000000001")
+	missing := user.ID + 1000
+
+	tests := []struct {
+		name    string
+		update  func(id int64) error
+		id      int64
+		wantErr error
+		check   func(u *User) bool
+	}{
+		{"role", func(id int64) error { return m.UpdateRole(t.Context(), id, "admin") }, user.ID, nil, func(u *User) bool { return u.Role == "admin" }},
+		{"role of missing user", func(id int64) error { return m.UpdateRole(t.Context(), id, "admin") }, missing, ErrUserNotFound, nil},
+		{"suspend", func(id int64) error { return m.SetSuspended(t.Context(), id, true) }, user.ID, nil, func(u *User) bool { return u.Suspended() }},
+		{"suspend again", func(id int64) error { return m.SetSuspended(t.Context(), id, true) }, user.ID, nil, func(u *User) bool { return u.Suspended() }},
+		{"unsuspend", func(id int64) error { return m.SetSuspended(t.Context(), id, false) }, user.ID, nil, func(u *User) bool { return !u.Suspended() }},
+		{"suspend missing user", func(id int64) error { return m.SetSuspended(t.Context(), id, true) }, missing, ErrUserNotFound, nil},
+		{"password", func(id int64) error {
+			u := &User{ID: id}
+			err := u.Password.Set("new-pa55word")
+			if err != nil {
+				return err
+			}
+			return m.UpdatePassword(t.Context(), u)
+		}, user.ID, nil, func(u *User) bool {
+			ok, _ := u.Password.Matches("new-pa55word")
+			return ok
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.update(tt.id)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if tt.check == nil {
+				return
+			}
+
+			got, err := m.GetByID(t.Context(), tt.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.check(got) {
+				t.Errorf("unexpected user %+v", got)
+			}
+		})
+	}
+}
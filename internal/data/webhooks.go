@@ -0,0 +1,382 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/VJ-2303/CityStars/internal/validator"
+	"github.com/lib/pq"
+)
+
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// Report lifecycle events webhooks can subscribe to
+const (
+	WebhookReportCreated       = "report.created"
+	WebhookReportStatusChanged = "report.status_changed"
+)
+
+// Delivery states, a delivery that keeps failing ends up dead
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryDead      = "dead"
+)
+
+// Webhook is an admin managed subscription to report lifecycle events
+type Webhook struct {
+	ID         int64    `json:"id"`
+	URL        string   `json:"url"`
+	Secret     string   `json:"-"`
+	EventTypes []string `json:"event_types"`
+	Active     bool     `json:"active"`
+	CreatedAt  Time     `json:"created_at"`
+}
+
+// ValidateWebhook validates the webhook subscription data
+func ValidateWebhook(v *validator.Validator, wh *Webhook) {
+	u, err := url.Parse(wh.URL)
+	v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "url", "must be a valid http or https URL")
+	v.Check(len(wh.Secret) >= 16, "secret", "secret must be at least 16 characters long")
+	v.Check(len(wh.EventTypes) > 0, "event_types", "at least one event type must be provided")
+	for _, t := range wh.EventTypes {
+		v.Check(validator.PermittedValue(t, WebhookReportCreated, WebhookReportStatusChanged), "event_types", "invalid event type "+t)
+	}
+}
+
+// WebhookModel wraps the database connection
+type WebhookModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// Insert creates a new webhook subscription
+func (m WebhookModel) Insert(ctx context.Context, wh *Webhook) error {
+	query := `
+		INSERT INTO webhooks (url, secret, event_types, active)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, wh.URL, wh.Secret, pq.Array(wh.EventTypes), wh.Active).Scan(
+		&wh.ID,
+		&wh.CreatedAt,
+	)
+}
+
+// GetAll retrieves every webhook subscription
+func (m WebhookModel) GetAll(ctx context.Context) ([]*Webhook, error) {
+	query := `
+		SELECT id, url, secret, event_types, active, created_at
+		FROM webhooks
+		ORDER BY id
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []*Webhook{}
+
+	for rows.Next() {
+		var wh Webhook
+		err := rows.Scan(
+			&wh.ID,
+			&wh.URL,
+			&wh.Secret,
+			pq.Array(&wh.EventTypes),
+			&wh.Active,
+			&wh.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		webhooks = append(webhooks, &wh)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+// Get retrieves a single webhook subscription by ID
+func (m WebhookModel) Get(ctx context.Context, id int64) (*Webhook, error) {
+	query := `
+		SELECT id, url, secret, event_types, active, created_at
+		FROM webhooks
+		WHERE id = $1
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	var wh Webhook
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&wh.ID,
+		&wh.URL,
+		&wh.Secret,
+		pq.Array(&wh.EventTypes),
+		&wh.Active,
+		&wh.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrWebhookNotFound
+		}
+		return nil, err
+	}
+
+	return &wh, nil
+}
+
+// Delete removes a webhook subscription along with its delivery log
+func (m WebhookModel) Delete(ctx context.Context, id int64) error {
+	query := `
+		DELETE FROM webhooks
+		WHERE id = $1
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrWebhookNotFound
+	}
+
+	return nil
+}
+
+// WebhookDelivery is a single attempt-tracked delivery of an event to a webhook
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	WebhookID      int64           `json:"webhook_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  Time            `json:"next_attempt_at"`
+	LastStatusCode *int            `json:"last_status_code,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	CreatedAt      Time            `json:"created_at"`
+	UpdatedAt      Time            `json:"updated_at"`
+
+	// URL and Secret of the webhook, only set on claimed deliveries
+	URL    string `json:"-"`
+	Secret string `json:"-"`
+}
+
+// WebhookDeliveryModel wraps the database connection
+type WebhookDeliveryModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// Enqueue queues a delivery of the event for every active webhook
+// subscribed to the event type and returns how many were queued.
+// Deliveries already queued for the same event key are skipped
+func (m WebhookDeliveryModel) Enqueue(ctx context.Context, eventType string, payload []byte, eventKey string) (int64, error) {
+	query := `
+		INSERT INTO webhook_deliveries (webhook_id, event_type, payload, event_key)
+		SELECT id, $1, $2, $3
+		FROM webhooks
+		WHERE active AND $1 = ANY(event_types)
+		ON CONFLICT (webhook_id, event_key) DO NOTHING
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, eventType, string(payload), eventKey)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// ClaimDue leases up to limit pending deliveries whose next attempt is due.
+// Claimed deliveries are pushed back by lease so that other workers skip
+// them, if the worker dies they become due again once the lease runs out
+func (m WebhookDeliveryModel) ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]*WebhookDelivery, error) {
+	query := `
+		UPDATE webhook_deliveries d
+		SET next_attempt_at = NOW() + $2 * INTERVAL '1 second'
+		FROM webhooks w
+		WHERE d.webhook_id = w.id
+		  AND d.id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		  )
+		RETURNING d.id, d.webhook_id, d.event_type, d.payload, d.status, d.attempts,
+		          d.next_attempt_at, d.last_status_code, d.last_error, d.created_at,
+		          d.updated_at, w.url, w.secret
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*WebhookDelivery{}
+
+	for rows.Next() {
+		var d WebhookDelivery
+		err := scanDelivery(rows, &d, &d.URL, &d.Secret)
+		if err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, &d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// MarkSucceeded records a successful delivery
+func (m WebhookDeliveryModel) MarkSucceeded(ctx context.Context, id int64, statusCode int) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = 'succeeded',
+		    attempts = attempts + 1,
+		    last_status_code = $2,
+		    last_error = '',
+		    updated_at = NOW()
+		WHERE id = $1
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id, statusCode)
+	return err
+}
+
+// MarkFailed records a failed attempt. The delivery is retried at
+// nextAttempt, or moved to the dead state when dead is true
+func (m WebhookDeliveryModel) MarkFailed(ctx context.Context, id int64, statusCode *int, lastError string, dead bool, nextAttempt time.Time) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = CASE WHEN $4 THEN 'dead' ELSE 'pending' END,
+		    attempts = attempts + 1,
+		    last_status_code = $2,
+		    last_error = $3,
+		    next_attempt_at = $5,
+		    updated_at = NOW()
+		WHERE id = $1
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id, statusCode, lastError, dead, nextAttempt)
+	return err
+}
+
+// GetForWebhook retrieves the delivery log of a webhook, newest first,
+// optionally restricted to deliveries in the given status
+func (m WebhookDeliveryModel) GetForWebhook(ctx context.Context, webhookID int64, status string, limit, offset int) ([]*WebhookDelivery, error) {
+	query := `
+		SELECT id, webhook_id, event_type, payload, status, attempts,
+		       next_attempt_at, last_status_code, last_error, created_at, updated_at
+		FROM webhook_deliveries
+		WHERE webhook_id = $1
+		  AND ($2 = '' OR status = $2)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3 OFFSET $4
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, webhookID, status, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*WebhookDelivery{}
+
+	for rows.Next() {
+		var d WebhookDelivery
+		err := scanDelivery(rows, &d)
+		if err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, &d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// scanDelivery scans the common delivery columns followed by any extra destinations
+func scanDelivery(rows *sql.Rows, d *WebhookDelivery, extra ...any) error {
+	var payload []byte
+	var statusCode sql.NullInt64
+
+	dest := []any{
+		&d.ID,
+		&d.WebhookID,
+		&d.EventType,
+		&payload,
+		&d.Status,
+		&d.Attempts,
+		&d.NextAttemptAt,
+		&statusCode,
+		&d.LastError,
+		&d.CreatedAt,
+		&d.UpdatedAt,
+	}
+
+	err := rows.Scan(append(dest, extra...)...)
+	if err != nil {
+		return err
+	}
+
+	d.Payload = payload
+	if statusCode.Valid {
+		code := int(statusCode.Int64)
+		d.LastStatusCode = &code
+	}
+
+	return nil
+}
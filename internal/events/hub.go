@@ -0,0 +1,136 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+)
+
+// Event types published on report writes
+const (
+	ReportCreated       = "report.created"
+	ReportUpdated       = "report.updated"
+	NotificationCreated = "notification.created"
+)
+
+// Event is a single message delivered to stream subscribers.
+// Public events go to the public feed, every event whose UserID
+// matches a subscriber is also delivered to that user's personal feed
+type Event struct {
+	ID       int64           `json:"id"`
+	Type     string          `json:"type"`
+	UserID   int64           `json:"user_id,omitempty"`
+	Public   bool            `json:"public"`
+	Category string          `json:"category,omitempty"`
+	Data     json.RawMessage `json:"data"`
+}
+
+// Publisher is implemented by anything report writes can hand events to
+type Publisher interface {
+	Publish(ctx context.Context, e Event) error
+}
+
+// Subscription receives the events matching its filter on C.
+// C is closed when the subscriber falls too far behind, the client
+// is then expected to reconnect using the last event ID it saw
+type Subscription struct {
+	C      <-chan Event
+	c      chan Event
+	filter func(Event) bool
+}
+
+// Hub is an in-process pub/sub fan out which also keeps the
+// most recent events around so that clients can resume a stream
+type Hub struct {
+	mu      sync.Mutex
+	subs    map[*Subscription]struct{}
+	history []Event
+	size    int
+	lastID  int64
+}
+
+// NewHub returns a hub remembering up to historySize events for resumption
+func NewHub(historySize int) *Hub {
+	return &Hub{
+		subs: make(map[*Subscription]struct{}),
+		size: historySize,
+	}
+}
+
+// Subscribe registers a new subscriber and returns it together with the
+// remembered events newer than lastID that match the filter
+func (h *Hub) Subscribe(lastID int64, filter func(Event) bool) (*Subscription, []Event) {
+	c := make(chan Event, 64)
+	sub := &Subscription{C: c, c: c, filter: filter}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	backlog := []Event{}
+	if lastID > 0 {
+		for _, e := range h.history {
+			if e.ID > lastID && filter(e) {
+				backlog = append(backlog, e)
+			}
+		}
+	}
+
+	h.subs[sub] = struct{}{}
+	return sub, backlog
+}
+
+// Unsubscribe removes the subscriber from the hub
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subs[sub]; ok {
+		delete(h.subs, sub)
+		close(sub.c)
+	}
+}
+
+// Broadcast records the event in the history and delivers it to every
+// matching subscriber. Slow subscribers are dropped rather than blocking
+// the publisher
+func (h *Hub) Broadcast(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.broadcast(e)
+}
+
+// broadcast does the work of Broadcast, the caller must hold h.mu
+func (h *Hub) broadcast(e Event) {
+	if e.ID > h.lastID {
+		h.lastID = e.ID
+	}
+
+	h.history = append(h.history, e)
+	if len(h.history) > h.size {
+		h.history = h.history[len(h.history)-h.size:]
+	}
+
+	for sub := range h.subs {
+		if !sub.filter(e) {
+			continue
+		}
+		select {
+		case sub.c <- e:
+		default:
+			delete(h.subs, sub)
+			close(sub.c)
+		}
+	}
+}
+
+// Publish assigns the next local event ID and broadcasts the event,
+// it lets the hub be used on its own when only a single replica runs
+func (h *Hub) Publish(ctx context.Context, e Event) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	e.ID = h.lastID + 1
+	h.broadcast(e)
+	return nil
+}
@@ -0,0 +1,94 @@
+package events
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// channel is the Postgres NOTIFY channel shared by every API replica
+const channel = "citystars_events"
+
+// PGBroker publishes events through Postgres NOTIFY and feeds every
+// notification it LISTENs to into the local hub, so subscribers on any
+// replica see events published by any other replica. Event IDs come from
+// a database sequence which keeps them consistent between replicas
+type PGBroker struct {
+	db     *sql.DB
+	dsn    string
+	hub    *Hub
+	logger *slog.Logger
+}
+
+// NewPGBroker returns a broker publishing through db and
+// listening on a dedicated connection opened with dsn
+func NewPGBroker(db *sql.DB, dsn string, hub *Hub, logger *slog.Logger) *PGBroker {
+	return &PGBroker{db: db, dsn: dsn, hub: hub, logger: logger}
+}
+
+// Publish sends the event to every replica, including this one.
+// The event is delivered to local subscribers once it comes back
+// through the listener
+func (b *PGBroker) Publish(ctx context.Context, e Event) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	err := b.db.QueryRowContext(ctx, `SELECT nextval('stream_event_id_seq')`).Scan(&e.ID)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.db.ExecContext(ctx, `SELECT pg_notify($1, $2)`, channel, string(payload))
+	return err
+}
+
+// Listen blocks receiving notifications and broadcasting them to the hub
+// until the context is cancelled. Lost connections are re-established by
+// the underlying listener
+func (b *PGBroker) Listen(ctx context.Context) error {
+	listener := pq.NewListener(b.dsn, 10*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			b.logger.Error("event listener connection problem", "error", err)
+		}
+	})
+	defer listener.Close()
+
+	err := listener.Listen(channel)
+	if err != nil {
+		return err
+	}
+
+	ping := time.NewTicker(90 * time.Second)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case n := <-listener.Notify:
+			// A nil notification is sent after the connection was
+			// re-established, anything published meanwhile is lost
+			if n == nil {
+				continue
+			}
+			var e Event
+			err := json.Unmarshal([]byte(n.Extra), &e)
+			if err != nil {
+				b.logger.Error("failed to decode event notification", "error", err)
+				continue
+			}
+			b.hub.Broadcast(e)
+		case <-ping.C:
+			go listener.Ping()
+		}
+	}
+}
@@ -0,0 +1,290 @@
+// Package keyring signs and verifies the JWTs issued by CityStars with
+// asymmetric keys. A keyring holds the active key, which signs new tokens,
+// along with the keys it replaced, which still verify the tokens they signed
+// until those expire. The public keys are published as a JWK set so that
+// other services can verify the tokens too
+package keyring
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Supported signing algorithms
+const (
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+)
+
+var (
+	ErrUnknownKey        = errors.New("keyring: unknown signing key")
+	ErrAlgorithmMismatch = errors.New("keyring: token algorithm doesn't match its key")
+	ErrInvalidScope      = errors.New("keyring: token has the wrong scope")
+)
+
+// Key is a signing key, previous keys may hold only the public key
+type Key struct {
+	ID        string // RFC 7638 thumbprint of the public key
+	Algorithm string
+
+	private crypto.Signer
+	public  crypto.PublicKey
+}
+
+// GenerateKey returns a new private key for the algorithm, RSA keys are 2048 bits
+func GenerateKey(algorithm string) (*Key, error) {
+	switch algorithm {
+	case EdDSA:
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return newKey(private)
+	case RS256:
+		private, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		return newKey(private)
+	default:
+		return nil, fmt.Errorf("keyring: unsupported algorithm %q", algorithm)
+	}
+}
+
+// ParseKey reads a PEM encoded key, either a PKCS #8 or PKCS #1 private key
+// or a PKIX public key. The algorithm follows from the key type
+func ParseKey(data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("keyring: no PEM block found")
+	}
+
+	var key any
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("keyring: unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("keyring: %w", err)
+	}
+	return newKey(key)
+}
+
+// MarshalPrivateKey returns the private key PEM encoded in PKCS #8
+func (k *Key) MarshalPrivateKey() ([]byte, error) {
+	if k.private == nil {
+		return nil, errors.New("keyring: key has no private key")
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(k.private)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// newKey wraps a private or public key of a supported type
+func newKey(key any) (*Key, error) {
+	k := &Key{}
+
+	switch key := key.(type) {
+	case ed25519.PrivateKey:
+		k.Algorithm, k.private, k.public = EdDSA, key, key.Public()
+	case ed25519.PublicKey:
+		k.Algorithm, k.public = EdDSA, key
+	case *rsa.PrivateKey:
+		k.Algorithm, k.private, k.public = RS256, key, key.Public()
+	case *rsa.PublicKey:
+		k.Algorithm, k.public = RS256, key
+	default:
+		return nil, fmt.Errorf("keyring: unsupported key type %T", key)
+	}
+
+	if rsaKey, ok := k.public.(*rsa.PublicKey); ok && rsaKey.N.BitLen() < 2048 {
+		return nil, errors.New("keyring: RSA keys must be at least 2048 bits")
+	}
+
+	k.ID = k.jwk().thumbprint()
+	return k, nil
+}
+
+// JWK is the JSON Web Key of a public key
+type JWK struct {
+	KeyType   string `json:"kty"`
+	ID        string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+func (k *Key) jwk() JWK {
+	jwk := JWK{ID: k.ID, Algorithm: k.Algorithm, Use: "sig"}
+
+	switch public := k.public.(type) {
+	case ed25519.PublicKey:
+		jwk.KeyType, jwk.Curve = "OKP", "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	}
+	return jwk
+}
+
+// thumbprint returns the RFC 7638 thumbprint, the SHA-256 of the required
+// members in lexicographic order
+func (jwk JWK) thumbprint() string {
+	var members []byte
+	if jwk.KeyType == "RSA" {
+		members, _ = json.Marshal(struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.KeyType, jwk.N})
+	} else {
+		members, _ = json.Marshal(struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Curve, jwk.KeyType, jwk.X})
+	}
+
+	sum := sha256.Sum256(members)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Claims are the claims of the tokens, the role only hints at what the
+// client may show as it is looked up on every request. AMR lists the
+// methods the subject authenticated with, as in RFC 8176 ("pwd", "otp")
+type Claims struct {
+	jwt.RegisteredClaims
+	Scope string   `json:"scope"`
+	Role  string   `json:"role,omitempty"`
+	AMR   []string `json:"amr,omitempty"`
+}
+
+// Keyring signs tokens with the active key and verifies them with any of its keys
+type Keyring struct {
+	Issuer   string
+	Audience string
+
+	active *Key
+	keys   []*Key // The active key first, then the previous keys
+}
+
+// New returns a keyring signing with active, which must hold a private key
+func New(issuer, audience string, active *Key, previous ...*Key) (*Keyring, error) {
+	if active.private == nil {
+		return nil, errors.New("keyring: the active key has no private key")
+	}
+
+	k := &Keyring{Issuer: issuer, Audience: audience, active: active}
+	seen := map[string]bool{}
+	for _, key := range append([]*Key{active}, previous...) {
+		if seen[key.ID] {
+			continue
+		}
+		seen[key.ID] = true
+		k.keys = append(k.keys, key)
+	}
+	return k, nil
+}
+
+// Issue signs a token for the subject valid for ttl, recording the
+// authentication methods amr
+func (k *Keyring) Issue(subject, scope, role string, ttl time.Duration, amr ...string) (string, time.Time, error) {
+	now := time.Now()
+	expiry := now.Add(ttl)
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(k.active.Algorithm), Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    k.Issuer,
+			Subject:   subject,
+			Audience:  jwt.ClaimStrings{k.Audience},
+			ExpiresAt: jwt.NewNumericDate(expiry),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+		Scope: scope,
+		Role:  role,
+		AMR:   amr,
+	})
+	token.Header["kid"] = k.active.ID
+
+	signed, err := token.SignedString(k.active.private)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, expiry, nil
+}
+
+// Parse verifies the token and returns its claims. The token must be signed
+// by one of the keys with the key's algorithm, issued by the keyring's issuer
+// for its audience, unexpired and of the scope
+func (k *Keyring) Parse(token, scope string) (*Claims, error) {
+	claims := &Claims{}
+
+	_, err := jwt.ParseWithClaims(token, claims, k.keyFunc,
+		jwt.WithValidMethods([]string{RS256, EdDSA}),
+		jwt.WithIssuer(k.Issuer),
+		jwt.WithAudience(k.Audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Scope != scope {
+		return nil, ErrInvalidScope
+	}
+	return claims, nil
+}
+
+// keyFunc picks the key by the kid header, pinning the algorithm to the
+// key's so that for example an HMAC token can't be checked against a public key
+func (k *Keyring) keyFunc(t *jwt.Token) (any, error) {
+	kid, _ := t.Header["kid"].(string)
+
+	for _, key := range k.keys {
+		if key.ID != kid {
+			continue
+		}
+		if t.Method.Alg() != key.Algorithm {
+			return nil, ErrAlgorithmMismatch
+		}
+		return key.public, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+// JWKS returns the public keys of the JWK set, the active key first
+func (k *Keyring) JWKS() []JWK {
+	keys := make([]JWK, len(k.keys))
+	for i, key := range k.keys {
+		keys[i] = key.jwk()
+	}
+	return keys
+}
@@ -0,0 +1,188 @@
+package keyring
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mustGenerate returns a new key for the algorithm
+func mustGenerate(t *testing.T, algorithm string) *Key {
+	t.Helper()
+
+	key, err := GenerateKey(algorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// mustNew returns a keyring for the citystars issuer and audience
+func mustNew(t *testing.T, active *Key, previous ...*Key) *Keyring {
+	t.Helper()
+
+	k, err := New("citystars", "citystars", active, previous...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestIssueAndParse(t *testing.T) {
+	for _, algorithm := range []string{EdDSA, RS256} {
+		t.Run(algorithm, func(t *testing.T) {
+			k := mustNew(t, mustGenerate(t, algorithm))
+
+			token, expiry, err := k.Issue("42", "authentication", "admin", time.Hour, "pwd", "otp")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if time.Until(expiry) < 59*time.Minute {
+				t.Errorf("got expiry %s, want an hour from now", expiry)
+			}
+
+			claims, err := k.Parse(token, "authentication")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.Subject != "42" || claims.Role != "admin" || claims.Issuer != "citystars" || len(claims.AMR) != 2 {
+				t.Errorf("unexpected claims %+v", claims)
+			}
+		})
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	key := mustGenerate(t, EdDSA)
+	k := mustNew(t, key)
+
+	valid, _, err := k.Issue("42", "authentication", "user", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issue := func(k *Keyring, scope string, ttl time.Duration) string {
+		token, _, err := k.Issue("42", scope, "user", ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	otherIssuer, err := New("elsewhere", "citystars", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherAudience, err := New("citystars", "elsewhere", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An HMAC token using the public key as the secret, the classic
+	// algorithm confusion attack
+	hmacToken := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "citystars",
+			Subject:   "42",
+			Audience:  jwt.ClaimStrings{"citystars"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+		Scope: "authentication",
+	})
+	hmacToken.Header["kid"] = key.ID
+	confused, err := hmacToken.SignedString([]byte(key.public.(ed25519.PublicKey)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"unknown key", issue(mustNew(t, mustGenerate(t, EdDSA)), "authentication", time.Hour), ErrUnknownKey},
+		{"expired", issue(k, "authentication", -time.Minute), jwt.ErrTokenExpired},
+		{"other issuer", issue(otherIssuer, "authentication", time.Hour), jwt.ErrTokenInvalidIssuer},
+		{"other audience", issue(otherAudience, "authentication", time.Hour), jwt.ErrTokenInvalidAudience},
+		{"other scope", issue(k, "password-reset", time.Hour), ErrInvalidScope},
+		{"algorithm confusion", confused, jwt.ErrTokenSignatureInvalid},
+		{"tampered", valid[:len(valid)-4] + "AAAA", jwt.ErrTokenSignatureInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := k.Parse(tt.token, "authentication")
+			if err == nil {
+				t.Fatal("token accepted")
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRotation(t *testing.T) {
+	old := mustGenerate(t, RS256)
+	token, _, err := mustNew(t, old).Issue("42", "authentication", "user", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The previous key only needs its public half to verify
+	der, err := x509.MarshalPKIXPublicKey(old.public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldPublic, err := ParseKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oldPublic.ID != old.ID {
+		t.Errorf("public key has id %s, want %s", oldPublic.ID, old.ID)
+	}
+
+	rotated := mustNew(t, mustGenerate(t, EdDSA), oldPublic)
+	_, err = rotated.Parse(token, "authentication")
+	if err != nil {
+		t.Errorf("token of the previous key rejected: %v", err)
+	}
+
+	jwks := rotated.JWKS()
+	if len(jwks) != 2 || jwks[0].KeyType != "OKP" || jwks[1].KeyType != "RSA" || jwks[1].ID != old.ID {
+		t.Errorf("unexpected JWK set %+v", jwks)
+	}
+
+	_, err = New("citystars", "citystars", oldPublic)
+	if err == nil {
+		t.Error("keyring signing with a public key")
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	key := mustGenerate(t, EdDSA)
+	pemKey, err := key.MarshalPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ParseKey(pemKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.ID != key.ID || parsed.Algorithm != EdDSA {
+		t.Errorf("got key %s %s, want %s %s", parsed.Algorithm, parsed.ID, EdDSA, key.ID)
+	}
+
+	for _, data := range []string{"", "not a key", "-----BEGIN CERTIFICATE-----\nAAAA\n-----END CERTIFICATE-----\n"} {
+		_, err := ParseKey([]byte(data))
+		if err == nil {
+			t.Errorf("parsed %q", data)
+		}
+	}
+}
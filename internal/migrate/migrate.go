@@ -0,0 +1,353 @@
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lockID is the key of the advisory lock held while migrating, so that
+// replicas starting at the same time apply the migrations only once
+const lockID int64 = 7_305_112_601
+
+var (
+	ErrChecksumMismatch = errors.New("applied migration was modified")
+	ErrUnknownMigration = errors.New("applied migration has no migration file")
+)
+
+// fileRegex matches migration file names such as 000001_create_users_table.up.sql
+var fileRegex = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is a single pair of up and down SQL files
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Checksum identifies the contents of the up migration, it is recorded
+// when the migration is applied to detect files edited afterwards
+func (m Migration) Checksum() string {
+	sum := sha256.Sum256([]byte(m.Up))
+	return hex.EncodeToString(sum[:])
+}
+
+// Status is a migration along with when it was applied, AppliedAt is nil
+// for pending migrations
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+	Modified  bool // The file changed since the migration was applied
+}
+
+// Load reads the migrations in the root of fsys ordered by version
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+	hasUp := map[int64]bool{}
+	for _, e := range entries {
+		matches := fileRegex.FindStringSubmatch(e.Name())
+		if e.IsDir() || matches == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %w", e.Name(), err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: matches[2]}
+			byVersion[version] = m
+		} else if m.Name != matches[2] {
+			return nil, fmt.Errorf("migration version %d is used by %s and %s", version, m.Name, matches[2])
+		}
+
+		contents, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+		if matches[3] == "up" {
+			m.Up = string(contents)
+			hasUp[version] = true
+		} else {
+			m.Down = string(contents)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if !hasUp[m.Version] {
+			return nil, fmt.Errorf("migration %d_%s has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Migrator applies the migrations of FS to the database, recording them
+// in the schema_migrations table
+type Migrator struct {
+	DB     *sql.DB
+	FS     fs.FS
+	Logger *slog.Logger
+}
+
+// applied is a row of the schema_migrations table
+type applied struct {
+	checksum  string
+	appliedAt time.Time
+}
+
+// Up applies every pending migration in order and returns how many were
+// applied. It refuses to run when an applied migration was modified
+func (m Migrator) Up(ctx context.Context) (int, error) {
+	migrations, err := Load(m.FS)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = m.withLock(ctx, func(conn *sql.Conn) error {
+		done, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, mig := range migrations {
+			a, ok := done[mig.Version]
+			if ok {
+				if a.checksum != mig.Checksum() {
+					return fmt.Errorf("%w: %d_%s", ErrChecksumMismatch, mig.Version, mig.Name)
+				}
+				continue
+			}
+
+			err = m.run(ctx, conn, mig, mig.Up, `
+				INSERT INTO schema_migrations (version, name, checksum)
+				VALUES ($1, $2, $3)`,
+				mig.Version, mig.Name, mig.Checksum())
+			if err != nil {
+				return err
+			}
+			m.Logger.Info("applied migration", "version", mig.Version, "name", mig.Name)
+			count++
+		}
+		return nil
+	})
+
+	return count, err
+}
+
+// Down reverts the last steps applied migrations, newest first, and
+// returns how many were reverted
+func (m Migrator) Down(ctx context.Context, steps int) (int, error) {
+	migrations, err := Load(m.FS)
+	if err != nil {
+		return 0, err
+	}
+
+	byVersion := map[int64]Migration{}
+	for _, mig := range migrations {
+		byVersion[mig.Version] = mig
+	}
+
+	count := 0
+	err = m.withLock(ctx, func(conn *sql.Conn) error {
+		done, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		versions := make([]int64, 0, len(done))
+		for version := range done {
+			versions = append(versions, version)
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+
+		for _, version := range versions[:min(steps, len(versions))] {
+			mig, ok := byVersion[version]
+			if !ok {
+				return fmt.Errorf("%w: version %d", ErrUnknownMigration, version)
+			}
+
+			err = m.run(ctx, conn, mig, mig.Down, `DELETE FROM schema_migrations WHERE version = $1`, mig.Version)
+			if err != nil {
+				return err
+			}
+			m.Logger.Info("reverted migration", "version", mig.Version, "name", mig.Name)
+			count++
+		}
+		return nil
+	})
+
+	return count, err
+}
+
+// Status returns every migration file along with whether it was applied
+func (m Migrator) Status(ctx context.Context) ([]Status, error) {
+	migrations, err := Load(m.FS)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	done, err := m.applied(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(migrations))
+	for _, mig := range migrations {
+		s := Status{Migration: mig}
+		if a, ok := done[mig.Version]; ok {
+			s.AppliedAt = &a.appliedAt
+			s.Modified = a.checksum != mig.Checksum()
+		}
+		statuses = append(statuses, s)
+	}
+
+	return statuses, nil
+}
+
+// withLock runs fn on a dedicated connection holding the migration lock,
+// advisory locks belong to a session so every statement must use conn
+func (m Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockID)
+	if err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockID)
+
+	return fn(conn)
+}
+
+// applied creates the schema_migrations table when missing and returns
+// the applied migrations by version
+func (m Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int64]applied, error) {
+	_, err := conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT PRIMARY KEY,
+			name TEXT NOT NULL,
+			checksum TEXT NOT NULL,
+			applied_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)`)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.QueryContext(ctx, `SELECT version, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	done := map[int64]applied{}
+	for rows.Next() {
+		var version int64
+		var a applied
+		err := rows.Scan(&version, &a.checksum, &a.appliedAt)
+		if err != nil {
+			return nil, err
+		}
+		done[version] = a
+	}
+
+	return done, rows.Err()
+}
+
+// run executes the migration SQL and the bookkeeping statement in a single
+// transaction, so that a failed migration leaves no trace
+func (m Migrator) run(ctx context.Context, conn *sql.Conn, mig Migration, migrationSQL, query string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, migrationSQL)
+	if err != nil {
+		return fmt.Errorf("migration %d_%s: %w", mig.Version, mig.Name, err)
+	}
+
+	_, err = tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Create adds an empty pair of up and down files to dir, numbered after
+// the latest migration there, and returns their paths
+func Create(dir, name string) ([]string, error) {
+	name = strings.Trim(regexp.MustCompile(`[^a-z0-9]+`).ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if name == "" {
+		return nil, errors.New("migration name must contain letters or digits")
+	}
+
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations, err := Load(os.DirFS(dir))
+	if err != nil {
+		return nil, err
+	}
+
+	var version int64 = 1
+	if len(migrations) > 0 {
+		version = migrations[len(migrations)-1].Version + 1
+	}
+
+	var paths []string
+	for _, direction := range []string{"up", "down"} {
+		path := filepath.Join(dir, fmt.Sprintf("%06d_%s.%s.sql", version, name, direction))
+
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		err = f.Close()
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
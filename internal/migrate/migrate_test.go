@@ -0,0 +1,120 @@
+package migrate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/VJ-2303/CityStars/migrations"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name         string
+		fsys         fstest.MapFS
+		wantVersions []int64
+		wantErr      bool
+	}{
+		{
+			name: "ordered by version",
+			fsys: fstest.MapFS{
+				"000002_b.up.sql":   {Data: []byte("CREATE TABLE b ();")},
+				"000002_b.down.sql": {Data: []byte("DROP TABLE b;")},
+				"000001_a.up.sql":   {Data: []byte("CREATE TABLE a ();")},
+				"README.md":         {Data: []byte("not a migration")},
+			},
+			wantVersions: []int64{1, 2},
+		},
+		{
+			name:         "empty up file",
+			fsys:         fstest.MapFS{"000001_a.up.sql": {}, "000001_a.down.sql": {}},
+			wantVersions: []int64{1},
+		},
+		{
+			name:    "down without up",
+			fsys:    fstest.MapFS{"000001_a.down.sql": {Data: []byte("DROP TABLE a;")}},
+			wantErr: true,
+		},
+		{
+			name: "version used twice",
+			fsys: fstest.MapFS{
+				"000001_a.up.sql": {Data: []byte("CREATE TABLE a ();")},
+				"000001_b.up.sql": {Data: []byte("CREATE TABLE b ();")},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := Load(tt.fsys)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if len(migrations) != len(tt.wantVersions) {
+				t.Fatalf("got %d migrations, want %d", len(migrations), len(tt.wantVersions))
+			}
+			for i, m := range migrations {
+				if m.Version != tt.wantVersions[i] {
+					t.Errorf("migration %d has version %d, want %d", i, m.Version, tt.wantVersions[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := Load(migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+	for i, m := range migrations {
+		if m.Version != int64(i+1) {
+			t.Errorf("migration %s has version %d, want %d", m.Name, m.Version, i+1)
+		}
+		if m.Down == "" {
+			t.Errorf("migration %d_%s has no down migration", m.Version, m.Name)
+		}
+	}
+}
+
+func TestCreate(t *testing.T) {
+	dir := t.TempDir()
+
+	paths, err := Create(dir, "Add report votes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		filepath.Join(dir, "000001_add_report_votes.up.sql"),
+		filepath.Join(dir, "000001_add_report_votes.down.sql"),
+	}
+	if len(paths) != 2 || paths[0] != want[0] || paths[1] != want[1] {
+		t.Fatalf("got paths %v, want %v", paths, want)
+	}
+
+	paths, err = Create(dir, "drop-report-votes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(paths[0]) != "000002_drop_report_votes.up.sql" {
+		t.Errorf("got %s, want the next version", paths[0])
+	}
+
+	_, err = Create(dir, "!!!")
+	if err == nil {
+		t.Error("created a migration without a name")
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 {
+		t.Errorf("got %d files, want 4", len(entries))
+	}
+}
@@ -0,0 +1,122 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTPDriver sends email through an SMTP server. Without a username the
+// server is used unauthenticated, which is how local mail catchers run
+type SMTPDriver struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	Sender   string
+}
+
+// Send delivers the message as a plain text email
+func (d SMTPDriver) Send(ctx context.Context, msg Message) error {
+	addr := net.JoinHostPort(d.Host, strconv.Itoa(d.Port))
+
+	var auth smtp.Auth
+	if d.Username != "" {
+		auth = smtp.PlainAuth("", d.Username, d.Password, d.Host)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", d.Sender)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.Recipient)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("UTF-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	// net/smtp has no context support, so the send is bounded by the
+	// context on a separate goroutine instead
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- smtp.SendMail(addr, auth, d.Sender, []string{msg.Recipient}, []byte(b.String()))
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SMSDriver sends text messages through an HTTP provider. The message is
+// posted as {"to", "from", "body"} JSON with the API key as a bearer token,
+// any 2xx response is taken as accepted
+type SMSDriver struct {
+	URL    string
+	APIKey string
+	Sender string
+	Client *http.Client
+}
+
+// Send posts the message to the provider
+func (d SMSDriver) Send(ctx context.Context, msg Message) error {
+	js, err := json.Marshal(map[string]string{
+		"to":   msg.Recipient,
+		"from": d.Sender,
+		"body": msg.Body,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(js))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if d.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+d.APIKey)
+	}
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("sms provider responded with status %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+
+	return nil
+}
+
+// LogDriver only logs messages, it stands in for channels
+// which have no provider configured
+type LogDriver struct {
+	Logger *slog.Logger
+}
+
+// Send logs the message
+func (d LogDriver) Send(ctx context.Context, msg Message) error {
+	d.Logger.Info("notification message",
+		"message_id", msg.ID,
+		"channel", msg.Channel,
+		"recipient", msg.Recipient,
+		"subject", msg.Subject,
+		"body", msg.Body)
+	return nil
+}
@@ -0,0 +1,235 @@
+package notifier
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpSession is what the fake SMTP server received during a session
+type smtpSession struct {
+	auth string
+	from string
+	to   []string
+	data string
+}
+
+// newSMTPServer starts a minimal SMTP server on a local port which accepts
+// every message, or rejects every recipient with reject set, and returns
+// its port along with the sessions it has served
+func newSMTPServer(t *testing.T, reject bool) (int, <-chan smtpSession) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	sessions := make(chan smtpSession, 1)
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, reject, sessions)
+		}
+	}()
+
+	return ln.Addr().(*net.TCPAddr).Port, sessions
+}
+
+func serveSMTP(conn net.Conn, reject bool, sessions chan<- smtpSession) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) {
+		conn.Write([]byte(line + "\r\n"))
+	}
+
+	var s smtpSession
+	reply("220 localhost ESMTP test")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+
+		switch verb {
+		case "EHLO", "HELO":
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			s.auth = strings.TrimPrefix(line, "AUTH PLAIN ")
+			reply("235 2.7.0 Authentication successful")
+		case "MAIL":
+			s.from = line
+			reply("250 OK")
+		case "RCPT":
+			if reject {
+				reply("550 5.1.1 No such user")
+				continue
+			}
+			s.to = append(s.to, line)
+			reply("250 OK")
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			s.data = data.String()
+			reply("250 OK queued")
+		case "QUIT":
+			reply("221 Bye")
+			sessions <- s
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestSMTPDriver(t *testing.T) {
+	msg := Message{
+		ID:        1,
+		Channel:   ChannelEmail,
+		Recipient: "priya@example.com",
+		Subject:   "உங்கள் புகார் சரி செய்யப்பட்டது",
+		Body:      "Hello Priya,\nYour report has been fixed.",
+	}
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		port, sessions := newSMTPServer(t, false)
+		d := SMTPDriver{Host: "127.0.0.1", Port: port, Sender: "noreply@citystars.example"}
+
+		err := d.Send(t.Context(), msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s := <-sessions
+		if s.auth != "" {
+			t.Errorf("got auth %q, want none without a username", s.auth)
+		}
+		if s.from != "MAIL FROM:<noreply@citystars.example>" || len(s.to) != 1 || s.to[0] != "RCPT TO:<priya@example.com>" {
+			t.Errorf("got envelope %q to %q", s.from, s.to)
+		}
+
+		for _, want := range []string{
+			"From: noreply@citystars.example\r\n",
+			"To: priya@example.com\r\n",
+			"Subject: =?UTF-8?q?",
+			"Content-Type: text/plain; charset=UTF-8\r\n",
+			"\r\n\r\nHello Priya,\r\nYour report has been fixed.",
+		} {
+			if !strings.Contains(s.data, want) {
+				t.Errorf("message doesn't contain %q:\n%s", want, s.data)
+			}
+		}
+	})
+
+	t.Run("authenticated", func(t *testing.T) {
+		port, sessions := newSMTPServer(t, false)
+		d := SMTPDriver{Host: "127.0.0.1", Port: port, Username: "citystars", Password: "hunter2hunter2", Sender: "noreply@citystars.example"}
+
+		err := d.Send(t.Context(), msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s := <-sessions
+		auth, err := base64.StdEncoding.DecodeString(s.auth)
+		if err != nil || string(auth) != "\x00citystars\x00hunter2hunter2" {
+			t.Errorf("got auth %q, want the plain credentials", auth)
+		}
+	})
+
+	t.Run("rejected recipient", func(t *testing.T) {
+		port, _ := newSMTPServer(t, true)
+		d := SMTPDriver{Host: "127.0.0.1", Port: port, Sender: "noreply@citystars.example"}
+
+		err := d.Send(t.Context(), msg)
+		if err == nil || !strings.Contains(err.Error(), "550") {
+			t.Errorf("got error %v, want the rejection", err)
+		}
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ln.Close()
+
+		d := SMTPDriver{Host: "127.0.0.1", Port: ln.Addr().(*net.TCPAddr).Port, Sender: "noreply@citystars.example"}
+		err = d.Send(t.Context(), msg)
+		if err == nil {
+			t.Error("got no error sending to a closed port")
+		}
+	})
+}
+
+func TestSMSDriver(t *testing.T) {
+	msg := Message{ID: 1, Channel: ChannelSMS, Recipient: "+919000000001", Body: "CityStars: your report has been fixed."}
+
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr string
+	}{
+		{"accepted", http.StatusAccepted, `{"id":"SM1"}`, ""},
+		{"rejected", http.StatusBadRequest, "invalid number\n", "status 400: invalid number"},
+		{"provider error", http.StatusServiceUnavailable, "", "status 503"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got map[string]string
+			var header http.Header
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				header = r.Header
+				json.NewDecoder(r.Body).Decode(&got)
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			d := SMSDriver{URL: srv.URL, APIKey: "sms-key", Sender: "CITYST", Client: srv.Client()}
+			err := d.Send(t.Context(), msg)
+
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("got error %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+
+			if got["to"] != msg.Recipient || got["from"] != "CITYST" || got["body"] != msg.Body {
+				t.Errorf("provider got %v", got)
+			}
+			if header.Get("Authorization") != "Bearer sms-key" || header.Get("Content-Type") != "application/json" {
+				t.Errorf("unexpected headers %v", header)
+			}
+		})
+	}
+}
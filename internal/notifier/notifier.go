@@ -0,0 +1,114 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"text/template"
+)
+
+// Channels messages can be sent on
+const (
+	ChannelEmail = "email"
+	ChannelSMS   = "sms"
+)
+
+// DefaultLocale is used when no template exists for the user's locale
+const DefaultLocale = "en"
+
+var ErrNoDriver = errors.New("no driver registered for channel")
+
+//go:embed "templates"
+var templateFS embed.FS
+
+// Message is a single rendered message ready to be sent
+type Message struct {
+	ID        int64
+	Channel   string
+	Recipient string
+	Subject   string
+	Body      string
+}
+
+// Driver delivers messages over a single channel
+type Driver interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Notifier renders messages from the per event and locale templates and
+// hands them to the driver registered for their channel
+type Notifier struct {
+	drivers   map[string]Driver
+	templates map[string]*template.Template
+}
+
+// New returns a notifier with the embedded templates loaded. Templates are
+// named <event type>.<locale>.tmpl and define a "subject" template along
+// with one body template per channel
+func New() (*Notifier, error) {
+	n := &Notifier{
+		drivers:   make(map[string]Driver),
+		templates: make(map[string]*template.Template),
+	}
+
+	files, err := fs.Glob(templateFS, "templates/*.tmpl")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		name := strings.TrimSuffix(path.Base(file), ".tmpl")
+		tmpl, err := template.New(name).ParseFS(templateFS, file)
+		if err != nil {
+			return nil, err
+		}
+		n.templates[name] = tmpl
+	}
+
+	return n, nil
+}
+
+// Register sets the driver used to send messages on the channel
+func (n *Notifier) Register(channel string, driver Driver) {
+	n.drivers[channel] = driver
+}
+
+// Render renders the subject and the channel's body for the event in the
+// given locale, falling back to DefaultLocale when there is no such template
+func (n *Notifier) Render(eventType, locale, channel string, data any) (string, string, error) {
+	tmpl, ok := n.templates[eventType+"."+locale]
+	if !ok {
+		tmpl, ok = n.templates[eventType+"."+DefaultLocale]
+		if !ok {
+			return "", "", fmt.Errorf("no template for event %q", eventType)
+		}
+	}
+
+	subject := new(bytes.Buffer)
+	err := tmpl.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return "", "", err
+	}
+
+	body := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(body, channel, data)
+	if err != nil {
+		return "", "", err
+	}
+
+	return strings.TrimSpace(subject.String()), strings.TrimSpace(body.String()), nil
+}
+
+// Send hands the message to the driver registered for its channel
+func (n *Notifier) Send(ctx context.Context, msg Message) error {
+	driver, ok := n.drivers[msg.Channel]
+	if !ok {
+		return fmt.Errorf("%w %q", ErrNoDriver, msg.Channel)
+	}
+	return driver.Send(ctx, msg)
+}
@@ -0,0 +1,97 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// statusChanged is the data the status change templates are rendered with
+var statusChanged = map[string]any{
+	"UserName":       "Priya",
+	"PreviousStatus": "pending",
+	"Report": map[string]any{
+		"Title":    "Broken streetlight",
+		"Location": "Anna Salai, Chennai",
+		"Status":   "in_progress",
+	},
+}
+
+func TestRender(t *testing.T) {
+	n, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		locale      string
+		channel     string
+		wantSubject string
+		wantBody    string
+	}{
+		{"english email", "en", ChannelEmail, "Your report is now in_progress", "has moved from pending to in_progress"},
+		{"english sms", "en", ChannelSMS, "Your report is now in_progress", `CityStars: your report "Broken streetlight" is now in_progress.`},
+		{"tamil email", "ta", ChannelEmail, "உங்கள் புகார் புதுப்பிக்கப்பட்டது", "வணக்கம் Priya"},
+		{"unknown locale", "fr", ChannelEmail, "Your report is now in_progress", "Hello Priya,"},
+		{"empty locale", "", ChannelSMS, "Your report is now in_progress", "CityStars: your report"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subject, body, err := n.Render("status_changed", tt.locale, tt.channel, statusChanged)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if subject != tt.wantSubject {
+				t.Errorf("got subject %q, want %q", subject, tt.wantSubject)
+			}
+			if !strings.Contains(body, tt.wantBody) {
+				t.Errorf("body doesn't contain %q:\n%s", tt.wantBody, body)
+			}
+		})
+	}
+
+	_, _, err = n.Render("report_deleted", "en", ChannelEmail, statusChanged)
+	if err == nil {
+		t.Error("rendered an unknown event")
+	}
+	_, _, err = n.Render("status_changed", "en", "push", statusChanged)
+	if err == nil {
+		t.Error("rendered an unknown channel")
+	}
+}
+
+// recordingDriver keeps the messages it was asked to send
+type recordingDriver struct {
+	sent []Message
+}
+
+func (d *recordingDriver) Send(ctx context.Context, msg Message) error {
+	d.sent = append(d.sent, msg)
+	return nil
+}
+
+func TestSend(t *testing.T) {
+	n, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	email := &recordingDriver{}
+	n.Register(ChannelEmail, email)
+
+	err = n.Send(t.Context(), Message{ID: 1, Channel: ChannelEmail, Recipient: "priya@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(email.sent) != 1 || email.sent[0].ID != 1 {
+		t.Errorf("driver got %+v", email.sent)
+	}
+
+	err = n.Send(t.Context(), Message{ID: 2, Channel: ChannelSMS, Recipient: "+919000000001"})
+	if !errors.Is(err, ErrNoDriver) {
+		t.Errorf("got error %v, want %v", err, ErrNoDriver)
+	}
+}
@@ -0,0 +1,63 @@
+package notifier
+
+import (
+	"fmt"
+	"time"
+)
+
+// QuietHours is a daily window, in the user's time zone, during which no
+// messages are sent. The window may wrap past midnight, like 22:00-07:00
+type QuietHours struct {
+	Start    string
+	End      string
+	Location *time.Location
+}
+
+// ParseClock parses an "HH:MM" time of day into minutes after midnight
+func ParseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// NextAllowed returns now when it falls outside of the quiet hours, otherwise
+// the time the quiet hours end. An unset or invalid window never delays
+func (q QuietHours) NextAllowed(now time.Time) time.Time {
+	if q.Start == "" || q.End == "" {
+		return now
+	}
+	start, err := ParseClock(q.Start)
+	if err != nil {
+		return now
+	}
+	end, err := ParseClock(q.End)
+	if err != nil || start == end {
+		return now
+	}
+
+	loc := q.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	local := now.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+
+	var quiet bool
+	if start < end {
+		quiet = minute >= start && minute < end
+	} else {
+		quiet = minute >= start || minute < end
+	}
+	if !quiet {
+		return now
+	}
+
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	next := midnight.Add(time.Duration(end) * time.Minute)
+	if !next.After(local) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
@@ -0,0 +1,86 @@
+package notifier
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextAllowed(t *testing.T) {
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+
+	utc := func(s string) time.Time {
+		t.Helper()
+		tm, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+
+	tests := []struct {
+		name  string
+		quiet QuietHours
+		now   time.Time
+		want  time.Time
+	}{
+		{"unset", QuietHours{}, utc("2026-03-01T23:00:00Z"), utc("2026-03-01T23:00:00Z")},
+		{"invalid", QuietHours{Start: "25:00", End: "07:00"}, utc("2026-03-01T23:00:00Z"), utc("2026-03-01T23:00:00Z")},
+		{"empty window", QuietHours{Start: "07:00", End: "07:00"}, utc("2026-03-01T07:00:00Z"), utc("2026-03-01T07:00:00Z")},
+
+		{"same day before", QuietHours{Start: "13:00", End: "15:00"}, utc("2026-03-01T12:59:00Z"), utc("2026-03-01T12:59:00Z")},
+		{"same day inside", QuietHours{Start: "13:00", End: "15:00"}, utc("2026-03-01T14:10:00Z"), utc("2026-03-01T15:00:00Z")},
+		{"same day end", QuietHours{Start: "13:00", End: "15:00"}, utc("2026-03-01T15:00:00Z"), utc("2026-03-01T15:00:00Z")},
+
+		{"overnight before", QuietHours{Start: "22:00", End: "07:00"}, utc("2026-03-01T21:59:00Z"), utc("2026-03-01T21:59:00Z")},
+		{"overnight evening", QuietHours{Start: "22:00", End: "07:00"}, utc("2026-03-01T22:00:00Z"), utc("2026-03-02T07:00:00Z")},
+		{"overnight month end", QuietHours{Start: "22:00", End: "07:00"}, utc("2026-02-28T23:30:00Z"), utc("2026-03-01T07:00:00Z")},
+		{"overnight morning", QuietHours{Start: "22:00", End: "07:00"}, utc("2026-03-02T06:59:00Z"), utc("2026-03-02T07:00:00Z")},
+		{"overnight after", QuietHours{Start: "22:00", End: "07:00"}, utc("2026-03-02T07:00:00Z"), utc("2026-03-02T07:00:00Z")},
+
+		// 22:00-07:00 in Chennai is 16:30-01:30 UTC
+		{"zone outside", QuietHours{Start: "22:00", End: "07:00", Location: kolkata}, utc("2026-03-01T16:29:00Z"), utc("2026-03-01T16:29:00Z")},
+		{"zone evening", QuietHours{Start: "22:00", End: "07:00", Location: kolkata}, utc("2026-03-01T17:00:00Z"), utc("2026-03-02T01:30:00Z")},
+		{"zone after utc midnight", QuietHours{Start: "22:00", End: "07:00", Location: kolkata}, utc("2026-03-02T00:45:00Z"), utc("2026-03-02T01:30:00Z")},
+
+		// Clocks go forward on 2026-03-08 in New York, 07:00 is 11:00 UTC rather than 12:00
+		{"zone daylight saving", QuietHours{Start: "22:00", End: "07:00", Location: newYork}, utc("2026-03-08T03:30:00Z"), utc("2026-03-08T11:00:00Z")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.quiet.NextAllowed(tt.now)
+			if !got.Equal(tt.want) {
+				t.Errorf("got %s, want %s", got.UTC(), tt.want)
+			}
+		})
+	}
+}
+
+func TestParseClock(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int
+		wantErr bool
+	}{
+		{"00:00", 0, false},
+		{"07:30", 450, false},
+		{"23:59", 1439, false},
+		{"24:00", 0, true},
+		{"7pm", 0, true},
+		{"", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseClock(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseClock(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// PostgresStore keeps the token buckets in the rate_limit_buckets table so
+// that every replica draws from the same buckets. Elapsed time is measured
+// with the database clock, which keeps replicas with skewed clocks consistent
+type PostgresStore struct {
+	DB *sql.DB
+}
+
+// Allow takes a token from the bucket identified by key
+func (s PostgresStore) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return Result{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO rate_limit_buckets (key, tokens, updated_at)
+		VALUES ($1, $2, clock_timestamp())
+		ON CONFLICT (key) DO NOTHING`,
+		key, float64(limit.Burst))
+	if err != nil {
+		return Result{}, err
+	}
+
+	var tokens, elapsed float64
+	err = tx.QueryRowContext(ctx, `
+		SELECT tokens, EXTRACT(EPOCH FROM clock_timestamp() - updated_at)
+		FROM rate_limit_buckets
+		WHERE key = $1
+		FOR UPDATE`,
+		key).Scan(&tokens, &elapsed)
+	if err != nil {
+		return Result{}, err
+	}
+
+	tokens, result := take(tokens, time.Duration(elapsed*float64(time.Second)), limit)
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE rate_limit_buckets
+		SET tokens = $2, updated_at = clock_timestamp()
+		WHERE key = $1`,
+		key, tokens)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return result, tx.Commit()
+}
+
+// Cleanup forgets the buckets which haven't been used for the given duration
+func (s PostgresStore) Cleanup(ctx context.Context, idle time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, 12*time.Second)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx, `
+		DELETE FROM rate_limit_buckets
+		WHERE updated_at < clock_timestamp() - $1 * INTERVAL '1 second'`,
+		idle.Seconds())
+	return err
+}
@@ -0,0 +1,95 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit describes a token bucket: Burst tokens at most, refilled at Rate tokens per second
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Every returns a limit allowing burst requests, refilled at one request per interval
+func Every(interval time.Duration, burst int) Limit {
+	return Limit{Rate: 1 / interval.Seconds(), Burst: burst}
+}
+
+// Result is the outcome of taking a token from a bucket
+type Result struct {
+	Allowed    bool
+	RetryAfter time.Duration
+}
+
+// Store keeps the token buckets. The in-memory store is enough for a single
+// replica, the Postgres store shares the buckets between replicas
+type Store interface {
+	// Allow takes a token from the bucket identified by key
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+	// Cleanup forgets the buckets which haven't been used for the given duration
+	Cleanup(ctx context.Context, idle time.Duration) error
+}
+
+// take refills a bucket holding tokens after elapsed and takes a token
+// from it when possible, returning the tokens left and the outcome
+func take(tokens float64, elapsed time.Duration, limit Limit) (float64, Result) {
+	tokens = math.Min(float64(limit.Burst), tokens+elapsed.Seconds()*limit.Rate)
+	if tokens >= 1 {
+		return tokens - 1, Result{Allowed: true}
+	}
+
+	wait := time.Duration((1 - tokens) / limit.Rate * float64(time.Second))
+	return tokens, Result{Allowed: false, RetryAfter: wait}
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// MemoryStore keeps the token buckets in process memory
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time // The clock, replaced in tests
+}
+
+// NewMemoryStore returns an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*bucket), now: time.Now}
+}
+
+// Allow takes a token from the bucket identified by key
+func (s *MemoryStore) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), updated: now}
+		s.buckets[key] = b
+	}
+
+	var result Result
+	b.tokens, result = take(b.tokens, now.Sub(b.updated), limit)
+	b.updated = now
+	return result, nil
+}
+
+// Cleanup forgets the buckets which haven't been used for the given duration
+func (s *MemoryStore) Cleanup(ctx context.Context, idle time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for key, b := range s.buckets {
+		if now.Sub(b.updated) > idle {
+			delete(s.buckets, key)
+		}
+	}
+	return nil
+}
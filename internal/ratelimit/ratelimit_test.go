@@ -0,0 +1,146 @@
+package ratelimit
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock which only moves when told to
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// newTestStore returns an in-memory store running on a fake clock
+func newTestStore() (*MemoryStore, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)}
+	s := NewMemoryStore()
+	s.now = clock.Now
+	return s, clock
+}
+
+func TestEvery(t *testing.T) {
+	limit := Every(500*time.Millisecond, 4)
+	if limit.Rate != 2 || limit.Burst != 4 {
+		t.Errorf("got %+v, want 2 tokens per second with a burst of 4", limit)
+	}
+}
+
+func TestTake(t *testing.T) {
+	limit := Limit{Rate: 2, Burst: 5}
+
+	tests := []struct {
+		name       string
+		tokens     float64
+		elapsed    time.Duration
+		wantTokens float64
+		want       Result
+	}{
+		{"full bucket", 5, 0, 4, Result{Allowed: true}},
+		{"last token", 1, 0, 0, Result{Allowed: true}},
+		{"empty", 0, 0, 0, Result{RetryAfter: 500 * time.Millisecond}},
+		{"partly refilled", 0, 200 * time.Millisecond, 0.4, Result{RetryAfter: 300 * time.Millisecond}},
+		{"refilled one token", 0, 500 * time.Millisecond, 0, Result{Allowed: true}},
+		{"refill adds up", 0.5, 250 * time.Millisecond, 0, Result{Allowed: true}},
+		{"refill capped at burst", 1, time.Hour, 4, Result{Allowed: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, got := take(tt.tokens, tt.elapsed, limit)
+			if math.Abs(tokens-tt.wantTokens) > 1e-9 {
+				t.Errorf("got %v tokens left, want %v", tokens, tt.wantTokens)
+			}
+			if got.Allowed != tt.want.Allowed || (got.RetryAfter-tt.want.RetryAfter).Abs() > time.Microsecond {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	limit := Every(time.Second, 3)
+
+	// Each step advances the clock and then takes a token from the same
+	// bucket, the steps run in order
+	tests := []struct {
+		name           string
+		advance        time.Duration
+		want           bool
+		wantRetryAfter time.Duration
+	}{
+		{"burst 1", 0, true, 0},
+		{"burst 2", 0, true, 0},
+		{"burst 3", 0, true, 0},
+		{"burst used up", 0, false, time.Second},
+		{"partly refilled", 400 * time.Millisecond, false, 600 * time.Millisecond},
+		{"refilled one", 600 * time.Millisecond, true, 0},
+		{"empty again", 0, false, time.Second},
+		{"idle refills to burst", time.Hour, true, 0},
+		{"burst after idle 2", 0, true, 0},
+		{"burst after idle 3", 0, true, 0},
+		{"no more than burst", 0, false, time.Second},
+	}
+
+	s, clock := newTestStore()
+	for _, tt := range tests {
+		clock.Advance(tt.advance)
+		got, err := s.Allow(t.Context(), "login:9000000001", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Allowed != tt.want || (got.RetryAfter-tt.wantRetryAfter).Abs() > time.Microsecond {
+			t.Errorf("%s: got %+v, want allowed %t retry after %s", tt.name, got, tt.want, tt.wantRetryAfter)
+		}
+	}
+
+	// Keys draw from separate buckets
+	got, err := s.Allow(t.Context(), "login:9000000002", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Allowed {
+		t.Error("another key was limited")
+	}
+}
+
+func TestMemoryStoreCleanup(t *testing.T) {
+	limit := Every(time.Hour, 1)
+	s, clock := newTestStore()
+
+	for _, key := range []string{"idle", "recent"} {
+		_, err := s.Allow(t.Context(), key, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(10 * time.Minute)
+	}
+
+	err := s.Cleanup(t.Context(), 15*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.buckets["idle"]; ok {
+		t.Error("the idle bucket was kept")
+	}
+	if _, ok := s.buckets["recent"]; !ok {
+		t.Fatal("the recent bucket was forgotten")
+	}
+
+	// The recent bucket is still empty, a forgotten one would start full
+	got, err := s.Allow(t.Context(), "recent", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Allowed {
+		t.Error("the recent bucket was refilled by the cleanup")
+	}
+}
@@ -0,0 +1,91 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 secret of the RFC 6238 test vectors, "12345678901234567890"
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// The RFC lists eight digit codes, six digit codes are their last six digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("got code %s at %d, want %s", got, tt.unix, tt.want)
+		}
+	}
+
+	_, err := Code("not base32!", 1)
+	if err == nil {
+		t.Error("invalid secret accepted")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	code := func(offset time.Duration) string {
+		c, err := Code(secret, Step(now.Add(offset)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name string
+		code string
+		want bool
+	}{
+		{"current", code(0), true},
+		{"previous period", code(-Period), true},
+		{"next period", code(Period), true},
+		{"too old", code(-3 * Period), false},
+		{"too short", code(0)[1:], false},
+		{"empty", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(secret, tt.code, now)
+			if ok != tt.want {
+				t.Fatalf("got %t, want %t", ok, tt.want)
+			}
+			if ok && (step < Step(now)-1 || step > Step(now)+1) {
+				t.Errorf("got step %d, want one around %d", step, Step(now))
+			}
+		})
+	}
+}
+
+func TestURI(t *testing.T) {
+	got := URI("CityStars", "9000000001", "ABC")
+	want := "otpauth://totp/CityStars:9000000001?issuer=CityStars&secret=ABC"
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if !strings.HasPrefix(URI("City Stars", "9000000001", "ABC"), "otpauth://totp/City%20Stars:9000000001?") {
+		t.Error("issuer isn't escaped")
+	}
+}
@@ -0,0 +1,88 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers set on every delivery. Receivers verify a delivery by computing
+// the HMAC-SHA256 of "<timestamp>.<body>" with the shared secret and
+// comparing it with the signature header, see Verify
+const (
+	HeaderEvent     = "X-CityStars-Event"
+	HeaderDelivery  = "X-CityStars-Delivery"
+	HeaderTimestamp = "X-CityStars-Timestamp"
+	HeaderSignature = "X-CityStars-Signature"
+)
+
+// Tolerance is how far the timestamp of a delivery may be from the
+// receiver's clock, older deliveries are treated as replayed
+const Tolerance = 5 * time.Minute
+
+// Sign returns the signature header value for the body sent at timestamp
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is a valid signature of the body sent at
+// timestamp, and the timestamp is within Tolerance of now
+func Verify(secret string, timestamp int64, body []byte, signature string) bool {
+	skew := time.Since(time.Unix(timestamp, 0))
+	if skew > Tolerance || skew < -Tolerance {
+		return false
+	}
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// Sender posts signed payloads to webhook URLs
+type Sender struct {
+	Client *http.Client
+}
+
+// NewSender returns a Sender whose requests time out after timeout
+func NewSender(timeout time.Duration) *Sender {
+	return &Sender{Client: &http.Client{Timeout: timeout}}
+}
+
+// Send delivers the payload and returns the receiver's status code.
+// Any response outside of the 2xx range is reported as an error
+func (s *Sender) Send(ctx context.Context, url, secret, eventType string, deliveryID int64, payload []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "CityStars-Webhooks/1.0")
+	req.Header.Set(HeaderEvent, eventType)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(deliveryID, 10))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(secret, timestamp, payload))
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// Drain a little of the body so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver responded with status %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
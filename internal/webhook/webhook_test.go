@@ -0,0 +1,112 @@
+package webhook
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"event":"report.created","report":{"id":1}}`)
+	now := time.Now().Unix()
+	signature := Sign(testSecret, now, body)
+
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp int64
+		body      []byte
+		signature string
+		want      bool
+	}{
+		{"valid", testSecret, now, body, signature, true},
+		{"tampered body", testSecret, now, []byte(`{"event":"report.created","report":{"id":2}}`), signature, false},
+		{"wrong secret", "fedcba9876543210fedcba9876543210", now, body, signature, false},
+		{"changed timestamp", testSecret, now + 1, body, signature, false},
+		{"malformed signature", testSecret, now, body, "sha256=zz", false},
+		{"empty signature", testSecret, now, body, "", false},
+		{"skew within tolerance", testSecret, now - 60, body, Sign(testSecret, now-60, body), true},
+		{"stale", testSecret, now - 600, body, Sign(testSecret, now-600, body), false},
+		{"future", testSecret, now + 600, body, Sign(testSecret, now+600, body), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Verify(tt.secret, tt.timestamp, tt.body, tt.signature); got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSign(t *testing.T) {
+	// Computed with: printf '1700000000.{}' | openssl dgst -sha256 -hmac 0123456789abcdef0123456789abcdef
+	want := "sha256=417adf36b901772f658597a76d329694e335e65fc0849d7715c3ca33406309b5"
+	if got := Sign(testSecret, 1700000000, []byte("{}")); got != want {
+		t.Errorf("got signature %s, want %s", got, want)
+	}
+}
+
+func TestSend(t *testing.T) {
+	payload := []byte(`{"event":"report.created"}`)
+
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{"accepted", http.StatusNoContent, false},
+		{"rejected", http.StatusBadRequest, true},
+		{"receiver error", http.StatusInternalServerError, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received *http.Request
+			var body []byte
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received = r
+				body, _ = io.ReadAll(r.Body)
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			status, err := NewSender(time.Second).Send(t.Context(), srv.URL, testSecret, "report.created", 42, payload)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if status != tt.status {
+				t.Errorf("got status %d, want %d", status, tt.status)
+			}
+
+			if received.Method != http.MethodPost || string(body) != string(payload) {
+				t.Errorf("got %s with body %s, want the payload posted", received.Method, body)
+			}
+			if received.Header.Get(HeaderEvent) != "report.created" || received.Header.Get(HeaderDelivery) != "42" {
+				t.Errorf("unexpected event headers %v", received.Header)
+			}
+			timestamp, err := strconv.ParseInt(received.Header.Get(HeaderTimestamp), 10, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !Verify(testSecret, timestamp, body, received.Header.Get(HeaderSignature)) {
+				t.Error("the delivery signature doesn't verify")
+			}
+		})
+	}
+
+	t.Run("unreachable", func(t *testing.T) {
+		srv := httptest.NewServer(http.NotFoundHandler())
+		srv.Close()
+
+		status, err := NewSender(time.Second).Send(t.Context(), srv.URL, testSecret, "report.created", 1, payload)
+		if err == nil || status != 0 {
+			t.Errorf("got status %d and error %v, want an error without a status", status, err)
+		}
+	})
+}
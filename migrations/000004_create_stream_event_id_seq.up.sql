@@ -0,0 +1 @@
+CREATE SEQUENCE IF NOT EXISTS stream_event_id_seq;
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_webhook_deliveries_event_key;
+ALTER TABLE webhook_deliveries DROP COLUMN IF EXISTS event_key;
+
+DROP INDEX IF EXISTS idx_notifications_event_key;
+ALTER TABLE notifications DROP COLUMN IF EXISTS event_key;
+
+DROP TABLE IF EXISTS outbox;
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS outbox (
+    id BIGSERIAL PRIMARY KEY,
+    idempotency_key TEXT NOT NULL UNIQUE,
+    event_type TEXT NOT NULL,
+    aggregate_id BIGINT NOT NULL,
+    payload JSONB NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    available_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    processed_at TIMESTAMP(0) WITH TIME ZONE,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_unprocessed ON outbox(id) WHERE processed_at IS NULL;
+
+-- Consumers record the outbox idempotency key so redelivered events are ignored
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS event_key TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_notifications_event_key ON notifications(event_key);
+
+ALTER TABLE webhook_deliveries ADD COLUMN IF NOT EXISTS event_key TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_webhook_deliveries_event_key ON webhook_deliveries(webhook_id, event_key);
@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    user_id BIGINT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    email TEXT NOT NULL DEFAULT '',
+    email_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    sms_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    locale TEXT NOT NULL DEFAULT 'en',
+    quiet_hours_start TEXT NOT NULL DEFAULT '',
+    quiet_hours_end TEXT NOT NULL DEFAULT '',
+    timezone TEXT NOT NULL DEFAULT 'UTC',
+    updated_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS notification_messages (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    channel TEXT NOT NULL,
+    event_type TEXT NOT NULL,
+    recipient TEXT NOT NULL,
+    subject TEXT NOT NULL DEFAULT '',
+    body TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'queued',
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    send_after TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    sent_at TIMESTAMP(0) WITH TIME ZONE,
+    event_key TEXT,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_notification_messages_event_key ON notification_messages(event_key, channel);
+CREATE INDEX IF NOT EXISTS idx_notification_messages_due ON notification_messages(send_after) WHERE status = 'queued';
+CREATE INDEX IF NOT EXISTS idx_notification_messages_user_id ON notification_messages(user_id, created_at DESC);
@@ -0,0 +1,14 @@
+CREATE UNLOGGED TABLE IF NOT EXISTS rate_limit_buckets (
+    key TEXT PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_rate_limit_buckets_updated_at ON rate_limit_buckets(updated_at);
+
+CREATE TABLE IF NOT EXISTS login_attempts (
+    phone_number VARCHAR(30) PRIMARY KEY,
+    failed_count INT NOT NULL DEFAULT 0,
+    last_failed_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    locked_until TIMESTAMP(0) WITH TIME ZONE
+);
@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS suspended_at TIMESTAMP(0) WITH TIME ZONE;
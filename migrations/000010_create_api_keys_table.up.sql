@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    prefix TEXT NOT NULL UNIQUE,
+    hash BYTEA NOT NULL,
+    scopes TEXT[] NOT NULL,
+    created_by BIGINT NOT NULL REFERENCES users(id),
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP(0) WITH TIME ZONE,
+    last_used_at TIMESTAMP(0) WITH TIME ZONE,
+    revoked_at TIMESTAMP(0) WITH TIME ZONE
+);
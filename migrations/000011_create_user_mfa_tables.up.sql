@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS user_mfa (
+    user_id BIGINT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    secret TEXT NOT NULL,
+    last_step BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    confirmed_at TIMESTAMP(0) WITH TIME ZONE
+);
+
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES user_mfa(user_id) ON DELETE CASCADE,
+    hash BYTEA NOT NULL,
+    used_at TIMESTAMP(0) WITH TIME ZONE,
+    UNIQUE (user_id, hash)
+);
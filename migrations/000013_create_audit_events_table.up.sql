@@ -0,0 +1,38 @@
+CREATE TABLE IF NOT EXISTS audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    actor_type TEXT NOT NULL,
+    actor_id BIGINT,
+    actor_role TEXT NOT NULL DEFAULT '',
+    actor_name TEXT NOT NULL DEFAULT '',
+    action TEXT NOT NULL,
+    target_type TEXT NOT NULL DEFAULT '',
+    target_id TEXT NOT NULL DEFAULT '',
+    before JSONB,
+    after JSONB,
+    ip TEXT NOT NULL DEFAULT '',
+    request_id TEXT NOT NULL DEFAULT '',
+    prev_hash BYTEA,
+    hash BYTEA NOT NULL UNIQUE
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_actor_idx ON audit_events (actor_type, actor_id);
+CREATE INDEX IF NOT EXISTS audit_events_target_idx ON audit_events (target_type, target_id);
+CREATE INDEX IF NOT EXISTS audit_events_action_idx ON audit_events (action);
+CREATE INDEX IF NOT EXISTS audit_events_created_at_idx ON audit_events (created_at);
+
+-- The log is append only, rewriting history has to go around the
+-- database's own checks and still breaks the hash chain
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_no_update_or_delete
+BEFORE UPDATE OR DELETE ON audit_events
+FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+
+CREATE TRIGGER audit_events_no_truncate
+BEFORE TRUNCATE ON audit_events
+FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only();
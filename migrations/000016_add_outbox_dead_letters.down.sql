@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_outbox_unprocessed;
+CREATE INDEX IF NOT EXISTS idx_outbox_unprocessed ON outbox(id) WHERE processed_at IS NULL;
+
+ALTER TABLE outbox DROP COLUMN IF EXISTS dead_at;
+ALTER TABLE outbox DROP COLUMN IF EXISTS handled;
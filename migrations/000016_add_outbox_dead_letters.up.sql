@@ -0,0 +1,8 @@
+-- Handlers an event has been handed to successfully, a retry skips them
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS handled TEXT[] NOT NULL DEFAULT '{}';
+
+-- Set once an event has failed too often to be retried
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS dead_at TIMESTAMP(0) WITH TIME ZONE;
+
+DROP INDEX IF EXISTS idx_outbox_unprocessed;
+CREATE INDEX IF NOT EXISTS idx_outbox_unprocessed ON outbox(id) WHERE processed_at IS NULL AND dead_at IS NULL;
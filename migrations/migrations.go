@@ -0,0 +1,10 @@
+// Package migrations embeds the SQL migrations so that the binary can
+// apply them without the files being shipped alongside it
+package migrations
+
+import "embed"
+
+// FS holds the up and down migration files
+//
+//go:embed *.sql
+var FS embed.FS